@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -17,14 +21,49 @@ func main() {
 
 	command := os.Args[1]
 
+	// Ctrl+C cancels whatever the command is waiting for
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
 	// Define flags for all commands
 	createCmd := flag.NewFlagSet("create-sensor", flag.ExitOnError)
 	createFrequency := createCmd.Int("frequency", 0, "Sensor frequency (required)")
+	createClient := addClientFlags(createCmd)
 
 	getCmd := flag.NewFlagSet("get-sensor", flag.ExitOnError)
 	getID := getCmd.Int("id", 0, "Sensor ID (required)")
+	getTimeout := getCmd.Duration("timeout", DefaultMaxWait, "How long to wait for the sensor to become ACTIVE")
+	getClient := addClientFlags(getCmd)
 
 	listCmd := flag.NewFlagSet("list-sensors", flag.ExitOnError)
+	listConcurrency := listCmd.Int("concurrency", DefaultConcurrency, "Sensors fetched at once")
+	listTimeout := listCmd.Duration("timeout", DefaultMaxWait, "How long to wait for each sensor to become ACTIVE")
+	listClient := addClientFlags(listCmd)
+
+	updateCmd := flag.NewFlagSet("update-sensor", flag.ExitOnError)
+	updateID := updateCmd.Int("id", 0, "Sensor ID (required)")
+	updateFrequency := updateCmd.Int("frequency", 0, "New sensor frequency (required)")
+	updateWait := updateCmd.Bool("wait", false, "Wait for the sensor to become ACTIVE again")
+	updateTimeout := updateCmd.Duration("timeout", DefaultMaxWait, "How long --wait waits")
+	updateClient := addClientFlags(updateCmd)
+
+	restartCmd := flag.NewFlagSet("restart-sensor", flag.ExitOnError)
+	restartID := restartCmd.Int("id", 0, "Sensor ID (required)")
+	restartWait := restartCmd.Bool("wait", false, "Wait for the sensor to become ACTIVE again")
+	restartTimeout := restartCmd.Duration("timeout", DefaultMaxWait, "How long --wait waits")
+	restartClient := addClientFlags(restartCmd)
+
+	deleteCmd := flag.NewFlagSet("delete-sensor", flag.ExitOnError)
+	deleteID := deleteCmd.Int("id", 0, "Sensor ID (required)")
+	deleteClient := addClientFlags(deleteCmd)
+
+	watchCmd := flag.NewFlagSet("watch-sensors", flag.ExitOnError)
+	watchIDs := watchCmd.String("ids", "", "Comma-separated sensor IDs (default: all sensors)")
+	watchInterval := watchCmd.Duration("interval", 5*time.Second, "How often to poll each sensor")
+	watchOutput := watchCmd.String("output", "", "Time-series file to append readings to (optional)")
+	watchFormat := watchCmd.String("format", "", "Format of --output: csv or jsonl (default: from its extension)")
+	watchWindow := watchCmd.Duration("window", time.Minute, "Rollup window for --output")
+	watchClient := addClientFlags(watchCmd)
 
 	serverCmd := flag.NewFlagSet("server", flag.ExitOnError)
 	serverPort := serverCmd.Int("port", 8080, "Server port")
@@ -39,23 +78,40 @@ func main() {
 	switch command {
 	case "create-sensor":
 		createCmd.Parse(os.Args[2:])
-		cmdCreateSensor(*createFrequency)
+		cmdCreateSensor(ctx, createClient(), *createFrequency)
 
 	case "get-sensor":
 		getCmd.Parse(os.Args[2:])
-		cmdGetSensor(*getID)
+		cmdGetSensor(ctx, getClient(), *getID, *getTimeout)
 
 	case "list-sensors":
 		listCmd.Parse(os.Args[2:])
-		cmdListSensors()
+		cmdListSensors(ctx, listClient(), GetSensorsOptions{Concurrency: *listConcurrency, Timeout: *listTimeout})
+
+	case "update-sensor":
+		updateCmd.Parse(os.Args[2:])
+		cmdUpdateSensor(ctx, updateClient(), *updateID, *updateFrequency, *updateWait, *updateTimeout)
+
+	case "restart-sensor":
+		restartCmd.Parse(os.Args[2:])
+		cmdRestartSensor(ctx, restartClient(), *restartID, *restartWait, *restartTimeout)
+
+	case "delete-sensor":
+		deleteCmd.Parse(os.Args[2:])
+		cmdDeleteSensor(ctx, deleteClient(), *deleteID)
+
+	case "watch-sensors":
+		watchCmd.Parse(os.Args[2:])
+		cmdWatchSensors(ctx, watchClient(), *watchIDs, *watchInterval, *watchOutput, *watchFormat, *watchWindow)
 
 	case "server":
 		serverCmd.Parse(os.Args[2:])
+		stop() // Ctrl+C stops the server outright
 		runServer(*serverPort, *serverUnreliability, *serverSlowness)
 
 	case "demo":
 		demoCmd.Parse(os.Args[2:])
-		runDemo(*demoPort, *demoUnreliability, *demoSlowness)
+		runDemo(ctx, *demoPort, *demoUnreliability, *demoSlowness)
 
 	case "help", "--help", "-h":
 		printUsage()
@@ -67,14 +123,39 @@ func main() {
 	}
 }
 
-func cmdCreateSensor(frequency int) {
+// addClientFlags registers the rate limit and circuit breaker flags on fs, and
+// returns a function building the client they describe
+func addClientFlags(fs *flag.FlagSet) func() *SatelliteInterface {
+	rate := fs.Float64("rate", 0, "Max requests per second, retries included (0 for no limit)")
+	burst := fs.Int("burst", 5, "Requests allowed in a burst under --rate")
+	failures := fs.Int("breaker-failures", 0, "Consecutive failures that open an endpoint's circuit breaker (0 for none)")
+	cooldown := fs.Duration("breaker-cooldown", 10*time.Second, "How long an open circuit breaker rejects requests")
+
+	return func() *SatelliteInterface {
+		client := NewSatelliteInterface(defaultURL)
+		if *rate > 0 {
+			client.Limiter = NewRateLimiter(*rate, *burst)
+		}
+		if *failures > 0 {
+			client.Breaker = NewCircuitBreaker(BreakerConfig{
+				FailureThreshold: *failures,
+				Cooldown:         *cooldown,
+				OnStateChange: func(endpoint string, from, to BreakerState) {
+					fmt.Printf("⚡ Circuit breaker for %s: %s -> %s\n", endpoint, from, to)
+				},
+			})
+		}
+		return client
+	}
+}
+
+func cmdCreateSensor(ctx context.Context, client *SatelliteInterface, frequency int) {
 	if frequency == 0 {
 		fmt.Println("Error: --frequency is required")
 		os.Exit(1)
 	}
 
-	client := NewSatelliteInterface(defaultURL)
-	sensor, err := client.CreateSensor(frequency)
+	sensor, err := client.CreateSensor(ctx, frequency)
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
@@ -89,20 +170,84 @@ func cmdCreateSensor(frequency int) {
 	}
 }
 
-func cmdGetSensor(id int) {
+func cmdGetSensor(ctx context.Context, client *SatelliteInterface, id int, timeout time.Duration) {
+	if id == 0 {
+		fmt.Println("Error: --id is required")
+		os.Exit(1)
+	}
+
+	client.MaxWait = timeout
+	sensor, err := client.GetSensor(ctx, id)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	printSensor("Sensor details", sensor)
+}
+
+func cmdUpdateSensor(ctx context.Context, client *SatelliteInterface, id, frequency int, wait bool, timeout time.Duration) {
+	if id == 0 || frequency == 0 {
+		fmt.Println("Error: --id and --frequency are required")
+		os.Exit(1)
+	}
+
+	sensor, err := client.UpdateSensor(ctx, id, frequency)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	printSensor("Sensor updated", sensor)
+	if wait {
+		waitForActive(ctx, client, id, timeout)
+	}
+}
+
+func cmdRestartSensor(ctx context.Context, client *SatelliteInterface, id int, wait bool, timeout time.Duration) {
 	if id == 0 {
 		fmt.Println("Error: --id is required")
 		os.Exit(1)
 	}
 
-	client := NewSatelliteInterface(defaultURL)
-	sensor, err := client.GetSensor(id)
+	sensor, err := client.RestartSensor(ctx, id)
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
+	printSensor("Sensor restarting", sensor)
+	if wait {
+		waitForActive(ctx, client, id, timeout)
+	}
+}
 
-	fmt.Printf("Sensor details:\n")
+func cmdDeleteSensor(ctx context.Context, client *SatelliteInterface, id int) {
+	if id == 0 {
+		fmt.Println("Error: --id is required")
+		os.Exit(1)
+	}
+
+	sensor, err := client.DeleteSensor(ctx, id)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	printSensor("Sensor deleted", sensor)
+}
+
+// waitForActive waits for sensor id to become ACTIVE after a restart or
+// frequency change, exiting if it doesn't
+func waitForActive(ctx context.Context, client *SatelliteInterface, id int, timeout time.Duration) {
+	client.MaxWait = timeout
+	sensor, err := client.GetSensor(ctx, id)
+	if err != nil {
+		handleError("GetSensor", err)
+		os.Exit(1)
+	}
+	printSensor("Sensor is back", sensor)
+}
+
+func printSensor(title string, sensor *Sensor) {
+	fmt.Printf("%s:\n", title)
 	fmt.Printf("  ID: %d\n", sensor.ID)
 	fmt.Printf("  Frequency: %d\n", sensor.Frequency)
 	fmt.Printf("  Status: %s\n", sensor.Status)
@@ -113,9 +258,8 @@ func cmdGetSensor(id int) {
 	}
 }
 
-func cmdListSensors() {
-	client := NewSatelliteInterface(defaultURL)
-	ids, err := client.GetSensorIDs()
+func cmdListSensors(ctx context.Context, client *SatelliteInterface, opts GetSensorsOptions) {
+	ids, err := client.GetSensorIDs(ctx)
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
@@ -123,11 +267,109 @@ func cmdListSensors() {
 
 	if len(ids) == 0 {
 		fmt.Println("No sensors found")
-	} else {
-		fmt.Printf("Found %d sensor(s):\n", len(ids))
-		for _, id := range ids {
-			fmt.Printf("  - %d\n", id)
+		return
+	}
+	fmt.Printf("Found %d sensor(s):\n", len(ids))
+
+	// Print each sensor as soon as it is fetched
+	failed := 0
+	for result := range client.GetSensors(ctx, ids, opts) {
+		if result.Err != nil {
+			failed++
+			fmt.Printf("  - %d: Error: %v\n", result.ID, result.Err)
+			continue
+		}
+		if result.Sensor.Measurement != nil {
+			fmt.Printf("  - %d: %s, frequency %d, measurement %.3f\n", result.ID, result.Sensor.Status, result.Sensor.Frequency, *result.Sensor.Measurement)
+		} else {
+			fmt.Printf("  - %d: %s, frequency %d, measurement null\n", result.ID, result.Sensor.Status, result.Sensor.Frequency)
+		}
+	}
+	fmt.Printf("Fetched %d of %d sensor(s)\n", len(ids)-failed, len(ids))
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func cmdWatchSensors(ctx context.Context, client *SatelliteInterface, idList string, interval time.Duration, output, format string, window time.Duration) {
+	var ids []int
+	for _, field := range strings.Split(idList, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			fmt.Printf("Error: invalid sensor ID %q in --ids\n", field)
+			os.Exit(1)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		var err error
+		if ids, err = client.GetSensorIDs(ctx); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		if len(ids) == 0 {
+			fmt.Println("No sensors found")
+			return
+		}
+	}
+
+	var recorder *Recorder
+	if output != "" {
+		if format == "" {
+			format = FormatFromPath(output)
+		}
+		var err error
+		if recorder, err = NewRecorder(output, format, window); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Recording to %s (%s, rollups every %v)\n", output, format, window)
+	}
+	fmt.Printf("Watching %d sensor(s) every %v, Ctrl+C to stop\n", len(ids), interval)
+
+	// Failed polls are reported and the watch goes on; only failing to record stops it
+	recorded := 0
+	readings := client.StreamMeasurements(ctx, ids, interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for done := false; !done; {
+		select {
+		case reading, ok := <-readings:
+			if !ok {
+				done = true
+				break
+			}
+			if reading.Err != nil {
+				fmt.Printf("  %s sensor %d: Error: %v\n", reading.Time.Format(time.TimeOnly), reading.SensorID, reading.Err)
+				continue
+			}
+			fmt.Printf("  %s sensor %d: %.3f\n", reading.Time.Format(time.TimeOnly), reading.SensorID, reading.Value)
+			if recorder != nil {
+				if err := recorder.Record(reading); err != nil {
+					fmt.Printf("Error recording to %s: %v\n", output, err)
+					os.Exit(1)
+				}
+				recorded++
+			}
+		case now := <-ticker.C:
+			if recorder != nil {
+				if err := recorder.FlushWindows(now); err != nil {
+					fmt.Printf("Error recording to %s: %v\n", output, err)
+					os.Exit(1)
+				}
+			}
+		}
+	}
+
+	if recorder != nil {
+		if err := recorder.Close(); err != nil {
+			fmt.Printf("Error recording to %s: %v\n", output, err)
+			os.Exit(1)
 		}
+		fmt.Printf("Recorded %d reading(s) to %s\n", recorded, output)
 	}
 }
 
@@ -137,7 +379,7 @@ func runServer(port int, unreliability float64, slowness time.Duration) {
 	server.Start(port)
 }
 
-func runClient(baseURL string) {
+func runClient(ctx context.Context, baseURL string) {
 	fmt.Println("=== Satellite API Client ===")
 	fmt.Printf("Connecting to: %s\n", baseURL)
 
@@ -148,7 +390,7 @@ func runClient(baseURL string) {
 	fmt.Println("==================================================")
 
 	// 1. Attempt to create a sensor
-	newSensor, err := interfaceClient.CreateSensor(42)
+	newSensor, err := interfaceClient.CreateSensor(ctx, 42)
 	if err != nil {
 		handleError("CreateSensor", err)
 	} else {
@@ -161,7 +403,7 @@ func runClient(baseURL string) {
 	}
 
 	// 2. Attempt to get all sensor IDs
-	ids, err := interfaceClient.GetSensorIDs()
+	ids, err := interfaceClient.GetSensorIDs(ctx)
 	if err != nil {
 		handleError("GetSensorIDs", err)
 	} else {
@@ -170,7 +412,7 @@ func runClient(baseURL string) {
 
 	// 3. Get sensor details (will automatically wait for ACTIVE status)
 	fmt.Printf("\n⏳ Retrieving sensor %d (will wait for ACTIVE status)...\n", newSensor.ID)
-	sensor, err := interfaceClient.GetSensor(newSensor.ID)
+	sensor, err := interfaceClient.GetSensor(ctx, newSensor.ID)
 	if err != nil {
 		handleError("GetSensor", err)
 	} else {
@@ -182,7 +424,7 @@ func runClient(baseURL string) {
 	}
 }
 
-func runDemo(port int, unreliability float64, slowness time.Duration) {
+func runDemo(ctx context.Context, port int, unreliability float64, slowness time.Duration) {
 	fmt.Println("=== Integrated Demo: Unreliable Satellite Simulation ===")
 
 	// Start mock server in background
@@ -198,13 +440,13 @@ func runDemo(port int, unreliability float64, slowness time.Duration) {
 
 	// Run client against local server
 	baseURL := fmt.Sprintf("http://localhost:%d", port)
-	runClient(baseURL)
+	runClient(ctx, baseURL)
 
 	fmt.Println("\n=== Demo Complete ===")
 	fmt.Println("Server is still running. Press Ctrl+C to exit.")
 
 	// Keep running
-	select {}
+	<-ctx.Done()
 }
 
 func printUsage() {
@@ -214,14 +456,25 @@ func printUsage() {
 	fmt.Println("\nCommands:")
 	fmt.Println("  create-sensor    Create a new sensor")
 	fmt.Println("  get-sensor       Get sensor details by ID")
-	fmt.Println("  list-sensors     List all sensor IDs")
+	fmt.Println("  list-sensors     List all sensors with their details")
+	fmt.Println("  update-sensor    Change a sensor's frequency")
+	fmt.Println("  restart-sensor   Restart a sensor, e.g. one that FAILED")
+	fmt.Println("  delete-sensor    Delete a sensor")
+	fmt.Println("  watch-sensors    Stream sensor measurements, optionally to a file")
 	fmt.Println("  server           Start mock satellite server")
 	fmt.Println("  demo             Run integrated demo")
 	fmt.Println("  help             Show this help message")
 	fmt.Println("\nExamples:")
 	fmt.Println("  ./satellite create-sensor --frequency=42")
 	fmt.Println("  ./satellite get-sensor --id=1")
+	fmt.Println("  ./satellite get-sensor --id=1 --timeout=1m")
 	fmt.Println("  ./satellite list-sensors")
+	fmt.Println("  ./satellite list-sensors --concurrency=16 --timeout=10s")
+	fmt.Println("  ./satellite list-sensors --rate=10 --breaker-failures=5")
+	fmt.Println("  ./satellite update-sensor --id=1 --frequency=50 --wait")
+	fmt.Println("  ./satellite restart-sensor --id=1 --wait")
+	fmt.Println("  ./satellite delete-sensor --id=1")
+	fmt.Println("  ./satellite watch-sensors --ids=1,2 --interval=2s --output=readings.csv")
 	fmt.Println("  ./satellite server --port=8080 --unreliability=0.2")
 	fmt.Println("  ./satellite demo")
 	fmt.Printf("\nNote: Client commands connect to %s by default\n", defaultURL)
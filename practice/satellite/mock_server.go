@@ -15,19 +15,37 @@ import (
 type MockServer struct {
 	mu              sync.RWMutex
 	sensors         map[int]*Sensor
+	generations     map[int]int // Per sensor, bumped by every event to cancel pending transitions
 	nextID          int
 	unreliability   float64 // 0.0 to 1.0 - probability of failure
 	slowness        time.Duration
 	resourceLimited bool
+	hang            time.Duration // How long a simulated connection timeout lasts
+	tick            time.Duration // Unit of the sensor lifecycle's delays
+	failureRate     float64       // Chance a sensor fails to come up after starting
 }
 
+// sensorEvent is what sets a sensor on its way through its lifecycle
+type sensorEvent int
+
+const (
+	sensorCreated sensorEvent = iota
+	sensorRestarted
+	sensorRetuned // Frequency changed while ACTIVE
+	sensorDeleted
+)
+
 // NewMockServer creates a new mock satellite server
 func NewMockServer(unreliability float64, slowness time.Duration) *MockServer {
 	return &MockServer{
 		sensors:       make(map[int]*Sensor),
+		generations:   make(map[int]int),
 		nextID:        1,
 		unreliability: unreliability,
 		slowness:      slowness,
+		hang:          10 * time.Second,
+		tick:          time.Second,
+		failureRate:   0.1,
 	}
 }
 
@@ -46,7 +64,7 @@ func (s *MockServer) simulateUnreliability(w http.ResponseWriter) bool {
 		switch failureType {
 		case 0:
 			// Connection timeout (no response)
-			time.Sleep(10 * time.Second)
+			time.Sleep(s.hang)
 			return false
 		case 1:
 			// Server error
@@ -62,35 +80,78 @@ func (s *MockServer) simulateUnreliability(w http.ResponseWriter) bool {
 	return true
 }
 
-// updateSensorStatus simulates sensor lifecycle
-func (s *MockServer) updateSensorStatus(sensor *Sensor) {
-	go func() {
-		// Simulate INITIALIZING -> ACTIVE transition
-		time.Sleep(time.Duration(5+rand.Intn(15)) * time.Second)
+// updateSensorStatus simulates the sensor lifecycle after event, moving it
+// through these states (delays in ticks, a second by default):
+//
+//	created:   INITIALIZING, then ACTIVE after 5-20 (or FAILED, 10% of the time)
+//	restarted: RESTARTING, then ACTIVE after 3-10 (or FAILED, 10% of the time)
+//	retuned:   RESTARTING, then ACTIVE after 1-3
+//	deleted:   TERMINATING, then gone after 1-3
+//
+// Each event cancels the transition still pending from the one before. The
+// caller must hold s.mu.
+func (s *MockServer) updateSensorStatus(sensor *Sensor, event sensorEvent) {
+	s.generations[sensor.ID]++
+	generation := s.generations[sensor.ID]
+
+	var delay time.Duration
+	var next func()
+	switch event {
+	case sensorCreated, sensorRestarted:
+		if event == sensorCreated {
+			sensor.Status = StatusInitializing
+			delay = s.ticks(5, 20)
+		} else {
+			sensor.Status = StatusRestarting
+			delay = s.ticks(3, 10)
+		}
+		sensor.Measurement = nil
+		next = func() {
+			if rand.Float64() < s.failureRate {
+				sensor.Status = StatusFailed
+			} else {
+				s.activate(sensor)
+			}
+		}
+	case sensorRetuned:
+		sensor.Status = StatusRestarting
+		sensor.Measurement = nil
+		delay = s.ticks(1, 3)
+		next = func() { s.activate(sensor) }
+	case sensorDeleted:
+		sensor.Status = StatusTerminating
+		sensor.Measurement = nil
+		delay = s.ticks(1, 3)
+		next = func() {
+			delete(s.sensors, sensor.ID)
+			delete(s.generations, sensor.ID)
+		}
+	}
 
+	go func() {
+		time.Sleep(delay)
 		s.mu.Lock()
 		defer s.mu.Unlock()
-
-		if sensor.Status == StatusInitializing {
-			// 90% chance of becoming active, 10% chance of failure
-			if rand.Float64() < 0.9 {
-				sensor.Status = StatusActive
-				// Start generating measurements
-				measurement := 50.0 + rand.Float64()*100.0
-				sensor.Measurement = &measurement
-			} else {
-				sensor.Status = StatusFailed
-			}
+		// Skip if another event has happened since
+		if s.generations[sensor.ID] == generation {
+			next()
 		}
 	}()
 
-	// Continuously update measurements for active sensors
+	if event != sensorCreated {
+		return
+	}
+	// Continuously update measurements for active sensors, until deleted
 	go func() {
-		ticker := time.NewTicker(5 * time.Second)
+		ticker := time.NewTicker(5 * s.tick)
 		defer ticker.Stop()
 
 		for range ticker.C {
 			s.mu.Lock()
+			if s.sensors[sensor.ID] != sensor {
+				s.mu.Unlock()
+				return
+			}
 			if sensor.Status == StatusActive {
 				// Update measurement with some drift
 				newValue := *sensor.Measurement + (rand.Float64()-0.5)*10.0
@@ -101,6 +162,18 @@ func (s *MockServer) updateSensorStatus(sensor *Sensor) {
 	}()
 }
 
+// activate makes sensor ACTIVE with a fresh measurement; the caller must hold s.mu
+func (s *MockServer) activate(sensor *Sensor) {
+	sensor.Status = StatusActive
+	measurement := 50.0 + rand.Float64()*100.0
+	sensor.Measurement = &measurement
+}
+
+// ticks returns a random delay of lo to hi ticks
+func (s *MockServer) ticks(lo, hi int) time.Duration {
+	return time.Duration(lo+rand.Intn(hi-lo+1)) * s.tick
+}
+
 // handleGetSensorIDs handles GET /sensor-ids
 func (s *MockServer) handleGetSensorIDs(w http.ResponseWriter, r *http.Request) {
 	if !s.simulateUnreliability(w) {
@@ -157,47 +230,160 @@ func (s *MockServer) handleCreateSensor(w http.ResponseWriter, r *http.Request)
 	s.nextID++
 
 	// Start background process to update sensor status
-	s.updateSensorStatus(sensor)
+	s.updateSensorStatus(sensor, sensorCreated)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(sensor)
 }
 
-// handleGetSensor handles GET /sensors/<id>
-func (s *MockServer) handleGetSensor(w http.ResponseWriter, r *http.Request) {
-	if !s.simulateUnreliability(w) {
-		return
-	}
-
-	// Extract sensor ID from path
+// sensorID extracts the sensor ID from a /sensors/<id>[/...] path, answering 400
+// if there is none
+func sensorID(w http.ResponseWriter, r *http.Request) (int, bool) {
 	parts := strings.Split(r.URL.Path, "/")
 	if len(parts) < 3 {
 		http.Error(w, "Invalid path", http.StatusBadRequest)
-		return
+		return 0, false
 	}
 
 	id, err := strconv.Atoi(parts[2])
 	if err != nil {
 		http.Error(w, "Invalid sensor ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// writeSensor answers with sensor as JSON; the caller must hold s.mu
+func writeSensor(w http.ResponseWriter, status int, sensor *Sensor) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(sensor)
+}
+
+// handleGetSensor handles GET /sensors/<id>
+func (s *MockServer) handleGetSensor(w http.ResponseWriter, r *http.Request) {
+	if !s.simulateUnreliability(w) {
+		return
+	}
+
+	id, ok := sensorID(w, r)
+	if !ok {
 		return
 	}
 
 	s.mu.RLock()
+	defer s.mu.RUnlock()
 	sensor, exists := s.sensors[id]
-	s.mu.RUnlock()
+	if !exists {
+		http.Error(w, "Sensor not found", http.StatusNotFound)
+		return
+	}
+
+	writeSensor(w, http.StatusOK, sensor)
+}
+
+// handleUpdateSensor handles PATCH /sensors/<id>, which changes the frequency.
+// An ACTIVE sensor restarts to retune; FAILED and TERMINATING sensors can't be
+// changed.
+func (s *MockServer) handleUpdateSensor(w http.ResponseWriter, r *http.Request) {
+	if !s.simulateUnreliability(w) {
+		return
+	}
 
+	id, ok := sensorID(w, r)
+	if !ok {
+		return
+	}
+
+	var req SensorUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Frequency < 0 {
+		http.Error(w, "Invalid frequency: must be non-negative", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sensor, exists := s.sensors[id]
 	if !exists {
 		http.Error(w, "Sensor not found", http.StatusNotFound)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(sensor)
+	switch sensor.Status {
+	case StatusFailed, StatusTerminating:
+		http.Error(w, fmt.Sprintf("Sensor is %s", sensor.Status), http.StatusConflict)
+		return
+	case StatusActive:
+		if req.Frequency != sensor.Frequency {
+			s.updateSensorStatus(sensor, sensorRetuned)
+		}
+	}
+	sensor.Frequency = req.Frequency
+
+	writeSensor(w, http.StatusOK, sensor)
 }
 
-// Start starts the mock server
-func (s *MockServer) Start(port int) {
+// handleRestartSensor handles POST /sensors/<id>/restart, which brings back
+// FAILED sensors (or not) and restarts any other but TERMINATING ones
+func (s *MockServer) handleRestartSensor(w http.ResponseWriter, r *http.Request) {
+	if !s.simulateUnreliability(w) {
+		return
+	}
+
+	id, ok := sensorID(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sensor, exists := s.sensors[id]
+	if !exists {
+		http.Error(w, "Sensor not found", http.StatusNotFound)
+		return
+	}
+	if sensor.Status == StatusTerminating {
+		http.Error(w, "Sensor is TERMINATING", http.StatusConflict)
+		return
+	}
+
+	s.updateSensorStatus(sensor, sensorRestarted)
+	writeSensor(w, http.StatusAccepted, sensor)
+}
+
+// handleDeleteSensor handles DELETE /sensors/<id>. The sensor is TERMINATING
+// for a while before it is gone; deleting it again meanwhile changes nothing.
+func (s *MockServer) handleDeleteSensor(w http.ResponseWriter, r *http.Request) {
+	if !s.simulateUnreliability(w) {
+		return
+	}
+
+	id, ok := sensorID(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sensor, exists := s.sensors[id]
+	if !exists {
+		http.Error(w, "Sensor not found", http.StatusNotFound)
+		return
+	}
+
+	if sensor.Status != StatusTerminating {
+		s.updateSensorStatus(sensor, sensorDeleted)
+	}
+	writeSensor(w, http.StatusAccepted, sensor)
+}
+
+// Handler returns the mock server's routes, for serving without Start
+func (s *MockServer) Handler() http.Handler {
 	// Create a new ServeMux for this server instance
 	mux := http.NewServeMux()
 
@@ -210,11 +396,25 @@ func (s *MockServer) Start(port int) {
 	})
 
 	mux.HandleFunc("/sensors/", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "GET" {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		if strings.HasSuffix(r.URL.Path, "/restart") {
+			if r.Method != "POST" {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			s.handleRestartSensor(w, r)
 			return
 		}
-		s.handleGetSensor(w, r)
+
+		switch r.Method {
+		case "GET":
+			s.handleGetSensor(w, r)
+		case "PATCH":
+			s.handleUpdateSensor(w, r)
+		case "DELETE":
+			s.handleDeleteSensor(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
 	})
 
 	mux.HandleFunc("/sensors", func(w http.ResponseWriter, r *http.Request) {
@@ -225,13 +425,18 @@ func (s *MockServer) Start(port int) {
 		s.handleCreateSensor(w, r)
 	})
 
+	return mux
+}
+
+// Start starts the mock server
+func (s *MockServer) Start(port int) {
 	addr := fmt.Sprintf(":%d", port)
 	fmt.Printf("🛰️  Mock satellite server starting on %s\n", addr)
 	fmt.Printf("   Unreliability: %.0f%%\n", s.unreliability*100)
 	fmt.Printf("   Max slowness: %v\n", s.slowness)
 	fmt.Println()
 
-	if err := http.ListenAndServe(addr, mux); err != nil {
+	if err := http.ListenAndServe(addr, s.Handler()); err != nil {
 		fmt.Printf("Server error: %v\n", err)
 	}
 }
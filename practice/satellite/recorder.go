@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Record formats
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// csvHeader is the first line of CSV recordings. Reading rows leave end, min, max,
+// avg and count empty; rollup rows leave value empty and put the window's start
+// in time.
+var csvHeader = []string{"kind", "sensor_id", "time", "value", "end", "min", "max", "avg", "count"}
+
+// readingRecord and rollupRecord are the lines of JSONL recordings
+type readingRecord struct {
+	Kind     string    `json:"kind"` // "reading"
+	SensorID int       `json:"sensor_id"`
+	Time     time.Time `json:"time"`
+	Value    float64   `json:"value"`
+}
+
+type rollupRecord struct {
+	Kind     string    `json:"kind"` // "rollup"
+	SensorID int       `json:"sensor_id"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Min      float64   `json:"min"`
+	Max      float64   `json:"max"`
+	Avg      float64   `json:"avg"`
+	Count    int       `json:"count"`
+}
+
+// rollupWindow accumulates the readings of one sensor's current window
+type rollupWindow struct {
+	start         time.Time
+	min, max, sum float64
+	count         int
+}
+
+// Recorder appends readings to a time-series file, one row per reading, and a
+// rollup row per sensor and window once the window is over. Every row is written
+// through to the file at once, so nothing recorded is lost if the process dies;
+// only the rollups of unfinished windows are. A Recorder is not safe for
+// concurrent use.
+type Recorder struct {
+	file    *os.File
+	format  string
+	csv     *csv.Writer
+	json    *json.Encoder
+	window  time.Duration
+	windows map[int]*rollupWindow // By sensor ID
+}
+
+// FormatFromPath returns the record format for a file name: jsonl for .jsonl and
+// .json files, csv otherwise.
+func FormatFromPath(path string) string {
+	switch filepath.Ext(path) {
+	case ".jsonl", ".json":
+		return FormatJSONL
+	}
+	return FormatCSV
+}
+
+// NewRecorder opens path for appending readings in format (FormatCSV or
+// FormatJSONL), creating it if needed, with rollups every window (aligned to the
+// clock, e.g. on the minute for a minute).
+func NewRecorder(path, format string, window time.Duration) (*Recorder, error) {
+	if format != FormatCSV && format != FormatJSONL {
+		return nil, fmt.Errorf("unknown record format %q (want %s or %s)", format, FormatCSV, FormatJSONL)
+	}
+	if window <= 0 {
+		return nil, fmt.Errorf("rollup window must be positive, got %v", window)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{file: file, format: format, window: window, windows: make(map[int]*rollupWindow)}
+	if format == FormatJSONL {
+		r.json = json.NewEncoder(file)
+		return r, nil
+	}
+
+	r.csv = csv.NewWriter(file)
+	info, err := file.Stat()
+	if err == nil && info.Size() == 0 {
+		err = r.writeCSV(csvHeader)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Record writes reading, after the rollups of any of its sensor's windows that
+// ended before it. Readings with an Err are ignored.
+func (r *Recorder) Record(reading Reading) error {
+	if reading.Err != nil {
+		return nil
+	}
+	start := reading.Time.Truncate(r.window)
+	w := r.windows[reading.SensorID]
+	if w != nil && w.start.Before(start) {
+		if err := r.writeRollup(reading.SensorID, w); err != nil {
+			return err
+		}
+		w = nil
+	}
+	if w == nil {
+		w = &rollupWindow{start: start, min: reading.Value, max: reading.Value}
+		r.windows[reading.SensorID] = w
+	}
+	w.min = min(w.min, reading.Value)
+	w.max = max(w.max, reading.Value)
+	w.sum += reading.Value
+	w.count++
+
+	if r.format == FormatJSONL {
+		return r.json.Encode(readingRecord{"reading", reading.SensorID, reading.Time.UTC(), reading.Value})
+	}
+	return r.writeCSV([]string{"reading", strconv.Itoa(reading.SensorID), formatTime(reading.Time), formatValue(reading.Value), "", "", "", "", ""})
+}
+
+// FlushWindows writes the rollups of the windows that ended by now, for sensors
+// that have sent nothing since.
+func (r *Recorder) FlushWindows(now time.Time) error {
+	for _, id := range r.sensorIDs() {
+		if w := r.windows[id]; !w.start.Add(r.window).After(now) {
+			if err := r.writeRollup(id, w); err != nil {
+				return err
+			}
+			delete(r.windows, id)
+		}
+	}
+	return nil
+}
+
+// Close writes the rollups of the unfinished windows, so far, and closes the file.
+func (r *Recorder) Close() error {
+	var err error
+	for _, id := range r.sensorIDs() {
+		if err = r.writeRollup(id, r.windows[id]); err != nil {
+			break
+		}
+	}
+	r.windows = nil
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// sensorIDs returns the sensors with an open window, sorted so rollups are
+// written in a stable order
+func (r *Recorder) sensorIDs() []int {
+	ids := make([]int, 0, len(r.windows))
+	for id := range r.windows {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// writeRollup writes the min, max and average of sensor id's window w
+func (r *Recorder) writeRollup(id int, w *rollupWindow) error {
+	end := w.start.Add(r.window)
+	avg := w.sum / float64(w.count)
+	if r.format == FormatJSONL {
+		return r.json.Encode(rollupRecord{"rollup", id, w.start.UTC(), end.UTC(), w.min, w.max, avg, w.count})
+	}
+	return r.writeCSV([]string{
+		"rollup", strconv.Itoa(id), formatTime(w.start), "", formatTime(end),
+		formatValue(w.min), formatValue(w.max), formatValue(avg), strconv.Itoa(w.count),
+	})
+}
+
+// writeCSV writes one row through to the file
+func (r *Recorder) writeCSV(row []string) error {
+	r.csv.Write(row)
+	r.csv.Flush()
+	return r.csv.Error()
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordAll records readings in a new file in format and returns its contents
+// after flushing the windows ended by flushAt and closing it
+func recordAll(t *testing.T, format string, readings []Reading, flushAt time.Time) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "readings."+format)
+	recorder, err := NewRecorder(path, format, time.Minute)
+	if err != nil {
+		t.Fatalf("NewRecorder failed: %v", err)
+	}
+	for _, reading := range readings {
+		if err := recorder.Record(reading); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+	}
+	if err := recorder.FlushWindows(flushAt); err != nil {
+		t.Fatalf("FlushWindows failed: %v", err)
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	return string(data)
+}
+
+// testReadings spans two one-minute windows for sensor 1 and one for sensor 2
+func testReadings() []Reading {
+	at := func(s string) time.Time {
+		t, _ := time.Parse(time.RFC3339, "2025-01-02T10:"+s+"Z")
+		return t
+	}
+	return []Reading{
+		{SensorID: 1, Time: at("00:10"), Value: 1},
+		{SensorID: 1, Time: at("00:20"), Value: 3.5},
+		{SensorID: 2, Time: at("00:30"), Value: 5},
+		{SensorID: 2, Time: at("00:40"), Err: errors.New("ignored")},
+		{SensorID: 1, Time: at("01:05"), Value: 2},
+	}
+}
+
+// TestRecorderCSV tests the readings and rollups written as CSV
+func TestRecorderCSV(t *testing.T) {
+	flushAt, _ := time.Parse(time.RFC3339, "2025-01-02T10:01:30Z")
+	got := recordAll(t, FormatCSV, testReadings(), flushAt)
+	want := `kind,sensor_id,time,value,end,min,max,avg,count
+reading,1,2025-01-02T10:00:10Z,1,,,,,
+reading,1,2025-01-02T10:00:20Z,3.5,,,,,
+reading,2,2025-01-02T10:00:30Z,5,,,,,
+rollup,1,2025-01-02T10:00:00Z,,2025-01-02T10:01:00Z,1,3.5,2.25,2
+reading,1,2025-01-02T10:01:05Z,2,,,,,
+rollup,2,2025-01-02T10:00:00Z,,2025-01-02T10:01:00Z,5,5,5,1
+rollup,1,2025-01-02T10:01:00Z,,2025-01-02T10:02:00Z,2,2,2,1
+`
+	if got != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+// TestRecorderJSONL tests the readings and rollups written as JSONL
+func TestRecorderJSONL(t *testing.T) {
+	got := recordAll(t, FormatJSONL, testReadings()[:2], time.Time{})
+	want := `{"kind":"reading","sensor_id":1,"time":"2025-01-02T10:00:10Z","value":1}
+{"kind":"reading","sensor_id":1,"time":"2025-01-02T10:00:20Z","value":3.5}
+{"kind":"rollup","sensor_id":1,"start":"2025-01-02T10:00:00Z","end":"2025-01-02T10:01:00Z","min":1,"max":3.5,"avg":2.25,"count":2}
+`
+	if got != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+// TestRecorderAppends tests that recording again adds to the file, with one
+// CSV header
+func TestRecorderAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "readings.csv")
+	for i := range 2 {
+		recorder, err := NewRecorder(path, FormatCSV, time.Minute)
+		if err != nil {
+			t.Fatalf("NewRecorder failed: %v", err)
+		}
+		recorder.Record(Reading{SensorID: 1, Time: time.Now(), Value: float64(i)})
+		recorder.Close()
+	}
+	data, _ := os.ReadFile(path)
+	if n := strings.Count(string(data), "kind,"); n != 1 {
+		t.Errorf("Expected one header, got %d", n)
+	}
+	if n := strings.Count(string(data), "\nreading,"); n != 2 {
+		t.Errorf("Expected both readings, got:\n%s", data)
+	}
+}
+
+// TestNewRecorderErrors tests the arguments NewRecorder refuses
+func TestNewRecorderErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "readings")
+	if _, err := NewRecorder(path, "xml", time.Minute); err == nil {
+		t.Errorf("Expected an error for an unknown format")
+	}
+	if _, err := NewRecorder(path, FormatCSV, 0); err == nil {
+		t.Errorf("Expected an error for a zero window")
+	}
+	if FormatFromPath("a.jsonl") != FormatJSONL || FormatFromPath("a.csv") != FormatCSV || FormatFromPath("a") != FormatCSV {
+		t.Errorf("Unexpected formats from paths")
+	}
+}
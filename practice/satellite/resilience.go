@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Rate Limiter ---
+
+// RateLimiter is a token bucket: it allows a number of requests per second on
+// average, and bursts above it. One limiter can be shared by any number of clients.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // Tokens added per second; 0 for no limit
+	burst  float64 // Most tokens the bucket holds
+	tokens float64 // Negative while requests are queued for tokens
+	last   time.Time
+	stats  LimiterStats
+}
+
+// LimiterStats counts what a RateLimiter has done.
+type LimiterStats struct {
+	Requests  int64         // Requests let through
+	Delayed   int64         // Requests that had to wait for a token
+	TotalWait time.Duration // Time requests spent waiting
+}
+
+// NewRateLimiter returns a limiter for rate requests per second, starting with a
+// full bucket of burst tokens (at least 1). A rate that isn't positive means no
+// limit: every request goes through at once, and is still counted.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	burst = max(burst, 1)
+	if !(rate > 0) { // NaN too
+		rate = 0
+	}
+	return &RateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Wait takes a token, waiting for one if the bucket is empty. Requests get their
+// tokens in the order they called Wait. It returns ctx's error if ctx ends first.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	if l.rate == 0 {
+		l.stats.Requests++
+		l.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens-- // Reserve a token, even if it has yet to be added
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			l.mu.Lock()
+			l.tokens++ // Hand the reservation back
+			l.mu.Unlock()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	l.mu.Lock()
+	l.stats.Requests++
+	if wait > 0 {
+		l.stats.Delayed++
+		l.stats.TotalWait += wait
+	}
+	l.mu.Unlock()
+	return nil
+}
+
+// Tokens returns how many requests could go through now without waiting.
+func (l *RateLimiter) Tokens() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate == 0 {
+		return l.burst
+	}
+	return max(0, min(l.burst, l.tokens+time.Since(l.last).Seconds()*l.rate))
+}
+
+// Stats returns the limiter's counters.
+func (l *RateLimiter) Stats() LimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
+
+// --- Circuit Breaker ---
+
+// BreakerState is the state of one endpoint's circuit.
+type BreakerState int
+
+const (
+	StateClosed   BreakerState = iota // Requests flow; failures are counted
+	StateOpen                         // Requests are rejected until the cooldown passes
+	StateHalfOpen                     // A few probe requests decide whether to close again
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+// ErrCircuitOpen is returned (wrapped) for requests the circuit breaker rejects.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerConfig configures a CircuitBreaker.
+type BreakerConfig struct {
+	FailureThreshold int           // Consecutive failures that open the circuit
+	Cooldown         time.Duration // How long it stays open before probing
+	HalfOpenProbes   int           // Requests let through at once while half-open; 1 if 0
+	// OnStateChange, if set, is called (without locks held) on every transition.
+	OnStateChange func(endpoint string, from, to BreakerState)
+}
+
+// BreakerStats describes one endpoint's circuit.
+type BreakerStats struct {
+	State               BreakerState
+	ConsecutiveFailures int   // Failures since the last success
+	Rejected            int64 // Requests rejected while open or half-open
+	Opened              int64 // Times the circuit has opened
+}
+
+// CircuitBreaker keeps a circuit per endpoint ("GET /sensors/{id}"): after
+// FailureThreshold failures in a row it opens and rejects that endpoint's requests
+// for Cooldown, then goes half-open and lets HalfOpenProbes requests through; one
+// success closes the circuit again, one failure reopens it. Connection errors and
+// 5xx responses are failures; any other response is a success.
+type CircuitBreaker struct {
+	config   BreakerConfig
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+// circuit is the state of one endpoint
+type circuit struct {
+	BreakerStats
+	openedAt   time.Time
+	probes     int    // Requests in flight while half-open
+	generation uint64 // Bumped on every transition, so late results are ignored
+}
+
+// NewCircuitBreaker returns a breaker with every circuit closed.
+func NewCircuitBreaker(config BreakerConfig) *CircuitBreaker {
+	config.FailureThreshold = max(config.FailureThreshold, 1)
+	if config.HalfOpenProbes <= 0 {
+		config.HalfOpenProbes = 1
+	}
+	return &CircuitBreaker{config: config, circuits: make(map[string]*circuit)}
+}
+
+// State returns the state of endpoint's circuit.
+func (b *CircuitBreaker) State(endpoint string) BreakerState {
+	b.mu.Lock()
+	c, ok := b.circuits[endpoint]
+	if !ok {
+		b.mu.Unlock()
+		return StateClosed
+	}
+	notify := b.refreshLocked(endpoint, c)
+	state := c.State
+	b.mu.Unlock()
+	notify()
+	return state
+}
+
+// Stats returns every endpoint's circuit that has seen a request.
+func (b *CircuitBreaker) Stats() map[string]BreakerStats {
+	b.mu.Lock()
+	stats := make(map[string]BreakerStats, len(b.circuits))
+	var notifies []func()
+	for endpoint, c := range b.circuits {
+		notifies = append(notifies, b.refreshLocked(endpoint, c))
+		stats[endpoint] = c.BreakerStats
+	}
+	b.mu.Unlock()
+	for _, notify := range notifies {
+		notify()
+	}
+	return stats
+}
+
+// allow decides whether a request to endpoint may go out. If so, it returns the
+// circuit's generation, which must be passed to record or release.
+func (b *CircuitBreaker) allow(endpoint string) (uint64, error) {
+	b.mu.Lock()
+	c, ok := b.circuits[endpoint]
+	if !ok {
+		c = &circuit{}
+		b.circuits[endpoint] = c
+	}
+	notify := b.refreshLocked(endpoint, c)
+	var err error
+	switch {
+	case c.State == StateOpen, c.State == StateHalfOpen && c.probes >= b.config.HalfOpenProbes:
+		c.Rejected++
+		err = fmt.Errorf("%s: %w", endpoint, ErrCircuitOpen)
+	case c.State == StateHalfOpen:
+		c.probes++
+	}
+	generation := c.generation
+	b.mu.Unlock()
+	notify()
+	return generation, err
+}
+
+// record reports how an allowed request went. Results from before the circuit's
+// last transition are ignored.
+func (b *CircuitBreaker) record(endpoint string, generation uint64, failed bool) {
+	b.mu.Lock()
+	c := b.circuits[endpoint]
+	if c.generation != generation {
+		b.mu.Unlock()
+		return
+	}
+	var to BreakerState
+	if failed {
+		c.ConsecutiveFailures++
+		to = c.State
+		if c.State == StateHalfOpen || c.ConsecutiveFailures >= b.config.FailureThreshold {
+			to = StateOpen
+		}
+	} else {
+		c.ConsecutiveFailures = 0
+		to = StateClosed
+	}
+	if c.State == StateHalfOpen {
+		c.probes--
+	}
+	notify := b.transitionLocked(endpoint, c, to)
+	b.mu.Unlock()
+	notify()
+}
+
+// release is record for a request that tells nothing about the endpoint's health,
+// such as one its caller canceled.
+func (b *CircuitBreaker) release(endpoint string, generation uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c := b.circuits[endpoint]; c.generation == generation && c.State == StateHalfOpen {
+		c.probes--
+	}
+}
+
+// refreshLocked moves an open circuit whose cooldown has passed to half-open. The
+// caller must hold mu and call the returned function once it has released mu.
+func (b *CircuitBreaker) refreshLocked(endpoint string, c *circuit) func() {
+	if c.State == StateOpen && time.Since(c.openedAt) >= b.config.Cooldown {
+		return b.transitionLocked(endpoint, c, StateHalfOpen)
+	}
+	return func() {}
+}
+
+// transitionLocked moves c to state to, if it isn't there already. The caller
+// must hold mu and call the returned function, which reports the transition to
+// OnStateChange, once it has released mu.
+func (b *CircuitBreaker) transitionLocked(endpoint string, c *circuit, to BreakerState) func() {
+	from := c.State
+	if from == to {
+		return func() {}
+	}
+	c.State = to
+	c.generation++
+	c.probes = 0
+	if to == StateOpen {
+		c.openedAt = time.Now()
+		c.Opened++
+	}
+	if b.config.OnStateChange == nil {
+		return func() {}
+	}
+	return func() { b.config.OnStateChange(endpoint, from, to) }
+}
+
+// --- Transport ---
+
+// guardedTransport sends each HTTP attempt, retries included, through the
+// client's rate limiter and circuit breaker, if it has them.
+type guardedTransport struct {
+	s    *SatelliteInterface
+	next http.RoundTripper
+}
+
+func (t *guardedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.s.Limiter != nil {
+		if err := t.s.Limiter.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+	breaker := t.s.Breaker
+	if breaker == nil {
+		return t.next.RoundTrip(req)
+	}
+
+	endpoint := endpointKey(req)
+	generation, err := breaker.allow(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	switch {
+	case err != nil && req.Context().Err() != nil:
+		breaker.release(endpoint, generation)
+	case err != nil || resp.StatusCode >= 500:
+		breaker.record(endpoint, generation, true)
+	default:
+		breaker.record(endpoint, generation, false)
+	}
+	return resp, err
+}
+
+// endpointKey names the endpoint of req, with numeric IDs in its path replaced
+// by {id}, e.g. "GET /sensors/{id}"
+func endpointKey(req *http.Request) string {
+	parts := strings.Split(req.URL.Path, "/")
+	for i, part := range parts {
+		if part != "" && strings.Trim(part, "0123456789") == "" {
+			parts[i] = "{id}"
+		}
+	}
+	return req.Method + " " + strings.Join(parts, "/")
+}
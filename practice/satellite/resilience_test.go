@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestRateLimiter tests that the limiter allows a burst, then paces requests
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(20, 2)
+	start := time.Now()
+	for range 6 {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatalf("Wait failed: %v", err)
+		}
+	}
+	// 2 tokens at once, then 4 more at 20 per second
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Expected about 200ms for 6 requests, took %v", elapsed)
+	}
+	if stats := limiter.Stats(); stats.Requests != 6 || stats.Delayed != 4 || stats.TotalWait <= 0 {
+		t.Errorf("Expected 6 requests with 4 delayed, got %+v", stats)
+	}
+}
+
+// TestRateLimiterCanceled tests that a canceled wait gives its token back
+func TestRateLimiterCanceled(t *testing.T) {
+	limiter := NewRateLimiter(5, 1)
+	limiter.Wait(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to stop the wait, got %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if tokens := limiter.Tokens(); tokens < 0.9 {
+		t.Errorf("Expected a token after 200ms, got %.2f", tokens)
+	}
+	if stats := limiter.Stats(); stats.Requests != 1 {
+		t.Errorf("Expected the canceled request not to count, got %+v", stats)
+	}
+}
+
+// TestRateLimiterUnlimited tests that a rate that isn't positive lets every
+// request through at once
+func TestRateLimiterUnlimited(t *testing.T) {
+	for _, rate := range []float64{0, -1, math.NaN()} {
+		limiter := NewRateLimiter(rate, 1)
+		start := time.Now()
+		for range 100 {
+			if err := limiter.Wait(context.Background()); err != nil {
+				t.Fatalf("Wait failed: %v", err)
+			}
+		}
+		if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+			t.Errorf("Expected no waiting at rate %v, took %v", rate, elapsed)
+		}
+		if stats := limiter.Stats(); stats.Requests != 100 || stats.Delayed != 0 {
+			t.Errorf("Expected 100 requests, none delayed, at rate %v, got %+v", rate, stats)
+		}
+		if tokens := limiter.Tokens(); tokens != 1 {
+			t.Errorf("Expected a full bucket at rate %v, got %v", rate, tokens)
+		}
+	}
+}
+
+// TestCircuitBreaker tests the breaker's transitions through a client: it opens
+// after repeated failures, rejects without calling the server, and closes after
+// a successful probe
+func TestCircuitBreaker(t *testing.T) {
+	var failing atomic.Bool
+	var hits atomic.Int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if failing.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("[1]"))
+	})
+	client.Client.RetryMax = 0
+	var mu sync.Mutex
+	var transitions []string
+	client.Breaker = NewCircuitBreaker(BreakerConfig{
+		FailureThreshold: 3,
+		Cooldown:         100 * time.Millisecond,
+		OnStateChange: func(endpoint string, from, to BreakerState) {
+			mu.Lock()
+			defer mu.Unlock()
+			transitions = append(transitions, fmt.Sprintf("%s: %s->%s", endpoint, from, to))
+		},
+	})
+	const endpoint = "GET /sensor-ids"
+
+	failing.Store(true)
+	for range 3 {
+		client.GetSensorIDs(context.Background())
+	}
+	if state := client.Breaker.State(endpoint); state != StateOpen {
+		t.Fatalf("Expected the circuit to open after 3 failures, got %s", state)
+	}
+	_, err := client.GetSensorIDs(context.Background())
+	var apiErr *SatelliteAPIError
+	if !errors.Is(err, ErrCircuitOpen) || !errors.As(err, &apiErr) || hits.Load() != 3 {
+		t.Errorf("Expected a rejection without a request, got %v after %d requests", err, hits.Load())
+	}
+	if state := client.Breaker.State("GET /sensors/{id}"); state != StateClosed {
+		t.Errorf("Expected other endpoints to stay closed, got %s", state)
+	}
+
+	// A failed probe reopens the circuit
+	time.Sleep(120 * time.Millisecond)
+	if state := client.Breaker.State(endpoint); state != StateHalfOpen {
+		t.Fatalf("Expected half-open after the cooldown, got %s", state)
+	}
+	client.GetSensorIDs(context.Background())
+	if state := client.Breaker.State(endpoint); state != StateOpen {
+		t.Errorf("Expected a failed probe to reopen the circuit, got %s", state)
+	}
+
+	// A successful probe closes it
+	failing.Store(false)
+	time.Sleep(120 * time.Millisecond)
+	if ids, err := client.GetSensorIDs(context.Background()); err != nil || len(ids) != 1 {
+		t.Errorf("Expected the probe to succeed, got %v, %v", ids, err)
+	}
+	stats := client.Breaker.Stats()[endpoint]
+	if stats.State != StateClosed || stats.ConsecutiveFailures != 0 || stats.Rejected != 1 || stats.Opened != 2 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := fmt.Sprint([]string{
+		endpoint + ": closed->open", endpoint + ": open->half-open", endpoint + ": half-open->open",
+		endpoint + ": open->half-open", endpoint + ": half-open->closed",
+	})
+	if got := fmt.Sprint(transitions); got != want {
+		t.Errorf("Expected transitions %s, got %s", want, got)
+	}
+}
+
+// TestCircuitBreakerHalfOpenProbes tests that a half-open circuit lets only
+// HalfOpenProbes requests through at once
+func TestCircuitBreakerHalfOpenProbes(t *testing.T) {
+	breaker := NewCircuitBreaker(BreakerConfig{FailureThreshold: 1, HalfOpenProbes: 2})
+	gen, _ := breaker.allow("GET /x")
+	breaker.record("GET /x", gen, true)
+
+	// The cooldown is 0, so the circuit is half-open at once
+	first, err1 := breaker.allow("GET /x")
+	_, err2 := breaker.allow("GET /x")
+	if _, err := breaker.allow("GET /x"); err1 != nil || err2 != nil || !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected 2 probes and a rejection, got %v, %v, %v", err1, err2, err)
+	}
+	breaker.release("GET /x", first)
+	if _, err := breaker.allow("GET /x"); err != nil {
+		t.Errorf("Expected a released probe to free its slot, got %v", err)
+	}
+
+	// Results from before a transition don't count
+	breaker.record("GET /x", gen, false)
+	if state := breaker.State("GET /x"); state != StateHalfOpen {
+		t.Errorf("Expected a stale result to be ignored, got %s", state)
+	}
+}
+
+// TestGuardsWithMockServer tests the rate limiter and circuit breaker together
+// against the mock server at several failure rates
+func TestGuardsWithMockServer(t *testing.T) {
+	for _, unreliability := range []float64{0, 0.5, 1} {
+		t.Run(fmt.Sprintf("unreliability=%.1f", unreliability), func(t *testing.T) {
+			server := NewMockServer(unreliability, 0)
+			server.hang = 100 * time.Millisecond
+			var hits atomic.Int32
+			handler := server.Handler()
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				hits.Add(1)
+				handler.ServeHTTP(w, r)
+			})
+			client.Client.HTTPClient.Timeout = 50 * time.Millisecond
+			client.Client.RetryMax = 2
+			client.Limiter = NewRateLimiter(200, 5)
+			client.Breaker = NewCircuitBreaker(BreakerConfig{FailureThreshold: 3, Cooldown: 50 * time.Millisecond})
+
+			const calls = 30
+			succeeded := 0
+			start := time.Now()
+			for range calls {
+				if _, err := client.GetSensorIDs(context.Background()); err == nil {
+					succeeded++
+				}
+			}
+			elapsed := time.Since(start)
+
+			limited := client.Limiter.Stats()
+			if int(hits.Load()) > int(limited.Requests) || limited.Requests > calls*3 {
+				t.Errorf("Expected at most %d attempts, all through the limiter; got %d hits and %d let through", calls*3, hits.Load(), limited.Requests)
+			}
+			if minimum := time.Duration(limited.Requests-5) * time.Second / 200; elapsed < minimum {
+				t.Errorf("Expected %d requests to take at least %v at 200/s, took %v", limited.Requests, minimum, elapsed)
+			}
+
+			stats := client.Breaker.Stats()["GET /sensor-ids"]
+			switch unreliability {
+			case 0:
+				if succeeded != calls || hits.Load() != calls || stats.Opened != 0 {
+					t.Errorf("Expected every call to succeed at once, got %d successes, %d hits, %+v", succeeded, hits.Load(), stats)
+				}
+			case 1:
+				if succeeded != 0 || stats.Opened == 0 || stats.Rejected == 0 {
+					t.Errorf("Expected the circuit to open and reject calls, got %d successes, %+v", succeeded, stats)
+				}
+				if hits.Load() >= calls {
+					t.Errorf("Expected the breaker to spare the server, got %d hits for %d calls", hits.Load(), calls)
+				}
+			}
+			t.Logf("%d/%d calls succeeded with %d requests in %v; breaker %+v", succeeded, calls, hits.Load(), elapsed, stats)
+		})
+	}
+}
+
+// TestEndpointKey tests that IDs are stripped from breaker keys
+func TestEndpointKey(t *testing.T) {
+	for path, want := range map[string]string{
+		"/sensor-ids":   "GET /sensor-ids",
+		"/sensors/42":   "GET /sensors/{id}",
+		"/sensors/7/x1": "GET /sensors/{id}/x1",
+	} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if got := endpointKey(req); got != want {
+			t.Errorf("Expected %q for %s, got %q", want, path, got)
+		}
+	}
+}
@@ -4,9 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
@@ -20,6 +24,11 @@ const (
 	RequestTimeout = 30 * time.Second
 	// Max retries for transient errors (connection, 5xx server errors).
 	MaxRetries = 5
+	// How long GetSensor waits for a sensor to become ACTIVE, and how often it checks.
+	DefaultMaxWait      = 30 * time.Second
+	DefaultPollInterval = 2 * time.Second
+	// Sensors GetSensors fetches at once by default.
+	DefaultConcurrency = 8
 )
 
 // --- Data Models ---
@@ -48,25 +57,95 @@ type SensorCreateRequest struct {
 	Frequency int `json:"frequency"`
 }
 
-// --- Custom Error ---
+// SensorUpdateRequest is the payload for PATCH /sensors/<id>.
+type SensorUpdateRequest struct {
+	Frequency int `json:"frequency"`
+}
+
+// --- Custom Errors ---
 
 // SatelliteAPIError represents an error from the API, usually non-retriable 4xx or a
-// final 5xx error after all retries.
+// final 5xx error after all retries. StatusCode is 0 when no response came back at
+// all (connection errors, per-attempt timeouts), and Err holds the cause.
 type SatelliteAPIError struct {
 	StatusCode int
 	Message    string
+	Err        error
 }
 
 func (e *SatelliteAPIError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("API Error: %s: %v", e.Message, e.Err)
+	}
 	return fmt.Sprintf("API Error (Status %d): %s", e.StatusCode, e.Message)
 }
 
+func (e *SatelliteAPIError) Unwrap() error {
+	return e.Err
+}
+
+// ErrTimeout matches (with errors.Is) every TimeoutError.
+var ErrTimeout = errors.New("timed out")
+
+// TimeoutError is returned when the context's deadline (or GetSensor's MaxWait)
+// passes, during an HTTP request, its retries or status polling.
+type TimeoutError struct {
+	Op     string       // What was running, e.g. "GET /sensors/1"
+	Status SensorStatus // Last status seen while waiting for ACTIVE, if any
+	Err    error        // context.DeadlineExceeded
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Status != "" {
+		return fmt.Sprintf("%s: timed out (last status: %s)", e.Op, e.Status)
+	}
+	return fmt.Sprintf("%s: timed out", e.Op)
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// TerminalStateError is returned by GetSensor for a sensor that is FAILED or
+// TERMINATING and so will never become ACTIVE.
+type TerminalStateError struct {
+	ID     int
+	Status SensorStatus
+}
+
+func (e *TerminalStateError) Error() string {
+	return fmt.Sprintf("sensor %d is in terminal state: %s", e.ID, e.Status)
+}
+
+// contextError describes why ctx ended while op was running: a *TimeoutError
+// for a deadline, or the wrapped context.Canceled.
+func contextError(ctx context.Context, op string) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &TimeoutError{Op: op, Err: ctx.Err()}
+	}
+	return fmt.Errorf("%s: %w", op, ctx.Err())
+}
+
 // --- Interface Implementation ---
 
-// SatelliteInterface manages communication with the satellite API.
+// SatelliteInterface manages communication with the satellite API. Every method
+// takes a context whose deadline and cancellation cut short requests, retry
+// backoffs and status polling alike.
 type SatelliteInterface struct {
-	BaseURL string
-	Client  *retryablehttp.Client
+	BaseURL      string
+	Client       *retryablehttp.Client
+	MaxWait      time.Duration // Longest GetSensor waits for ACTIVE
+	PollInterval time.Duration // Time between GetSensor's status checks
+	// Limiter, if set, paces every HTTP attempt, retries included. Waiting for it
+	// counts toward the attempt's RequestTimeout.
+	Limiter *RateLimiter
+	// Breaker, if set, rejects requests to endpoints that keep failing, and stops
+	// their retries, until they recover.
+	Breaker *CircuitBreaker
 }
 
 // NewSatelliteInterface creates a new interface client configured for resilience.
@@ -84,19 +163,31 @@ func NewSatelliteInterface(baseURL string) *SatelliteInterface {
 			// Do NOT retry on most 4xx client errors
 			return false, nil
 		}
+		if errors.Is(err, ErrCircuitOpen) {
+			// Nor while the circuit breaker is open: it would reject the retries too
+			return false, err
+		}
 		// Use the default retry logic for transient errors (connection errors, 5xx, 429)
 		return retryablehttp.DefaultRetryPolicy(ctx, resp, err)
 	}
-
-	return &SatelliteInterface{
-		BaseURL: baseURL,
-		Client:  client,
+	// Hand back the last response once retries run out, so its status reaches
+	// SatelliteAPIError
+	client.ErrorHandler = retryablehttp.PassthroughErrorHandler
+
+	s := &SatelliteInterface{
+		BaseURL:      baseURL,
+		Client:       client,
+		MaxWait:      DefaultMaxWait,
+		PollInterval: DefaultPollInterval,
 	}
+	client.HTTPClient.Transport = &guardedTransport{s: s, next: client.HTTPClient.Transport}
+	return s
 }
 
 // internalRequest executes a resilient HTTP request and handles API errors.
-func (s *SatelliteInterface) internalRequest(method, endpoint string, reqBody interface{}, respData interface{}) error {
+func (s *SatelliteInterface) internalRequest(ctx context.Context, method, endpoint string, reqBody interface{}, respData interface{}) error {
 	url := s.BaseURL + endpoint
+	op := method + " " + endpoint
 
 	// 1. Prepare Request Body (if any)
 	var bodyReader io.Reader
@@ -109,7 +200,7 @@ func (s *SatelliteInterface) internalRequest(method, endpoint string, reqBody in
 	}
 
 	// Create the request
-	req, err := retryablehttp.NewRequest(method, url, bodyReader)
+	req, err := retryablehttp.NewRequestWithContext(ctx, method, url, bodyReader)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -118,8 +209,20 @@ func (s *SatelliteInterface) internalRequest(method, endpoint string, reqBody in
 	// 2. Execute Request
 	resp, err := s.Client.Do(req)
 	if err != nil {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if ctx.Err() != nil {
+			return contextError(ctx, op)
+		}
+		if errors.Is(err, ErrCircuitOpen) {
+			return &SatelliteAPIError{Message: fmt.Sprintf("Request to %s rejected", endpoint), Err: err}
+		}
 		// This includes errors after all retries have failed (timeout/connection issues)
-		return fmt.Errorf("request failed after %d retries: %w", s.Client.RetryMax, err)
+		return &SatelliteAPIError{
+			Message: fmt.Sprintf("Request to %s failed after %d retries", endpoint, s.Client.RetryMax),
+			Err:     err,
+		}
 	}
 	defer resp.Body.Close()
 
@@ -142,41 +245,91 @@ func (s *SatelliteInterface) internalRequest(method, endpoint string, reqBody in
 }
 
 // GetSensorIDs GET /sensor-ids
-func (s *SatelliteInterface) GetSensorIDs() ([]int, error) {
+func (s *SatelliteInterface) GetSensorIDs(ctx context.Context) ([]int, error) {
 	fmt.Println("Attempting to GET /sensor-ids...")
 	var ids []int
-	if err := s.internalRequest(http.MethodGet, "/sensor-ids", nil, &ids); err != nil {
+	if err := s.internalRequest(ctx, http.MethodGet, "/sensor-ids", nil, &ids); err != nil {
 		return nil, err
 	}
 	return ids, nil
 }
 
 // CreateSensor POST /sensors
-func (s *SatelliteInterface) CreateSensor(frequency int) (*Sensor, error) {
+func (s *SatelliteInterface) CreateSensor(ctx context.Context, frequency int) (*Sensor, error) {
 	fmt.Printf("Attempting to POST /sensors with frequency: %d...\n", frequency)
 	req := SensorCreateRequest{Frequency: frequency}
 	sensor := &Sensor{}
-	if err := s.internalRequest(http.MethodPost, "/sensors", req, sensor); err != nil {
+	if err := s.internalRequest(ctx, http.MethodPost, "/sensors", req, sensor); err != nil {
+		return nil, err
+	}
+	return sensor, nil
+}
+
+// UpdateSensor PATCH /sensors/<id>
+// Changes the sensor's frequency. An ACTIVE sensor goes RESTARTING to retune; use
+// GetSensor to wait for it. FAILED and TERMINATING sensors answer 409 Conflict.
+func (s *SatelliteInterface) UpdateSensor(ctx context.Context, id, frequency int) (*Sensor, error) {
+	endpoint := fmt.Sprintf("/sensors/%d", id)
+	fmt.Printf("Attempting to PATCH %s with frequency: %d...\n", endpoint, frequency)
+	sensor := &Sensor{}
+	if err := s.internalRequest(ctx, http.MethodPatch, endpoint, SensorUpdateRequest{Frequency: frequency}, sensor); err != nil {
+		return nil, err
+	}
+	return sensor, nil
+}
+
+// RestartSensor POST /sensors/<id>/restart
+// Returns the sensor RESTARTING; use GetSensor to wait for it to become ACTIVE (or
+// FAILED). TERMINATING sensors answer 409 Conflict.
+func (s *SatelliteInterface) RestartSensor(ctx context.Context, id int) (*Sensor, error) {
+	endpoint := fmt.Sprintf("/sensors/%d/restart", id)
+	fmt.Printf("Attempting to POST %s...\n", endpoint)
+	sensor := &Sensor{}
+	if err := s.internalRequest(ctx, http.MethodPost, endpoint, nil, sensor); err != nil {
+		return nil, err
+	}
+	return sensor, nil
+}
+
+// DeleteSensor DELETE /sensors/<id>
+// The sensor is TERMINATING for a while, then gone (404).
+func (s *SatelliteInterface) DeleteSensor(ctx context.Context, id int) (*Sensor, error) {
+	endpoint := fmt.Sprintf("/sensors/%d", id)
+	fmt.Printf("Attempting to DELETE %s...\n", endpoint)
+	sensor := &Sensor{}
+	if err := s.internalRequest(ctx, http.MethodDelete, endpoint, nil, sensor); err != nil {
 		return nil, err
 	}
 	return sensor, nil
 }
 
 // GetSensor GET /sensors/<id>
-// Automatically retries if sensor status is INITIALIZING or RESTARTING (up to 30 seconds)
-func (s *SatelliteInterface) GetSensor(id int) (*Sensor, error) {
+// Automatically retries if sensor status is INITIALIZING or RESTARTING, for up to
+// MaxWait or until ctx ends. Returns a *TerminalStateError for FAILED and
+// TERMINATING sensors and a *TimeoutError if it runs out of time.
+func (s *SatelliteInterface) GetSensor(ctx context.Context, id int) (*Sensor, error) {
+	return s.waitForSensor(ctx, id, s.MaxWait)
+}
+
+// waitForSensor is GetSensor, waiting up to maxWait.
+func (s *SatelliteInterface) waitForSensor(ctx context.Context, id int, maxWait time.Duration) (*Sensor, error) {
 	endpoint := fmt.Sprintf("/sensors/%d", id)
 	fmt.Printf("Attempting to GET %s...\n", endpoint)
 
-	maxWait := 30 * time.Second
-	pollInterval := 2 * time.Second
-	deadline := time.Now().Add(maxWait)
+	ctx, cancel := context.WithTimeout(ctx, maxWait)
+	defer cancel()
 
+	var status SensorStatus // Last status seen
 	for {
 		sensor := &Sensor{}
-		if err := s.internalRequest(http.MethodGet, endpoint, nil, sensor); err != nil {
+		if err := s.internalRequest(ctx, http.MethodGet, endpoint, nil, sensor); err != nil {
+			var timeout *TimeoutError
+			if errors.As(err, &timeout) {
+				timeout.Status = status
+			}
 			return nil, err
 		}
+		status = sensor.Status
 
 		// Return immediately if active
 		if sensor.Status == StatusActive {
@@ -185,30 +338,136 @@ func (s *SatelliteInterface) GetSensor(id int) (*Sensor, error) {
 
 		// Return error for terminal states
 		if sensor.Status == StatusFailed || sensor.Status == StatusTerminating {
-			return nil, fmt.Errorf("sensor %d is in terminal state: %s", id, sensor.Status)
-		}
-
-		// Check timeout
-		if time.Now().After(deadline) {
-			return nil, fmt.Errorf("timeout waiting for sensor %d to become ACTIVE (current status: %s)", id, sensor.Status)
+			return nil, &TerminalStateError{ID: id, Status: sensor.Status}
 		}
 
 		// Retry for INITIALIZING or RESTARTING states
 		fmt.Printf("   Sensor status: %s, retrying...\n", sensor.Status)
-		time.Sleep(pollInterval)
+		timer := time.NewTimer(s.PollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			err := contextError(ctx, fmt.Sprintf("waiting for sensor %d to become ACTIVE", id))
+			var timeout *TimeoutError
+			if errors.As(err, &timeout) {
+				timeout.Status = status
+			}
+			return nil, err
+		case <-timer.C:
+		}
 	}
 }
 
+// GetSensorsOptions configures GetSensors.
+type GetSensorsOptions struct {
+	Concurrency int           // Sensors fetched at once; DefaultConcurrency if 0
+	Timeout     time.Duration // How long each sensor may take, polling included; MaxWait if 0
+}
+
+// SensorResult is the outcome of fetching one sensor: Sensor, or Err.
+type SensorResult struct {
+	ID     int
+	Sensor *Sensor
+	Err    error
+}
+
+// GetSensors fetches the sensors ids with a pool of opts.Concurrency workers, each
+// waiting for its sensor to become ACTIVE as GetSensor does, but for at most
+// opts.Timeout. Results are sent as they arrive, in no particular order, and the
+// channel is closed once every ID has one. Once ctx ends, the IDs not yet fetched
+// get its error. The channel is buffered for all of ids, so the workers never wait
+// for the caller.
+func (s *SatelliteInterface) GetSensors(ctx context.Context, ids []int, opts GetSensorsOptions) <-chan SensorResult {
+	workers := opts.Concurrency
+	if workers <= 0 {
+		workers = DefaultConcurrency
+	}
+	workers = min(workers, len(ids))
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = s.MaxWait
+	}
+
+	jobs := make(chan int)
+	results := make(chan SensorResult, len(ids))
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				result := SensorResult{ID: id}
+				if ctx.Err() != nil {
+					result.Err = contextError(ctx, fmt.Sprintf("GET /sensors/%d", id))
+				} else {
+					result.Sensor, result.Err = s.waitForSensor(ctx, id, timeout)
+				}
+				results <- result
+			}
+		}()
+	}
+	go func() {
+		for _, id := range ids {
+			jobs <- id
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// SensorErrors maps sensor IDs to why GetSensors could not fetch them.
+type SensorErrors map[int]error
+
+func (e SensorErrors) Error() string {
+	ids := make([]int, 0, len(e))
+	for id := range e {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	msgs := make([]string, len(ids))
+	for i, id := range ids {
+		msgs[i] = fmt.Sprintf("sensor %d: %v", id, e[id])
+	}
+	return fmt.Sprintf("%d sensor(s) failed: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// CollectSensors drains the results of GetSensors, returning the sensors fetched,
+// sorted by ID, and the failures as SensorErrors (nil if there were none).
+func CollectSensors(results <-chan SensorResult) ([]*Sensor, error) {
+	var sensors []*Sensor
+	failed := SensorErrors{}
+	for result := range results {
+		if result.Err != nil {
+			failed[result.ID] = result.Err
+		} else {
+			sensors = append(sensors, result.Sensor)
+		}
+	}
+	sort.Slice(sensors, func(i, j int) bool { return sensors[i].ID < sensors[j].ID })
+	if len(failed) > 0 {
+		return sensors, failed
+	}
+	return sensors, nil
+}
+
 // --- Helper Functions ---
 
 func handleError(op string, err error) {
-	if apiErr, ok := err.(*SatelliteAPIError); ok {
+	var apiErr *SatelliteAPIError
+	var terminalErr *TerminalStateError
+	switch {
+	case errors.As(err, &apiErr) && apiErr.StatusCode != 0:
 		fmt.Printf("\n❌ A non-retriable API error occurred during %s:\n", op)
 		fmt.Printf("   Status: %d\n", apiErr.StatusCode)
 		fmt.Printf("   Message: %s\n", apiErr.Message)
-		// Optionally print the error body
-		// fmt.Printf("   Body: %+v\n", apiErr.Body)
-	} else {
+	case errors.Is(err, ErrTimeout):
+		fmt.Printf("\n❌ %s timed out:\n", op)
+		fmt.Printf("   Error: %v\n", err)
+	case errors.As(err, &terminalErr):
+		fmt.Printf("\n❌ %s failed: sensor %d is %s and will not become ACTIVE\n", op, terminalErr.ID, terminalErr.Status)
+	default:
 		fmt.Printf("\n❌ A critical error occurred during %s after all retries failed:\n", op)
 		fmt.Printf("   Error: %v\n", err)
 	}
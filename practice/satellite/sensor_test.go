@@ -1,6 +1,14 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -14,7 +22,7 @@ func TestSatelliteInterface_GetSensorIDs(t *testing.T) {
 	client := NewSatelliteInterface("http://localhost:8081")
 
 	// Initially should have no sensors
-	ids, err := client.GetSensorIDs()
+	ids, err := client.GetSensorIDs(context.Background())
 	if err != nil {
 		t.Fatalf("GetSensorIDs failed: %v", err)
 	}
@@ -23,7 +31,7 @@ func TestSatelliteInterface_GetSensorIDs(t *testing.T) {
 	}
 
 	// Create a sensor
-	sensor, err := client.CreateSensor(100)
+	sensor, err := client.CreateSensor(context.Background(), 100)
 	if err != nil {
 		t.Fatalf("CreateSensor failed: %v", err)
 	}
@@ -32,7 +40,7 @@ func TestSatelliteInterface_GetSensorIDs(t *testing.T) {
 	}
 
 	// Now should have 1 sensor
-	ids, err = client.GetSensorIDs()
+	ids, err = client.GetSensorIDs(context.Background())
 	if err != nil {
 		t.Fatalf("GetSensorIDs failed: %v", err)
 	}
@@ -48,7 +56,7 @@ func TestSatelliteInterface_CreateSensor(t *testing.T) {
 
 	client := NewSatelliteInterface("http://localhost:8082")
 
-	sensor, err := client.CreateSensor(42)
+	sensor, err := client.CreateSensor(context.Background(), 42)
 	if err != nil {
 		t.Fatalf("CreateSensor failed: %v", err)
 	}
@@ -72,13 +80,13 @@ func TestSatelliteInterface_GetSensor(t *testing.T) {
 	client := NewSatelliteInterface("http://localhost:8083")
 
 	// Create a sensor
-	created, err := client.CreateSensor(99)
+	created, err := client.CreateSensor(context.Background(), 99)
 	if err != nil {
 		t.Fatalf("CreateSensor failed: %v", err)
 	}
 
 	// Get the sensor
-	fetched, err := client.GetSensor(created.ID)
+	fetched, err := client.GetSensor(context.Background(), created.ID)
 	if err != nil {
 		t.Fatalf("GetSensor failed: %v", err)
 	}
@@ -99,7 +107,7 @@ func TestSatelliteInterface_GetSensor_NotFound(t *testing.T) {
 	client := NewSatelliteInterface("http://localhost:8084")
 
 	// Try to get non-existent sensor
-	_, err := client.GetSensor(999)
+	_, err := client.GetSensor(context.Background(), 999)
 	if err == nil {
 		t.Error("Expected error for non-existent sensor")
 	}
@@ -123,7 +131,7 @@ func TestSatelliteInterface_WithUnreliability(t *testing.T) {
 	client := NewSatelliteInterface("http://localhost:8085")
 
 	// Should eventually succeed despite unreliability
-	sensor, err := client.CreateSensor(123)
+	sensor, err := client.CreateSensor(context.Background(), 123)
 	if err != nil {
 		t.Logf("CreateSensor failed even with retries: %v", err)
 		// Don't fail the test - unreliability might cause all retries to fail
@@ -141,7 +149,7 @@ func TestSatelliteInterface_InvalidFrequency(t *testing.T) {
 	client := NewSatelliteInterface("http://localhost:8086")
 
 	// Try to create sensor with invalid frequency
-	_, err := client.CreateSensor(-1)
+	_, err := client.CreateSensor(context.Background(), -1)
 	if err == nil {
 		t.Error("Expected error for invalid frequency")
 	}
@@ -164,7 +172,7 @@ func TestSatelliteInterface_RetryOnServerError(t *testing.T) {
 
 	// This should retry multiple times due to 5xx errors
 	start := time.Now()
-	_, err := client.GetSensorIDs()
+	_, err := client.GetSensorIDs(context.Background())
 	duration := time.Since(start)
 
 	// If it retried, it should take longer than a single request
@@ -184,7 +192,7 @@ func TestSatelliteInterface_CustomErrorType(t *testing.T) {
 	client := NewSatelliteInterface("http://localhost:8088")
 
 	// Test that 404 returns proper error type
-	_, err := client.GetSensor(999)
+	_, err := client.GetSensor(context.Background(), 999)
 	if err == nil {
 		t.Fatal("Expected error for non-existent sensor")
 	}
@@ -216,7 +224,7 @@ func TestSatelliteInterface_ConcurrentRequests(t *testing.T) {
 	done := make(chan bool, 5)
 	for i := 0; i < 5; i++ {
 		go func(freq int) {
-			_, err := client.CreateSensor(freq)
+			_, err := client.CreateSensor(context.Background(), freq)
 			if err != nil {
 				t.Logf("Concurrent create failed for freq %d: %v", freq, err)
 			}
@@ -230,7 +238,7 @@ func TestSatelliteInterface_ConcurrentRequests(t *testing.T) {
 	}
 
 	// Verify we can still query the server
-	ids, err := client.GetSensorIDs()
+	ids, err := client.GetSensorIDs(context.Background())
 	if err != nil {
 		t.Fatalf("GetSensorIDs failed after concurrent creates: %v", err)
 	}
@@ -247,14 +255,14 @@ func TestSatelliteInterface_GetSensorWithRetry(t *testing.T) {
 	client := NewSatelliteInterface("http://localhost:8090")
 
 	// Create a sensor
-	newSensor, err := client.CreateSensor(42)
+	newSensor, err := client.CreateSensor(context.Background(), 42)
 	if err != nil {
 		t.Fatalf("CreateSensor failed: %v", err)
 	}
 
 	// GetSensor should automatically wait for it to become active
 	start := time.Now()
-	sensor, err := client.GetSensor(newSensor.ID)
+	sensor, err := client.GetSensor(context.Background(), newSensor.ID)
 	elapsed := time.Since(start)
 
 	if err != nil {
@@ -267,3 +275,301 @@ func TestSatelliteInterface_GetSensorWithRetry(t *testing.T) {
 
 	t.Logf("GetSensor returned active sensor in %v", elapsed)
 }
+
+// newTestClient returns a client for handler with fast retries and polling
+func newTestClient(t *testing.T, handler http.HandlerFunc) *SatelliteInterface {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	client := NewSatelliteInterface(srv.URL)
+	client.Client.RetryWaitMin = 10 * time.Millisecond
+	client.Client.RetryWaitMax = 10 * time.Millisecond
+	client.Client.Logger = nil
+	client.PollInterval = 20 * time.Millisecond
+	return client
+}
+
+// sensorHandler answers every request with a sensor in status
+func sensorHandler(status SensorStatus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Sensor{ID: 1, Status: status})
+	}
+}
+
+// TestSatelliteInterface_GetSensorDeadline tests that the context's deadline
+// stops status polling with a TimeoutError
+func TestSatelliteInterface_GetSensorDeadline(t *testing.T) {
+	client := newTestClient(t, sensorHandler(StatusInitializing))
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.GetSensor(ctx, 1)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected GetSensor to stop at the deadline, took %v", elapsed)
+	}
+	var timeout *TimeoutError
+	if !errors.Is(err, ErrTimeout) || !errors.As(err, &timeout) {
+		t.Fatalf("Expected a TimeoutError, got %T: %v", err, err)
+	}
+	if timeout.Status != StatusInitializing || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the last status and the deadline as cause, got %+v", timeout)
+	}
+}
+
+// TestSatelliteInterface_GetSensorMaxWait tests that MaxWait bounds polling
+// without a deadline on the context
+func TestSatelliteInterface_GetSensorMaxWait(t *testing.T) {
+	client := newTestClient(t, sensorHandler(StatusRestarting))
+	client.MaxWait = 100 * time.Millisecond
+
+	_, err := client.GetSensor(context.Background(), 1)
+	var timeout *TimeoutError
+	if !errors.As(err, &timeout) || timeout.Status != StatusRestarting {
+		t.Errorf("Expected a TimeoutError after RESTARTING, got %v", err)
+	}
+}
+
+// TestSatelliteInterface_GetSensorCanceled tests that cancellation is not
+// reported as a timeout
+func TestSatelliteInterface_GetSensorCanceled(t *testing.T) {
+	client := newTestClient(t, sensorHandler(StatusInitializing))
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	_, err := client.GetSensor(ctx, 1)
+	if !errors.Is(err, context.Canceled) || errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+// TestSatelliteInterface_TerminalState tests the error for sensors that
+// will never become ACTIVE
+func TestSatelliteInterface_TerminalState(t *testing.T) {
+	for _, status := range []SensorStatus{StatusFailed, StatusTerminating} {
+		client := newTestClient(t, sensorHandler(status))
+		_, err := client.GetSensor(context.Background(), 1)
+		var terminal *TerminalStateError
+		if !errors.As(err, &terminal) || terminal.Status != status || terminal.ID != 1 {
+			t.Errorf("Expected a TerminalStateError for %s, got %v", status, err)
+		}
+	}
+}
+
+// TestSatelliteInterface_DeadlineDuringRetries tests that the deadline cuts
+// short the retry backoff
+func TestSatelliteInterface_DeadlineDuringRetries(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	})
+	client.Client.RetryWaitMin = time.Second
+	client.Client.RetryWaitMax = time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.GetSensorIDs(ctx)
+	if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+		t.Errorf("Expected the deadline to interrupt the backoff, took %v", elapsed)
+	}
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+}
+
+// TestSatelliteInterface_APIFailure tests that a final 5xx and a dead
+// connection are both SatelliteAPIErrors
+func TestSatelliteInterface_APIFailure(t *testing.T) {
+	attempts := 0
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	})
+	client.Client.RetryMax = 2
+	_, err := client.CreateSensor(context.Background(), 1)
+	var apiErr *SatelliteAPIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected a 503 SatelliteAPIError, got %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+
+	client = NewSatelliteInterface("http://127.0.0.1:1")
+	client.Client.RetryMax = 0
+	client.Client.Logger = nil
+	_, err = client.GetSensorIDs(context.Background())
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 0 || apiErr.Err == nil || errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected a SatelliteAPIError without status, got %v", err)
+	}
+}
+
+// TestSatelliteInterface_GetSensors tests that GetSensors returns every sensor
+// it can, an error for each it can't, and never exceeds its concurrency
+func TestSatelliteInterface_GetSensors(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(20 * time.Millisecond)
+
+		id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/sensors/"))
+		switch {
+		case id == 404:
+			http.Error(w, "not found", http.StatusNotFound)
+		case id == 500:
+			json.NewEncoder(w).Encode(Sensor{ID: id, Status: StatusInitializing})
+		default:
+			json.NewEncoder(w).Encode(Sensor{ID: id, Frequency: id, Status: StatusActive})
+		}
+	})
+
+	ids := []int{404, 500}
+	for id := 1; id <= 20; id++ {
+		ids = append(ids, id)
+	}
+	start := time.Now()
+	sensors, err := CollectSensors(client.GetSensors(context.Background(), ids, GetSensorsOptions{
+		Concurrency: 4,
+		Timeout:     200 * time.Millisecond,
+	}))
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the stuck sensor to time out on its own, took %v", elapsed)
+	}
+
+	if len(sensors) != 20 || sensors[0].ID != 1 || sensors[19].ID != 20 {
+		t.Errorf("Expected sensors 1 to 20 in order, got %d sensors", len(sensors))
+	}
+	var failed SensorErrors
+	if !errors.As(err, &failed) || len(failed) != 2 {
+		t.Fatalf("Expected 2 SensorErrors, got %v", err)
+	}
+	var apiErr *SatelliteAPIError
+	if !errors.As(failed[404], &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected a 404 for sensor 404, got %v", failed[404])
+	}
+	if !errors.Is(failed[500], ErrTimeout) {
+		t.Errorf("Expected sensor 500 to time out, got %v", failed[500])
+	}
+	if p := peak.Load(); p > 4 || p < 2 {
+		t.Errorf("Expected at most 4 requests at once, got %d", p)
+	}
+}
+
+// TestSatelliteInterface_GetSensorsCanceled tests that every ID still gets a
+// result once the context is canceled
+func TestSatelliteInterface_GetSensorsCanceled(t *testing.T) {
+	client := newTestClient(t, sensorHandler(StatusInitializing))
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	results := client.GetSensors(ctx, []int{1, 2, 3, 4, 5, 6}, GetSensorsOptions{Concurrency: 2})
+	n := 0
+	for result := range results {
+		n++
+		if !errors.Is(result.Err, context.Canceled) {
+			t.Errorf("Expected sensor %d to be canceled, got %v", result.ID, result.Err)
+		}
+	}
+	if n != 6 {
+		t.Errorf("Expected 6 results, got %d", n)
+	}
+
+	if sensors, err := CollectSensors(client.GetSensors(ctx, nil, GetSensorsOptions{})); sensors != nil || err != nil {
+		t.Errorf("Expected nothing for no IDs, got %v, %v", sensors, err)
+	}
+}
+
+// newLifecycleServer returns a client for a reliable mock server whose sensor
+// lifecycle runs in milliseconds
+func newLifecycleServer(t *testing.T, failureRate float64) (*MockServer, *SatelliteInterface) {
+	t.Helper()
+	server := NewMockServer(0, 0)
+	server.tick = 5 * time.Millisecond
+	server.failureRate = failureRate
+	client := newTestClient(t, server.Handler().ServeHTTP)
+	return server, client
+}
+
+// TestSatelliteInterface_SensorLifecycle tests updating, restarting and deleting
+// a sensor, and what GetSensor sees along the way
+func TestSatelliteInterface_SensorLifecycle(t *testing.T) {
+	_, client := newLifecycleServer(t, 0)
+	ctx := context.Background()
+	created, _ := client.CreateSensor(ctx, 10)
+	if _, err := client.GetSensor(ctx, created.ID); err != nil {
+		t.Fatalf("GetSensor failed: %v", err)
+	}
+
+	// Retuning an ACTIVE sensor restarts it
+	sensor, err := client.UpdateSensor(ctx, created.ID, 20)
+	if err != nil || sensor.Status != StatusRestarting || sensor.Frequency != 20 || sensor.Measurement != nil {
+		t.Fatalf("Expected the sensor RESTARTING at frequency 20, got %+v, %v", sensor, err)
+	}
+	if sensor, err = client.GetSensor(ctx, created.ID); err != nil || sensor.Frequency != 20 || sensor.Measurement == nil {
+		t.Errorf("Expected the sensor ACTIVE again, got %+v, %v", sensor, err)
+	}
+
+	sensor, err = client.RestartSensor(ctx, created.ID)
+	if err != nil || sensor.Status != StatusRestarting {
+		t.Fatalf("Expected the sensor RESTARTING, got %+v, %v", sensor, err)
+	}
+	if _, err = client.GetSensor(ctx, created.ID); err != nil {
+		t.Errorf("Expected the sensor ACTIVE after restarting, got %v", err)
+	}
+
+	// A deleted sensor is TERMINATING, then gone
+	if sensor, err = client.DeleteSensor(ctx, created.ID); err != nil || sensor.Status != StatusTerminating {
+		t.Fatalf("Expected the sensor TERMINATING, got %+v, %v", sensor, err)
+	}
+	var terminal *TerminalStateError
+	if _, err = client.GetSensor(ctx, created.ID); !errors.As(err, &terminal) || terminal.Status != StatusTerminating {
+		t.Errorf("Expected a TerminalStateError for TERMINATING, got %v", err)
+	}
+	var apiErr *SatelliteAPIError
+	if _, err = client.RestartSensor(ctx, created.ID); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusConflict {
+		t.Errorf("Expected 409 restarting a TERMINATING sensor, got %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err = client.GetSensor(ctx, created.ID); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 once deleted, got %v", err)
+	}
+	if ids, _ := client.GetSensorIDs(ctx); len(ids) != 0 {
+		t.Errorf("Expected no sensors left, got %v", ids)
+	}
+	if _, err = client.DeleteSensor(ctx, created.ID); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 deleting again, got %v", err)
+	}
+}
+
+// TestSatelliteInterface_RestartFailedSensor tests that a FAILED sensor can't be
+// updated, but can be restarted
+func TestSatelliteInterface_RestartFailedSensor(t *testing.T) {
+	server, client := newLifecycleServer(t, 1)
+	ctx := context.Background()
+	created, _ := client.CreateSensor(ctx, 10)
+
+	var terminal *TerminalStateError
+	if _, err := client.GetSensor(ctx, created.ID); !errors.As(err, &terminal) || terminal.Status != StatusFailed {
+		t.Fatalf("Expected a TerminalStateError for FAILED, got %v", err)
+	}
+	var apiErr *SatelliteAPIError
+	if _, err := client.UpdateSensor(ctx, created.ID, 20); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusConflict {
+		t.Errorf("Expected 409 updating a FAILED sensor, got %v", err)
+	}
+	if _, err := client.UpdateSensor(ctx, created.ID, -1); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a negative frequency, got %v", err)
+	}
+
+	server.mu.Lock()
+	server.failureRate = 0
+	server.mu.Unlock()
+	if _, err := client.RestartSensor(ctx, created.ID); err != nil {
+		t.Fatalf("RestartSensor failed: %v", err)
+	}
+	if sensor, err := client.GetSensor(ctx, created.ID); err != nil || sensor.Status != StatusActive {
+		t.Errorf("Expected the sensor ACTIVE after restarting, got %+v, %v", sensor, err)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Reading is a new measurement from a sensor, or Err if polling it failed.
+type Reading struct {
+	SensorID int
+	Time     time.Time
+	Value    float64
+	Err      error
+}
+
+// StreamMeasurements polls each of the sensors ids every interval and sends their
+// measurements as they change: a value equal to the sensor's last one is not sent
+// again. Sensors that are not ACTIVE send nothing, except a *TerminalStateError
+// when they become FAILED or TERMINATING; they are still polled in case they come
+// back. Failed polls send their error, usually a *SatelliteAPIError, and polling
+// carries on, except for a sensor that no longer exists (404). The channel is
+// closed once ctx ends.
+func (s *SatelliteInterface) StreamMeasurements(ctx context.Context, ids []int, interval time.Duration) <-chan Reading {
+	readings := make(chan Reading)
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.watchSensor(ctx, id, interval, readings)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(readings)
+	}()
+	return readings
+}
+
+// watchSensor polls one sensor for StreamMeasurements until ctx ends or the
+// sensor is gone.
+func (s *SatelliteInterface) watchSensor(ctx context.Context, id int, interval time.Duration, readings chan<- Reading) {
+	endpoint := fmt.Sprintf("/sensors/%d", id)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last *float64       // Last value sent
+	var status SensorStatus // Last status seen
+	for {
+		sensor := &Sensor{}
+		err := s.internalRequest(ctx, http.MethodGet, endpoint, nil, sensor)
+		if ctx.Err() != nil {
+			return
+		}
+
+		var reading *Reading
+		var apiErr *SatelliteAPIError
+		gone := errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+		switch {
+		case err != nil:
+			reading = &Reading{SensorID: id, Time: time.Now(), Err: err}
+		case sensor.Status != status && (sensor.Status == StatusFailed || sensor.Status == StatusTerminating):
+			reading = &Reading{SensorID: id, Time: time.Now(), Err: &TerminalStateError{ID: id, Status: sensor.Status}}
+		case sensor.Status == StatusActive && sensor.Measurement != nil && (last == nil || *last != *sensor.Measurement):
+			last = sensor.Measurement
+			reading = &Reading{SensorID: id, Time: time.Now(), Value: *sensor.Measurement}
+		}
+		if err == nil {
+			status = sensor.Status
+		}
+
+		if reading != nil {
+			select {
+			case readings <- *reading:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if gone {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
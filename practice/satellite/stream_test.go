@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sequenceHandler answers the nth request for /sensors/1 with replies[n] (the last
+// one from then on): a status code, or a sensor. /sensors/2 doesn't exist.
+func sequenceHandler(replies []any) http.HandlerFunc {
+	var mu sync.Mutex
+	n := 0
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sensors/1" {
+			http.Error(w, "Sensor not found", http.StatusNotFound)
+			return
+		}
+		mu.Lock()
+		reply := replies[min(n, len(replies)-1)]
+		n++
+		mu.Unlock()
+		if code, ok := reply.(int); ok {
+			http.Error(w, "down", code)
+			return
+		}
+		json.NewEncoder(w).Encode(reply)
+	}
+}
+
+func activeSensor(value float64) Sensor {
+	return Sensor{ID: 1, Status: StatusActive, Measurement: &value}
+}
+
+// TestStreamMeasurements tests that the stream skips repeated values and
+// inactive states, reports errors and failures, and goes on after them
+func TestStreamMeasurements(t *testing.T) {
+	client := newTestClient(t, sequenceHandler([]any{
+		Sensor{ID: 1, Status: StatusInitializing},
+		http.StatusServiceUnavailable,
+		activeSensor(10),
+		activeSensor(10),
+		http.StatusServiceUnavailable,
+		activeSensor(11),
+		Sensor{ID: 1, Status: StatusFailed},
+		Sensor{ID: 1, Status: StatusFailed},
+		Sensor{ID: 1, Status: StatusRestarting},
+		activeSensor(12),
+	}))
+	client.Client.RetryMax = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var got []string
+	var gone error
+	for reading := range client.StreamMeasurements(ctx, []int{1, 2}, 10*time.Millisecond) {
+		var apiErr *SatelliteAPIError
+		var terminal *TerminalStateError
+		switch {
+		case reading.SensorID == 2:
+			if gone != nil {
+				t.Errorf("Expected sensor 2 to stop being polled after a 404")
+			}
+			gone = reading.Err
+		case errors.As(reading.Err, &apiErr):
+			got = append(got, "503")
+		case errors.As(reading.Err, &terminal):
+			got = append(got, string(terminal.Status))
+		case reading.Err != nil:
+			t.Fatalf("Unexpected error: %v", reading.Err)
+		default:
+			got = append(got, formatValue(reading.Value))
+		}
+		if len(got) == 6 {
+			// Give sensor 1 time to repeat its last value, which mustn't be sent
+			time.AfterFunc(50*time.Millisecond, cancel)
+		}
+	}
+
+	if want := "503 10 503 11 FAILED 12"; strings.Join(got, " ") != want {
+		t.Errorf("Expected %s, got %s", want, strings.Join(got, " "))
+	}
+	var apiErr *SatelliteAPIError
+	if !errors.As(gone, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected a 404 for sensor 2, got %v", gone)
+	}
+}
+
+// TestRecordStreamWithErrors tests that recording a stream from a flaky server
+// keeps every value the server managed to send
+func TestRecordStreamWithErrors(t *testing.T) {
+	// Every other request fails; the others count up from 1
+	var replies []any
+	for i := 1; i <= 20; i++ {
+		replies = append(replies, http.StatusServiceUnavailable, activeSensor(float64(i)))
+	}
+	client := newTestClient(t, sequenceHandler(replies))
+	client.Client.RetryMax = 0
+	path := filepath.Join(t.TempDir(), "readings.jsonl")
+	recorder, err := NewRecorder(path, FormatJSONL, time.Hour)
+	if err != nil {
+		t.Fatalf("NewRecorder failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	failures := 0
+	for reading := range client.StreamMeasurements(ctx, []int{1}, 5*time.Millisecond) {
+		if reading.Err != nil {
+			failures++
+		} else if reading.Value == 20 {
+			cancel()
+		}
+		if err := recorder.Record(reading); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if failures != 20 || len(lines) != 21 {
+		t.Fatalf("Expected 20 failures, 20 readings and a rollup, got %d failures and:\n%s", failures, data)
+	}
+	for i, line := range lines[:20] {
+		var rec readingRecord
+		json.Unmarshal([]byte(line), &rec)
+		if rec.Kind != "reading" || rec.Value != float64(i+1) {
+			t.Errorf("Expected reading %d, got %s", i+1, line)
+		}
+	}
+	if !strings.Contains(lines[20], `"min":1,"max":20,"avg":10.5,"count":20`) {
+		t.Errorf("Expected a rollup of 1 to 20, got %s", lines[20])
+	}
+}
@@ -0,0 +1,230 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Harness runs a whole cluster in one process over a Network, for tests
+// that partition nodes and then check the replicas still agree
+type Harness struct {
+	Network *Network
+
+	cfg Config // Template for every node's Config
+	dir string
+	ids []string
+
+	mu    sync.Mutex
+	nodes map[string]*Node
+}
+
+// NewHarness starts n nodes, n1 to n<n>, each keeping its state under dir.
+// cfg sets the timeouts and snapshot threshold; ID, Peers and Dir are
+// filled in per node.
+func NewHarness(n int, dir string, cfg Config) (*Harness, error) {
+	h := &Harness{
+		Network: NewNetwork(),
+		cfg:     cfg,
+		dir:     dir,
+		nodes:   make(map[string]*Node),
+	}
+	for i := 1; i <= n; i++ {
+		h.ids = append(h.ids, fmt.Sprintf("n%d", i))
+	}
+	for _, id := range h.ids {
+		if err := h.startNode(id); err != nil {
+			h.Stop()
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// startNode creates node id from its directory and connects it
+func (h *Harness) startNode(id string) error {
+	cfg := h.cfg
+	cfg.ID, cfg.Peers, cfg.Dir = id, h.ids, filepath.Join(h.dir, id)
+	node, err := NewNode(cfg, h.Network.Transport(id))
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	h.nodes[id] = node
+	h.mu.Unlock()
+	h.Network.Add(node)
+	node.Start()
+	return nil
+}
+
+// IDs returns the node IDs
+func (h *Harness) IDs() []string {
+	return slices.Clone(h.ids)
+}
+
+// Node returns node id
+func (h *Harness) Node(id string) *Node {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.nodes[id]
+}
+
+// Nodes returns every node in ID order
+func (h *Harness) Nodes() []*Node {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	nodes := make([]*Node, len(h.ids))
+	for i, id := range h.ids {
+		nodes[i] = h.nodes[id]
+	}
+	return nodes
+}
+
+// Leader returns the node that thinks it is leader in the highest term, or
+// nil. Right after a partition that may be a stale leader that can no
+// longer commit anything.
+func (h *Harness) Leader() *Node {
+	var leader *Node
+	var term uint64
+	for _, node := range h.Nodes() {
+		if s := node.Status(); s.Role == Leader && s.Term > term {
+			leader, term = node, s.Term
+		}
+	}
+	return leader
+}
+
+// WaitForLeader waits until some node is leader
+func (h *Harness) WaitForLeader(ctx context.Context) (*Node, error) {
+	for {
+		if leader := h.Leader(); leader != nil {
+			return leader, nil
+		}
+		select {
+		case <-time.After(h.cfg.HeartbeatInterval):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("no leader elected: %w", ctx.Err())
+		}
+	}
+}
+
+// Partition splits the network into groups (see Network.Partition)
+func (h *Harness) Partition(groups ...[]string) {
+	h.Network.Partition(groups...)
+}
+
+// Heal reconnects every node
+func (h *Harness) Heal() {
+	h.Network.Heal()
+}
+
+// Restart stops node id and starts a new node from its saved state, as if
+// its process had been restarted
+func (h *Harness) Restart(id string) error {
+	h.Node(id).Stop()
+	return h.startNode(id)
+}
+
+// Do runs op against the current leader, retrying with the next leader
+// while nodes answer ErrNotLeader (which means op didn't happen). Each
+// attempt gets at most attempt of ctx's time; an attempt that times out
+// is returned, since op may or may not have happened.
+func (h *Harness) Do(ctx context.Context, attempt time.Duration, op func(ctx context.Context, leader *Node) error) error {
+	for {
+		if leader := h.Leader(); leader != nil {
+			attemptCtx, cancel := context.WithTimeout(ctx, attempt)
+			err := op(attemptCtx, leader)
+			cancel()
+			if !errors.Is(err, ErrNotLeader) && !errors.Is(err, ErrStopped) {
+				return err
+			}
+		}
+		select {
+		case <-time.After(h.cfg.HeartbeatInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// WaitConverged waits until every node has applied the same entries, then
+// checks they hold the same data and checkpoints. Heal first: a node that
+// is cut off won't catch up.
+func (h *Harness) WaitConverged(ctx context.Context) error {
+	for {
+		var applied []uint64
+		for _, node := range h.Nodes() {
+			s := node.Status()
+			applied = append(applied, s.LastApplied, s.CommitIndex)
+		}
+		if slices.Min(applied) == slices.Max(applied) {
+			// A node may apply more entries while the states are read; then
+			// they aren't all compared, so try again
+			points, err := h.consistency()
+			if err != nil || points == 1 {
+				return err
+			}
+		}
+		select {
+		case <-time.After(h.cfg.HeartbeatInterval):
+		case <-ctx.Done():
+			return fmt.Errorf("nodes didn't converge (applied/commit %v): %w", applied, ctx.Err())
+		}
+	}
+}
+
+// CheckConsistency compares every pair of nodes that have applied the same
+// number of entries: they must hold the same data and checkpoints. Nodes
+// at different points in the log aren't compared.
+func (h *Harness) CheckConsistency() error {
+	_, err := h.consistency()
+	return err
+}
+
+// consistency runs CheckConsistency and returns the number of distinct
+// points in the log the nodes were at
+func (h *Harness) consistency() (int, error) {
+	type replica struct {
+		id          string
+		data        map[string]string
+		checkpoints []string
+	}
+	seen := make(map[uint64]replica)
+	for _, node := range h.Nodes() {
+		applied, data, infos := node.localState()
+		var checkpoints []string
+		for _, info := range infos {
+			checkpoints = append(checkpoints, fmt.Sprintf("%d:%s", info.ID, info.Name))
+		}
+		r, ok := seen[applied]
+		if !ok {
+			seen[applied] = replica{node.ID(), data, checkpoints}
+			continue
+		}
+		if !maps.Equal(r.data, data) {
+			return 0, fmt.Errorf("%s and %s applied %d entries but hold different data: %v vs %v",
+				r.id, node.ID(), applied, r.data, data)
+		}
+		if !slices.Equal(r.checkpoints, checkpoints) {
+			return 0, fmt.Errorf("%s and %s applied %d entries but hold different checkpoints: %v vs %v",
+				r.id, node.ID(), applied, r.checkpoints, checkpoints)
+		}
+	}
+	return len(seen), nil
+}
+
+// Stop stops every node
+func (h *Harness) Stop() {
+	for _, node := range h.Nodes() {
+		if node != nil {
+			node.Stop()
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// HTTPTransport sends RPCs as JSON POSTs to the /raft/ endpoints that
+// NewHandler serves on each node
+type HTTPTransport struct {
+	addrs  map[string]string // Node ID -> host:port
+	client *http.Client
+}
+
+// NewHTTPTransport creates a transport for a cluster whose node IDs map to
+// addrs (host:port)
+func NewHTTPTransport(addrs map[string]string) *HTTPTransport {
+	return &HTTPTransport{addrs: addrs, client: &http.Client{}}
+}
+
+func (t *HTTPTransport) RequestVote(ctx context.Context, to string, req *RequestVoteRequest) (*RequestVoteResponse, error) {
+	resp := &RequestVoteResponse{}
+	return resp, t.call(ctx, to, "/raft/vote", req, resp)
+}
+
+func (t *HTTPTransport) AppendEntries(ctx context.Context, to string, req *AppendEntriesRequest) (*AppendEntriesResponse, error) {
+	resp := &AppendEntriesResponse{}
+	return resp, t.call(ctx, to, "/raft/append", req, resp)
+}
+
+func (t *HTTPTransport) InstallSnapshot(ctx context.Context, to string, req *InstallSnapshotRequest) (*InstallSnapshotResponse, error) {
+	resp := &InstallSnapshotResponse{}
+	return resp, t.call(ctx, to, "/raft/snapshot", req, resp)
+}
+
+// call POSTs req to path on node to and decodes the reply into resp
+func (t *HTTPTransport) call(ctx context.Context, to, path string, req, resp any) error {
+	addr, ok := t.addrs[to]
+	if !ok {
+		return fmt.Errorf("%w: unknown node %s", ErrUnreachable, to)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+addr+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpResp, err := t.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnreachable, err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(httpResp.Body, 512))
+		return fmt.Errorf("%s%s: %s: %s", addr, path, httpResp.Status, bytes.TrimSpace(msg))
+	}
+	if err := json.NewDecoder(httpResp.Body).Decode(resp); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// maxValueSize caps the body of a PUT /kv/{key}
+const maxValueSize = 1 << 20
+
+// NewHandler serves node's Raft RPCs under /raft/ and a client API:
+//
+//	GET    /kv/{key}            value, or 404
+//	PUT    /kv/{key}            body is the value; 204
+//	DELETE /kv/{key}            204, or 404 if it didn't exist
+//	POST   /checkpoint?name=    {"id": n}
+//	POST   /revert              204, or 409 if there is no checkpoint
+//	GET    /status              the node's Status as JSON
+//
+// A follower redirects client requests to the leader (307, using addrs to
+// find it), or answers 503 while there is none.
+func NewHandler(node *Node, addrs map[string]string) http.Handler {
+	h := &handler{node: node, addrs: addrs}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /raft/vote", rpcHandler(node.HandleRequestVote))
+	mux.HandleFunc("POST /raft/append", rpcHandler(node.HandleAppendEntries))
+	mux.HandleFunc("POST /raft/snapshot", rpcHandler(node.HandleInstallSnapshot))
+	mux.HandleFunc("GET /kv/{key}", h.get)
+	mux.HandleFunc("PUT /kv/{key}", h.put)
+	mux.HandleFunc("DELETE /kv/{key}", h.delete)
+	mux.HandleFunc("POST /checkpoint", h.checkpoint)
+	mux.HandleFunc("POST /revert", h.revert)
+	mux.HandleFunc("GET /status", h.status)
+	return mux
+}
+
+// rpcHandler decodes a JSON request, passes it to handle and encodes the
+// reply
+func rpcHandler[Req, Resp any](handle func(*Req) (*Resp, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := new(Req)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, err := handle(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, resp)
+	}
+}
+
+// clientTimeout bounds how long a client request waits for a commit
+const clientTimeout = 5 * time.Second
+
+type handler struct {
+	node  *Node
+	addrs map[string]string
+}
+
+func (h *handler) get(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), clientTimeout)
+	defer cancel()
+	value, ok, err := h.node.Get(ctx, r.PathValue("key"))
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	io.WriteString(w, value)
+}
+
+func (h *handler) put(w http.ResponseWriter, r *http.Request) {
+	value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxValueSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), clientTimeout)
+	defer cancel()
+	if err := h.node.Put(ctx, r.PathValue("key"), string(value)); err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) delete(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), clientTimeout)
+	defer cancel()
+	deleted, err := h.node.Delete(ctx, r.PathValue("key"))
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	if !deleted {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) checkpoint(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), clientTimeout)
+	defer cancel()
+	id, err := h.node.Checkpoint(ctx, r.URL.Query().Get("name"))
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	writeJSON(w, map[string]uint64{"id": uint64(id)})
+}
+
+func (h *handler) revert(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), clientTimeout)
+	defer cancel()
+	err := h.node.Revert(ctx)
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, ErrNotLeader), errors.Is(err, ErrLeadershipLost), errors.Is(err, ErrStopped), ctx.Err() != nil:
+		h.fail(w, r, err)
+	default:
+		http.Error(w, err.Error(), http.StatusConflict) // No checkpoint to revert to
+	}
+}
+
+func (h *handler) status(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, h.node.Status())
+}
+
+// fail answers a request the node couldn't serve: a redirect to the leader
+// if it knows one, else 503, or 504 if it ran out of time
+func (h *handler) fail(w http.ResponseWriter, r *http.Request, err error) {
+	var notLeader *NotLeaderError
+	if errors.As(err, &notLeader) {
+		if addr, ok := h.addrs[notLeader.LeaderID]; ok {
+			http.Redirect(w, r, "http://"+addr+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+			return
+		}
+	}
+	status := http.StatusServiceUnavailable
+	if errors.Is(err, context.DeadlineExceeded) {
+		status = http.StatusGatewayTimeout
+	}
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, err.Error(), status)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
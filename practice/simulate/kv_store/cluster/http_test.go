@@ -0,0 +1,142 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startHTTPCluster runs n nodes on local ports, talking over HTTP as
+// separate processes would, and returns their addresses by ID
+func startHTTPCluster(t *testing.T, n int) map[string]string {
+	t.Helper()
+	addrs := make(map[string]string)
+	listeners := make(map[string]net.Listener)
+	var ids []string
+	for i := 1; i <= n; i++ {
+		id := fmt.Sprintf("n%d", i)
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Listen failed: %v", err)
+		}
+		ids = append(ids, id)
+		addrs[id], listeners[id] = ln.Addr().String(), ln
+	}
+
+	dir := t.TempDir()
+	for _, id := range ids {
+		cfg := testConfig
+		cfg.ID, cfg.Peers, cfg.Dir = id, ids, filepath.Join(dir, id)
+		node, err := NewNode(cfg, NewHTTPTransport(addrs))
+		if err != nil {
+			t.Fatalf("NewNode failed: %v", err)
+		}
+		srv := &http.Server{Handler: NewHandler(node, addrs)}
+		go srv.Serve(listeners[id])
+		node.Start()
+		t.Cleanup(func() {
+			srv.Close()
+			node.Stop()
+		})
+	}
+	return addrs
+}
+
+// status fetches a node's /status
+func status(t *testing.T, addr string) Status {
+	t.Helper()
+	resp, err := http.Get("http://" + addr + "/status")
+	if err != nil {
+		t.Fatalf("GET /status failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var s Status
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		t.Fatalf("Failed to decode status: %v", err)
+	}
+	return s
+}
+
+// do sends one client request and returns the status code and body
+func do(t *testing.T, method, url, body string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	content, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, strings.TrimSpace(string(content))
+}
+
+// TestHTTPCluster tests a cluster talking over HTTP, with clients sent to
+// followers and redirected to the leader
+func TestHTTPCluster(t *testing.T) {
+	addrs := startHTTPCluster(t, 3)
+
+	var leader string
+	for deadline := time.Now().Add(5 * time.Second); leader == "" && time.Now().Before(deadline); {
+		for id, addr := range addrs {
+			if status(t, addr).Role == Leader {
+				leader = id
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if leader == "" {
+		t.Fatal("No leader elected")
+	}
+	follower := "n1"
+	if leader == follower {
+		follower = "n2"
+	}
+	base := "http://" + addrs[follower]
+
+	// The client follows the 307 to the leader, body and all
+	if code, body := do(t, "PUT", base+"/kv/name", "ada"); code != http.StatusNoContent {
+		t.Fatalf("Expected PUT to succeed, got %d %s", code, body)
+	}
+	if code, body := do(t, "GET", base+"/kv/name", ""); code != http.StatusOK || body != "ada" {
+		t.Errorf("Expected name=ada, got %d %q", code, body)
+	}
+	if code, body := do(t, "POST", base+"/checkpoint?name=v1", ""); code != http.StatusOK || body != `{"id":1}` {
+		t.Errorf("Expected checkpoint 1, got %d %s", code, body)
+	}
+	do(t, "PUT", base+"/kv/name", "grace")
+	if code, _ := do(t, "POST", base+"/revert", ""); code != http.StatusNoContent {
+		t.Errorf("Expected revert to succeed, got %d", code)
+	}
+	if code, _ := do(t, "POST", base+"/revert", ""); code != http.StatusConflict {
+		t.Errorf("Expected 409 reverting without a checkpoint, got %d", code)
+	}
+	if _, body := do(t, "GET", base+"/kv/name", ""); body != "ada" {
+		t.Errorf("Expected name=ada after revert, got %q", body)
+	}
+	if code, _ := do(t, "DELETE", base+"/kv/name", ""); code != http.StatusNoContent {
+		t.Errorf("Expected DELETE to succeed, got %d", code)
+	}
+	if code, _ := do(t, "GET", base+"/kv/name", ""); code != http.StatusNotFound {
+		t.Errorf("Expected 404 after delete, got %d", code)
+	}
+
+	// Every follower applies the log too
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	want := status(t, addrs[leader]).CommitIndex
+	for id, addr := range addrs {
+		for status(t, addr).LastApplied < want {
+			if ctx.Err() != nil {
+				t.Fatalf("Expected %s to apply %d entries", id, want)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Network connects nodes in one process by calling their handlers directly.
+// It can partition them and delay or drop messages, for tests.
+type Network struct {
+	mu       sync.RWMutex
+	nodes    map[string]*Node
+	group    map[string]int // Partition group per node; nil = fully connected
+	maxDelay time.Duration
+	dropRate float64
+}
+
+// NewNetwork creates an empty, fully connected network
+func NewNetwork() *Network {
+	return &Network{nodes: make(map[string]*Node)}
+}
+
+// Add connects node, replacing any node with the same ID
+func (nw *Network) Add(node *Node) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+
+	nw.nodes[node.ID()] = node
+}
+
+// Transport returns the transport for node from to send its RPCs on
+func (nw *Network) Transport(from string) Transport {
+	return &memTransport{nw: nw, from: from}
+}
+
+// Partition splits the network: nodes can only reach nodes in the same
+// group, and nodes in no group can't reach anyone
+func (nw *Network) Partition(groups ...[]string) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+
+	nw.group = make(map[string]int)
+	for i, group := range groups {
+		for _, id := range group {
+			nw.group[id] = i + 1
+		}
+	}
+}
+
+// Heal reconnects every node
+func (nw *Network) Heal() {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+
+	nw.group = nil
+}
+
+// SetUnreliable delays each message by up to maxDelay and drops a
+// dropRate fraction of them (requests and replies alike)
+func (nw *Network) SetUnreliable(maxDelay time.Duration, dropRate float64) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+
+	nw.maxDelay, nw.dropRate = maxDelay, dropRate
+}
+
+// deliver waits out a message's delay and returns the node it reaches, or
+// ErrUnreachable if it is cut off or dropped
+func (nw *Network) deliver(ctx context.Context, from, to string) (*Node, error) {
+	nw.mu.RLock()
+	maxDelay, dropRate := nw.maxDelay, nw.dropRate
+	nw.mu.RUnlock()
+
+	if maxDelay > 0 {
+		select {
+		case <-time.After(rand.N(maxDelay)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	nw.mu.RLock()
+	defer nw.mu.RUnlock()
+	node, ok := nw.nodes[to]
+	if !ok || rand.Float64() < dropRate {
+		return nil, fmt.Errorf("%w: %s", ErrUnreachable, to)
+	}
+	if nw.group != nil && (nw.group[from] == 0 || nw.group[from] != nw.group[to]) {
+		return nil, fmt.Errorf("%w: %s", ErrUnreachable, to)
+	}
+	return node, nil
+}
+
+// memTransport sends one node's RPCs over a Network
+type memTransport struct {
+	nw   *Network
+	from string
+}
+
+// call delivers a request to node to, runs handle on it and delivers the
+// reply back, either of which can be lost
+func call[Resp any](ctx context.Context, t *memTransport, to string, handle func(*Node) (*Resp, error)) (*Resp, error) {
+	node, err := t.nw.deliver(ctx, t.from, to)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := handle(node)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := t.nw.deliver(ctx, to, t.from); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (t *memTransport) RequestVote(ctx context.Context, to string, req *RequestVoteRequest) (*RequestVoteResponse, error) {
+	return call(ctx, t, to, func(n *Node) (*RequestVoteResponse, error) { return n.HandleRequestVote(req) })
+}
+
+func (t *memTransport) AppendEntries(ctx context.Context, to string, req *AppendEntriesRequest) (*AppendEntriesResponse, error) {
+	return call(ctx, t, to, func(n *Node) (*AppendEntriesResponse, error) { return n.HandleAppendEntries(req) })
+}
+
+func (t *memTransport) InstallSnapshot(ctx context.Context, to string, req *InstallSnapshotRequest) (*InstallSnapshotResponse, error) {
+	return call(ctx, t, to, func(n *Node) (*InstallSnapshotResponse, error) { return n.HandleInstallSnapshot(req) })
+}
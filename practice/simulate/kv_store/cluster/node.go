@@ -0,0 +1,974 @@
+// Package cluster replicates a KVStore over several nodes with Raft. Every
+// Put, Delete, Checkpoint and Revert goes through the leader's log and is
+// applied by each node in log order once a majority has stored it; reads are
+// served by the leader after confirming it still is one, so they see every
+// write acknowledged before they started. Followers that fall too far
+// behind are sent a SaveToDisk snapshot instead of the log.
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"workshop/practice/simulate/kv_store/store"
+)
+
+// Role is a node's part in the Raft protocol
+type Role int
+
+const (
+	Follower Role = iota
+	Candidate
+	Leader
+)
+
+func (r Role) String() string {
+	switch r {
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	default:
+		return "follower"
+	}
+}
+
+func (r Role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Role) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "follower":
+		*r = Follower
+	case "candidate":
+		*r = Candidate
+	case "leader":
+		*r = Leader
+	default:
+		return fmt.Errorf("unknown role %q", text)
+	}
+	return nil
+}
+
+// maxBatch caps the entries sent in one AppendEntries
+const maxBatch = 500
+
+// Config configures a Node
+type Config struct {
+	ID    string   // This node's ID
+	Peers []string // IDs of every node in the cluster, including ID
+	Dir   string   // Raft state and snapshots; reused on restart
+
+	ElectionTimeout   time.Duration // Default 300ms; each wait is random in [t, 2t)
+	HeartbeatInterval time.Duration // Default 50ms
+	SnapshotThreshold int           // Applied entries kept in the log before a snapshot (default 1000); bounds the log rewritten on every append
+
+	Logf func(format string, args ...any) // Role changes and errors (default: discarded)
+}
+
+// Status is a point-in-time view of a node's Raft state
+type Status struct {
+	ID            string `json:"id"`
+	Role          Role   `json:"role"`
+	Term          uint64 `json:"term"`
+	LeaderID      string `json:"leaderId,omitempty"`
+	CommitIndex   uint64 `json:"commitIndex"`
+	LastApplied   uint64 `json:"lastApplied"`
+	LastIndex     uint64 `json:"lastIndex"`
+	SnapshotIndex uint64 `json:"snapshotIndex"`
+}
+
+// result is the outcome of applying one command
+type result struct {
+	deleted bool
+	id      store.CheckpointID
+	err     error
+}
+
+// proposal is a client waiting for the entry it appended at some index
+type proposal struct {
+	term uint64
+	done chan result
+}
+
+// Node is one member of a cluster. It owns a KVStore that only changes by
+// applying committed log entries.
+type Node struct {
+	id        string
+	peers     []string // Every other node
+	cfg       Config
+	transport Transport
+	kv        *store.KVStore[string]
+	ctx       context.Context // Cancelled by Stop, aborting RPCs in flight
+	cancel    context.CancelFunc
+
+	mu          sync.Mutex // Protects the fields below
+	role        Role
+	term        uint64
+	votedFor    string
+	leaderID    string
+	log         []Entry // Entries after snapIndex
+	snapIndex   uint64  // Last index covered by the snapshot
+	snapTerm    uint64
+	snapFile    string // Snapshot file name in Dir ("" = none yet)
+	snapshot    []byte // Its contents, sent to lagging followers
+	commitIndex uint64
+	lastApplied uint64
+	deadline    time.Time // Start an election if no leader is heard from by then
+	pending     map[uint64]*proposal
+	applied     chan struct{} // Closed and replaced whenever lastApplied moves
+	stopped     bool
+
+	// Leader only
+	nextIndex  map[string]uint64
+	matchIndex map[string]uint64
+	inflight   map[string]bool // One AppendEntries or InstallSnapshot per peer at a time
+	lastSent   map[string]time.Time
+
+	stop chan struct{}
+	wg   sync.WaitGroup // The ticker and RPCs in flight
+}
+
+// NewNode creates a node, restoring its log and snapshot from cfg.Dir if it
+// has run before. Call Start to join the cluster.
+func NewNode(cfg Config, transport Transport) (*Node, error) {
+	if !slices.Contains(cfg.Peers, cfg.ID) {
+		return nil, fmt.Errorf("node %q is not in peers %v", cfg.ID, cfg.Peers)
+	}
+	if cfg.ElectionTimeout <= 0 {
+		cfg.ElectionTimeout = 300 * time.Millisecond
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = 50 * time.Millisecond
+	}
+	if cfg.SnapshotThreshold <= 0 {
+		cfg.SnapshotThreshold = 1000
+	}
+	if cfg.Logf == nil {
+		cfg.Logf = func(string, ...any) {}
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create node directory: %w", err)
+	}
+
+	n := &Node{
+		id:         cfg.ID,
+		cfg:        cfg,
+		transport:  transport,
+		kv:         store.NewKVStore[string](),
+		pending:    make(map[uint64]*proposal),
+		applied:    make(chan struct{}),
+		nextIndex:  make(map[string]uint64),
+		matchIndex: make(map[string]uint64),
+		inflight:   make(map[string]bool),
+		lastSent:   make(map[string]time.Time),
+		stop:       make(chan struct{}),
+	}
+	for _, peer := range cfg.Peers {
+		if peer != cfg.ID {
+			n.peers = append(n.peers, peer)
+		}
+	}
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+
+	state, ok, err := readState(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		n.term, n.votedFor, n.log = state.Term, state.VotedFor, state.Log
+		n.snapIndex, n.snapTerm, n.snapFile = state.SnapIndex, state.SnapTerm, state.Snapshot
+	}
+	if n.snapFile != "" {
+		path := filepath.Join(cfg.Dir, n.snapFile)
+		if err := n.kv.LoadFromDisk(path); err != nil {
+			return nil, fmt.Errorf("failed to load snapshot: %w", err)
+		}
+		if n.snapshot, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("failed to read snapshot: %w", err)
+		}
+		n.commitIndex, n.lastApplied = n.snapIndex, n.snapIndex
+	}
+	n.removeStaleSnapshots()
+	return n, nil
+}
+
+// Start runs the node's election and heartbeat timer
+func (n *Node) Start() {
+	n.mu.Lock()
+	n.resetDeadlineLocked()
+	n.mu.Unlock()
+
+	tick := max(n.cfg.HeartbeatInterval/2, time.Millisecond)
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-n.stop:
+				return
+			case <-ticker.C:
+				n.tick()
+			}
+		}
+	}()
+}
+
+// Stop stops the node. Clients still waiting on it get ErrStopped; its
+// state stays in Dir for a new node to pick up.
+func (n *Node) Stop() {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return
+	}
+	n.stopped = true
+	for index, p := range n.pending {
+		p.done <- result{err: ErrStopped}
+		delete(n.pending, index)
+	}
+	n.mu.Unlock()
+
+	close(n.stop)
+	n.cancel()
+	n.wg.Wait()
+}
+
+// ID returns the node's ID
+func (n *Node) ID() string {
+	return n.id
+}
+
+// Store returns the node's local copy of the data. Reads from it may be
+// stale on a follower; never write to it.
+func (n *Node) Store() *store.KVStore[string] {
+	return n.kv
+}
+
+// Status returns the node's current Raft state
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return Status{
+		ID:            n.id,
+		Role:          n.role,
+		Term:          n.term,
+		LeaderID:      n.leaderID,
+		CommitIndex:   n.commitIndex,
+		LastApplied:   n.lastApplied,
+		LastIndex:     n.lastIndexLocked(),
+		SnapshotIndex: n.snapIndex,
+	}
+}
+
+// Put sets key to value through the log. It returns once the write is
+// applied on the leader, or with a NotLeaderError if this node isn't the
+// leader. If ctx ends first, or with ErrLeadershipLost, the write may or
+// may not have happened.
+func (n *Node) Put(ctx context.Context, key, value string) error {
+	_, err := n.propose(ctx, Command{Op: OpPut, Key: key, Value: value})
+	return err
+}
+
+// Delete removes key through the log, returning true if it existed (see Put)
+func (n *Node) Delete(ctx context.Context, key string) (bool, error) {
+	res, err := n.propose(ctx, Command{Op: OpDelete, Key: key})
+	return res.deleted, err
+}
+
+// Checkpoint takes a checkpoint on every node at the same point in the log
+// and returns its ID (see Put)
+func (n *Node) Checkpoint(ctx context.Context, name string) (store.CheckpointID, error) {
+	res, err := n.propose(ctx, Command{Op: OpCheckpoint, Name: name})
+	return res.id, err
+}
+
+// Revert restores every node to the last checkpoint (see Put). It fails on
+// every node alike if there is no checkpoint.
+func (n *Node) Revert(ctx context.Context) error {
+	_, err := n.propose(ctx, Command{Op: OpRevert})
+	return err
+}
+
+// Get returns key's value. It is linearizable: only the leader answers,
+// after hearing from a majority that it still is one, and it reflects every
+// write acknowledged before Get was called.
+func (n *Node) Get(ctx context.Context, key string) (string, bool, error) {
+	if err := n.readBarrier(ctx); err != nil {
+		return "", false, err
+	}
+	value, ok := n.kv.Get(key)
+	return value, ok, nil
+}
+
+// GetAllData returns a linearizable copy of all key-value pairs (see Get)
+func (n *Node) GetAllData(ctx context.Context) (map[string]string, error) {
+	if err := n.readBarrier(ctx); err != nil {
+		return nil, err
+	}
+	data, _ := n.kv.GetAllData()
+	return data, nil
+}
+
+// propose appends cmd to the leader's log and waits for it to be applied
+func (n *Node) propose(ctx context.Context, cmd Command) (result, error) {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return result{}, ErrStopped
+	}
+	if n.role != Leader {
+		leader := n.leaderID
+		n.mu.Unlock()
+		return result{}, &NotLeaderError{LeaderID: leader}
+	}
+
+	entry := Entry{Index: n.lastIndexLocked() + 1, Term: n.term, Cmd: cmd}
+	n.log = append(n.log, entry)
+	if err := n.persistLocked(); err != nil {
+		n.log = n.log[:len(n.log)-1]
+		n.mu.Unlock()
+		return result{}, err
+	}
+	p := &proposal{term: entry.Term, done: make(chan result, 1)}
+	if old, ok := n.pending[entry.Index]; ok {
+		old.done <- result{err: ErrLeadershipLost}
+	}
+	n.pending[entry.Index] = p
+	n.advanceCommitLocked() // A single node commits on its own
+	n.replicateLocked(true)
+	n.mu.Unlock()
+
+	select {
+	case res := <-p.done:
+		return res, res.err
+	case <-ctx.Done():
+		n.mu.Lock()
+		if n.pending[entry.Index] == p {
+			delete(n.pending, entry.Index)
+		}
+		n.mu.Unlock()
+		return result{}, ctx.Err()
+	}
+}
+
+// readBarrier returns once the leader has applied everything committed when
+// it was called, and has confirmed with a majority that it is still leader
+// (the Raft "read index")
+func (n *Node) readBarrier(ctx context.Context) error {
+	n.mu.Lock()
+	// A new leader doesn't know how far its predecessor committed until an
+	// entry from its own term commits (the no-op it appends on election)
+	for {
+		if n.stopped {
+			n.mu.Unlock()
+			return ErrStopped
+		}
+		if n.role != Leader {
+			leader := n.leaderID
+			n.mu.Unlock()
+			return &NotLeaderError{LeaderID: leader}
+		}
+		if n.termAtLocked(n.commitIndex) == n.term {
+			break
+		}
+		applied := n.applied
+		n.mu.Unlock()
+		select {
+		case <-applied:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		n.mu.Lock()
+	}
+	readIndex, term := n.commitIndex, n.term
+	requests := make(map[string]*AppendEntriesRequest, len(n.peers))
+	for _, peer := range n.peers {
+		prev := max(n.nextIndex[peer]-1, n.snapIndex)
+		requests[peer] = &AppendEntriesRequest{
+			Term:         term,
+			LeaderID:     n.id,
+			PrevLogIndex: prev,
+			PrevLogTerm:  n.termAtLocked(prev),
+			LeaderCommit: n.commitIndex,
+		}
+	}
+	acks := make(chan bool, len(n.peers))
+	for peer, req := range requests {
+		n.goRPC(func(ctx context.Context) {
+			resp, err := n.transport.AppendEntries(ctx, peer, req)
+			if err == nil && resp.Term > term {
+				n.mu.Lock()
+				n.stepDownLocked(resp.Term, "")
+				n.mu.Unlock()
+			}
+			// Any reply in our term, matching log or not, means the peer
+			// hasn't moved on to a newer leader
+			acks <- err == nil && resp.Term == term
+		})
+	}
+	n.mu.Unlock()
+
+	for votes, replies := 1, 0; votes < n.quorum(); replies++ {
+		if replies == len(requests) {
+			return &NotLeaderError{}
+		}
+		select {
+		case ok := <-acks:
+			if ok {
+				votes++
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return n.waitApplied(ctx, readIndex)
+}
+
+// waitApplied waits until the node has applied index
+func (n *Node) waitApplied(ctx context.Context, index uint64) error {
+	for {
+		n.mu.Lock()
+		done, applied := n.lastApplied >= index, n.applied
+		n.mu.Unlock()
+		if done {
+			return nil
+		}
+		select {
+		case <-applied:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// quorum returns the number of nodes that make a majority
+func (n *Node) quorum() int {
+	return (len(n.peers)+1)/2 + 1
+}
+
+// tick starts an election if the leader has gone quiet, or sends
+// heartbeats if this node is the leader
+func (n *Node) tick() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.stopped {
+		return
+	}
+	if n.role == Leader {
+		n.replicateLocked(false)
+	} else if time.Now().After(n.deadline) {
+		n.startElectionLocked()
+	}
+}
+
+// resetDeadlineLocked pushes back the next election by a random timeout
+func (n *Node) resetDeadlineLocked() {
+	t := n.cfg.ElectionTimeout
+	n.deadline = time.Now().Add(t + rand.N(t))
+}
+
+// goRPC runs call in a goroutine Stop waits for, with a context Stop cancels
+func (n *Node) goRPC(call func(ctx context.Context)) {
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		ctx, cancel := context.WithTimeout(n.ctx, 10*n.cfg.ElectionTimeout)
+		defer cancel()
+		call(ctx)
+	}()
+}
+
+// startElectionLocked becomes a candidate in a new term and asks every
+// peer for its vote
+func (n *Node) startElectionLocked() {
+	n.role = Candidate
+	n.term++
+	n.votedFor = n.id
+	n.leaderID = ""
+	n.resetDeadlineLocked()
+	if err := n.persistLocked(); err != nil {
+		n.cfg.Logf("%s: %v", n.id, err)
+		return
+	}
+	if n.quorum() == 1 {
+		n.becomeLeaderLocked()
+		return
+	}
+
+	req := &RequestVoteRequest{
+		Term:         n.term,
+		CandidateID:  n.id,
+		LastLogIndex: n.lastIndexLocked(),
+		LastLogTerm:  n.termAtLocked(n.lastIndexLocked()),
+	}
+	votes := 1
+	for _, peer := range n.peers {
+		n.goRPC(func(ctx context.Context) {
+			resp, err := n.transport.RequestVote(ctx, peer, req)
+			if err != nil {
+				return
+			}
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if resp.Term > n.term {
+				n.stepDownLocked(resp.Term, "")
+				return
+			}
+			if !resp.VoteGranted || n.role != Candidate || n.term != req.Term {
+				return
+			}
+			votes++
+			if votes == n.quorum() {
+				n.becomeLeaderLocked()
+			}
+		})
+	}
+}
+
+// becomeLeaderLocked takes over as leader and appends a no-op, whose commit
+// also commits every entry left over from earlier terms
+func (n *Node) becomeLeaderLocked() {
+	n.role = Leader
+	n.leaderID = n.id
+	n.cfg.Logf("%s: became leader for term %d", n.id, n.term)
+	for _, peer := range n.peers {
+		n.nextIndex[peer] = n.lastIndexLocked() + 1
+		n.matchIndex[peer] = 0
+	}
+	n.log = append(n.log, Entry{Index: n.lastIndexLocked() + 1, Term: n.term, Cmd: Command{Op: OpNoop}})
+	if err := n.persistLocked(); err != nil {
+		n.cfg.Logf("%s: %v", n.id, err)
+	}
+	n.advanceCommitLocked()
+	n.replicateLocked(true)
+}
+
+// stepDownLocked becomes a follower, moving to term if it is newer.
+// leader is who it heard from ("" if nobody).
+func (n *Node) stepDownLocked(term uint64, leader string) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		if err := n.persistLocked(); err != nil {
+			n.cfg.Logf("%s: %v", n.id, err)
+		}
+	}
+	if n.role == Leader {
+		n.cfg.Logf("%s: stepped down in term %d", n.id, n.term)
+		n.resetDeadlineLocked()
+	}
+	n.role = Follower
+	n.leaderID = leader
+}
+
+// replicateLocked sends AppendEntries to every peer that has entries to
+// catch up on or is due a heartbeat (every peer if force), unless one is
+// already in flight to it
+func (n *Node) replicateLocked(force bool) {
+	if n.role != Leader || n.stopped {
+		return
+	}
+	for _, peer := range n.peers {
+		if n.inflight[peer] {
+			continue
+		}
+		due := time.Since(n.lastSent[peer]) >= n.cfg.HeartbeatInterval
+		if force || due || n.nextIndex[peer] <= n.lastIndexLocked() {
+			n.sendLocked(peer)
+		}
+	}
+}
+
+// sendLocked sends peer the entries from its nextIndex, or the snapshot if
+// those have been compacted away
+func (n *Node) sendLocked(peer string) {
+	n.inflight[peer] = true
+	n.lastSent[peer] = time.Now()
+
+	next := n.nextIndex[peer]
+	if next <= n.snapIndex {
+		req := &InstallSnapshotRequest{
+			Term:      n.term,
+			LeaderID:  n.id,
+			LastIndex: n.snapIndex,
+			LastTerm:  n.snapTerm,
+			Data:      n.snapshot,
+		}
+		n.goRPC(func(ctx context.Context) {
+			resp, err := n.transport.InstallSnapshot(ctx, peer, req)
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			n.inflight[peer] = false
+			if err == nil {
+				n.handleSnapshotResponseLocked(peer, req, resp)
+			}
+		})
+		return
+	}
+
+	req := &AppendEntriesRequest{
+		Term:         n.term,
+		LeaderID:     n.id,
+		PrevLogIndex: next - 1,
+		PrevLogTerm:  n.termAtLocked(next - 1),
+		Entries:      n.entriesLocked(next),
+		LeaderCommit: n.commitIndex,
+	}
+	n.goRPC(func(ctx context.Context) {
+		resp, err := n.transport.AppendEntries(ctx, peer, req)
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		n.inflight[peer] = false
+		if err == nil {
+			n.handleAppendResponseLocked(peer, req, resp)
+		}
+	})
+}
+
+func (n *Node) handleAppendResponseLocked(peer string, req *AppendEntriesRequest, resp *AppendEntriesResponse) {
+	if resp.Term > n.term {
+		n.stepDownLocked(resp.Term, "")
+		return
+	}
+	if n.role != Leader || req.Term != n.term {
+		return
+	}
+	if resp.Success {
+		match := req.PrevLogIndex + uint64(len(req.Entries))
+		n.matchIndex[peer] = max(n.matchIndex[peer], match)
+		n.nextIndex[peer] = max(n.nextIndex[peer], match+1)
+		n.advanceCommitLocked()
+	} else {
+		next := req.PrevLogIndex
+		if resp.ConflictIndex > 0 {
+			next = min(next, resp.ConflictIndex)
+		}
+		n.nextIndex[peer] = max(next, n.matchIndex[peer]+1, 1)
+	}
+	if n.nextIndex[peer] <= n.lastIndexLocked() && !n.stopped {
+		n.sendLocked(peer)
+	}
+}
+
+func (n *Node) handleSnapshotResponseLocked(peer string, req *InstallSnapshotRequest, resp *InstallSnapshotResponse) {
+	if resp.Term > n.term {
+		n.stepDownLocked(resp.Term, "")
+		return
+	}
+	if n.role != Leader || req.Term != n.term {
+		return
+	}
+	n.matchIndex[peer] = max(n.matchIndex[peer], req.LastIndex)
+	n.nextIndex[peer] = max(n.nextIndex[peer], req.LastIndex+1)
+	n.advanceCommitLocked()
+	if n.nextIndex[peer] <= n.lastIndexLocked() && !n.stopped {
+		n.sendLocked(peer)
+	}
+}
+
+// advanceCommitLocked commits the newest entry stored on a majority.
+// Only entries from the current term are counted; earlier ones commit with
+// them (Raft §5.4.2).
+func (n *Node) advanceCommitLocked() {
+	for index := n.lastIndexLocked(); index > n.commitIndex; index-- {
+		if n.termAtLocked(index) != n.term {
+			return
+		}
+		votes := 1
+		for _, peer := range n.peers {
+			if n.matchIndex[peer] >= index {
+				votes++
+			}
+		}
+		if votes >= n.quorum() {
+			n.commitIndex = index
+			n.applyLocked()
+			return
+		}
+	}
+}
+
+// applyLocked applies committed entries to the store, answers the clients
+// waiting on them and compacts the log once it is long enough
+func (n *Node) applyLocked() {
+	if n.lastApplied >= n.commitIndex {
+		return
+	}
+	for n.lastApplied < n.commitIndex {
+		entry := n.log[n.lastApplied-n.snapIndex]
+		res := n.execute(entry.Cmd)
+		n.lastApplied = entry.Index
+		if p, ok := n.pending[entry.Index]; ok {
+			delete(n.pending, entry.Index)
+			if p.term != entry.Term {
+				res = result{err: ErrLeadershipLost} // Another leader's entry won
+			}
+			p.done <- res
+		}
+	}
+	close(n.applied)
+	n.applied = make(chan struct{})
+
+	if n.lastApplied-n.snapIndex >= uint64(n.cfg.SnapshotThreshold) {
+		if err := n.snapshotLocked(); err != nil {
+			n.cfg.Logf("%s: %v", n.id, err)
+		}
+	}
+}
+
+// execute applies one command to the store
+func (n *Node) execute(cmd Command) result {
+	var res result
+	switch cmd.Op {
+	case OpPut:
+		n.kv.Put(cmd.Key, cmd.Value)
+	case OpDelete:
+		res.deleted = n.kv.Delete(cmd.Key)
+	case OpCheckpoint:
+		res.id = n.kv.Checkpoint(cmd.Name)
+	case OpRevert:
+		res.err = n.kv.Revert()
+	}
+	return res
+}
+
+// snapshotLocked saves the store as of lastApplied and drops the log up to
+// there
+func (n *Node) snapshotLocked() error {
+	index, term := n.lastApplied, n.termAtLocked(n.lastApplied)
+	name := snapshotName(index, term)
+	path := filepath.Join(n.cfg.Dir, name)
+	if err := n.kv.SaveToDisk(path); err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	n.log = slices.Clone(n.log[index-n.snapIndex:])
+	n.setSnapshotLocked(index, term, name, data)
+	return n.persistLocked()
+}
+
+// setSnapshotLocked records a new snapshot, deleting the old file
+func (n *Node) setSnapshotLocked(index, term uint64, name string, data []byte) {
+	if n.snapFile != "" && n.snapFile != name {
+		os.Remove(filepath.Join(n.cfg.Dir, n.snapFile))
+	}
+	n.snapIndex, n.snapTerm, n.snapFile, n.snapshot = index, term, name, data
+}
+
+// HandleRequestVote answers a candidate's RequestVote
+func (n *Node) HandleRequestVote(req *RequestVoteRequest) (*RequestVoteResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.stopped {
+		return nil, ErrStopped
+	}
+	if req.Term > n.term {
+		n.stepDownLocked(req.Term, "")
+	}
+	resp := &RequestVoteResponse{Term: n.term}
+	if req.Term < n.term {
+		return resp, nil
+	}
+
+	last := n.lastIndexLocked()
+	lastTerm := n.termAtLocked(last)
+	upToDate := req.LastLogTerm > lastTerm || (req.LastLogTerm == lastTerm && req.LastLogIndex >= last)
+	if (n.votedFor == "" || n.votedFor == req.CandidateID) && upToDate {
+		n.votedFor = req.CandidateID
+		if err := n.persistLocked(); err != nil {
+			return resp, nil // A vote we can't remember isn't given
+		}
+		resp.VoteGranted = true
+		n.resetDeadlineLocked()
+	}
+	return resp, nil
+}
+
+// HandleAppendEntries stores a leader's entries if the log matches at
+// PrevLogIndex, and applies whatever the leader has committed
+func (n *Node) HandleAppendEntries(req *AppendEntriesRequest) (*AppendEntriesResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.stopped {
+		return nil, ErrStopped
+	}
+	resp := &AppendEntriesResponse{Term: n.term}
+	if req.Term < n.term {
+		return resp, nil
+	}
+	n.stepDownLocked(req.Term, req.LeaderID)
+	n.resetDeadlineLocked()
+	resp.Term = n.term
+
+	entries := req.Entries
+	if req.PrevLogIndex < n.snapIndex {
+		// Everything up to snapIndex is committed here already
+		skip := n.snapIndex - req.PrevLogIndex
+		entries = entries[min(skip, uint64(len(entries))):]
+	} else if req.PrevLogIndex > n.lastIndexLocked() {
+		resp.ConflictIndex = n.lastIndexLocked() + 1
+		return resp, nil
+	} else if term := n.termAtLocked(req.PrevLogIndex); term != req.PrevLogTerm {
+		// Skip back over the whole conflicting term
+		index := req.PrevLogIndex
+		for index > n.snapIndex+1 && n.termAtLocked(index-1) == term {
+			index--
+		}
+		resp.ConflictIndex = index
+		return resp, nil
+	}
+
+	for i, entry := range entries {
+		if entry.Index <= n.lastIndexLocked() && n.termAtLocked(entry.Index) == entry.Term {
+			continue
+		}
+		// Build the new log in a fresh array, so the old one is intact to put
+		// back if it can't be persisted
+		old := n.log
+		n.log = slices.Concat(n.log[:entry.Index-n.snapIndex-1], entries[i:])
+		if err := n.persistLocked(); err != nil {
+			n.log = old
+			n.cfg.Logf("%s: %v", n.id, err)
+			return resp, nil
+		}
+		break
+	}
+	resp.Success = true
+
+	lastNew := req.PrevLogIndex + uint64(len(req.Entries))
+	if commit := min(req.LeaderCommit, lastNew); commit > n.commitIndex {
+		n.commitIndex = commit
+		n.applyLocked()
+	}
+	return resp, nil
+}
+
+// HandleInstallSnapshot replaces the node's state with the leader's
+// snapshot, keeping any log entries after it
+func (n *Node) HandleInstallSnapshot(req *InstallSnapshotRequest) (*InstallSnapshotResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.stopped {
+		return nil, ErrStopped
+	}
+	resp := &InstallSnapshotResponse{Term: n.term}
+	if req.Term < n.term {
+		return resp, nil
+	}
+	n.stepDownLocked(req.Term, req.LeaderID)
+	n.resetDeadlineLocked()
+	resp.Term = n.term
+	if req.LastIndex <= n.commitIndex {
+		return resp, nil // Nothing we don't have already
+	}
+
+	name := snapshotName(req.LastIndex, req.LastTerm)
+	path := filepath.Join(n.cfg.Dir, name)
+	if err := store.WriteFileAtomic(path, req.Data); err != nil {
+		n.cfg.Logf("%s: failed to write snapshot: %v", n.id, err)
+		return resp, nil
+	}
+	if err := n.kv.LoadFromDisk(path); err != nil {
+		n.cfg.Logf("%s: failed to load snapshot: %v", n.id, err)
+		os.Remove(path)
+		return resp, nil
+	}
+
+	if req.LastIndex < n.lastIndexLocked() && n.termAtLocked(req.LastIndex) == req.LastTerm {
+		n.log = slices.Clone(n.log[req.LastIndex-n.snapIndex:])
+	} else {
+		n.log = nil
+	}
+	n.setSnapshotLocked(req.LastIndex, req.LastTerm, name, req.Data)
+	n.commitIndex, n.lastApplied = req.LastIndex, req.LastIndex
+	for index, p := range n.pending {
+		if index <= req.LastIndex {
+			p.done <- result{err: ErrLeadershipLost}
+			delete(n.pending, index)
+		}
+	}
+	close(n.applied)
+	n.applied = make(chan struct{})
+	if err := n.persistLocked(); err != nil {
+		n.cfg.Logf("%s: %v", n.id, err)
+	}
+	return resp, nil
+}
+
+// lastIndexLocked returns the index of the last log entry
+func (n *Node) lastIndexLocked() uint64 {
+	return n.snapIndex + uint64(len(n.log))
+}
+
+// termAtLocked returns the term of the entry at index (0 if it isn't in the
+// log or the snapshot)
+func (n *Node) termAtLocked(index uint64) uint64 {
+	if index == n.snapIndex {
+		return n.snapTerm
+	}
+	if index < n.snapIndex || index > n.lastIndexLocked() {
+		return 0
+	}
+	return n.log[index-n.snapIndex-1].Term
+}
+
+// entriesLocked returns a copy of up to maxBatch entries from index on
+func (n *Node) entriesLocked(index uint64) []Entry {
+	from := index - n.snapIndex - 1
+	to := min(from+maxBatch, uint64(len(n.log)))
+	return slices.Clone(n.log[from:to])
+}
+
+// persistLocked writes the term, vote and log to Dir. The whole log is
+// rewritten and fsynced every time, so each append costs O(log length):
+// SnapshotThreshold is what keeps that bounded, and lowering it trades
+// cheaper appends for more frequent snapshots.
+func (n *Node) persistLocked() error {
+	return writeState(n.cfg.Dir, &persistentState{
+		Term:      n.term,
+		VotedFor:  n.votedFor,
+		SnapIndex: n.snapIndex,
+		SnapTerm:  n.snapTerm,
+		Snapshot:  n.snapFile,
+		Log:       n.log,
+	})
+}
+
+// removeStaleSnapshots deletes snapshot files left behind by a crash
+// between writing a snapshot and recording it
+func (n *Node) removeStaleSnapshots() {
+	files, _ := filepath.Glob(filepath.Join(n.cfg.Dir, "snapshot-*.kvs.gz"))
+	for _, file := range files {
+		if filepath.Base(file) != n.snapFile {
+			os.Remove(file)
+		}
+	}
+}
+
+// localState returns what the node has applied, read at one instant, for
+// comparing replicas
+func (n *Node) localState() (applied uint64, data map[string]string, checkpoints []store.CheckpointInfo) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	data, _ = n.kv.GetAllData()
+	return n.lastApplied, data, n.kv.ListCheckpoints()
+}
@@ -0,0 +1,444 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testConfig keeps elections quick without being so tight that a slow
+// machine keeps electing
+var testConfig = Config{
+	ElectionTimeout:   150 * time.Millisecond,
+	HeartbeatInterval: 30 * time.Millisecond,
+}
+
+// startHarness runs an n-node cluster for the test and waits for a leader
+func startHarness(t *testing.T, n int, cfg Config) (*Harness, *Node) {
+	t.Helper()
+	h, err := NewHarness(n, t.TempDir(), cfg)
+	if err != nil {
+		t.Fatalf("NewHarness failed: %v", err)
+	}
+	t.Cleanup(h.Stop)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	leader, err := h.WaitForLeader(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, leader
+}
+
+// waitConverged fails the test if the nodes don't agree within 5 seconds
+func waitConverged(t *testing.T, h *Harness) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := h.WaitConverged(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// put writes through whichever node is leader, failing the test on error
+func put(t *testing.T, h *Harness, key, value string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := h.Do(ctx, time.Second, func(ctx context.Context, leader *Node) error {
+		return leader.Put(ctx, key, value)
+	})
+	if err != nil {
+		t.Fatalf("Put(%s) failed: %v", key, err)
+	}
+}
+
+// others returns every ID in ids except id
+func others(ids []string, id string) []string {
+	var rest []string
+	for _, other := range ids {
+		if other != id {
+			rest = append(rest, other)
+		}
+	}
+	return rest
+}
+
+// TestElection tests that a leader is elected and that no term ever has
+// two leaders
+func TestElection(t *testing.T) {
+	h, leader := startHarness(t, 3, testConfig)
+
+	leaders := make(map[uint64]string)
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		for _, node := range h.Nodes() {
+			s := node.Status()
+			if s.Role != Leader {
+				continue
+			}
+			if other, ok := leaders[s.Term]; ok && other != s.ID {
+				t.Fatalf("Expected one leader in term %d, got %s and %s", s.Term, other, s.ID)
+			}
+			leaders[s.Term] = s.ID
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, node := range h.Nodes() {
+		if s := node.Status(); s.LeaderID != leader.ID() {
+			t.Errorf("Expected %s to follow %s, got %q", s.ID, leader.ID(), s.LeaderID)
+		}
+	}
+}
+
+// TestReplication tests that every command is applied on every node, and
+// that followers turn clients away with the leader's ID
+func TestReplication(t *testing.T) {
+	h, leader := startHarness(t, 3, testConfig)
+	ctx := context.Background()
+
+	if err := leader.Put(ctx, "a", "1"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	leader.Put(ctx, "b", "2")
+	id, err := leader.Checkpoint(ctx, "before")
+	if err != nil || id != 1 {
+		t.Fatalf("Expected checkpoint 1, got %d, %v", id, err)
+	}
+	leader.Put(ctx, "a", "changed")
+	if deleted, err := leader.Delete(ctx, "b"); !deleted || err != nil {
+		t.Errorf("Expected Delete to find b, got %v, %v", deleted, err)
+	}
+	if deleted, _ := leader.Delete(ctx, "missing"); deleted {
+		t.Errorf("Expected Delete of a missing key to return false")
+	}
+	if value, ok, err := leader.Get(ctx, "a"); value != "changed" || !ok || err != nil {
+		t.Errorf("Expected a=changed, got %q, %v, %v", value, ok, err)
+	}
+
+	if err := leader.Revert(ctx); err != nil {
+		t.Fatalf("Revert failed: %v", err)
+	}
+	if err := leader.Revert(ctx); err == nil {
+		t.Errorf("Expected Revert without checkpoints to fail")
+	}
+	waitConverged(t, h)
+
+	for _, node := range h.Nodes() {
+		data, _ := node.Store().GetAllData()
+		if len(data) != 2 || data["a"] != "1" || data["b"] != "2" {
+			t.Errorf("Expected %s to hold a=1 b=2, got %v", node.ID(), data)
+		}
+		if node == leader {
+			continue
+		}
+		var notLeader *NotLeaderError
+		if err := node.Put(ctx, "x", "y"); !errors.As(err, &notLeader) || notLeader.LeaderID != leader.ID() {
+			t.Errorf("Expected %s to redirect writes to %s, got %v", node.ID(), leader.ID(), err)
+		}
+		if _, _, err := node.Get(ctx, "a"); !errors.Is(err, ErrNotLeader) {
+			t.Errorf("Expected %s to refuse reads, got %v", node.ID(), err)
+		}
+	}
+}
+
+// TestLeaderIsolated tests that a leader cut off from the majority can
+// neither commit writes nor serve reads, that the majority elects a new
+// leader, and that the old leader's uncommitted write is discarded on heal
+func TestLeaderIsolated(t *testing.T) {
+	h, old := startHarness(t, 5, testConfig)
+	put(t, h, "k", "before")
+
+	h.Partition([]string{old.ID()}, others(h.IDs(), old.ID()))
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	if err := old.Put(ctx, "k", "lost"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected an isolated leader's write to time out, got %v", err)
+	}
+	cancel()
+	ctx, cancel = context.WithTimeout(context.Background(), 300*time.Millisecond)
+	if _, _, err := old.Get(ctx, "k"); err == nil {
+		t.Errorf("Expected an isolated leader to refuse reads")
+	}
+	cancel()
+
+	put(t, h, "k", "after") // Retries until the majority has a new leader
+	if leader := h.Leader(); leader == old {
+		t.Fatalf("Expected a new leader, still %s", old.ID())
+	}
+
+	h.Heal()
+	waitConverged(t, h)
+	for _, node := range h.Nodes() {
+		if value, _ := node.Store().Get("k"); value != "after" {
+			t.Errorf("Expected %s to hold k=after, got %q", node.ID(), value)
+		}
+	}
+	if s := old.Status(); s.Role != Follower {
+		t.Errorf("Expected the old leader to step down, got %s", s.Role)
+	}
+}
+
+// TestSnapshotCatchUp tests that a follower that missed compacted entries
+// is brought up to date with a snapshot, checkpoints included
+func TestSnapshotCatchUp(t *testing.T) {
+	cfg := testConfig
+	cfg.SnapshotThreshold = 20
+	h, leader := startHarness(t, 3, cfg)
+	lagging := others(h.IDs(), leader.ID())[0]
+
+	h.Partition(others(h.IDs(), lagging))
+	put(t, h, "k0", "v0")
+	ctx := context.Background()
+	if _, err := h.Leader().Checkpoint(ctx, "cp"); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	for i := 1; i < 100; i++ {
+		put(t, h, fmt.Sprintf("k%d", i), fmt.Sprintf("v%d", i))
+	}
+	if s := h.Leader().Status(); s.SnapshotIndex == 0 {
+		t.Fatalf("Expected the leader to have compacted its log, got %+v", s)
+	}
+
+	h.Heal()
+	waitConverged(t, h)
+	if s := h.Node(lagging).Status(); s.SnapshotIndex == 0 {
+		t.Errorf("Expected %s to catch up from a snapshot, got %+v", lagging, s)
+	}
+	if data, _ := h.Node(lagging).Store().GetAllData(); len(data) != 100 {
+		t.Errorf("Expected 100 keys on %s, got %d", lagging, len(data))
+	}
+
+	// The checkpoint came across in the snapshot, so Revert works everywhere
+	err := h.Do(ctx, time.Second, func(ctx context.Context, leader *Node) error {
+		return leader.Revert(ctx)
+	})
+	if err != nil {
+		t.Fatalf("Revert failed: %v", err)
+	}
+	waitConverged(t, h)
+	if data, _ := h.Node(lagging).Store().GetAllData(); len(data) != 1 {
+		t.Errorf("Expected 1 key on %s after revert, got %v", lagging, data)
+	}
+}
+
+// TestRestart tests that nodes restarted from their directories keep their
+// data and log, including a restarted leader
+func TestRestart(t *testing.T) {
+	cfg := testConfig
+	cfg.SnapshotThreshold = 10
+	h, leader := startHarness(t, 3, cfg)
+	for i := 0; i < 25; i++ {
+		put(t, h, fmt.Sprintf("k%d", i), strconv.Itoa(i))
+	}
+	waitConverged(t, h)
+
+	for _, id := range h.IDs() {
+		if err := h.Restart(id); err != nil {
+			t.Fatalf("Restart(%s) failed: %v", id, err)
+		}
+	}
+	if s := h.Node(leader.ID()).Status(); s.SnapshotIndex == 0 || s.LastApplied < s.SnapshotIndex {
+		t.Errorf("Expected the restarted node to start from its snapshot, got %+v", s)
+	}
+	put(t, h, "after", "restart")
+	waitConverged(t, h)
+	for _, node := range h.Nodes() {
+		if data, _ := node.Store().GetAllData(); len(data) != 26 {
+			t.Errorf("Expected 26 keys on %s after restart, got %d", node.ID(), len(data))
+		}
+	}
+}
+
+// TestAppendEntriesPersistFailure tests that entries a follower fails to
+// persist are rejected and left out of its log, so memory and disk agree
+func TestAppendEntriesPersistFailure(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "a")
+	node, err := NewNode(Config{ID: "a", Peers: []string{"a", "b", "c"}, Dir: dir}, nil)
+	if err != nil {
+		t.Fatalf("NewNode failed: %v", err)
+	}
+	cmd := Command{Op: OpPut, Key: "k", Value: "v"}
+	resp, err := node.HandleAppendEntries(&AppendEntriesRequest{
+		Term: 2, LeaderID: "b",
+		Entries: []Entry{{Index: 1, Term: 1, Cmd: cmd}, {Index: 2, Term: 1, Cmd: cmd}},
+	})
+	if err != nil || !resp.Success {
+		t.Fatalf("Expected the first entries to be stored, got %+v, %v", resp, err)
+	}
+
+	// Replacing entry 2 now fails: the node directory is gone
+	os.RemoveAll(dir)
+	conflicting := &AppendEntriesRequest{
+		Term: 2, LeaderID: "b", PrevLogIndex: 1, PrevLogTerm: 1,
+		Entries: []Entry{{Index: 2, Term: 2, Cmd: cmd}, {Index: 3, Term: 2, Cmd: cmd}},
+	}
+	if resp, _ := node.HandleAppendEntries(conflicting); resp.Success {
+		t.Fatal("Expected the entries to be rejected when they can't be persisted")
+	}
+	node.mu.Lock()
+	log := slices.Clone(node.log)
+	node.mu.Unlock()
+	if len(log) != 2 || log[1].Term != 1 {
+		t.Errorf("Expected the log to be unchanged, got %+v", log)
+	}
+
+	os.MkdirAll(dir, 0755)
+	if resp, _ := node.HandleAppendEntries(conflicting); !resp.Success {
+		t.Fatal("Expected the entries to be stored once the directory is back")
+	}
+	state, _, err := readState(dir)
+	if err != nil || len(state.Log) != 3 || state.Log[1].Term != 2 {
+		t.Errorf("Expected the new entries on disk, got %+v, %v", state.Log, err)
+	}
+}
+
+// TestPartitionsRandomized runs clients against a five-node cluster while
+// the network is split at random and messages are delayed and dropped.
+// Each writer counts up on its own key, so the checks are simple:
+//   - a read never returns less than a write acknowledged before it began
+//     (reads are linearizable), nor more than the writer has tried
+//   - once healed, every node holds the same data, and each key is at
+//     least its last acknowledged count
+func TestPartitionsRandomized(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping randomized partitions in short mode")
+	}
+	h, _ := startHarness(t, 5, testConfig)
+	h.Network.SetUnreliable(5*time.Millisecond, 0.05)
+	ids := h.IDs()
+
+	const writers = 3
+	var mu sync.Mutex
+	acked := make([]int, writers) // Highest count acknowledged per key
+	tried := make([]int, writers) // Highest count attempted per key
+	stop := make(chan struct{})
+	errs := make(chan error, writers+1)
+	var wg sync.WaitGroup
+
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key := fmt.Sprintf("w%d", w)
+			for n := 1; ; n++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				mu.Lock()
+				tried[w] = n
+				mu.Unlock()
+				// Puts are idempotent, so retrying after an unknown outcome
+				// is safe
+				for {
+					ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+					err := h.Do(ctx, 300*time.Millisecond, func(ctx context.Context, leader *Node) error {
+						return leader.Put(ctx, key, strconv.Itoa(n))
+					})
+					cancel()
+					if err == nil {
+						break
+					}
+					select {
+					case <-stop:
+						return
+					default:
+					}
+				}
+				mu.Lock()
+				acked[w] = n
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r := rand.New(rand.NewPCG(7, 7))
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			w := r.IntN(writers)
+			mu.Lock()
+			floor := acked[w]
+			mu.Unlock()
+			var value string
+			var found bool
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			err := h.Do(ctx, 200*time.Millisecond, func(ctx context.Context, leader *Node) error {
+				var err error
+				value, found, err = leader.Get(ctx, fmt.Sprintf("w%d", w))
+				return err
+			})
+			cancel()
+			if err != nil {
+				continue // No leader reachable right now
+			}
+			got := 0
+			if found {
+				got, _ = strconv.Atoi(value)
+			}
+			mu.Lock()
+			ceiling := tried[w]
+			mu.Unlock()
+			if got < floor || got > ceiling {
+				errs <- fmt.Errorf("read w%d=%d, expected between %d and %d", w, got, floor, ceiling)
+				return
+			}
+		}
+	}()
+
+	r := rand.New(rand.NewPCG(5, 5))
+	for round := 0; round < 10; round++ {
+		switch r.IntN(3) {
+		case 0:
+			h.Heal()
+		case 1:
+			// A majority and a minority
+			shuffled := append([]string(nil), ids...)
+			r.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+			h.Partition(shuffled[:3], shuffled[3:])
+		default:
+			// No majority anywhere: nothing can commit
+			h.Partition(ids[:2], ids[2:4], ids[4:])
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	h.Heal()
+	h.Network.SetUnreliable(0, 0)
+	time.Sleep(300 * time.Millisecond) // Let the writers make progress again
+	close(stop)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	t.Logf("Acknowledged writes per key: %v", acked)
+
+	waitConverged(t, h)
+	data, _ := h.Leader().Store().GetAllData()
+	for w := 0; w < writers; w++ {
+		got, _ := strconv.Atoi(data[fmt.Sprintf("w%d", w)])
+		if got < acked[w] || got > tried[w] {
+			t.Errorf("Expected w%d between %d and %d, got %d", w, acked[w], tried[w], got)
+		}
+		if acked[w] == 0 {
+			t.Errorf("Expected writer %d to make progress", w)
+		}
+	}
+}
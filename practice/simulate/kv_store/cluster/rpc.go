@@ -0,0 +1,118 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotLeader is wrapped by the NotLeaderError returned for a write
+	// or read sent to a node that isn't the leader
+	ErrNotLeader = errors.New("not the leader")
+	// ErrLeadershipLost is returned for a write when its node stopped being
+	// leader before learning whether the write committed. It may or may
+	// not have been applied.
+	ErrLeadershipLost = errors.New("leadership lost before the command committed")
+	// ErrUnreachable is returned by a transport that can't reach a node
+	ErrUnreachable = errors.New("node unreachable")
+	// ErrStopped is returned by a node after Stop
+	ErrStopped = errors.New("node stopped")
+)
+
+// NotLeaderError is returned by a node that can't serve a request because
+// it isn't the leader. LeaderID is the leader it last heard from ("" if it
+// doesn't know one).
+type NotLeaderError struct {
+	LeaderID string
+}
+
+func (e *NotLeaderError) Error() string {
+	if e.LeaderID == "" {
+		return "not the leader (leader unknown)"
+	}
+	return fmt.Sprintf("not the leader (leader is %s)", e.LeaderID)
+}
+
+func (e *NotLeaderError) Unwrap() error {
+	return ErrNotLeader
+}
+
+// Op is the kind of a replicated Command
+type Op string
+
+const (
+	OpNoop       Op = "noop" // Appended by a new leader to commit entries from earlier terms
+	OpPut        Op = "put"
+	OpDelete     Op = "delete"
+	OpCheckpoint Op = "checkpoint"
+	OpRevert     Op = "revert"
+)
+
+// Command is one store operation in the Raft log
+type Command struct {
+	Op    Op     `json:"op"`
+	Key   string `json:"key,omitempty"`
+	Value string `json:"value,omitempty"`
+	Name  string `json:"name,omitempty"` // Checkpoint name
+}
+
+// Entry is one Raft log entry
+type Entry struct {
+	Index uint64  `json:"index"`
+	Term  uint64  `json:"term"`
+	Cmd   Command `json:"cmd"`
+}
+
+// RequestVoteRequest asks for a vote in an election
+type RequestVoteRequest struct {
+	Term         uint64 `json:"term"`
+	CandidateID  string `json:"candidateId"`
+	LastLogIndex uint64 `json:"lastLogIndex"`
+	LastLogTerm  uint64 `json:"lastLogTerm"`
+}
+
+type RequestVoteResponse struct {
+	Term        uint64 `json:"term"`
+	VoteGranted bool   `json:"voteGranted"`
+}
+
+// AppendEntriesRequest replicates entries (none for a heartbeat)
+type AppendEntriesRequest struct {
+	Term         uint64  `json:"term"`
+	LeaderID     string  `json:"leaderId"`
+	PrevLogIndex uint64  `json:"prevLogIndex"`
+	PrevLogTerm  uint64  `json:"prevLogTerm"`
+	Entries      []Entry `json:"entries,omitempty"`
+	LeaderCommit uint64  `json:"leaderCommit"`
+}
+
+// AppendEntriesResponse reports whether the follower's log matched at
+// PrevLogIndex. On a mismatch ConflictIndex is where the leader should
+// retry from, so it can skip back a whole term at a time.
+type AppendEntriesResponse struct {
+	Term          uint64 `json:"term"`
+	Success       bool   `json:"success"`
+	ConflictIndex uint64 `json:"conflictIndex,omitempty"`
+}
+
+// InstallSnapshotRequest replaces a lagging follower's state with the
+// leader's snapshot (a SaveToDisk file) as of LastIndex
+type InstallSnapshotRequest struct {
+	Term      uint64 `json:"term"`
+	LeaderID  string `json:"leaderId"`
+	LastIndex uint64 `json:"lastIndex"`
+	LastTerm  uint64 `json:"lastTerm"`
+	Data      []byte `json:"data"`
+}
+
+type InstallSnapshotResponse struct {
+	Term uint64 `json:"term"`
+}
+
+// Transport carries RPCs from one node to the others, identified by ID
+type Transport interface {
+	RequestVote(ctx context.Context, to string, req *RequestVoteRequest) (*RequestVoteResponse, error)
+	AppendEntries(ctx context.Context, to string, req *AppendEntriesRequest) (*AppendEntriesResponse, error)
+	InstallSnapshot(ctx context.Context, to string, req *InstallSnapshotRequest) (*InstallSnapshotResponse, error)
+}
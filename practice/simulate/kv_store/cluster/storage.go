@@ -0,0 +1,61 @@
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"workshop/practice/simulate/kv_store/store"
+)
+
+// stateFile holds a node's persistent Raft state in its Dir
+const stateFile = "raft.json"
+
+// persistentState is what a node must remember across restarts: its vote,
+// its log and the snapshot the log starts after. The snapshot itself is a
+// SaveToDisk file named by Snapshot, written before this file points at it,
+// so a crash between the two leaves the old snapshot in use.
+type persistentState struct {
+	Term      uint64  `json:"term"`
+	VotedFor  string  `json:"votedFor,omitempty"`
+	SnapIndex uint64  `json:"snapIndex"`
+	SnapTerm  uint64  `json:"snapTerm"`
+	Snapshot  string  `json:"snapshot,omitempty"`
+	Log       []Entry `json:"log"`
+}
+
+// snapshotName returns the file name for the snapshot as of index
+func snapshotName(index, term uint64) string {
+	return fmt.Sprintf("snapshot-%d-%d.kvs.gz", index, term)
+}
+
+// readState reads dir's persistent state, returning ok=false for a node that
+// has never run
+func readState(dir string) (state persistentState, ok bool, err error) {
+	content, err := os.ReadFile(filepath.Join(dir, stateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return state, false, nil
+	}
+	if err != nil {
+		return state, false, fmt.Errorf("failed to read raft state: %w", err)
+	}
+	if err := json.Unmarshal(content, &state); err != nil {
+		return state, false, fmt.Errorf("failed to parse raft state: %w", err)
+	}
+	return state, true, nil
+}
+
+// writeState replaces dir's persistent state with store.WriteFileAtomic, so
+// it survives power loss as well as the process restarting.
+func writeState(dir string, state *persistentState) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode raft state: %w", err)
+	}
+	if err := store.WriteFileAtomic(filepath.Join(dir, stateFile), content); err != nil {
+		return fmt.Errorf("failed to write raft state: %w", err)
+	}
+	return nil
+}
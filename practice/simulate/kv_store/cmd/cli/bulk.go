@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"workshop/practice/simulate/kv_store/store"
+)
+
+// Data formats for import and export
+const (
+	dataCSV   = "csv"   // A header row naming the columns, then one row per key
+	dataJSONL = "jsonl" // One JSON object per line
+)
+
+// dataFormat returns the format to import or export filename in: -format if
+// it is set, else the one its extension selects
+func dataFormat(filename string) (string, error) {
+	name := *format
+	if name == "" {
+		switch strings.ToLower(filepath.Ext(filename)) {
+		case ".csv":
+			name = dataCSV
+		case ".jsonl", ".ndjson":
+			name = dataJSONL
+		default:
+			return "", codedError{codeUsage, fmt.Errorf("can't tell the format of '%s' from its extension (use -format csv or jsonl)", filename)}
+		}
+	}
+	if name != dataCSV && name != dataJSONL {
+		return "", codedError{codeUsage, fmt.Errorf("unknown data format '%s' (want csv or jsonl)", name)}
+	}
+	return name, nil
+}
+
+// importFile puts the keyCol and valueCol of every row in filename into the
+// store as one bulk load, after a checkpoint that undoes it. Nothing is
+// loaded if any row is bad.
+func importFile(filename, keyCol, valueCol string) error {
+	f, err := dataFormat(filename)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var pairs []store.KeyValue[string]
+	if f == dataCSV {
+		pairs, err = readCSV(file, keyCol, valueCol)
+	} else {
+		pairs, err = readJSONL(file, keyCol, valueCol)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+
+	name := "import:" + filepath.Base(filename)
+	id, err := kvStore.BulkLoad(name, pairs)
+	if err != nil {
+		return err
+	}
+	report(importResult{File: filename, Format: f, Rows: len(pairs), Checkpoint: id, Name: name})
+	return nil
+}
+
+// readCSV reads the keyCol and valueCol columns, named in the header row,
+// of each row in r
+func readCSV(r io.Reader, keyCol, valueCol string) ([]store.KeyValue[string], error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("no header row")
+	}
+	if err != nil {
+		return nil, err
+	}
+	header[0] = strings.TrimPrefix(header[0], "\ufeff") // Byte order mark
+	keyIndex, valueIndex := slices.Index(header, keyCol), slices.Index(header, valueCol)
+	for _, col := range []struct {
+		name  string
+		index int
+	}{{keyCol, keyIndex}, {valueCol, valueIndex}} {
+		if col.index < 0 {
+			return nil, codedError{codeUsage, fmt.Errorf("no column '%s' (columns: %s)", col.name, strings.Join(header, ", "))}
+		}
+	}
+
+	var pairs []store.KeyValue[string]
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return pairs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(keyIndex)
+		pair, err := rowPair(record[keyIndex], record[valueIndex])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		pairs = append(pairs, pair)
+	}
+}
+
+// readJSONL reads the keyCol and valueCol fields of each object in r, one
+// per line. String fields are taken without their quotes (except as -type
+// json values); other fields as JSON.
+func readJSONL(r io.Reader, keyCol, valueCol string) ([]store.KeyValue[string], error) {
+	br := bufio.NewReader(r)
+	var pairs []store.KeyValue[string]
+	for lineNo := 1; ; lineNo++ {
+		line, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(line, &fields); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			key, hasKey := fields[keyCol]
+			value, hasValue := fields[valueCol]
+			if !hasKey || !hasValue {
+				missing := keyCol
+				if hasKey {
+					missing = valueCol
+				}
+				return nil, codedError{codeUsage, fmt.Errorf("line %d: no field '%s'", lineNo, missing)}
+			}
+			pair, err := rowPair(jsonText(key, false), jsonText(value, vt.name == "json"))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			pairs = append(pairs, pair)
+		}
+		if err == io.EOF {
+			return pairs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// jsonText returns a JSON field as text: a string's contents, unless raw is
+// set, or the JSON itself
+func jsonText(field json.RawMessage, raw bool) string {
+	var s string
+	if !raw && json.Unmarshal(field, &s) == nil {
+		return s
+	}
+	return string(field)
+}
+
+// rowPair checks the key and value read from one row
+func rowPair(key, value string) (store.KeyValue[string], error) {
+	if key == "" {
+		return store.KeyValue[string]{}, codedError{codeInvalidValue, errors.New("empty key")}
+	}
+	normalized, err := vt.normalize(value)
+	if err != nil {
+		return store.KeyValue[string]{}, codedError{codeInvalidValue, fmt.Errorf("key '%s': %w", key, err)}
+	}
+	return store.KeyValue[string]{Key: key, Value: normalized}, nil
+}
+
+// exportFile writes every pair, in key order, to filename with the key in
+// keyCol and the value in valueCol, in a form importFile reads back
+func exportFile(filename, keyCol, valueCol string) error {
+	f, err := dataFormat(filename)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	var n int
+	if f == dataCSV {
+		n, err = writeCSV(w, keyCol, valueCol)
+	} else {
+		n, err = writeJSONL(w, keyCol, valueCol)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	report(exportResult{File: filename, Format: f, Keys: n})
+	return nil
+}
+
+// writeCSV writes a header row and then each pair's canonical text
+func writeCSV(w io.Writer, keyCol, valueCol string) (int, error) {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{keyCol, valueCol})
+	n := 0
+	for k, v := range kvStore.Scan("", "") {
+		cw.Write([]string{k, v})
+		n++
+	}
+	cw.Flush()
+	return n, cw.Error()
+}
+
+// writeJSONL writes one object per pair, with the value as the JSON of its
+// -type
+func writeJSONL(w io.Writer, keyCol, valueCol string) (int, error) {
+	keyField, _ := json.Marshal(keyCol)
+	valueField, _ := json.Marshal(valueCol)
+	n := 0
+	for k, v := range kvStore.Scan("", "") {
+		key, _ := json.Marshal(k)
+		value, err := vt.codec.Marshal(v)
+		if err != nil {
+			return n, fmt.Errorf("failed to encode value of '%s': %w", k, err)
+		}
+		if _, err := fmt.Fprintf(w, "{%s:%s,%s:%s}\n", keyField, key, valueField, value); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
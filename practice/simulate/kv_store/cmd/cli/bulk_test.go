@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestImportCSV tests importing two named columns of a CSV dump, and that
+// revert undoes the import
+func TestImportCSV(t *testing.T) {
+	buf := useTestStore(t)
+	kvStore.Put("keep", "1")
+	os.WriteFile("customers.csv", []byte("\ufeffIndex,Customer Id,Email\n1,DD37Cf93aecA6Dc,\"a@x.com\"\n2,1Ef7b82A4CAAD10,b@x.com\n"), 0644)
+
+	if !executeInteractiveCommand(`import customers.csv "Customer Id" Email`) {
+		t.Fatalf("Expected import to succeed: %s", buf)
+	}
+	if !strings.Contains(buf.String(), "Imported 2 row(s)") {
+		t.Errorf("Expected an import report, got %q", buf)
+	}
+	if v, _ := kvStore.Get("1Ef7b82A4CAAD10"); v != "b@x.com" {
+		t.Errorf("Expected b@x.com, got %q", v)
+	}
+
+	executeInteractiveCommand("revert")
+	if data, _ := kvStore.GetAllData(); len(data) != 1 {
+		t.Errorf("Expected revert to undo the import, got %v", data)
+	}
+}
+
+// TestImportJSONL tests importing two fields of each line of a JSON Lines
+// dump, such as the backlog's requests.jsonl
+func TestImportJSONL(t *testing.T) {
+	useTestStore(t)
+	os.WriteFile("requests.jsonl", []byte(`{"request_id": "user-001", "title": "First", "body": "..."}`+"\n\n"+`{"request_id": "user-002", "title": "Second"}`+"\n"), 0644)
+
+	if !executeInteractiveCommand("import requests.jsonl request_id title") {
+		t.Fatalf("Expected import to succeed")
+	}
+	if data, _ := kvStore.GetAllData(); len(data) != 2 || data["user-002"] != "Second" {
+		t.Errorf("Expected 2 keys with user-002=Second, got %v", data)
+	}
+}
+
+// TestImportErrors tests that a bad file imports nothing
+func TestImportErrors(t *testing.T) {
+	buf := useTestStore(t)
+	vt, _ = lookupValueType("int")
+	kvStore = newStore()
+	os.WriteFile("data.csv", []byte("key,value\na,1\nb,two\n"), 0644)
+	os.WriteFile("data.jsonl", []byte(`{"key":"a","value":1}`+"\n"+`{"key":"b"}`+"\n"), 0644)
+	os.WriteFile("data.txt", []byte("a 1\n"), 0644)
+
+	for _, tt := range []struct{ input, want string }{
+		{"import data.csv", "line 3: key 'b'"},
+		{"import data.csv id", "no column 'id'"},
+		{"import data.jsonl", "line 2: no field 'value'"},
+		{"import data.txt", "can't tell the format"},
+		{"import nope.csv", "no such file"},
+	} {
+		buf.Reset()
+		if executeInteractiveCommand(tt.input) {
+			t.Errorf("Expected '%s' to fail", tt.input)
+		}
+		if !strings.Contains(buf.String(), tt.want) {
+			t.Errorf("Expected '%s' to report %q, got %q", tt.input, tt.want, buf)
+		}
+	}
+	if data, _ := kvStore.GetAllData(); len(data) != 0 || kvStore.GetCheckpointCount() != 0 {
+		t.Errorf("Expected failed imports to change nothing, got %v and %d checkpoints", data, kvStore.GetCheckpointCount())
+	}
+}
+
+// TestExportRoundTrip tests that each format reads back what it exports
+func TestExportRoundTrip(t *testing.T) {
+	for _, tt := range []struct{ typ, file string }{
+		{"string", "out.csv"},
+		{"string", "out.jsonl"},
+		{"json", "out.jsonl"},
+	} {
+		t.Run(tt.typ+"/"+tt.file, func(t *testing.T) {
+			useTestStore(t)
+			vt, _ = lookupValueType(tt.typ)
+			kvStore = newStore()
+			want := map[string]string{"a": `"quoted, text"`, "b": `{"n":[1,2]}`, "c\nd": "3"}
+			for k, v := range want {
+				kvStore.Put(k, v)
+			}
+
+			if !executeInteractiveCommand("export " + tt.file + " id val") {
+				t.Fatalf("Expected export to %s to succeed", tt.file)
+			}
+			kvStore = newStore()
+			if !executeInteractiveCommand("import " + tt.file + " id val") {
+				t.Fatalf("Expected import of %s to succeed", tt.file)
+			}
+			got, _ := kvStore.GetAllData()
+			if len(got) != len(want) {
+				t.Errorf("Expected %d keys back from %s (-type %s), got %v", len(want), tt.file, tt.typ, got)
+			}
+			for k, v := range want {
+				if got[k] != v {
+					t.Errorf("Expected %q=%q back from %s (-type %s), got %q", k, v, tt.file, tt.typ, got[k])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// A script is a file of interactive-mode commands, one per line, run by
+// `kv-cli exec script.kv` (or piped to stdin) against one store that is
+// saved once at the end:
+//
+//	# Stop at the first failure
+//	set -e
+//	put name Alice
+//	get name
+//	> ✅ 'name' = "Alice"
+//	! get missing
+//	> ❌ Key 'missing' not found
+//
+// Lines starting with # are comments. set -e stops the script at the first
+// failed command and set +e carries on past them. A command prefixed with !
+// must fail. Lines starting with > are the expected output of the command
+// above them, line for line (a bare > is an empty line); commands without
+// them print their output unchecked. exit or quit ends the script early.
+
+// scriptStep is one command of a script and what it should do
+type scriptStep struct {
+	line     int      // Line number, for messages
+	input    string   // Command line, as typed in interactive mode
+	wantFail bool     // Prefixed with !
+	want     []string // Expected output lines, nil if unchecked
+	abort    bool     // set -e was in effect
+}
+
+// parseScript reads a script into steps
+func parseScript(r io.Reader, name string) ([]scriptStep, error) {
+	var steps []scriptStep
+	abort := false
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if want, ok := strings.CutPrefix(line, ">"); ok {
+			if len(steps) == 0 {
+				return nil, fmt.Errorf("%s:%d: expected output before any command", name, n)
+			}
+			step := &steps[len(steps)-1]
+			step.want = append(step.want, strings.TrimPrefix(want, " "))
+			continue
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case line == "set -e":
+			abort = true
+			continue
+		case line == "set +e":
+			abort = false
+			continue
+		}
+		step := scriptStep{line: n, input: line, abort: abort}
+		if input, ok := strings.CutPrefix(line, "!"); ok {
+			step.input, step.wantFail = strings.TrimSpace(input), true
+		}
+		steps = append(steps, step)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return steps, nil
+}
+
+// runScript runs the script in r (named name in messages) and returns an
+// error if any command failed unexpectedly or printed something other than
+// its expected output
+func runScript(r io.Reader, name string) error {
+	steps, err := parseScript(r, name)
+	if err != nil {
+		return err
+	}
+
+	stdout := out
+	defer func() { out = stdout }()
+
+	failures := 0
+	for _, step := range steps {
+		if step.input == "exit" || step.input == "quit" {
+			break
+		}
+
+		var buf bytes.Buffer
+		out = &buf
+		ok := executeInteractiveCommand(step.input)
+		out = stdout
+		stdout.Write(buf.Bytes())
+
+		failed := false
+		if ok == step.wantFail {
+			failed = true
+			if ok {
+				fmt.Fprintf(stdout, "❌ %s:%d: '%s' succeeded but was expected to fail\n", name, step.line, step.input)
+			} else {
+				fmt.Fprintf(stdout, "❌ %s:%d: '%s' failed\n", name, step.line, step.input)
+			}
+		}
+		if got := outputLines(buf.String()); step.want != nil && !slices.Equal(got, step.want) {
+			failed = true
+			fmt.Fprintf(stdout, "❌ %s:%d: unexpected output from '%s'\n", name, step.line, step.input)
+			for _, line := range step.want {
+				fmt.Fprintf(stdout, "  - %s\n", line)
+			}
+			for _, line := range got {
+				fmt.Fprintf(stdout, "  + %s\n", line)
+			}
+		}
+		if failed {
+			failures++
+			if step.abort {
+				return fmt.Errorf("%s:%d: stopped by set -e", name, step.line)
+			}
+		}
+	}
+	if failures > 0 {
+		return fmt.Errorf("%s: %d command(s) failed", name, failures)
+	}
+	return nil
+}
+
+// runScriptFile runs the script in filename, or stdin if it is "" or "-"
+func runScriptFile(filename string) error {
+	if filename == "" || filename == "-" {
+		return runScript(os.Stdin, "stdin")
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return runScript(f, filename)
+}
+
+// stdinIsPipe reports whether stdin is a pipe or file rather than a
+// terminal, so that running with no command runs it as a script
+func stdinIsPipe() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice == 0
+}
+
+// outputLines splits a command's output into lines without trailing spaces
+func outputLines(s string) []string {
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	if s == "" {
+		lines = nil
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return lines
+}
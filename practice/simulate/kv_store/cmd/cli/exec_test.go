@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useTestStore points the CLI at a fresh string store, with output captured
+// in the returned buffer and the working directory set to a temporary one
+func useTestStore(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var err error
+	if vt, err = lookupValueType("string"); err != nil {
+		t.Fatalf("lookupValueType failed: %v", err)
+	}
+	kvStore = newStore()
+	t.Chdir(t.TempDir())
+
+	var buf bytes.Buffer
+	out = &buf
+	t.Cleanup(func() { out = os.Stdout })
+	return &buf
+}
+
+// TestScripts runs each script in testdata as a golden test: every command
+// must succeed (or fail, if marked with !) and print its expected output
+func TestScripts(t *testing.T) {
+	scripts, err := filepath.Glob("testdata/*.kv")
+	if err != nil || len(scripts) == 0 {
+		t.Fatalf("Expected scripts in testdata, got %v (%v)", scripts, err)
+	}
+	for _, script := range scripts {
+		t.Run(filepath.Base(script), func(t *testing.T) {
+			path, _ := filepath.Abs(script)
+			buf := useTestStore(t)
+			if err := runScriptFile(path); err != nil {
+				t.Errorf("Script failed: %v\n%s", err, buf)
+			}
+		})
+	}
+}
+
+// TestScriptFailures tests that failed commands and mismatched output are
+// reported, and that set -e stops at the first of them
+func TestScriptFailures(t *testing.T) {
+	buf := useTestStore(t)
+	script := `
+put a 1
+get a
+> ✅ 'a' = 2
+get missing
+! get a
+set -e
+put b 2
+! put c 3
+put d 4
+`
+	err := runScript(strings.NewReader(script), "test.kv")
+	if err == nil || err.Error() != "test.kv:9: stopped by set -e" {
+		t.Errorf("Expected set -e to stop at line 9, got %v", err)
+	}
+	for _, want := range []string{
+		"❌ test.kv:3: unexpected output from 'get a'\n  - ✅ 'a' = 2\n  + ✅ 'a' = 1\n",
+		"❌ test.kv:5: 'get missing' failed\n",
+		"❌ test.kv:6: 'get a' succeeded but was expected to fail\n",
+		"❌ test.kv:9: 'put c 3' succeeded but was expected to fail\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, buf)
+		}
+	}
+	if _, ok := kvStore.Get("d"); ok {
+		t.Errorf("Expected the script to stop before putting d")
+	}
+
+	useTestStore(t)
+	err = runScript(strings.NewReader("put a 1\nget missing\nput b 2\nexit\nput c 3\n"), "test.kv")
+	if err == nil || err.Error() != "test.kv: 1 command(s) failed" {
+		t.Errorf("Expected one failed command, got %v", err)
+	}
+	if _, ok := kvStore.Get("b"); !ok {
+		t.Errorf("Expected the script to carry on past the failure without set -e")
+	}
+	if _, ok := kvStore.Get("c"); ok {
+		t.Errorf("Expected exit to end the script")
+	}
+}
+
+// TestParseScript tests comments, directives and expected output lines
+func TestParseScript(t *testing.T) {
+	script := "# comment\n\nput a \"x y\"\n>\n>   indented\nset -e\n  ! get b  \n"
+	steps, err := parseScript(strings.NewReader(script), "test.kv")
+	if err != nil {
+		t.Fatalf("parseScript failed: %v", err)
+	}
+	if len(steps) != 2 {
+		t.Fatalf("Expected 2 steps, got %d", len(steps))
+	}
+	put, get := steps[0], steps[1]
+	if put.line != 3 || put.input != `put a "x y"` || put.abort || len(put.want) != 2 || put.want[0] != "" || put.want[1] != "  indented" {
+		t.Errorf("Unexpected first step %+v", put)
+	}
+	if get.line != 7 || get.input != "get b" || !get.wantFail || !get.abort || get.want != nil {
+		t.Errorf("Unexpected second step %+v", get)
+	}
+
+	if _, err := parseScript(strings.NewReader("> orphan\n"), "test.kv"); err == nil {
+		t.Errorf("Expected an error for output before any command")
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// errInterrupted is returned by readLine when Ctrl+C discards the line
+var errInterrupted = errors.New("interrupted")
+
+// maxHistory is the number of lines kept in the history file
+const maxHistory = 1000
+
+// Entries spanning several lines are stored on one line of the history file
+var (
+	historyEscaper   = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	historyUnescaper = strings.NewReplacer(`\\`, `\`, `\n`, "\n")
+)
+
+// Keys the line editor handles
+const (
+	keyCtrlA     = 1
+	keyCtrlB     = 2
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlF     = 6
+	keyBackspace = 8
+	keyTab       = 9
+	keyCtrlK     = 11
+	keyCtrlL     = 12
+	keyEnter     = 13
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlU     = 21
+	keyCtrlW     = 23
+	keyEscape    = 27
+	keyDelete    = 127
+)
+
+// lineEditor reads REPL input. On a terminal it edits the line in place
+// (arrows, Home/End, Ctrl+A/E/K/U/W), recalls history with Up/Down and
+// completes with Tab; otherwise it reads plain lines. History is appended to
+// a file as lines are entered, so it survives the session.
+type lineEditor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	terminal bool                                               // Edit lines in place
+	makeRaw  func() (func(), error)                             // Puts the terminal in raw mode while a line is read, if set
+	complete func(line string) (start int, candidates []string) // Candidates for line[start:]
+
+	history     []string
+	historyFile string // "" keeps history for this session only
+
+	mu      sync.Mutex // Guards the line being edited, shared with printAbove
+	editing bool
+	prompt  string
+	line    []rune
+	pos     int // Cursor position in line
+}
+
+// newLineEditor creates an editor reading in and writing out, loading
+// history from historyFile if it is set
+func newLineEditor(in io.Reader, out io.Writer, terminal bool, historyFile string) *lineEditor {
+	e := &lineEditor{in: bufio.NewReader(in), out: out, terminal: terminal, historyFile: historyFile}
+	if historyFile != "" {
+		e.loadHistory()
+	}
+	return e
+}
+
+// loadHistory reads the history file, trimming it to maxHistory lines
+func (e *lineEditor) loadHistory() {
+	content, err := os.ReadFile(e.historyFile)
+	if err != nil {
+		return
+	}
+	for line := range strings.Lines(string(content)) {
+		if line = strings.TrimRight(line, "\r\n"); line != "" {
+			e.history = append(e.history, historyUnescaper.Replace(line))
+		}
+	}
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+		var b strings.Builder
+		for _, line := range e.history {
+			b.WriteString(historyEscaper.Replace(line) + "\n")
+		}
+		os.WriteFile(e.historyFile, []byte(b.String()), 0600)
+	}
+}
+
+// addHistory records an entered line (which may span several), skipping
+// repeats of the last one
+func (e *lineEditor) addHistory(line string) {
+	if line == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return
+	}
+	e.history = append(e.history, line)
+	if e.historyFile == "" {
+		return
+	}
+	os.MkdirAll(filepath.Dir(e.historyFile), 0700)
+	f, err := os.OpenFile(e.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(f, historyEscaper.Replace(line))
+	f.Close()
+}
+
+// readLine shows prompt and returns the line entered, without its newline.
+// It returns io.EOF at the end of input (or Ctrl+D on an empty line) and
+// errInterrupted if Ctrl+C discarded the line.
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	if !e.terminal {
+		fmt.Fprint(e.out, prompt)
+		line, err := e.in.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	if e.makeRaw != nil {
+		restore, err := e.makeRaw()
+		if err != nil {
+			return "", err
+		}
+		defer restore()
+	}
+
+	e.mu.Lock()
+	e.editing, e.prompt, e.line, e.pos = true, prompt, nil, 0
+	e.redrawLocked()
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		e.editing = false
+		e.mu.Unlock()
+	}()
+
+	historyPos := len(e.history)
+	var draft []rune // The new line, while browsing history
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		e.mu.Lock()
+		switch r {
+		case keyEnter, '\n':
+			line := string(e.line)
+			fmt.Fprint(e.out, "\n")
+			e.mu.Unlock()
+			return line, nil
+		case keyCtrlC:
+			fmt.Fprint(e.out, "^C\n")
+			e.mu.Unlock()
+			return "", errInterrupted
+		case keyCtrlD:
+			if len(e.line) == 0 {
+				fmt.Fprint(e.out, "\n")
+				e.mu.Unlock()
+				return "", io.EOF
+			}
+			e.deleteLocked(e.pos, e.pos+1)
+		case keyBackspace, keyDelete:
+			e.deleteLocked(e.pos-1, e.pos)
+		case keyCtrlA:
+			e.pos = 0
+		case keyCtrlE:
+			e.pos = len(e.line)
+		case keyCtrlB:
+			e.pos = max(e.pos-1, 0)
+		case keyCtrlF:
+			e.pos = min(e.pos+1, len(e.line))
+		case keyCtrlK:
+			e.deleteLocked(e.pos, len(e.line))
+		case keyCtrlU:
+			e.deleteLocked(0, e.pos)
+		case keyCtrlW:
+			start := e.pos
+			for start > 0 && e.line[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && e.line[start-1] != ' ' {
+				start--
+			}
+			e.deleteLocked(start, e.pos)
+		case keyCtrlL:
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case keyCtrlP, keyCtrlN:
+			historyPos, draft = e.browseLocked(r == keyCtrlP, historyPos, draft)
+		case keyTab:
+			e.completeLocked()
+		case keyEscape:
+			historyPos, draft = e.escapeLocked(historyPos, draft)
+		default:
+			if unicode.IsPrint(r) {
+				e.line = append(e.line[:e.pos], append([]rune{r}, e.line[e.pos:]...)...)
+				e.pos++
+			}
+		}
+		e.redrawLocked()
+		e.mu.Unlock()
+	}
+}
+
+// escapeLocked handles the rest of an escape sequence (arrow, Home, End
+// and Delete keys); the caller must hold mu
+func (e *lineEditor) escapeLocked(historyPos int, draft []rune) (int, []rune) {
+	if r, _, err := e.in.ReadRune(); err != nil || (r != '[' && r != 'O') {
+		return historyPos, draft
+	}
+	r, _, err := e.in.ReadRune()
+	if err != nil {
+		return historyPos, draft
+	}
+	if r >= '0' && r <= '9' { // e.g. ESC [ 3 ~
+		if next, _, err := e.in.ReadRune(); err != nil || next != '~' {
+			return historyPos, draft
+		}
+	}
+	switch r {
+	case 'A':
+		return e.browseLocked(true, historyPos, draft)
+	case 'B':
+		return e.browseLocked(false, historyPos, draft)
+	case 'C':
+		e.pos = min(e.pos+1, len(e.line))
+	case 'D':
+		e.pos = max(e.pos-1, 0)
+	case 'H', '1', '7':
+		e.pos = 0
+	case 'F', '4', '8':
+		e.pos = len(e.line)
+	case '3':
+		e.deleteLocked(e.pos, e.pos+1)
+	}
+	return historyPos, draft
+}
+
+// browseLocked moves through history, older if back is true, keeping the
+// line being typed as draft to come back to; the caller must hold mu
+func (e *lineEditor) browseLocked(back bool, historyPos int, draft []rune) (int, []rune) {
+	switch {
+	case back && historyPos > 0:
+		if historyPos == len(e.history) {
+			draft = e.line
+		}
+		historyPos--
+		e.line = []rune(e.history[historyPos])
+	case !back && historyPos < len(e.history):
+		historyPos++
+		if historyPos == len(e.history) {
+			e.line = draft
+		} else {
+			e.line = []rune(e.history[historyPos])
+		}
+	}
+	e.pos = len(e.line)
+	return historyPos, draft
+}
+
+// deleteLocked removes line[from:to], clamped to the line; the caller must
+// hold mu
+func (e *lineEditor) deleteLocked(from, to int) {
+	from, to = max(from, 0), min(to, len(e.line))
+	if from >= to {
+		return
+	}
+	e.line = append(e.line[:from], e.line[to:]...)
+	e.pos = from
+}
+
+// completeLocked completes the word before the cursor: fully if there is
+// one candidate, else to their common prefix, listing them if that adds
+// nothing. The caller must hold mu.
+func (e *lineEditor) completeLocked() {
+	if e.complete == nil {
+		return
+	}
+	start, candidates := e.complete(string(e.line[:e.pos]))
+	if len(candidates) == 0 {
+		return
+	}
+	word := string(e.line[start:e.pos])
+	insert := commonPrefix(candidates)
+	if len(candidates) == 1 {
+		insert += " "
+	}
+	if insert == word {
+		fmt.Fprint(e.out, "\n"+strings.Join(candidates, "  ")+"\n")
+		return
+	}
+	rest := append([]rune(insert), e.line[e.pos:]...)
+	e.line = append(e.line[:start], rest...)
+	e.pos = start + len([]rune(insert))
+}
+
+// commonPrefix returns the longest prefix shared by every string in s
+func commonPrefix(s []string) string {
+	prefix := s[0]
+	for _, c := range s[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			_, size := utf8.DecodeLastRuneInString(prefix)
+			prefix = prefix[:len(prefix)-size]
+		}
+	}
+	return prefix
+}
+
+// redrawLocked repaints the prompt and line and places the cursor, showing
+// newlines (in recalled multi-line entries) as ↵; the caller must hold mu
+func (e *lineEditor) redrawLocked() {
+	fmt.Fprintf(e.out, "\r\x1b[K%s%s", e.prompt, strings.ReplaceAll(string(e.line), "\n", "↵"))
+	if back := len(e.line) - e.pos; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
+
+// printAbove prints msg (one or more lines) without disturbing a line being
+// edited: it appears above the prompt, which is drawn again below it
+func (e *lineEditor) printAbove(msg string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.editing || !e.terminal {
+		fmt.Fprint(e.out, msg)
+		return
+	}
+	fmt.Fprint(e.out, "\r\x1b[K"+msg)
+	e.redrawLocked()
+}
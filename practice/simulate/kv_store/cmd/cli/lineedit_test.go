@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// newTestEditor returns a terminal editor reading keys, with output
+// discarded
+func newTestEditor(keys, historyFile string) *lineEditor {
+	return newLineEditor(strings.NewReader(keys), io.Discard, true, historyFile)
+}
+
+// readLines reads lines from e until the input runs out
+func readLines(t *testing.T, e *lineEditor) []string {
+	t.Helper()
+	var lines []string
+	for {
+		line, err := e.readLine("> ")
+		if err == io.EOF {
+			return lines
+		}
+		if errors.Is(err, errInterrupted) {
+			lines = append(lines, "^C")
+			continue
+		}
+		if err != nil {
+			t.Fatalf("readLine failed: %v", err)
+		}
+		lines = append(lines, line)
+		e.addHistory(line)
+	}
+}
+
+// TestLineEditorEditing tests cursor movement and the editing keys
+func TestLineEditorEditing(t *testing.T) {
+	keys := "gt\x1b[De\r" + // Left, insert
+		"put a 1\x01\x1b[3~g\x05!\r" + // Home, Delete, End
+		"put key value\x17\x17x\r" + // Ctrl+W twice
+		"abc\x02\x02\x0bz\r" + // Ctrl+B twice, Ctrl+K
+		"abc\x15\x7f\r" + // Ctrl+U, Backspace at the start
+		"discard\x03" + // Ctrl+C
+		"ab\x1bOH\x06\x1b[F\x04c\r" // ESC O H (Home), Ctrl+F, End, Ctrl+D mid-line
+	got := readLines(t, newTestEditor(keys, ""))
+	want := []string{"get", "gut a 1!", "put x", "az", "", "^C", "abc"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+// TestLineEditorHistory tests recalling lines with Up/Down, coming back to
+// the line being typed, and the history file
+func TestLineEditorHistory(t *testing.T) {
+	historyFile := filepath.Join(t.TempDir(), "history")
+	keys := "one\r" +
+		"two\r" +
+		"two\r" + // Not repeated in history
+		"three\x1b[A\x1b[A\x1b[A\x1b[A\x1b[B\r" + // Up past the oldest, then Down
+		"draft\x10\x0e\x0e\r" // Ctrl+P, then Ctrl+N back to the draft
+	e := newTestEditor(keys, historyFile)
+	got := readLines(t, e)
+	want := []string{"one", "two", "two", "two", "draft"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	e.addHistory("put j 'x\ny'") // Continued over two lines
+
+	content, _ := os.ReadFile(historyFile)
+	if want := "one\ntwo\ndraft\nput j 'x\\ny'\n"; string(content) != want {
+		t.Errorf("Expected history file %q, got %q", want, content)
+	}
+	if e := newTestEditor("", historyFile); !slices.Equal(e.history, []string{"one", "two", "draft", "put j 'x\ny'"}) {
+		t.Errorf("Expected history to be loaded with newlines restored, got %q", e.history)
+	}
+}
+
+// TestLineEditorHistoryLimit tests that a long history file is trimmed
+func TestLineEditorHistoryLimit(t *testing.T) {
+	historyFile := filepath.Join(t.TempDir(), "history")
+	var lines strings.Builder
+	for i := 0; i < maxHistory+10; i++ {
+		lines.WriteString("get k\n")
+	}
+	os.WriteFile(historyFile, []byte(lines.String()), 0600)
+
+	e := newTestEditor("", historyFile)
+	if len(e.history) != maxHistory {
+		t.Errorf("Expected %d lines of history, got %d", maxHistory, len(e.history))
+	}
+	content, _ := os.ReadFile(historyFile)
+	if n := strings.Count(string(content), "\n"); n != maxHistory {
+		t.Errorf("Expected the file trimmed to %d lines, got %d", maxHistory, n)
+	}
+}
+
+// TestLineEditorComplete tests completing to one candidate and to the
+// common prefix of several
+func TestLineEditorComplete(t *testing.T) {
+	e := newTestEditor("get us\t1\r"+"get u\tx\r"+"get z\t\r"+"get user:\t\r", "")
+	e.complete = func(line string) (int, []string) {
+		start := strings.LastIndex(line, " ") + 1
+		var candidates []string
+		for _, key := range []string{"user:1", "user:2", "zebra"} {
+			if strings.HasPrefix(key, line[start:]) {
+				candidates = append(candidates, key)
+			}
+		}
+		return start, candidates
+	}
+	got := readLines(t, e)
+	want := []string{"get user:1", "get user:x", "get zebra ", "get user:"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+// TestLineEditorPlain tests reading lines when stdin isn't a terminal
+func TestLineEditorPlain(t *testing.T) {
+	e := newLineEditor(strings.NewReader("put a 1\r\nget a"), io.Discard, false, "")
+	got := readLines(t, e)
+	if want := []string{"put a 1", "get a"}; !slices.Equal(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+// TestPrintAbove tests that output arriving mid-line is printed above the
+// prompt, which is redrawn with the line so far
+func TestPrintAbove(t *testing.T) {
+	var buf strings.Builder
+	e := newLineEditor(strings.NewReader(""), &buf, true, "")
+	e.editing, e.prompt, e.line, e.pos = true, "kv> ", []rune("get a"), 3
+	e.printAbove("🔔 'a' = 1\n")
+	if want := "\r\x1b[K🔔 'a' = 1\n\r\x1b[Kkv> get a\x1b[2D"; buf.String() != want {
+		t.Errorf("Expected %q, got %q", want, buf.String())
+	}
+}
@@ -1,18 +1,33 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"iter"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
 
+	"workshop/practice/simulate/kv_store/server"
 	"workshop/practice/simulate/kv_store/store"
 )
 
 var (
-	kvStore     = store.NewKVStore()
+	out         io.Writer = os.Stdout // Where commands print; a buffer when a script checks output
+	kvStore     *store.KVStore[string]
+	vt          valueType // Value type selected with -type
 	defaultFile = ".kv_store.json"
 	autoLoad    = true
 	autoSave    = true
@@ -20,9 +35,34 @@ var (
 
 // Command-line flags
 var (
-	key   = flag.String("key", "", "Key for get/put/delete operations")
-	value = flag.String("value", "", "Value for put/count operations")
-	file  = flag.String("file", "kv_store.json", "File path for save/load operations")
+	key     = flag.String("key", "", "Key for get/put/delete operations")
+	value   = flag.String("value", "", "Value for put/count operations")
+	file    = flag.String("file", "kv_store.json", "File path for save/load operations")
+	useWAL  = flag.Bool("wal", false, "Log every change to a write-ahead log instead of auto-saving")
+	walSync = flag.String("sync", "always", "WAL fsync policy: always, batched or none")
+	typ     = flag.String("type", "int", "Value type: int, string, bytes (base64) or json")
+	ttl     = flag.Duration("ttl", 0, "Expire the key after this long (put only, e.g. 30s, 5m)")
+	start   = flag.String("start", "", "First key for scan/rscan (inclusive)")
+	end     = flag.String("end", "", "Last key for scan/rscan (exclusive, empty = no limit)")
+	prefix  = flag.String("prefix", "", "Key prefix for the prefix command")
+	name    = flag.String("name", "", "Checkpoint name (checkpoint) or name/ID (revert, release)")
+	addr    = flag.String("addr", "localhost:6380", "Address for the serve command to listen on")
+	admin   = flag.String("admin", "", "Address for serve to also serve /metrics, /debug/state and /healthz on over HTTP (default: none)")
+	format  = flag.String("format", "", "Snapshot format for save/convert: json, binary or gzip; data format for import/export: csv or jsonl (default: by file extension)")
+	lo      = flag.String("lo", "", "Lowest value for countrange (inclusive)")
+	hi      = flag.String("hi", "", "Highest value for countrange (inclusive)")
+	topK    = flag.Int("k", 10, "Number of keys for top")
+
+	keyCol   = flag.String("key-col", "key", "Column (csv) or field (jsonl) holding keys, for import/export")
+	valueCol = flag.String("value-col", "value", "Column (csv) or field (jsonl) holding values, for import/export")
+
+	maxKeys  = flag.Int("maxkeys", 0, "Evict keys beyond this many (0 = no limit)")
+	maxBytes = flag.Int64("maxbytes", 0, "Evict keys beyond about this many bytes (0 = no limit)")
+	evict    = flag.String("evict", "lru", "Eviction policy for -maxkeys/-maxbytes: lru, lfu or random")
+
+	historyFile = flag.String("history", defaultHistoryFile(), "File keeping interactive command history (empty = none)")
+
+	outputFormat = flag.String("output", outputText, "Output format: text, table or json")
 )
 
 func main() {
@@ -32,381 +72,1294 @@ func main() {
 	// Parse flags
 	flag.Parse()
 
+	// From here on errors are reported in the -output format
+	command := flag.Arg(0)
+	if _, err := parseOutputFormat(*outputFormat); err != nil {
+		exitWithError(command, codeUsage, "❌ Error: %v", err)
+	}
+
+	var err error
+	if vt, err = lookupValueType(*typ); err != nil {
+		exitWithError(command, codeUsage, "❌ Error: %v", err)
+	}
+	if *format != "" && command != "import" && command != "export" { // Checked by dataFormat for those
+		if _, err := store.ParseSnapshotFormat(*format); err != nil {
+			exitWithError(command, codeUsage, "❌ Error: %v", err)
+		}
+	}
+	if _, err := store.NewEvictionPolicy(*evict); err != nil {
+		exitWithError(command, codeUsage, "❌ Error: %v", err)
+	}
+	kvStore = newStore()
+
+	if *useWAL {
+		// The WAL replaces auto-save: every change is logged as it happens
+		if err := openWALStore(); err != nil {
+			exitWithError(command, errorCode(err, codeIO), "❌ Error opening write-ahead log: %v", err)
+		}
+		autoLoad = false
+		autoSave = false
+	}
+
 	// Auto-load from default file if it exists
 	if autoLoad {
 		if _, err := os.Stat(defaultFile); err == nil {
-			kvStore.LoadFromDisk(defaultFile)
+			// Refuse to continue (and auto-save over the file) if it holds
+			// values of another -type
+			if err := loadWarning(kvStore.LoadFromDisk(defaultFile)); err != nil {
+				exitWithError(command, errorCode(err, codeIO), "❌ Error loading '%s' as %s values: %v", defaultFile, vt.name, err)
+			}
+		}
+	}
+
+	// Drop keys whose TTL passed since the last invocation
+	kvStore.ExpireNow()
+
+	// Command is the first non-flag argument
+	if command == "" {
+		if !stdinIsPipe() {
+			// No command provided - enter interactive mode
+			runInteractiveMode()
+			finish(command)
+			return
 		}
+		// Commands piped in run as a script
+		command = "exec"
 	}
 
-	// Get command (first non-flag argument)
-	args := flag.Args()
-	if len(args) < 1 {
-		// No command provided - enter interactive mode
-		runInteractiveMode()
+	if !executeCommand(command) {
+		closeStore() // The command has already failed
+		os.Exit(1)
+	}
+
+	// Auto-save after each command (except load, convert, help and watch;
+	// serve saves on shutdown itself; exec saves here once the script is
+	// done)
+	if autoSave && command != "load" && command != "convert" && command != "help" && command != "serve" && command != "watch" {
+		saveStore(defaultFile)
+	}
+	finish(command)
+}
+
+// finish closes the store at the end of a successful run, failing the run if
+// the write-ahead log can't be flushed
+func finish(command string) {
+	if err := closeStore(); err != nil {
+		exitWithError(command, errorCode(err, codeIO), "❌ Error writing log: %v", err)
+	}
+}
+
+// closeStore flushes, fsyncs and closes the write-ahead log of the store in
+// use, which clearStore may have replaced since startup. Every exit path
+// calls it, so -sync batched doesn't lose records of earlier commands.
+func closeStore() error {
+	if kvStore == nil {
+		return nil
+	}
+	return kvStore.Close()
+}
+
+// newStore creates an empty store for the selected value type, with a value
+// index ordered by that type and the -maxkeys/-maxbytes capacity
+func newStore() *store.KVStore[string] {
+	kv := store.NewKVStoreWithCodec(vt.codec)
+	kv.EnableValueIndex(vt.compare)
+	setCapacity(kv)
+	return kv
+}
+
+// setCapacity applies -maxkeys, -maxbytes and -evict to kv, with a policy
+// of its own
+func setCapacity(kv *store.KVStore[string]) {
+	if *maxKeys <= 0 && *maxBytes <= 0 {
 		return
 	}
+	policy, _ := store.NewEvictionPolicy(*evict) // Checked in main
+	kv.SetCapacity(store.Capacity{MaxKeys: *maxKeys, MaxBytes: *maxBytes, Policy: policy})
+}
+
+// openWALStore replaces kvStore with one backed by defaultFile and its WAL
+func openWALStore() error {
+	policy, err := store.ParseSyncPolicy(*walSync)
+	if err != nil {
+		return err
+	}
+	kv, err := store.OpenKVStoreWithCodec(defaultFile, vt.codec, store.WALOptions{SyncPolicy: policy})
+	if err = loadWarning(err); err != nil {
+		return err
+	}
+	kv.EnableValueIndex(vt.compare)
+	setCapacity(kv)
+	kvStore = kv
+	return nil
+}
+
+// loadWarning prints an ErrInconsistentState from loading a snapshot (the
+// store loaded it anyway, with its value counts rebuilt) and returns nil for
+// it; any other error is returned unchanged
+func loadWarning(err error) error {
+	if errors.Is(err, store.ErrInconsistentState) {
+		fmt.Fprintf(out, "⚠️  %v (value counts rebuilt from data)\n", err)
+		return nil
+	}
+	return err
+}
+
+// clearStore empties the store, including its WAL and snapshot if enabled
+func clearStore() error {
+	if !*useWAL {
+		kvStore = newStore()
+		return nil
+	}
+	if err := kvStore.Close(); err != nil {
+		return err
+	}
+	os.Remove(defaultFile)
+	os.Remove(defaultFile + ".wal")
+	return openWALStore()
+}
+
+// serveStore serves kvStore over TCP until interrupted, then shuts down
+// gracefully: in-flight commands finish and the store is saved
+func serveStore() error {
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+
+	snapshot := ""
+	if autoSave {
+		snapshot = defaultFile // With -wal every change is already logged
+	}
+	srv := server.New(kvStore, snapshot)
+	srv.SetValueNormalizer(vt.normalize)
+
+	var adminSrv *http.Server
+	adminErr := make(chan error, 1)
+	if *admin != "" {
+		adminLn, err := net.Listen("tcp", *admin)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		kvStore.EnableMetrics()
+		adminSrv = &http.Server{Handler: server.NewAdminHandler(kvStore)}
+		go func() { adminErr <- adminSrv.Serve(adminLn) }()
+		fmt.Fprintf(out, "✅ Admin endpoints on http://%s (/metrics, /debug/state, /healthz)\n", adminLn.Addr())
+	}
 
-	command := args[0]
-	executeCommand(command)
+	kvStore.StartJanitor(time.Second)
+	defer kvStore.StopJanitor()
 
-	// Auto-save after each command (except load and help)
-	if autoSave && command != "load" && command != "help" {
-		kvStore.SaveToDisk(defaultFile)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Serve(ln) }()
+	fmt.Fprintf(out, "✅ Serving %s values on %s (Ctrl+C to stop)\n", vt.name, ln.Addr())
+
+	select {
+	case err := <-serveErr:
+		return err
+	case err := <-adminErr:
+		return fmt.Errorf("admin server: %w", err)
+	case <-ctx.Done():
+	}
+
+	fmt.Fprintln(out, "Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if adminSrv != nil {
+		adminSrv.Shutdown(shutdownCtx)
+	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
 	}
+	if err := <-serveErr; !errors.Is(err, server.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// watchUntilInterrupted prints changes to keys starting with prefix, as
+// other sessions save them, until Ctrl+C
+func watchUntilInterrupted(prefix string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := watchKeys(prefix); err != nil {
+		return err
+	}
+	defer stopWatch()
+	fmt.Fprintf(out, "✅ Watching %s for changes (Ctrl+C to stop)\n", describePrefix(prefix))
+	<-ctx.Done()
+	return nil
+}
+
+// describePrefix names the keys a prefix selects, for messages
+func describePrefix(prefix string) string {
+	if prefix == "" {
+		return "all keys"
+	}
+	return fmt.Sprintf("keys starting with '%s'", prefix)
+}
+
+// snapshotFormat returns the -format to write filename in, or the one its
+// extension selects if -format isn't set
+func snapshotFormat(filename string) store.SnapshotFormat {
+	if *format == "" {
+		return store.FormatForFile(filename)
+	}
+	f, _ := store.ParseSnapshotFormat(*format) // Checked in main
+	return f
+}
+
+// saveStore saves kvStore to filename in snapshotFormat
+func saveStore(filename string) error {
+	return kvStore.SaveToDiskAs(filename, snapshotFormat(filename))
+}
+
+// convertSnapshot rewrites the snapshot src as dst in snapshotFormat
+func convertSnapshot(src, dst string) error {
+	f := snapshotFormat(dst)
+	if err := store.ConvertSnapshot(src, dst, f); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "✅ Converted '%s' to %s in '%s'\n", src, f, dst)
+	return nil
+}
+
+// compactStore folds the WAL into a fresh snapshot
+func compactStore() error {
+	if !*useWAL {
+		return fmt.Errorf("write-ahead log is not enabled (run with -wal)")
+	}
+	return kvStore.Compact()
 }
 
 func runInteractiveMode() {
-	fmt.Println("🗄️  Mini KV Store - Interactive Mode")
-	fmt.Println("Type 'help' for available commands or 'exit' to quit")
-	fmt.Println()
+	fmt.Fprintln(out, "🗄️  Mini KV Store - Interactive Mode")
+	fmt.Fprintln(out, "Type 'help' for available commands or 'exit' to quit")
+	fmt.Fprintln(out)
 
-	scanner := bufio.NewScanner(os.Stdin)
+	fd := int(os.Stdin.Fd())
+	restore, err := makeRaw(fd)
+	terminal := err == nil
+	if terminal {
+		restore()
+	}
+	editor := newLineEditor(os.Stdin, out, terminal, *historyFile)
+	if terminal {
+		editor.makeRaw = func() (func(), error) { return makeRaw(fd) }
+	}
+	editor.complete = completeInput
+	printAsync = editor.printAbove
+	defer stopWatch()
 
 	for {
-		fmt.Print("kv> ")
-
-		if !scanner.Scan() {
+		input, err := readInput(editor)
+		if errors.Is(err, errInterrupted) {
+			continue
+		}
+		if err != nil {
 			break
 		}
 
-		input := strings.TrimSpace(scanner.Text())
+		input = strings.TrimSpace(input)
 
 		if input == "" {
 			continue
 		}
+		editor.addHistory(input)
 
 		if input == "exit" || input == "quit" {
-			fmt.Println("Goodbye!")
+			fmt.Fprintln(out, "Goodbye!")
 			break
 		}
 
+		// This session's own changes are not news to its watch
+		w := activeWatch
+		if w != nil {
+			w.pause()
+		}
+
 		executeInteractiveCommand(input)
 
 		// Auto-save after each command
 		if autoSave {
-			kvStore.SaveToDisk(defaultFile)
+			saveStore(defaultFile)
+		}
+		if w != nil {
+			w.resume()
+		}
+	}
+}
+
+// readInput reads one command, which carries on over more lines while a
+// quote is open or a line ends with a backslash
+func readInput(editor *lineEditor) (string, error) {
+	input, err := editor.readLine("kv> ")
+	for err == nil {
+		_, open := splitInput(input)
+		if open == 0 {
+			break
+		}
+		var more string
+		if more, err = editor.readLine("...> "); err != nil {
+			break
 		}
+		if open == '\\' {
+			input = strings.TrimSuffix(input, "\\") + more
+		} else {
+			input += "\n" + more
+		}
+	}
+	return input, err
+}
+
+// defaultHistoryFile returns ~/.kv_history, or "" if there is no home
+// directory
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
 	}
+	return filepath.Join(home, ".kv_history")
 }
 
-func executeInteractiveCommand(input string) {
+// printAsync prints output that arrives between commands, such as watch
+// events; the REPL prints it above the line being typed
+var printAsync = func(msg string) { fmt.Fprint(out, msg) }
+
+// watchKeys starts watching for changes to keys starting with prefix made
+// by other sessions, replacing any watch already running
+func watchKeys(prefix string) error {
+	stopWatch()
+	w, err := startWatch(prefix, printAsync)
+	if err != nil {
+		return err
+	}
+	activeWatch = w
+	return nil
+}
+
+// stopWatch stops the running watch, if any; it returns false if there
+// was none
+func stopWatch() bool {
+	if activeWatch == nil {
+		return false
+	}
+	activeWatch.stop()
+	activeWatch = nil
+	return true
+}
+
+// executeInteractiveCommand runs one line of input; it returns false if the
+// command failed
+func executeInteractiveCommand(input string) bool {
 	parts := parseInput(input)
 	if len(parts) == 0 {
-		return
+		return true
 	}
+	return runCommand(parts[0], func() bool { return interactiveCommand(parts) })
+}
 
+// interactiveCommand runs the command parts[0] with arguments parts[1:]
+func interactiveCommand(parts []string) bool {
 	command := parts[0]
 
 	switch command {
 	case "put":
 		if len(parts) < 3 {
-			fmt.Println("Usage: put <key> <value>")
-			return
+			return fail(codeUsage, "Usage: put <key> <value>")
 		}
 		key := parts[1]
-		valueStr := strings.Join(parts[2:], " ")
-		value, err := strconv.Atoi(valueStr)
+		value, err := vt.normalize(strings.Join(parts[2:], " "))
 		if err != nil {
-			fmt.Printf("❌ Error: value must be an integer, got '%s'\n", valueStr)
-			return
+			return fail(codeInvalidValue, "❌ Error: %v", err)
 		}
 		kvStore.Put(key, value)
-		fmt.Printf("✅ Set '%s' = %d\n", key, value)
+		if err := kvStore.Err(); err != nil {
+			return fail(codeIO, "❌ Error writing log: %v", err)
+		}
+		report(newPutResult(key, value, 0))
+
+	case "setex":
+		if len(parts) < 4 {
+			return fail(codeUsage, "Usage: setex <key> <ttl> <value>")
+		}
+		key := parts[1]
+		expiresIn, err := time.ParseDuration(parts[2])
+		if err != nil || expiresIn <= 0 {
+			return fail(codeUsage, "❌ Error: ttl must be a positive duration like 30s, got '%s'", parts[2])
+		}
+		value, err := vt.normalize(strings.Join(parts[3:], " "))
+		if err != nil {
+			return fail(codeInvalidValue, "❌ Error: %v", err)
+		}
+		kvStore.PutWithTTL(key, value, expiresIn)
+		if err := kvStore.Err(); err != nil {
+			return fail(codeIO, "❌ Error writing log: %v", err)
+		}
+		report(newPutResult(key, value, expiresIn))
+
+	case "ttl":
+		if len(parts) < 2 {
+			return fail(codeUsage, "Usage: ttl <key>")
+		}
+		return printTTL(parts[1])
 
 	case "get":
 		if len(parts) < 2 {
-			fmt.Println("Usage: get <key>")
-			return
+			return fail(codeUsage, "Usage: get <key>")
 		}
 		key := parts[1]
 		val, exists := kvStore.Get(key)
-		if exists {
-			fmt.Printf("✅ '%s' = %d\n", key, val)
-		} else {
-			fmt.Printf("❌ Key '%s' not found\n", key)
+		if !exists {
+			return fail(codeNotFound, "❌ Key '%s' not found", key)
 		}
+		report(getResult{Key: key, Value: typedValue(val)})
 
 	case "delete", "del":
 		if len(parts) < 2 {
-			fmt.Println("Usage: delete <key>")
-			return
+			return fail(codeUsage, "Usage: delete <key>")
 		}
 		key := parts[1]
-		if deleted := kvStore.Delete(key); deleted {
-			fmt.Printf("✅ Deleted key '%s'\n", key)
-		} else {
-			fmt.Printf("❌ Key '%s' not found\n", key)
+		if !kvStore.Delete(key) {
+			if err := kvStore.Err(); err != nil {
+				return fail(codeIO, "❌ Error writing log: %v", err)
+			}
+			return fail(codeNotFound, "❌ Key '%s' not found", key)
 		}
+		report(deleteResult{Key: key})
 
 	case "count":
 		if len(parts) < 2 {
-			fmt.Println("Usage: count <value>")
-			return
+			return fail(codeUsage, "Usage: count <value>")
 		}
-		valueStr := strings.Join(parts[1:], " ")
-		value, err := strconv.Atoi(valueStr)
+		value, err := vt.normalize(strings.Join(parts[1:], " "))
 		if err != nil {
-			fmt.Printf("❌ Error: value must be an integer, got '%s'\n", valueStr)
-			return
+			return fail(codeInvalidValue, "❌ Error: %v", err)
 		}
-		count := kvStore.CountValue(value)
-		fmt.Printf("✅ Value %d appears %d time(s)\n", value, count)
+		report(countResult{Value: typedValue(value), Count: kvStore.CountValue(value)})
+
+	case "keys":
+		if len(parts) < 2 {
+			return fail(codeUsage, "Usage: keys <value>")
+		}
+		if err := printKeysWithValue(strings.Join(parts[1:], " ")); err != nil {
+			return fail(codeInvalidValue, "❌ Error: %v", err)
+		}
+
+	case "countrange":
+		if len(parts) != 3 {
+			return fail(codeUsage, "Usage: countrange <lo> <hi>")
+		}
+		if err := printCountRange(parts[1], parts[2]); err != nil {
+			return fail(codeInvalidValue, "❌ Error: %v", err)
+		}
+
+	case "top":
+		k := 10
+		if len(parts) > 1 {
+			n, err := strconv.Atoi(parts[1])
+			if err != nil || n <= 0 {
+				return fail(codeUsage, "Usage: top [k]")
+			}
+			k = n
+		}
+		printTopK(k)
+
+	case "stats":
+		printStats()
 
 	case "checkpoint", "cp":
-		kvStore.Checkpoint()
-		count := kvStore.GetCheckpointCount()
-		fmt.Printf("✅ Checkpoint created (total: %d)\n", count)
+		name := ""
+		if len(parts) > 1 {
+			name = parts[1]
+		}
+		if err := createCheckpoint(name); err != nil {
+			return fail(errorCode(err, codeFailed), "❌ Error: %v", err)
+		}
 
 	case "revert", "rv":
-		err := kvStore.Revert()
-		if err != nil {
-			fmt.Printf("❌ Error: %v\n", err)
-		} else {
-			fmt.Println("✅ Reverted to last checkpoint")
+		ref := ""
+		if len(parts) > 1 {
+			ref = parts[1]
+		}
+		if err := revertStore(ref); err != nil {
+			return fail(errorCode(err, codeFailed), "❌ Error: %v", err)
 		}
 
+	case "release":
+		if len(parts) < 2 {
+			return fail(codeUsage, "Usage: release <name|id>")
+		}
+		if err := releaseCheckpoint(parts[1]); err != nil {
+			return fail(errorCode(err, codeFailed), "❌ Error: %v", err)
+		}
+
+	case "checkpoints", "cps":
+		printCheckpoints()
+
 	case "save":
 		filename := defaultFile
 		if len(parts) > 1 {
 			filename = parts[1]
 		}
-		err := kvStore.SaveToDisk(filename)
-		if err != nil {
-			fmt.Printf("❌ Error saving: %v\n", err)
-		} else {
-			fmt.Printf("✅ Saved to '%s'\n", filename)
+		if err := saveStore(filename); err != nil {
+			return fail(errorCode(err, codeIO), "❌ Error saving: %v", err)
 		}
+		report(saveResult{File: filename, Format: snapshotFormat(filename).String()})
 
 	case "load":
 		filename := defaultFile
 		if len(parts) > 1 {
 			filename = parts[1]
 		}
-		err := kvStore.LoadFromDisk(filename)
-		if err != nil {
-			fmt.Printf("❌ Error loading: %v\n", err)
-		} else {
-			fmt.Printf("✅ Loaded from '%s'\n", filename)
+		if err := loadWarning(kvStore.LoadFromDisk(filename)); err != nil {
+			return fail(errorCode(err, codeIO), "❌ Error loading: %v", err)
+		}
+		report(loadResult{File: filename, Keys: kvStore.Stats().Keys})
+
+	case "convert":
+		if len(parts) != 3 {
+			return fail(codeUsage, "Usage: convert <src> <dst>")
+		}
+		if err := convertSnapshot(parts[1], parts[2]); err != nil {
+			return fail(errorCode(err, codeIO), "❌ Error converting: %v", err)
+		}
+
+	case "import", "export":
+		if len(parts) < 2 || len(parts) > 4 {
+			return fail(codeUsage, "Usage: %s <file> [key-col] [value-col]", command)
+		}
+		cols := append(parts[2:], *keyCol, *valueCol)[:2] // Default to -key-col and -value-col
+		if err := transferFile(command, parts[1], cols[0], cols[1]); err != nil {
+			return fail(errorCode(err, codeIO), "❌ Error %sing: %v", command, err)
 		}
 
 	case "list", "ls":
 		printList()
 
+	case "scan", "rscan":
+		if len(parts) > 3 {
+			return fail(codeUsage, "Usage: %s [start] [end]", command)
+		}
+		var from, to string
+		if len(parts) > 1 {
+			from = parts[1]
+		}
+		if len(parts) > 2 {
+			to = parts[2]
+		}
+		printScan(scanRange(command, from, to))
+
+	case "prefix":
+		if len(parts) != 2 {
+			return fail(codeUsage, "Usage: prefix <prefix>")
+		}
+		printScan(kvStore.ScanPrefix(parts[1]))
+
+	case "watch":
+		if len(parts) > 2 {
+			return fail(codeUsage, "Usage: watch [prefix]")
+		}
+		prefix := ""
+		if len(parts) > 1 {
+			prefix = parts[1]
+		}
+		if err := watchKeys(prefix); err != nil {
+			return fail(errorCode(err, codeIO), "❌ Error watching: %v", err)
+		}
+		fmt.Fprintf(out, "✅ Watching %s for changes from other sessions ('unwatch' to stop)\n", describePrefix(prefix))
+
+	case "unwatch":
+		if !stopWatch() {
+			return fail(codeFailed, "❌ Not watching")
+		}
+		fmt.Fprintln(out, "✅ Stopped watching")
+
 	case "clear":
-		kvStore = store.NewKVStore()
-		fmt.Println("✅ Store cleared")
+		if err := clearStore(); err != nil {
+			return fail(errorCode(err, codeIO), "❌ Error clearing: %v", err)
+		}
+		fmt.Fprintln(out, "✅ Store cleared")
+
+	case "compact":
+		if err := compactStore(); err != nil {
+			return fail(errorCode(err, codeFailed), "❌ Error compacting: %v", err)
+		}
+		fmt.Fprintln(out, "✅ Log compacted into snapshot")
 
 	case "help", "?":
 		printInteractiveHelp()
 
 	default:
-		fmt.Printf("Unknown command: %s\n", command)
-		fmt.Println("Type 'help' for available commands")
+		return fail(codeUnknownCommand, "Unknown command: %s\nType 'help' for available commands", command)
 	}
+	return true
 }
 
+// parseInput splits a command line into words (see splitInput)
 func parseInput(input string) []string {
-	// Simple parsing that handles quoted strings
-	var parts []string
-	var current strings.Builder
-	inQuotes := false
-
-	for i := 0; i < len(input); i++ {
-		char := input[i]
-
-		switch char {
-		case '"':
-			inQuotes = !inQuotes
-		case ' ':
-			if inQuotes {
-				current.WriteByte(char)
-			} else if current.Len() > 0 {
-				parts = append(parts, current.String())
-				current.Reset()
+	parts, _ := splitInput(input)
+	return parts
+}
+
+// splitInput splits input into words separated by spaces, like a shell:
+//
+//   - Double quotes group words and may appear anywhere in one; inside them
+//     \" \\ \n and \t are escapes (other backslashes are kept).
+//   - Single quotes group words literally, with no escapes, but only at the
+//     start of a word, so apostrophes (don't) need no escaping.
+//   - Elsewhere a backslash makes the next character literal.
+//
+// open is the quote left unterminated at the end of input, '\\' if input
+// ends with a lone backslash, or 0.
+func splitInput(input string) (parts []string, open rune) {
+	var word strings.Builder
+	inWord := false
+	runes := []rune(input)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case open == '\'':
+			if r == '\'' {
+				open = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case open == '"':
+			switch {
+			case r == '"':
+				open = 0
+			case r == '\\' && i+1 < len(runes):
+				i++
+				switch esc := runes[i]; esc {
+				case 'n':
+					word.WriteRune('\n')
+				case 't':
+					word.WriteRune('\t')
+				case '"', '\\':
+					word.WriteRune(esc)
+				default:
+					word.WriteRune(r)
+					word.WriteRune(esc)
+				}
+			default:
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			if i+1 == len(runes) {
+				open = r
+				break
+			}
+			i++
+			word.WriteRune(runes[i])
+			inWord = true
+		case r == '"':
+			open, inWord = r, true
+		case r == '\'' && !inWord:
+			open, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				parts = append(parts, word.String())
+				word.Reset()
+				inWord = false
 			}
 		default:
-			current.WriteByte(char)
+			word.WriteRune(r)
+			inWord = true
 		}
 	}
+	if inWord {
+		parts = append(parts, word.String())
+	}
+	return parts, open
+}
 
-	if current.Len() > 0 {
-		parts = append(parts, current.String())
+// commands are the interactive commands, for tab completion
+var commands = []string{
+	"checkpoint", "checkpoints", "clear", "compact", "convert", "count", "countrange",
+	"delete", "exit", "export", "get", "help", "import", "keys", "list", "load", "prefix",
+	"put", "quit", "release", "revert", "rscan", "save", "scan", "setex", "stats", "top",
+	"ttl", "unwatch", "watch",
+}
+
+// maxCompletions caps the keys offered by tab completion
+const maxCompletions = 100
+
+// completeInput returns the start (in runes) of the word before the end of
+// line and the words it could be: a command name, then a key (or a
+// checkpoint name for revert and release)
+func completeInput(line string) (int, []string) {
+	start := strings.LastIndexAny(line, " \t") + 1
+	word := line[start:]
+	start = utf8.RuneCountInString(line[:start])
+
+	var candidates []string
+	switch args := strings.Fields(line[:len(line)-len(word)]); {
+	case len(args) == 0:
+		for _, c := range commands {
+			if strings.HasPrefix(c, word) {
+				candidates = append(candidates, c)
+			}
+		}
+	case len(args) > 1:
+	case args[0] == "revert" || args[0] == "rv" || args[0] == "release":
+		for _, cp := range kvStore.ListCheckpoints() {
+			if cp.Name != "" && strings.HasPrefix(cp.Name, word) {
+				candidates = append(candidates, cp.Name)
+			}
+		}
+	case slices.Contains([]string{"put", "setex", "get", "ttl", "delete", "del", "scan", "rscan", "prefix", "watch"}, args[0]):
+		for key := range kvStore.ScanPrefix(word) {
+			if len(candidates) == maxCompletions {
+				break
+			}
+			candidates = append(candidates, key)
+		}
 	}
+	return start, candidates
+}
 
-	return parts
+// executeCommand runs a command given with flags; it returns false if the
+// command failed
+func executeCommand(command string) bool {
+	if command == "exec" || command == "serve" || command == "watch" {
+		// These run for a while and print as they go; exec prints each of
+		// its script's commands in the -output format itself
+		return flagCommand(command)
+	}
+	return runCommand(command, func() bool { return flagCommand(command) })
 }
 
-func executeCommand(command string) {
+// flagCommand runs command with the arguments in flags
+func flagCommand(command string) bool {
 	switch command {
 	case "put":
 		if *key == "" || *value == "" {
-			fmt.Println("Error: put requires --key and --value flags")
-			fmt.Println("Usage: kv-cli put --key <key> --value <value>")
-			os.Exit(1)
+			return fail(codeUsage, "Error: put requires --key and --value flags\nUsage: kv-cli put --key <key> --value <value>")
 		}
-		intValue, err := strconv.Atoi(*value)
+		normalized, err := vt.normalize(*value)
 		if err != nil {
-			fmt.Printf("❌ Error: value must be an integer, got '%s'\n", *value)
-			os.Exit(1)
+			return fail(codeInvalidValue, "❌ Error: %v", err)
+		}
+		if *ttl > 0 {
+			kvStore.PutWithTTL(*key, normalized, *ttl)
+		} else {
+			kvStore.Put(*key, normalized)
+		}
+		if err := kvStore.Err(); err != nil {
+			return fail(codeIO, "❌ Error writing log: %v", err)
+		}
+		report(newPutResult(*key, normalized, *ttl))
+
+	case "ttl":
+		if *key == "" {
+			return fail(codeUsage, "Error: ttl requires --key flag\nUsage: kv-cli ttl --key <key>")
 		}
-		kvStore.Put(*key, intValue)
-		fmt.Printf("✅ Set '%s' = %d\n", *key, intValue)
+		return printTTL(*key)
 
 	case "get":
 		if *key == "" {
-			fmt.Println("Error: get requires --key flag")
-			fmt.Println("Usage: kv-cli get --key <key>")
-			os.Exit(1)
+			return fail(codeUsage, "Error: get requires --key flag\nUsage: kv-cli get --key <key>")
 		}
 		val, exists := kvStore.Get(*key)
-		if exists {
-			fmt.Printf("✅ '%s' = %d\n", *key, val)
-		} else {
-			fmt.Printf("❌ Key '%s' not found\n", *key)
-			os.Exit(1)
+		if !exists {
+			return fail(codeNotFound, "❌ Key '%s' not found", *key)
 		}
+		report(getResult{Key: *key, Value: typedValue(val)})
 
 	case "delete", "del":
 		if *key == "" {
-			fmt.Println("Error: delete requires --key flag")
-			fmt.Println("Usage: kv-cli delete --key <key>")
-			os.Exit(1)
+			return fail(codeUsage, "Error: delete requires --key flag\nUsage: kv-cli delete --key <key>")
 		}
-		if deleted := kvStore.Delete(*key); deleted {
-			fmt.Printf("✅ Deleted key '%s'\n", *key)
-		} else {
-			fmt.Printf("❌ Key '%s' not found\n", *key)
-			os.Exit(1)
+		if !kvStore.Delete(*key) {
+			if err := kvStore.Err(); err != nil {
+				return fail(codeIO, "❌ Error writing log: %v", err)
+			}
+			return fail(codeNotFound, "❌ Key '%s' not found", *key)
 		}
+		report(deleteResult{Key: *key})
 
 	case "count":
 		if *value == "" {
-			fmt.Println("Error: count requires --value flag")
-			fmt.Println("Usage: kv-cli count --value <value>")
-			os.Exit(1)
+			return fail(codeUsage, "Error: count requires --value flag\nUsage: kv-cli count --value <value>")
 		}
-		intValue, err := strconv.Atoi(*value)
+		normalized, err := vt.normalize(*value)
 		if err != nil {
-			fmt.Printf("❌ Error: value must be an integer, got '%s'\n", *value)
-			os.Exit(1)
+			return fail(codeInvalidValue, "❌ Error: %v", err)
+		}
+		report(countResult{Value: typedValue(normalized), Count: kvStore.CountValue(normalized)})
+
+	case "keys":
+		if *value == "" {
+			return fail(codeUsage, "Error: keys requires --value flag\nUsage: kv-cli keys --value <value>")
+		}
+		if err := printKeysWithValue(*value); err != nil {
+			return fail(codeInvalidValue, "❌ Error: %v", err)
+		}
+
+	case "countrange":
+		if *lo == "" || *hi == "" {
+			return fail(codeUsage, "Error: countrange requires --lo and --hi flags\nUsage: kv-cli countrange --lo <value> --hi <value>")
+		}
+		if err := printCountRange(*lo, *hi); err != nil {
+			return fail(codeInvalidValue, "❌ Error: %v", err)
 		}
-		count := kvStore.CountValue(intValue)
-		fmt.Printf("✅ Value %d appears %d time(s)\n", intValue, count)
+
+	case "top":
+		if *topK <= 0 {
+			return fail(codeUsage, "Error: top requires --k > 0")
+		}
+		printTopK(*topK)
+
+	case "stats":
+		printStats()
 
 	case "checkpoint", "cp":
-		kvStore.Checkpoint()
-		count := kvStore.GetCheckpointCount()
-		fmt.Printf("✅ Checkpoint created (total: %d)\n", count)
+		if err := createCheckpoint(*name); err != nil {
+			return fail(errorCode(err, codeFailed), "❌ Error: %v", err)
+		}
 
 	case "revert", "rv":
-		err := kvStore.Revert()
-		if err != nil {
-			fmt.Printf("❌ Error: %v\n", err)
-			os.Exit(1)
+		if err := revertStore(*name); err != nil {
+			return fail(errorCode(err, codeFailed), "❌ Error: %v", err)
 		}
-		fmt.Println("✅ Reverted to last checkpoint")
+
+	case "release":
+		if *name == "" {
+			return fail(codeUsage, "Error: release requires --name flag\nUsage: kv-cli release --name <name|id>")
+		}
+		if err := releaseCheckpoint(*name); err != nil {
+			return fail(errorCode(err, codeFailed), "❌ Error: %v", err)
+		}
+
+	case "checkpoints", "cps":
+		printCheckpoints()
 
 	case "save":
-		err := kvStore.SaveToDisk(*file)
-		if err != nil {
-			fmt.Printf("❌ Error saving: %v\n", err)
-			os.Exit(1)
+		if err := saveStore(*file); err != nil {
+			return fail(errorCode(err, codeIO), "❌ Error saving: %v", err)
 		}
-		fmt.Printf("✅ Saved to '%s'\n", *file)
+		report(saveResult{File: *file, Format: snapshotFormat(*file).String()})
 
 	case "load":
-		err := kvStore.LoadFromDisk(*file)
-		if err != nil {
-			fmt.Printf("❌ Error loading: %v\n", err)
-			os.Exit(1)
+		if err := loadWarning(kvStore.LoadFromDisk(*file)); err != nil {
+			return fail(errorCode(err, codeIO), "❌ Error loading: %v", err)
+		}
+		report(loadResult{File: *file, Keys: kvStore.Stats().Keys})
+
+	case "convert":
+		args := flag.Args()
+		if len(args) != 3 {
+			return fail(codeUsage, "Error: convert requires a source and a destination file\nUsage: kv-cli [-format json|binary|gzip] convert <src> <dst>")
+		}
+		if err := convertSnapshot(args[1], args[2]); err != nil {
+			return fail(errorCode(err, codeIO), "❌ Error converting: %v", err)
+		}
+
+	case "import", "export":
+		args := flag.Args()
+		if len(args) != 2 {
+			return fail(codeUsage, "Error: %s requires a file\nUsage: kv-cli [-format csv|jsonl] [-key-col <col>] [-value-col <col>] %s <file>", command, command)
+		}
+		if err := transferFile(command, args[1], *keyCol, *valueCol); err != nil {
+			return fail(errorCode(err, codeIO), "❌ Error %sing: %v", command, err)
 		}
-		fmt.Printf("✅ Loaded from '%s'\n", *file)
 
 	case "list", "ls":
 		printList()
 
+	case "scan", "rscan":
+		printScan(scanRange(command, *start, *end))
+
+	case "prefix":
+		if *prefix == "" {
+			return fail(codeUsage, "Error: prefix requires --prefix flag\nUsage: kv-cli prefix --prefix <prefix>")
+		}
+		printScan(kvStore.ScanPrefix(*prefix))
+
 	case "clear":
-		kvStore = store.NewKVStore()
-		fmt.Println("✅ Store cleared")
+		if err := clearStore(); err != nil {
+			return fail(errorCode(err, codeIO), "❌ Error clearing: %v", err)
+		}
+		fmt.Fprintln(out, "✅ Store cleared")
+
+	case "compact":
+		if err := compactStore(); err != nil {
+			return fail(errorCode(err, codeFailed), "❌ Error compacting: %v", err)
+		}
+		fmt.Fprintln(out, "✅ Log compacted into snapshot")
+
+	case "exec":
+		if len(flag.Args()) > 2 {
+			return fail(codeUsage, "Usage: kv-cli exec [script.kv]")
+		}
+		if err := runScriptFile(flag.Arg(1)); err != nil {
+			return fail(errorCode(err, codeFailed), "❌ Error: %v", err)
+		}
+
+	case "watch":
+		if err := watchUntilInterrupted(*prefix); err != nil {
+			return fail(errorCode(err, codeIO), "❌ Error watching: %v", err)
+		}
+
+	case "serve":
+		if err := serveStore(); err != nil {
+			return fail(errorCode(err, codeIO), "❌ Error serving: %v", err)
+		}
+		fmt.Fprintln(out, "✅ Server stopped")
 
 	case "help", "?", "-h", "--help":
 		printHelp()
 
 	default:
-		fmt.Printf("Unknown command: %s\n", command)
-		fmt.Println("Run 'kv-cli help' for usage information")
-		os.Exit(1)
+		return fail(codeUnknownCommand, "Unknown command: %s\nRun 'kv-cli help' for usage information", command)
 	}
+	return true
 }
 
-func printList() {
-	data, valueCounts := kvStore.GetAllData()
+// transferFile imports or exports filename, with keys and values in the
+// columns keyCol and valueCol
+func transferFile(command, filename, keyCol, valueCol string) error {
+	if command == "import" {
+		return importFile(filename, keyCol, valueCol)
+	}
+	return exportFile(filename, keyCol, valueCol)
+}
 
-	if len(data) == 0 {
-		fmt.Println("Store is empty")
+// printKeysWithValue prints the keys whose value is input
+func printKeysWithValue(input string) error {
+	value, err := vt.normalize(input)
+	if err != nil {
+		return err
+	}
+	keys := kvStore.KeysWithValue(value)
+	if len(keys) == 0 {
+		fmt.Fprintf(out, "No keys have value %s\n", value)
+		return nil
+	}
+	fmt.Fprintf(out, "Keys with value %s:\n", value)
+	for _, key := range keys {
+		fmt.Fprintf(out, "  %s\n", key)
+	}
+	fmt.Fprintf(out, "\nTotal: %d key(s)\n", len(keys))
+	return nil
+}
+
+// printCountRange prints how many keys have a value in [loInput, hiInput]
+func printCountRange(loInput, hiInput string) error {
+	from, err := vt.normalize(loInput)
+	if err != nil {
+		return err
+	}
+	to, err := vt.normalize(hiInput)
+	if err != nil {
+		return err
+	}
+	count, err := kvStore.CountRange(from, to)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "✅ %d key(s) have a value between %s and %s\n", count, from, to)
+	return nil
+}
+
+// printTopK prints the k keys with the largest values
+func printTopK(k int) {
+	top, err := kvStore.TopK(k)
+	if err != nil {
+		fmt.Fprintf(out, "❌ Error: %v\n", err)
+		return
+	}
+	if len(top) == 0 {
+		fmt.Fprintln(out, "(empty)")
 		return
 	}
+	fmt.Fprintf(out, "Top %d by value:\n", len(top))
+	for i, kv := range top {
+		fmt.Fprintf(out, "  %d. %s = %s\n", i+1, kv.Key, kv.Value)
+	}
+}
 
-	fmt.Println("\nCurrent Key-Value Pairs:")
-	fmt.Println("------------------------")
-	for k, v := range data {
-		fmt.Printf("  %s = %d\n", k, v)
+// printStats prints the store's size against its capacity and its hit,
+// miss and eviction counters
+func printStats() {
+	s := kvStore.Stats()
+	limit := ""
+	if *maxKeys > 0 || *maxBytes > 0 {
+		limit = fmt.Sprintf(" (evicting by %s)", *evict)
 	}
+	fmt.Fprintf(out, "Keys:      %d%s%s\n", s.Keys, capacityLimit(int64(*maxKeys)), limit)
+	fmt.Fprintf(out, "Bytes:     ~%d%s\n", s.Bytes, capacityLimit(*maxBytes))
+	fmt.Fprintf(out, "Hits:      %d\n", s.Hits)
+	fmt.Fprintf(out, "Misses:    %d\n", s.Misses)
+	if lookups := s.Hits + s.Misses; lookups > 0 {
+		fmt.Fprintf(out, "Hit rate:  %.1f%%\n", 100*float64(s.Hits)/float64(lookups))
+	}
+	fmt.Fprintf(out, "Evictions: %d\n", s.Evictions)
+}
 
-	fmt.Println("\nValue Counts:")
-	fmt.Println("-------------")
-	for v, count := range valueCounts {
-		fmt.Printf("  %d → %d\n", v, count)
+// capacityLimit formats a limit for printStats, or "" if there is none
+func capacityLimit(n int64) string {
+	if n <= 0 {
+		return ""
 	}
+	return fmt.Sprintf(" / %d", n)
+}
 
-	checkpointCount := kvStore.GetCheckpointCount()
-	fmt.Printf("\nTotal keys: %d\n", len(data))
-	fmt.Printf("Checkpoints: %d\n", checkpointCount)
+// printTTL prints the time left before key expires; it returns false if
+// the key doesn't exist
+func printTTL(key string) bool {
+	if _, exists := kvStore.Get(key); !exists {
+		return fail(codeNotFound, "❌ Key '%s' not found", key)
+	}
+	if remaining, ok := kvStore.TTL(key); ok {
+		fmt.Fprintf(out, "✅ '%s' expires in %v\n", key, remaining.Round(time.Millisecond))
+	} else {
+		fmt.Fprintf(out, "✅ '%s' has no expiry\n", key)
+	}
+	return true
+}
+
+// scanRange returns the keys in [from, to), descending for rscan
+func scanRange(command, from, to string) iter.Seq2[string, string] {
+	if command == "rscan" {
+		return kvStore.ScanReverse(from, to)
+	}
+	return kvStore.Scan(from, to)
+}
+
+// createCheckpoint creates a checkpoint with an optional name
+func createCheckpoint(name string) error {
+	id := kvStore.Checkpoint(name)
+	if id == 0 {
+		return fmt.Errorf("failed to log checkpoint: %w", kvStore.Err())
+	}
+	report(checkpointResult{ID: id, Name: name, Checkpoints: kvStore.GetCheckpointCount()})
+	return nil
+}
+
+// revertStore reverts to the last checkpoint, or to the one named (or
+// numbered) ref, undoing every later checkpoint too
+func revertStore(ref string) error {
+	if ref == "" {
+		checkpoints := kvStore.ListCheckpoints()
+		if err := kvStore.Revert(); err != nil {
+			if len(checkpoints) == 0 {
+				return codedError{codeNotFound, err}
+			}
+			return err
+		}
+		last := checkpoints[len(checkpoints)-1]
+		report(revertResult{ID: last.ID, Name: last.Name, Checkpoints: kvStore.GetCheckpointCount()})
+		return nil
+	}
+
+	id, found := kvStore.FindCheckpoint(ref)
+	if !found {
+		return codedError{codeNotFound, fmt.Errorf("no checkpoint '%s'", ref)}
+	}
+	name := checkpointName(id)
+	if err := kvStore.RevertTo(id); err != nil {
+		return err
+	}
+	report(revertResult{ID: id, Name: name, Checkpoints: kvStore.GetCheckpointCount(), ref: ref})
+	return nil
+}
+
+// releaseCheckpoint drops the checkpoint named (or numbered) ref, keeping
+// the data as it is
+func releaseCheckpoint(ref string) error {
+	id, found := kvStore.FindCheckpoint(ref)
+	if !found {
+		return codedError{codeNotFound, fmt.Errorf("no checkpoint '%s'", ref)}
+	}
+	if err := kvStore.ReleaseCheckpoint(id); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "✅ Released checkpoint '%s' (remaining: %d)\n", ref, kvStore.GetCheckpointCount())
+	return nil
+}
+
+// checkpointName returns the name of checkpoint id, or "" if it has none
+func checkpointName(id store.CheckpointID) string {
+	for _, cp := range kvStore.ListCheckpoints() {
+		if cp.ID == id {
+			return cp.Name
+		}
+	}
+	return ""
+}
+
+// printCheckpoints lists the checkpoints from oldest to newest
+func printCheckpoints() {
+	checkpoints := kvStore.ListCheckpoints()
+	if len(checkpoints) == 0 {
+		fmt.Fprintln(out, "No checkpoints")
+		return
+	}
+
+	fmt.Fprintln(out, "\nCheckpoints (oldest first):")
+	fmt.Fprintln(out, "---------------------------")
+	for _, cp := range checkpoints {
+		name := cp.Name
+		if name == "" {
+			name = "-"
+		}
+		created := "unknown"
+		if !cp.Created.IsZero() {
+			created = cp.Created.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(out, "  %3d  %-16s %s  %d key(s) changed since\n", cp.ID, name, created, cp.ChangedKeys)
+	}
+}
+
+// printScan prints the pairs visited by a scan, in order
+func printScan(pairs iter.Seq2[string, string]) {
+	n := 0
+	for k, v := range pairs {
+		fmt.Fprintf(out, "  %s = %s\n", k, v)
+		n++
+	}
+	fmt.Fprintf(out, "✅ %d key(s)\n", n)
+}
+
+// printList prints every pair in key order and the value counts
+func printList() {
+	data, valueCounts := kvStore.GetAllData()
+	r := listResult{
+		Data:        make([]keyValue, 0, len(data)),
+		ValueCounts: make([]valueCount, 0, len(valueCounts)),
+		Keys:        len(data),
+		Checkpoints: kvStore.GetCheckpointCount(),
+	}
+	for k, v := range kvStore.Scan("", "") {
+		r.Data = append(r.Data, keyValue{Key: k, Value: typedValue(v)})
+	}
+
+	values := make([]string, 0, len(valueCounts))
+	for v := range valueCounts {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	for _, v := range values {
+		r.ValueCounts = append(r.ValueCounts, valueCount{Value: typedValue(v), Count: valueCounts[v]})
+	}
+	report(r)
 }
 
 func printUsage() {
-	fmt.Println("Mini KV Store - Command Line Interface")
-	fmt.Println()
-	fmt.Println("USAGE:")
-	fmt.Println("  kv-cli                    # Interactive mode")
-	fmt.Println("  kv-cli [flags] <command>  # Flag-based mode")
-	fmt.Println()
-	fmt.Println("FLAGS:")
-	fmt.Println("  -key <key>       Key for get/put/delete operations")
-	fmt.Println("  -value <value>   Value for put/count operations")
-	fmt.Println("  -file <path>     File path for save/load (default: kv_store.json)")
-	fmt.Println()
-	fmt.Println("COMMANDS:")
-	fmt.Println("  put              Store a key-value pair (requires -key and -value)")
-	fmt.Println("  get              Retrieve value for a key (requires -key)")
-	fmt.Println("  delete, del      Delete a key-value pair (requires -key)")
-	fmt.Println("  count            Count keys with the given value (requires -value)")
-	fmt.Println("  checkpoint, cp   Create a snapshot of current state")
-	fmt.Println("  revert, rv       Revert to last checkpoint")
-	fmt.Println("  save             Save to disk (optional -file)")
-	fmt.Println("  load             Load from disk (optional -file)")
-	fmt.Println("  list, ls         Show all key-value pairs")
-	fmt.Println("  clear            Clear the entire store")
-	fmt.Println("  help, ?, -h      Show this help message")
-	fmt.Println()
-	fmt.Println("EXAMPLES:")
-	fmt.Println("  kv-cli -key name -value Alice put")
-	fmt.Println("  kv-cli -key message -value \"hello world\" put")
-	fmt.Println("  kv-cli -key name get")
-	fmt.Println("  kv-cli -key name delete")
-	fmt.Println("  kv-cli -value active count")
-	fmt.Println("  kv-cli checkpoint")
-	fmt.Println("  kv-cli revert")
-	fmt.Println("  kv-cli -file backup.json save")
-	fmt.Println("  kv-cli -file backup.json load")
-	fmt.Println("  kv-cli list")
-	fmt.Println()
-	fmt.Println("NOTES:")
-	fmt.Println("  • Run without arguments to enter interactive mode")
-	fmt.Println("  • In flag-based mode, flags must come BEFORE the command")
-	fmt.Println("  • Data automatically persists to .kv_store.json")
-	fmt.Println("  • Use quotes for values with spaces")
+	fmt.Fprintln(out, "Mini KV Store - Command Line Interface")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "USAGE:")
+	fmt.Fprintln(out, "  kv-cli                    # Interactive mode")
+	fmt.Fprintln(out, "  kv-cli [flags] <command>  # Flag-based mode")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "FLAGS:")
+	fmt.Fprintln(out, "  -key <key>       Key for get/put/delete operations")
+	fmt.Fprintln(out, "  -value <value>   Value for put/count operations")
+	fmt.Fprintln(out, "  -file <path>     File path for save/load (default: kv_store.json)")
+	fmt.Fprintln(out, "  -wal             Log changes to .kv_store.json.wal instead of auto-saving")
+	fmt.Fprintln(out, "  -sync <policy>   WAL fsync policy: always, batched, none (default: always)")
+	fmt.Fprintln(out, "  -type <type>     Value type: int, string, bytes (base64), json (default: int)")
+	fmt.Fprintln(out, "  -ttl <duration>  Expire a put key after this long (e.g. 30s, 5m)")
+	fmt.Fprintln(out, "  -start <key>     First key for scan/rscan (inclusive)")
+	fmt.Fprintln(out, "  -end <key>       Stop scan/rscan before this key (default: no limit)")
+	fmt.Fprintln(out, "  -prefix <prefix> Key prefix for the prefix command")
+	fmt.Fprintln(out, "  -addr <addr>     Address for serve to listen on (default: localhost:6380)")
+	fmt.Fprintln(out, "  -admin <addr>    Also serve /metrics, /debug/state and /healthz over HTTP (serve)")
+	fmt.Fprintln(out, "  -name <name>     Checkpoint name (checkpoint), or name/ID (revert, release)")
+	fmt.Fprintln(out, "  -format <fmt>    Snapshot format for save/convert: json, binary, gzip")
+	fmt.Fprintln(out, "                   (default: .kvs is binary, .gz is gzip, anything else json),")
+	fmt.Fprintln(out, "                   or data format for import/export: csv, jsonl (default: by extension)")
+	fmt.Fprintln(out, "  -key-col <col>   CSV column or JSONL field holding keys, for import/export (default: key)")
+	fmt.Fprintln(out, "  -value-col <col> CSV column or JSONL field holding values, for import/export (default: value)")
+	fmt.Fprintln(out, "  -lo, -hi <value> Value range for countrange (inclusive)")
+	fmt.Fprintln(out, "  -k <n>           Number of keys for top (default: 10)")
+	fmt.Fprintln(out, "  -maxkeys <n>     Evict keys beyond n (default: no limit)")
+	fmt.Fprintln(out, "  -maxbytes <n>    Evict keys beyond about n bytes of keys and values")
+	fmt.Fprintln(out, "  -evict <policy>  Eviction policy: lru, lfu, random (default: lru)")
+	fmt.Fprintln(out, "  -history <file>  Interactive command history (default: ~/.kv_history, empty = none)")
+	fmt.Fprintln(out, "  -output <fmt>    Output format: text, table or json (default: text)")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "COMMANDS:")
+	fmt.Fprintln(out, "  put              Store a key-value pair (requires -key and -value)")
+	fmt.Fprintln(out, "  get              Retrieve value for a key (requires -key)")
+	fmt.Fprintln(out, "  ttl              Show time left before a key expires (requires -key)")
+	fmt.Fprintln(out, "  delete, del      Delete a key-value pair (requires -key)")
+	fmt.Fprintln(out, "  count            Count keys with the given value (requires -value)")
+	fmt.Fprintln(out, "  keys             List keys with the given value (requires -value)")
+	fmt.Fprintln(out, "  countrange       Count keys with a value in [-lo, -hi]")
+	fmt.Fprintln(out, "  top              Show the -k keys with the largest values")
+	fmt.Fprintln(out, "  stats            Show size, capacity and hit/miss/eviction counters")
+	fmt.Fprintln(out, "  checkpoint, cp   Create a snapshot of current state (optional -name)")
+	fmt.Fprintln(out, "  revert, rv       Revert to last checkpoint, or to -name and everything after it")
+	fmt.Fprintln(out, "  release          Drop checkpoint -name without changing data")
+	fmt.Fprintln(out, "  checkpoints, cps List checkpoints with their IDs, names and times")
+	fmt.Fprintln(out, "  save             Save to disk (optional -file)")
+	fmt.Fprintln(out, "  load             Load from disk in any format (optional -file)")
+	fmt.Fprintln(out, "  convert          Rewrite snapshot <src> as <dst> (optional -format)")
+	fmt.Fprintln(out, "  import           Put rows of a CSV or JSONL <file> as one revertable batch")
+	fmt.Fprintln(out, "  export           Write every pair to a CSV or JSONL <file>")
+	fmt.Fprintln(out, "  list, ls         Show all key-value pairs, sorted by key")
+	fmt.Fprintln(out, "  scan             Show keys in [-start, -end) in ascending order")
+	fmt.Fprintln(out, "  rscan            Show keys in [-start, -end) in descending order")
+	fmt.Fprintln(out, "  prefix           Show keys starting with a prefix (requires -prefix)")
+	fmt.Fprintln(out, "  watch            Print changes other sessions save (optional -prefix) until Ctrl+C")
+	fmt.Fprintln(out, "  clear            Clear the entire store")
+	fmt.Fprintln(out, "  compact          Fold the write-ahead log into .kv_store.json (requires -wal)")
+	fmt.Fprintln(out, "  exec [script]    Run a script of interactive commands (stdin if omitted), saving once")
+	fmt.Fprintln(out, "  serve            Serve the store over TCP (Redis protocol) until Ctrl+C")
+	fmt.Fprintln(out, "  help, ?, -h      Show this help message")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "EXAMPLES:")
+	fmt.Fprintln(out, "  kv-cli -key name -value Alice put")
+	fmt.Fprintln(out, "  kv-cli -key message -value \"hello world\" put")
+	fmt.Fprintln(out, "  kv-cli -key name get")
+	fmt.Fprintln(out, "  kv-cli -key session -value 1 -ttl 30s put")
+	fmt.Fprintln(out, "  kv-cli -key name delete")
+	fmt.Fprintln(out, "  kv-cli -value active count")
+	fmt.Fprintln(out, "  kv-cli -value active keys")
+	fmt.Fprintln(out, "  kv-cli -lo 10 -hi 20 countrange")
+	fmt.Fprintln(out, "  kv-cli -k 3 top")
+	fmt.Fprintln(out, "  kv-cli -maxkeys 1000 -evict lfu -key name -value 1 put")
+	fmt.Fprintln(out, "  kv-cli checkpoint")
+	fmt.Fprintln(out, "  kv-cli -name before-import checkpoint")
+	fmt.Fprintln(out, "  kv-cli revert")
+	fmt.Fprintln(out, "  kv-cli -name before-import revert")
+	fmt.Fprintln(out, "  kv-cli -file backup.json save")
+	fmt.Fprintln(out, "  kv-cli -file backup.json load")
+	fmt.Fprintln(out, "  kv-cli -file backup.kvs.gz save")
+	fmt.Fprintln(out, "  kv-cli convert .kv_store.json store.kvs")
+	fmt.Fprintln(out, "  kv-cli -format json convert store.kvs store.json")
+	fmt.Fprintln(out, "  kv-cli -type string -key-col \"Customer Id\" -value-col Email import customers-100.csv")
+	fmt.Fprintln(out, "  kv-cli -type string -key-col request_id -value-col title import requests.jsonl")
+	fmt.Fprintln(out, "  kv-cli -type string export dump.csv")
+	fmt.Fprintln(out, "  kv-cli list")
+	fmt.Fprintln(out, "  kv-cli -output json -key name get")
+	fmt.Fprintln(out, "  kv-cli -output table list")
+	fmt.Fprintln(out, "  kv-cli -start user:100 -end user:200 scan")
+	fmt.Fprintln(out, "  kv-cli -prefix user: prefix")
+	fmt.Fprintln(out, "  kv-cli -wal -sync batched -key name -value 1 put")
+	fmt.Fprintln(out, "  kv-cli -type json -key user -value '{\"age\": 30}' put")
+	fmt.Fprintln(out, "  kv-cli -addr :6380 serve")
+	fmt.Fprintln(out, "  kv-cli -admin localhost:9090 serve")
+	fmt.Fprintln(out, "  kv-cli -type string exec setup.kv")
+	fmt.Fprintln(out, "  kv-cli < setup.kv")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "NOTES:")
+	fmt.Fprintln(out, "  • Run without arguments to enter interactive mode, or pipe in a script")
+	fmt.Fprintln(out, "  • Scripts: # comments, set -e, !cmd must fail, > lines are expected output")
+	fmt.Fprintln(out, "  • A failed script exits 1 without saving")
+	fmt.Fprintln(out, "  • In flag-based mode, flags must come BEFORE the command")
+	fmt.Fprintln(out, "  • Data automatically persists to .kv_store.json")
+	fmt.Fprintln(out, "  • Use quotes for values with spaces")
+	fmt.Fprintln(out, "  • Use the same -type for every command on a store file")
+	fmt.Fprintln(out, "  • -output json prints one object per command: {command, ok, result|error, output}")
+	fmt.Fprintln(out, "  • Hit and miss counters start at zero with each run; use interactive mode or serve")
 }
 
 func printHelp() {
@@ -414,25 +1367,57 @@ func printHelp() {
 }
 
 func printInteractiveHelp() {
-	fmt.Println("\nAvailable Commands:")
-	fmt.Println("-------------------")
-	fmt.Println("  put <key> <value>    Store a key-value pair")
-	fmt.Println("  get <key>            Retrieve value for a key")
-	fmt.Println("  delete <key>         Delete a key-value pair")
-	fmt.Println("  count <value>        Count keys with the given value")
-	fmt.Println("  checkpoint           Create a snapshot of current state")
-	fmt.Println("  revert               Revert to last checkpoint")
-	fmt.Println("  save [file]          Save to disk (default: .kv_store.json)")
-	fmt.Println("  load [file]          Load from disk (default: .kv_store.json)")
-	fmt.Println("  list                 Show all key-value pairs")
-	fmt.Println("  clear                Clear the entire store")
-	fmt.Println("  help                 Show this help message")
-	fmt.Println("  exit, quit           Exit interactive mode")
-	fmt.Println("\nExamples:")
-	fmt.Println("  put name Alice")
-	fmt.Println("  put message \"hello world\"")
-	fmt.Println("  get name")
-	fmt.Println("  count active")
-	fmt.Println("  save backup.json")
-	fmt.Println()
+	fmt.Fprintln(out, "\nAvailable Commands:")
+	fmt.Fprintln(out, "-------------------")
+	fmt.Fprintln(out, "  put <key> <value>    Store a key-value pair")
+	fmt.Fprintln(out, "  setex <key> <ttl> <value>  Store a key that expires after ttl (e.g. 30s)")
+	fmt.Fprintln(out, "  get <key>            Retrieve value for a key")
+	fmt.Fprintln(out, "  ttl <key>            Show time left before a key expires")
+	fmt.Fprintln(out, "  delete <key>         Delete a key-value pair")
+	fmt.Fprintln(out, "  count <value>        Count keys with the given value")
+	fmt.Fprintln(out, "  keys <value>         List keys with the given value")
+	fmt.Fprintln(out, "  countrange <lo> <hi> Count keys with a value in [lo, hi]")
+	fmt.Fprintln(out, "  top [k]              Show the k keys with the largest values (default: 10)")
+	fmt.Fprintln(out, "  stats                Show size, capacity and hit/miss/eviction counters")
+	fmt.Fprintln(out, "  checkpoint [name]    Create a snapshot of current state")
+	fmt.Fprintln(out, "  revert [name|id]     Revert to last checkpoint, or to the given one")
+	fmt.Fprintln(out, "  release <name|id>    Drop a checkpoint without changing data")
+	fmt.Fprintln(out, "  checkpoints          List checkpoints")
+	fmt.Fprintln(out, "  save [file]          Save to disk (default: .kv_store.json)")
+	fmt.Fprintln(out, "  load [file]          Load from disk (default: .kv_store.json)")
+	fmt.Fprintln(out, "  convert <src> <dst>  Rewrite a snapshot file in another format")
+	fmt.Fprintln(out, "  import <file> [key-col] [value-col]  Put the rows of a .csv or .jsonl file")
+	fmt.Fprintln(out, "  export <file> [key-col] [value-col]  Write every pair to a .csv or .jsonl file")
+	fmt.Fprintln(out, "  list                 Show all key-value pairs, sorted by key")
+	fmt.Fprintln(out, "  scan [start] [end]   Show keys in [start, end) in ascending order")
+	fmt.Fprintln(out, "  rscan [start] [end]  Show keys in [start, end) in descending order")
+	fmt.Fprintln(out, "  prefix <prefix>      Show keys starting with prefix")
+	fmt.Fprintln(out, "  watch [prefix]       Show changes other sessions save as they happen")
+	fmt.Fprintln(out, "  unwatch              Stop watching")
+	fmt.Fprintln(out, "  clear                Clear the entire store")
+	fmt.Fprintln(out, "  compact              Fold the write-ahead log into a snapshot")
+	fmt.Fprintln(out, "  help                 Show this help message")
+	fmt.Fprintln(out, "  exit, quit           Exit interactive mode")
+	fmt.Fprintln(out, "\nExamples:")
+	fmt.Fprintln(out, "  put name Alice")
+	fmt.Fprintln(out, "  put message \"hello world\"")
+	fmt.Fprintln(out, "  get name")
+	fmt.Fprintln(out, "  count active")
+	fmt.Fprintln(out, "  keys active")
+	fmt.Fprintln(out, "  countrange 10 20")
+	fmt.Fprintln(out, "  top 3")
+	fmt.Fprintln(out, "  cp before-import")
+	fmt.Fprintln(out, "  rv before-import")
+	fmt.Fprintln(out, "  scan user:100 user:200")
+	fmt.Fprintln(out, "  prefix user:")
+	fmt.Fprintln(out, "  save backup.json")
+	fmt.Fprintln(out, "  save backup.kvs.gz")
+	fmt.Fprintln(out, "  import customers-100.csv \"Customer Id\" Email")
+	fmt.Fprintln(out, "  put user '{\"age\": 30}'")
+	fmt.Fprintln(out, "  watch user:")
+	fmt.Fprintln(out, "\nEditing:")
+	fmt.Fprintln(out, "  Up/Down recall history (kept in ~/.kv_history), Tab completes commands and keys")
+	fmt.Fprintln(out, "  Quote with \"...\" (escapes \\\" \\n \\t) or '...' (literal); an open quote or")
+	fmt.Fprintln(out, "  a trailing \\ continues the command on the next line")
+	fmt.Fprintln(out)
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestSplitInput tests quoting and escapes in command lines
+func TestSplitInput(t *testing.T) {
+	tests := []struct {
+		input string
+		parts []string
+		open  rune
+	}{
+		{`put name Alice`, []string{"put", "name", "Alice"}, 0},
+		{`put msg "hello world"`, []string{"put", "msg", "hello world"}, 0},
+		{`put msg "say \"hi\"\n\tbye \\ \x"`, []string{"put", "msg", "say \"hi\"\n\tbye \\ \\x"}, 0},
+		{`put user '{"age": 30}'`, []string{"put", "user", `{"age": 30}`}, 0},
+		{`put msg don't 'a\b'`, []string{"put", "msg", "don't", `a\b`}, 0},
+		{`put a\ b c\"d`, []string{"put", "a b", `c"d`}, 0},
+		{`put k ""`, []string{"put", "k", ""}, 0},
+		{`put k pre"fix ed"`, []string{"put", "k", "prefix ed"}, 0},
+		{"  get\ta  ", []string{"get", "a"}, 0},
+		{`put k "open`, []string{"put", "k", "open"}, '"'},
+		{`put k 'open`, []string{"put", "k", "open"}, '\''},
+		{`put k v\`, []string{"put", "k", "v"}, '\\'},
+		{``, nil, 0},
+	}
+	for _, tt := range tests {
+		parts, open := splitInput(tt.input)
+		if !slices.Equal(parts, tt.parts) || open != tt.open {
+			t.Errorf("splitInput(%q) = %q, %q; expected %q, %q", tt.input, parts, open, tt.parts, tt.open)
+		}
+	}
+}
+
+// TestReadInput tests that open quotes and trailing backslashes continue
+// a command on the next line
+func TestReadInput(t *testing.T) {
+	e := newLineEditor(strings.NewReader("put j '{\"a\": 1,\n\"b\": 2}'\nput k long\\\nvalue\nget j\n"), io.Discard, false, "")
+	var got []string
+	for {
+		input, err := readInput(e)
+		if err != nil {
+			break
+		}
+		got = append(got, input)
+	}
+	want := []string{"put j '{\"a\": 1,\n\"b\": 2}'", "put k longvalue", "get j"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+// TestCompleteInput tests completing commands, keys and checkpoint names
+func TestCompleteInput(t *testing.T) {
+	useTestStore(t)
+	kvStore.Put("user:1", "a")
+	kvStore.Put("user:2", "b")
+	kvStore.Put("other", "c")
+	kvStore.Checkpoint("before-import")
+
+	tests := []struct {
+		line       string
+		start      int
+		candidates []string
+	}{
+		{"ch", 0, []string{"checkpoint", "checkpoints"}},
+		{"get us", 4, []string{"user:1", "user:2"}},
+		{"délete ", 7, nil}, // Not a command; start counts runes
+		{"del o", 4, []string{"other"}},
+		{"rv be", 3, []string{"before-import"}},
+		{"put user:1 ", 11, nil}, // Values aren't completed
+	}
+	for _, tt := range tests {
+		start, candidates := completeInput(tt.line)
+		if start != tt.start || !slices.Equal(candidates, tt.candidates) {
+			t.Errorf("completeInput(%q) = %d, %q; expected %d, %q", tt.line, start, candidates, tt.start, tt.candidates)
+		}
+	}
+}
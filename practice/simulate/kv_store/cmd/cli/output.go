@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"workshop/practice/simulate/kv_store/store"
+)
+
+// Formats for -output
+const (
+	outputText  = "text"  // Messages for people, as the CLI always printed
+	outputTable = "table" // Aligned columns with a header row
+	outputJSON  = "json"  // One JSON object per command
+)
+
+// Error codes in -output json. They are part of the schema: scripts match
+// on them, so existing codes never change meaning.
+const (
+	codeUsage          = "usage"           // Missing or malformed arguments
+	codeUnknownCommand = "unknown_command" // No such command
+	codeInvalidValue   = "invalid_value"   // The value doesn't parse as -type
+	codeNotFound       = "not_found"       // No such key or checkpoint
+	codeIO             = "io_error"        // Reading or writing a file failed
+	codeFailed         = "failed"          // Anything else
+)
+
+// commandReply is the JSON object printed for each command. Result is set
+// for commands with a structured result (see the *Result types) and Output
+// holds any other lines the command printed, such as warnings, or all of
+// them for commands without one.
+type commandReply struct {
+	Command string        `json:"command"`
+	OK      bool          `json:"ok"`
+	Result  any           `json:"result,omitempty"`
+	Error   *commandError `json:"error,omitempty"`
+	Output  []string      `json:"output,omitempty"`
+}
+
+// commandError is a failed command's error in -output json
+type commandError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// The running command's result or error, kept for runCommand in json mode
+var (
+	reported any
+	failure  *commandError
+)
+
+// parseOutputFormat checks a -output value
+func parseOutputFormat(name string) (string, error) {
+	switch name {
+	case outputText, outputTable, outputJSON:
+		return name, nil
+	}
+	return "", fmt.Errorf("unknown output format '%s' (want text, table or json)", name)
+}
+
+// runCommand runs a command and returns whether it succeeded. In json mode
+// whatever the command prints is collected into a commandReply.
+func runCommand(command string, run func() bool) bool {
+	if *outputFormat != outputJSON {
+		return run()
+	}
+
+	stdout := out
+	var buf bytes.Buffer
+	out, reported, failure = &buf, nil, nil
+	ok := run()
+	out = stdout
+
+	reply := commandReply{Command: command, OK: ok, Output: outputLines(buf.String())}
+	if ok {
+		reply.Result = reported
+	} else if reply.Error = failure; reply.Error == nil {
+		// Failed without calling fail; the last line printed says why
+		message := "failed"
+		if n := len(reply.Output); n > 0 {
+			message = strings.TrimPrefix(reply.Output[n-1], "❌ ")
+		}
+		reply.Error = &commandError{Code: codeFailed, Message: message}
+	}
+	printReply(reply)
+	return ok
+}
+
+// printReply prints reply as one line of JSON
+func printReply(reply commandReply) {
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(reply); err != nil {
+		enc.Encode(commandReply{Command: reply.Command, Error: &commandError{Code: codeFailed, Message: err.Error()}})
+	}
+}
+
+// fail prints a failed command's message, or keeps it with its code in json
+// mode, and returns false for the command to return
+func fail(code, format string, args ...any) bool {
+	msg := fmt.Sprintf(format, args...)
+	if *outputFormat == outputJSON {
+		failure = &commandError{Code: code, Message: strings.TrimPrefix(strings.TrimPrefix(msg, "❌ "), "Error: ")}
+		return false
+	}
+	fmt.Fprintln(out, msg)
+	return false
+}
+
+// exitWithError reports an error from outside any command, such as a bad
+// flag, in the -output format and exits
+func exitWithError(command, code, format string, args ...any) {
+	runCommand(command, func() bool { return fail(code, format, args...) })
+	closeStore() // Already failing; keep what was logged before the error
+	os.Exit(1)
+}
+
+// codedError is an error the CLI knows the code of
+type codedError struct {
+	code string
+	err  error
+}
+
+func (e codedError) Error() string { return e.err.Error() }
+func (e codedError) Unwrap() error { return e.err }
+
+// errorCode returns the code for an error from the CLI, the store or the
+// file system, or fallback if it isn't one of those
+func errorCode(err error, fallback string) string {
+	var coded codedError
+	var pathErr *fs.PathError
+	switch {
+	case errors.As(err, &coded):
+		return coded.code
+	case errors.Is(err, store.ErrCheckpointNotFound):
+		return codeNotFound
+	case errors.As(err, &pathErr), errors.Is(err, store.ErrChecksumMismatch), errors.Is(err, store.ErrUnsupportedVersion):
+		return codeIO
+	}
+	return fallback
+}
+
+// A result is what a command prints when it succeeds, in each format
+type result interface {
+	text(w io.Writer)
+	table() (header []string, rows [][]string)
+}
+
+// report prints a command's result in the -output format
+func report(r result) {
+	switch *outputFormat {
+	case outputJSON:
+		reported = r
+	case outputTable:
+		header, rows := r.table()
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, strings.Join(header, "\t"))
+		for _, row := range rows {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		w.Flush()
+	default:
+		r.text(out)
+	}
+}
+
+// typedValue is a value's canonical text, marshaled as the JSON the -type
+// stores: a number for int, a string for string and bytes (base64), and the
+// value itself for json
+type typedValue string
+
+func (v typedValue) MarshalJSON() ([]byte, error) {
+	return vt.codec.Marshal(string(v))
+}
+
+// orDash returns s, or "-" if it is empty, for table cells
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+type putResult struct {
+	Key       string     `json:"key"`
+	Value     typedValue `json:"value"`
+	ExpiresIn string     `json:"expires_in,omitempty"` // A Go duration, e.g. "30s"
+}
+
+func newPutResult(key, value string, expiresIn time.Duration) putResult {
+	r := putResult{Key: key, Value: typedValue(value)}
+	if expiresIn > 0 {
+		r.ExpiresIn = expiresIn.String()
+	}
+	return r
+}
+
+func (r putResult) text(w io.Writer) {
+	if r.ExpiresIn != "" {
+		fmt.Fprintf(w, "✅ Set '%s' = %s (expires in %s)\n", r.Key, r.Value, r.ExpiresIn)
+		return
+	}
+	fmt.Fprintf(w, "✅ Set '%s' = %s\n", r.Key, r.Value)
+}
+
+func (r putResult) table() ([]string, [][]string) {
+	return []string{"KEY", "VALUE", "EXPIRES IN"}, [][]string{{r.Key, string(r.Value), orDash(r.ExpiresIn)}}
+}
+
+type getResult struct {
+	Key   string     `json:"key"`
+	Value typedValue `json:"value"`
+}
+
+func (r getResult) text(w io.Writer) {
+	fmt.Fprintf(w, "✅ '%s' = %s\n", r.Key, r.Value)
+}
+
+func (r getResult) table() ([]string, [][]string) {
+	return []string{"KEY", "VALUE"}, [][]string{{r.Key, string(r.Value)}}
+}
+
+type deleteResult struct {
+	Key string `json:"key"`
+}
+
+func (r deleteResult) text(w io.Writer) {
+	fmt.Fprintf(w, "✅ Deleted key '%s'\n", r.Key)
+}
+
+func (r deleteResult) table() ([]string, [][]string) {
+	return []string{"DELETED"}, [][]string{{r.Key}}
+}
+
+type countResult struct {
+	Value typedValue `json:"value"`
+	Count int        `json:"count"`
+}
+
+func (r countResult) text(w io.Writer) {
+	fmt.Fprintf(w, "✅ Value %s appears %d time(s)\n", r.Value, r.Count)
+}
+
+func (r countResult) table() ([]string, [][]string) {
+	return []string{"VALUE", "COUNT"}, [][]string{{string(r.Value), fmt.Sprint(r.Count)}}
+}
+
+// keyValue is one pair in a listResult
+type keyValue struct {
+	Key   string     `json:"key"`
+	Value typedValue `json:"value"`
+}
+
+// valueCount is one value's count in a listResult
+type valueCount struct {
+	Value typedValue `json:"value"`
+	Count int        `json:"count"`
+}
+
+type listResult struct {
+	Data        []keyValue   `json:"data"`         // In key order
+	ValueCounts []valueCount `json:"value_counts"` // In value text order
+	Keys        int          `json:"keys"`
+	Checkpoints int          `json:"checkpoints"`
+}
+
+func (r listResult) text(w io.Writer) {
+	if r.Keys == 0 {
+		fmt.Fprintln(w, "Store is empty")
+		return
+	}
+
+	fmt.Fprintln(w, "\nCurrent Key-Value Pairs:")
+	fmt.Fprintln(w, "------------------------")
+	for _, kv := range r.Data {
+		fmt.Fprintf(w, "  %s = %s\n", kv.Key, kv.Value)
+	}
+
+	fmt.Fprintln(w, "\nValue Counts:")
+	fmt.Fprintln(w, "-------------")
+	for _, vc := range r.ValueCounts {
+		fmt.Fprintf(w, "  %s → %d\n", vc.Value, vc.Count)
+	}
+
+	fmt.Fprintf(w, "\nTotal keys: %d\n", r.Keys)
+	fmt.Fprintf(w, "Checkpoints: %d\n", r.Checkpoints)
+}
+
+func (r listResult) table() ([]string, [][]string) {
+	rows := make([][]string, 0, len(r.Data))
+	for _, kv := range r.Data {
+		rows = append(rows, []string{kv.Key, string(kv.Value)})
+	}
+	return []string{"KEY", "VALUE"}, rows
+}
+
+type checkpointResult struct {
+	ID          store.CheckpointID `json:"id"`
+	Name        string             `json:"name,omitempty"`
+	Checkpoints int                `json:"checkpoints"`
+}
+
+func (r checkpointResult) text(w io.Writer) {
+	if r.Name != "" {
+		fmt.Fprintf(w, "✅ Checkpoint %d '%s' created (total: %d)\n", r.ID, r.Name, r.Checkpoints)
+	} else {
+		fmt.Fprintf(w, "✅ Checkpoint %d created (total: %d)\n", r.ID, r.Checkpoints)
+	}
+}
+
+func (r checkpointResult) table() ([]string, [][]string) {
+	return []string{"ID", "NAME", "CHECKPOINTS"}, [][]string{{fmt.Sprint(r.ID), orDash(r.Name), fmt.Sprint(r.Checkpoints)}}
+}
+
+type revertResult struct {
+	ID          store.CheckpointID `json:"id"` // The checkpoint reverted to
+	Name        string             `json:"name,omitempty"`
+	Checkpoints int                `json:"checkpoints"` // Remaining
+	ref         string             // As given, "" for the last checkpoint
+}
+
+func (r revertResult) text(w io.Writer) {
+	if r.ref == "" {
+		fmt.Fprintln(w, "✅ Reverted to last checkpoint")
+		return
+	}
+	fmt.Fprintf(w, "✅ Reverted to checkpoint '%s' (remaining: %d)\n", r.ref, r.Checkpoints)
+}
+
+func (r revertResult) table() ([]string, [][]string) {
+	return []string{"REVERTED TO", "NAME", "CHECKPOINTS"}, [][]string{{fmt.Sprint(r.ID), orDash(r.Name), fmt.Sprint(r.Checkpoints)}}
+}
+
+type saveResult struct {
+	File   string `json:"file"`
+	Format string `json:"format"`
+}
+
+func (r saveResult) text(w io.Writer) {
+	fmt.Fprintf(w, "✅ Saved to '%s'\n", r.File)
+}
+
+func (r saveResult) table() ([]string, [][]string) {
+	return []string{"SAVED", "FORMAT"}, [][]string{{r.File, r.Format}}
+}
+
+type loadResult struct {
+	File string `json:"file"`
+	Keys int    `json:"keys"`
+}
+
+func (r loadResult) text(w io.Writer) {
+	fmt.Fprintf(w, "✅ Loaded from '%s'\n", r.File)
+}
+
+func (r loadResult) table() ([]string, [][]string) {
+	return []string{"LOADED", "KEYS"}, [][]string{{r.File, fmt.Sprint(r.Keys)}}
+}
+
+type importResult struct {
+	File       string             `json:"file"`
+	Format     string             `json:"format"`
+	Rows       int                `json:"rows"`
+	Checkpoint store.CheckpointID `json:"checkpoint"` // Taken before the rows were loaded
+	Name       string             `json:"name"`       // The checkpoint's
+}
+
+func (r importResult) text(w io.Writer) {
+	fmt.Fprintf(w, "✅ Imported %d row(s) from '%s' after checkpoint %d '%s' (revert to undo)\n", r.Rows, r.File, r.Checkpoint, r.Name)
+}
+
+func (r importResult) table() ([]string, [][]string) {
+	return []string{"IMPORTED", "FORMAT", "ROWS", "CHECKPOINT"}, [][]string{{r.File, r.Format, fmt.Sprint(r.Rows), fmt.Sprint(r.Checkpoint)}}
+}
+
+type exportResult struct {
+	File   string `json:"file"`
+	Format string `json:"format"`
+	Keys   int    `json:"keys"`
+}
+
+func (r exportResult) text(w io.Writer) {
+	fmt.Fprintf(w, "✅ Exported %d key(s) to '%s'\n", r.Keys, r.File)
+}
+
+func (r exportResult) table() ([]string, [][]string) {
+	return []string{"EXPORTED", "FORMAT", "KEYS"}, [][]string{{r.File, r.Format, fmt.Sprint(r.Keys)}}
+}
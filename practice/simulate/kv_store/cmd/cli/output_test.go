@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files in testdata/output")
+
+// outputSession runs every command with a structured result, and the ways
+// they fail
+var outputSession = []string{
+	"put name Alice",
+	"setex token 30s abc",
+	"get name",
+	"get missing",
+	"count Alice",
+	"delete token",
+	"delete token",
+	"checkpoint before",
+	"put name Bob",
+	"checkpoint",
+	"list",
+	"revert",
+	"revert before",
+	"revert nope",
+	"save out.json",
+	"load out.json",
+	"load missing.json",
+	"top 1",
+	"put",
+	"frobnicate",
+}
+
+// useOutputFormat sets -output for the test
+func useOutputFormat(t *testing.T, format string) {
+	t.Helper()
+	saved := *outputFormat
+	*outputFormat = format
+	t.Cleanup(func() { *outputFormat = saved })
+}
+
+// TestOutputFormats runs outputSession in each -output format and compares
+// what it prints with testdata/output/<format>.golden (go test -update
+// rewrites them)
+func TestOutputFormats(t *testing.T) {
+	for _, format := range []string{outputText, outputTable, outputJSON} {
+		t.Run(format, func(t *testing.T) {
+			golden, _ := filepath.Abs(filepath.Join("testdata", "output", format+".golden"))
+			buf := useTestStore(t)
+			useOutputFormat(t, format)
+
+			var got strings.Builder
+			for _, input := range outputSession {
+				buf.Reset()
+				ok := executeInteractiveCommand(input)
+				fmt.Fprintf(&got, "kv> %s (ok=%v)\n%s", input, ok, buf)
+			}
+
+			if *update {
+				if err := os.WriteFile(golden, []byte(got.String()), 0644); err != nil {
+					t.Fatalf("Failed to update %s: %v", golden, err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("Failed to read %s: %v", golden, err)
+			}
+			if got.String() != string(want) {
+				t.Errorf("Output differs from %s (go test -update to accept):\n%s", golden, got.String())
+			}
+		})
+	}
+}
+
+// TestJSONValueTypes tests that values are JSON of their -type
+func TestJSONValueTypes(t *testing.T) {
+	buf := useTestStore(t)
+	useOutputFormat(t, outputJSON)
+	for _, tt := range []struct{ typ, input, want string }{
+		{"int", "42", `{"key":"k","value":42}`},
+		{"string", "42", `{"key":"k","value":"42"}`},
+		{"bytes", "aGk=", `{"key":"k","value":"aGk="}`},
+		{"json", `'{"b": [1, 2]}'`, `{"key":"k","value":{"b":[1,2]}}`},
+	} {
+		vt, _ = lookupValueType(tt.typ)
+		kvStore = newStore()
+		executeInteractiveCommand("put k " + tt.input)
+		buf.Reset()
+		executeInteractiveCommand("get k")
+
+		var reply struct{ Result json.RawMessage }
+		if err := json.Unmarshal(buf.Bytes(), &reply); err != nil {
+			t.Fatalf("Expected one JSON object for -type %s, got %q: %v", tt.typ, buf, err)
+		}
+		if string(reply.Result) != tt.want {
+			t.Errorf("Expected result %s for -type %s, got %s", tt.want, tt.typ, reply.Result)
+		}
+	}
+}
+
+// TestFlagCommandOutput tests that flag-mode commands report failures in
+// JSON and return false, for main to exit non-zero
+func TestFlagCommandOutput(t *testing.T) {
+	buf := useTestStore(t)
+	useOutputFormat(t, outputJSON)
+	savedKey := *key
+	t.Cleanup(func() { *key = savedKey })
+
+	*key = "missing"
+	if executeCommand("get") {
+		t.Errorf("Expected get of a missing key to fail")
+	}
+	if want := `{"command":"get","ok":false,"error":{"code":"not_found","message":"Key 'missing' not found"}}` + "\n"; buf.String() != want {
+		t.Errorf("Expected %s, got %s", want, buf)
+	}
+
+	buf.Reset()
+	*key = ""
+	if executeCommand("get") {
+		t.Errorf("Expected get without --key to fail")
+	}
+	var reply commandReply
+	if err := json.Unmarshal(buf.Bytes(), &reply); err != nil || reply.Error == nil || reply.Error.Code != codeUsage {
+		t.Errorf("Expected a usage error, got %s", buf)
+	}
+
+	buf.Reset()
+	kvStore.Put("a", "1")
+	if !executeCommand("checkpoint") {
+		t.Errorf("Expected checkpoint to succeed")
+	}
+	if want := `{"command":"checkpoint","ok":true,"result":{"id":1,"checkpoints":1}}` + "\n"; buf.String() != want {
+		t.Errorf("Expected %s, got %s", want, buf)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package main
+
+import "errors"
+
+// makeRaw is not supported here, so the REPL reads plain lines
+func makeRaw(fd int) (restore func(), err error) {
+	return nil, errors.ErrUnsupported
+}
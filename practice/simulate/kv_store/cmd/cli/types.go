@@ -0,0 +1,128 @@
+package main
+
+import (
+	"cmp"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"workshop/practice/simulate/kv_store/store"
+)
+
+// valueType maps the text typed at the CLI to a typed store value.
+//
+// The CLI keeps a store.KVStore[string] holding each value's canonical text
+// form; the codec converts that text to and from the typed JSON encoding, so
+// files written with -type int/bytes/json are the same as those written by
+// a KVStore[int], KVStore[store.Blob] or KVStore[store.JSONValue].
+type valueType struct {
+	name      string
+	normalize func(input string) (string, error) // Validate input, return its canonical text
+	codec     store.Codec[string]
+	compare   func(a, b string) int // Orders canonical texts by typed value, for the value index
+}
+
+// textCodec adapts a typed codec to the CLI's canonical text values
+type textCodec[V comparable] struct {
+	inner  store.Codec[V]
+	parse  func(string) (V, error)
+	format func(V) string
+}
+
+func (c textCodec[V]) Marshal(text string) ([]byte, error) {
+	value, err := c.parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return c.inner.Marshal(value)
+}
+
+func (c textCodec[V]) Unmarshal(data []byte) (string, error) {
+	value, err := c.inner.Unmarshal(data)
+	if err != nil {
+		return "", err
+	}
+	return c.format(value), nil
+}
+
+// newValueType builds a valueType from a typed codec, its text form and its
+// order
+func newValueType[V comparable](name string, inner store.Codec[V], parse func(string) (V, error), format func(V) string, compare func(a, b V) int) valueType {
+	return valueType{
+		name: name,
+		normalize: func(input string) (string, error) {
+			value, err := parse(input)
+			if err != nil {
+				return "", err
+			}
+			return format(value), nil
+		},
+		codec: textCodec[V]{inner: inner, parse: parse, format: format},
+		compare: func(a, b string) int {
+			// Stored texts are canonical, so they always parse
+			va, errA := parse(a)
+			vb, errB := parse(b)
+			if errA != nil || errB != nil {
+				return strings.Compare(a, b)
+			}
+			return compare(va, vb)
+		},
+	}
+}
+
+var valueTypes = map[string]valueType{
+	"int": newValueType("int", store.JSONCodec[int]{},
+		func(s string) (int, error) {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return 0, fmt.Errorf("value must be an integer, got '%s'", s)
+			}
+			return n, nil
+		},
+		strconv.Itoa,
+		cmp.Compare[int]),
+	"string": newValueType("string", store.JSONCodec[string]{},
+		func(s string) (string, error) { return s, nil },
+		func(s string) string { return s },
+		strings.Compare),
+	"bytes": newValueType("bytes", store.BlobCodec{},
+		func(s string) (store.Blob, error) {
+			raw, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return "", fmt.Errorf("value must be base64-encoded bytes, got '%s'", s)
+			}
+			return store.Blob(raw), nil
+		},
+		func(b store.Blob) string { return base64.StdEncoding.EncodeToString([]byte(b)) },
+		func(a, b store.Blob) int { return strings.Compare(string(a), string(b)) }),
+	"json": newValueType("json", store.JSONValueCodec{},
+		store.NewJSONValue,
+		func(v store.JSONValue) string { return string(v) },
+		compareJSON),
+}
+
+// compareJSON orders JSON values numerically when both are numbers and by
+// their canonical text otherwise
+func compareJSON(a, b store.JSONValue) int {
+	x, errA := strconv.ParseFloat(string(a), 64)
+	y, errB := strconv.ParseFloat(string(b), 64)
+	if errA == nil && errB == nil {
+		return cmp.Compare(x, y)
+	}
+	return strings.Compare(string(a), string(b))
+}
+
+// lookupValueType returns the valueType for a -type flag value
+func lookupValueType(name string) (valueType, error) {
+	vt, ok := valueTypes[name]
+	if !ok {
+		return valueType{}, fmt.Errorf("unknown value type '%s' (want %s)", name, strings.Join(valueTypeNames(), ", "))
+	}
+	return vt, nil
+}
+
+// valueTypeNames lists the supported -type values
+func valueTypeNames() []string {
+	return []string{"int", "string", "bytes", "json"}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"workshop/practice/simulate/kv_store/store"
+)
+
+// watchInterval is how often a watch checks the store's files
+const watchInterval = 250 * time.Millisecond
+
+// sessionWatch reports changes other sessions save to the store's files.
+// Every session keeps its own copy of the store and writes it back to
+// .kv_store.json (or its WAL) after each command, so a watch polls the files
+// and compares what they hold with what it saw last.
+type sessionWatch struct {
+	prefix string
+	print  func(string)
+
+	mu     sync.Mutex // Guards the fields below, shared with pause and resume
+	stamp  string     // Size and modification time of the files
+	data   map[string]string
+	paused bool // While this session runs a command
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+// activeWatch is the REPL's running watch, if any
+var activeWatch *sessionWatch
+
+// startWatch starts reporting changes to keys starting with prefix through
+// print, until it is stopped
+func startWatch(prefix string, print func(string)) (*sessionWatch, error) {
+	w := &sessionWatch{prefix: prefix, print: print, quit: make(chan struct{}), done: make(chan struct{})}
+	if err := w.resyncLocked(); err != nil { // Not running yet
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+// run polls the files until quit is closed
+func (w *sessionWatch) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.quit:
+			return
+		case <-ticker.C:
+			w.poll()
+		}
+	}
+}
+
+// poll reports the changes since the last poll, if the files changed
+func (w *sessionWatch) poll() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stamp := filesStamp()
+	if w.paused || stamp == w.stamp {
+		return
+	}
+	data, err := readSessionData()
+	if err != nil {
+		return // Try again next time, e.g. a torn write
+	}
+	if changes := diffSessions(w.data, data, w.prefix); changes != "" {
+		w.print(changes)
+	}
+	w.stamp, w.data = stamp, data
+}
+
+// pause stops reporting while this session runs a command, whose own
+// changes to the files are not news
+func (w *sessionWatch) pause() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paused = true
+}
+
+// resume takes the files as they are after this session's command, without
+// reporting anything, and carries on watching
+func (w *sessionWatch) resume() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paused = false
+	w.resyncLocked()
+}
+
+// resyncLocked takes the files as they are; the caller must hold mu
+func (w *sessionWatch) resyncLocked() error {
+	data, err := readSessionData()
+	if err != nil {
+		return err
+	}
+	w.stamp, w.data = filesStamp(), data
+	return nil
+}
+
+// stop stops w and waits for it to finish
+func (w *sessionWatch) stop() {
+	close(w.quit)
+	<-w.done
+}
+
+// filesStamp identifies the current contents of the store's files
+func filesStamp() string {
+	var b strings.Builder
+	for _, name := range []string{defaultFile, defaultFile + ".wal"} {
+		if info, err := os.Stat(name); err == nil {
+			fmt.Fprintf(&b, "%d@%d ", info.Size(), info.ModTime().UnixNano())
+		}
+		b.WriteString(";")
+	}
+	return b.String()
+}
+
+// readSessionData reads the data saved in the store's files, without
+// opening them for writing
+func readSessionData() (map[string]string, error) {
+	kv := store.NewKVStoreWithCodec(vt.codec)
+	var err error
+	if *useWAL {
+		kv, err = store.ReadKVStoreWithCodec(defaultFile, vt.codec, store.WALOptions{})
+	} else if _, statErr := os.Stat(defaultFile); statErr == nil {
+		err = kv.LoadFromDisk(defaultFile)
+	}
+	if err != nil && !errors.Is(err, store.ErrInconsistentState) {
+		return nil, err
+	}
+	data, _ := kv.GetAllData()
+	return data, nil
+}
+
+// diffSessions describes the changes from old to new to keys starting with
+// prefix, one line each in key order, or returns "" if there are none
+func diffSessions(old, new map[string]string, prefix string) string {
+	keys := make(map[string]bool)
+	for key := range old {
+		keys[key] = true
+	}
+	for key := range new {
+		keys[key] = true
+	}
+
+	var b strings.Builder
+	for _, key := range slices.Sorted(maps.Keys(keys)) {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		oldValue, existed := old[key]
+		newValue, exists := new[key]
+		switch {
+		case !exists:
+			fmt.Fprintf(&b, "🔔 '%s' deleted (was %s)\n", key, oldValue)
+		case !existed:
+			fmt.Fprintf(&b, "🔔 '%s' = %s\n", key, newValue)
+		case newValue != oldValue:
+			fmt.Fprintf(&b, "🔔 '%s' = %s (was %s)\n", key, newValue, oldValue)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"workshop/practice/simulate/kv_store/store"
+)
+
+// TestDiffSessions tests the lines describing changes between two saves
+func TestDiffSessions(t *testing.T) {
+	old := map[string]string{"user:1": "a", "user:2": "b", "user:3": "c", "other": "x"}
+	new := map[string]string{"user:1": "a", "user:2": "B", "user:4": "d", "other": "y"}
+	want := "🔔 'user:2' = B (was b)\n🔔 'user:3' deleted (was c)\n🔔 'user:4' = d\n"
+	if got := diffSessions(old, new, "user:"); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if got := diffSessions(old, old, ""); got != "" {
+		t.Errorf("Expected no changes, got %q", got)
+	}
+}
+
+// TestSessionWatch tests that a watch reports what another session saves,
+// but not what this session saved while it was paused
+func TestSessionWatch(t *testing.T) {
+	useTestStore(t)
+	other := store.NewKVStoreWithCodec(vt.codec)
+	other.Put("a", "1")
+	other.SaveToDisk(defaultFile)
+
+	events := make(chan string, 10)
+	w, err := startWatch("", func(msg string) { events <- msg })
+	if err != nil {
+		t.Fatalf("startWatch failed: %v", err)
+	}
+	defer w.stop()
+
+	// This session's own save
+	w.pause()
+	kvStore.Put("mine", "1")
+	saveStore(defaultFile)
+	time.Sleep(2 * watchInterval)
+	w.resume()
+
+	other.Put("b", "2")
+	other.Delete("a")
+	time.Sleep(10 * time.Millisecond) // Let the modification time move on
+	other.SaveToDisk(defaultFile)
+
+	select {
+	case msg := <-events:
+		// Each session saves its whole store: a went with this session's
+		// save, unreported, and mine goes with the other's
+		if want := "🔔 'b' = 2\n🔔 'mine' deleted (was 1)\n"; msg != want {
+			t.Errorf("Expected %q, got %q", want, msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the watch to report the other session's save")
+	}
+}
@@ -0,0 +1,120 @@
+// Command kv-cluster runs one node of a Raft-replicated KV store. Start one
+// process per node, each with the same -peers list:
+//
+//	kv-cluster -id n1 -peers n1=localhost:7001,n2=localhost:7002,n3=localhost:7003
+//	kv-cluster -id n2 -peers n1=localhost:7001,n2=localhost:7002,n3=localhost:7003
+//	kv-cluster -id n3 -peers n1=localhost:7001,n2=localhost:7002,n3=localhost:7003
+//
+// Then talk to any node over HTTP (see cluster.NewHandler):
+//
+//	curl -L -X PUT -d 42 localhost:7002/kv/answer
+//	curl -L localhost:7003/kv/answer
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"workshop/practice/simulate/kv_store/cluster"
+)
+
+// Command-line flags
+var (
+	id        = flag.String("id", "", "This node's ID (must be in -peers)")
+	peers     = flag.String("peers", "", "Every node as id=host:port, comma-separated")
+	dir       = flag.String("dir", "", "Directory for Raft state and snapshots (default cluster-data/<id>)")
+	election  = flag.Duration("election", 300*time.Millisecond, "Minimum election timeout")
+	heartbeat = flag.Duration("heartbeat", 50*time.Millisecond, "Leader heartbeat interval")
+	snapshot  = flag.Int("snapshot", 1000, "Applied log entries kept before compacting into a snapshot")
+)
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	addrs, err := parsePeers(*peers)
+	if err != nil {
+		return err
+	}
+	addr, ok := addrs[*id]
+	if !ok {
+		return fmt.Errorf("-id %q is not in -peers", *id)
+	}
+	if *dir == "" {
+		*dir = filepath.Join("cluster-data", *id)
+	}
+
+	logger := log.New(os.Stderr, "", log.Ltime|log.Lmicroseconds)
+	node, err := cluster.NewNode(cluster.Config{
+		ID:                *id,
+		Peers:             slices.Sorted(maps.Keys(addrs)),
+		Dir:               *dir,
+		ElectionTimeout:   *election,
+		HeartbeatInterval: *heartbeat,
+		SnapshotThreshold: *snapshot,
+		Logf:              logger.Printf,
+	}, cluster.NewHTTPTransport(addrs))
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{Addr: addr, Handler: cluster.NewHandler(node, addrs)}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.ListenAndServe() }()
+	node.Start()
+	fmt.Printf("✅ Node %s serving on %s (data in %s, Ctrl+C to stop)\n", *id, addr, *dir)
+
+	select {
+	case err = <-serveErr:
+	case <-ctx.Done():
+		fmt.Println("Shutting down...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err = srv.Shutdown(shutdownCtx)
+	}
+	node.Stop()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// parsePeers parses "n1=host:port,n2=host:port" into a map from ID to
+// address
+func parsePeers(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, fmt.Errorf("-peers is required")
+	}
+	addrs := make(map[string]string)
+	for _, peer := range strings.Split(s, ",") {
+		peerID, addr, ok := strings.Cut(strings.TrimSpace(peer), "=")
+		if !ok || peerID == "" || addr == "" {
+			return nil, fmt.Errorf("invalid peer %q (expected id=host:port)", peer)
+		}
+		if _, dup := addrs[peerID]; dup {
+			return nil, fmt.Errorf("duplicate peer %q", peerID)
+		}
+		addrs[peerID] = addr
+	}
+	return addrs, nil
+}
@@ -6,11 +6,12 @@ import (
 )
 
 func main() {
-	fmt.Println("=== KV Store Demo ===\n")
+	fmt.Println("=== KV Store Demo ===")
+	fmt.Println()
 
 	// Test 1: Basic Put/Get
 	fmt.Println("Test 1: Basic Put/Get Operations")
-	kv := store.NewKVStore()
+	kv := store.NewKVStore[int]()
 	kv.Put("name", 100)
 	kv.Put("age", 30)
 	kv.Put("city", 10001)
@@ -42,7 +43,7 @@ func main() {
 	// Test 3: Checkpoint and Revert
 	fmt.Println("Test 3: Checkpoint and Revert")
 	kv.Put("name", 200)
-	kv.Checkpoint()
+	kv.Checkpoint("")
 	fmt.Println("Checkpoint created")
 
 	kv.Put("name", 999)
@@ -55,11 +56,11 @@ func main() {
 	// Test 4: Multiple Checkpoints
 	fmt.Println("Test 4: Multiple Checkpoints")
 	kv.Put("state", 11)
-	kv.Checkpoint()
+	kv.Checkpoint("")
 	fmt.Println("Checkpoint 1 created (state=11)")
 
 	kv.Put("state", 22)
-	kv.Checkpoint()
+	kv.Checkpoint("")
 	fmt.Println("Checkpoint 2 created (state=22)")
 
 	kv.Put("state", 33)
@@ -74,7 +75,7 @@ func main() {
 
 	// Test 5: Value count with updates
 	fmt.Println("Test 5: Value Count with Updates")
-	kv2 := store.NewKVStore()
+	kv2 := store.NewKVStore[int]()
 	kv2.Put("k1", 5)
 	kv2.Put("k2", 5)
 	kv2.Put("k3", 6)
@@ -84,10 +85,10 @@ func main() {
 
 	// Test 6: Save and Load
 	fmt.Println("Test 6: Save and Load to Disk")
-	kv3 := store.NewKVStore()
+	kv3 := store.NewKVStore[int]()
 	kv3.Put("data", 777)
 	kv3.Put("yes", 1)
-	kv3.Checkpoint()
+	kv3.Checkpoint("")
 	kv3.Put("value", 50)
 
 	err := kv3.SaveToDisk("test_demo.json")
@@ -97,7 +98,7 @@ func main() {
 	}
 	fmt.Println("  Saved to test_demo.json")
 
-	kv4 := store.NewKVStore()
+	kv4 := store.NewKVStore[int]()
 	err = kv4.LoadFromDisk("test_demo.json")
 	if err != nil {
 		fmt.Printf("Error loading: %v\n", err)
@@ -111,5 +112,20 @@ func main() {
 	fmt.Printf("  Checkpoint count after load: %d\n", kv4.GetCheckpointCount())
 	fmt.Println()
 
+	// Test 7: Typed values
+	fmt.Println("Test 7: Typed Values")
+	names := store.NewKVStore[string]()
+	names.Put("user1", "active")
+	names.Put("user2", "active")
+	fmt.Printf("  'active' count: %d\n", names.CountValue("active"))
+
+	docs := store.NewKVStoreWithCodec[store.JSONValue](store.JSONValueCodec{})
+	doc, _ := store.NewJSONValue(`{"name": "Alice", "age": 30}`)
+	docs.Put("alice", doc)
+	if val, exists := docs.Get("alice"); exists {
+		fmt.Printf("  alice = %s\n", val)
+	}
+	fmt.Println()
+
 	fmt.Println("=== All Tests Complete ===")
 }
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"workshop/practice/simulate/kv_store/store"
+)
+
+// NewAdminHandler serves operators' views of kv over HTTP:
+//
+//	GET /metrics      metrics in the Prometheus text format (see KVStore.WriteMetrics)
+//	GET /debug/state  the data and checkpoint count, as KVStore.Print shows them
+//	GET /healthz      200 "ok", or 503 with the error once the store has stopped (see KVStore.Err)
+//
+// Call kv.EnableMetrics first for the latency histograms.
+func NewAdminHandler[V comparable](kv *store.KVStore[V]) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		kv.WriteMetrics(w)
+	})
+	mux.HandleFunc("GET /debug/state", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		kv.PrintTo(w)
+	})
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		if err := kv.Err(); err != nil {
+			http.Error(w, fmt.Sprintf("store stopped: %v", err), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	return mux
+}
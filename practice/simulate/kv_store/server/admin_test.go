@@ -0,0 +1,73 @@
+package server
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"workshop/practice/simulate/kv_store/store"
+)
+
+// TestAdminHandler tests the admin endpoints
+func TestAdminHandler(t *testing.T) {
+	kv := store.NewKVStore[string]()
+	kv.EnableMetrics()
+	kv.Put("name", "Alice")
+	kv.Checkpoint("")
+	srv := httptest.NewServer(NewAdminHandler(kv))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		method, path string
+		status       int
+		contains     []string
+	}{
+		{"GET", "/metrics", 200, []string{"kv_keys 1\n", "kv_checkpoint_depth 1\n", `kv_operation_duration_seconds_count{op="put"} 1`}},
+		{"GET", "/debug/state", 200, []string{"Current State:\n  name: Alice\nCheckpoints: 1\n"}},
+		{"GET", "/healthz", 200, []string{"ok\n"}},
+		{"POST", "/metrics", 405, nil},
+		{"GET", "/nope", 404, nil},
+	} {
+		req, _ := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", tt.method, tt.path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("Expected %s %s to answer %d, got %d", tt.method, tt.path, tt.status, resp.StatusCode)
+		}
+		for _, want := range tt.contains {
+			if !strings.Contains(string(body), want) {
+				t.Errorf("Expected %s %s to contain %q, got:\n%s", tt.method, tt.path, want, body)
+			}
+		}
+	}
+}
+
+// TestAdminHealthzStopped tests that /healthz fails once the store has stopped
+func TestAdminHealthzStopped(t *testing.T) {
+	kv, err := store.OpenKVStore[float64](filepath.Join(t.TempDir(), "store.json"), store.WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenKVStore failed: %v", err)
+	}
+	defer kv.Close()
+	kv.Put("x", math.NaN()) // JSON can't encode it, so it can't be logged
+	srv := httptest.NewServer(NewAdminHandler(kv))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/healthz")
+	if err != nil {
+		t.Fatalf("GET /healthz failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || !strings.HasPrefix(string(body), "store stopped: ") {
+		t.Errorf("Expected a 503 saying the store stopped, got %d %s", resp.StatusCode, body)
+	}
+}
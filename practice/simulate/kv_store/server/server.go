@@ -0,0 +1,381 @@
+// Package server exposes a KVStore over TCP using a subset of the Redis
+// protocol (RESP2), so redis-cli and Redis client libraries can talk to it
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"workshop/practice/simulate/kv_store/store"
+)
+
+// ErrServerClosed is returned by Serve after Shutdown
+var ErrServerClosed = errors.New("server closed")
+
+// Server serves one KVStore to any number of concurrent connections. The
+// store's own lock makes every command atomic; the server adds no locking of
+// its own around the data.
+type Server struct {
+	kv        *store.KVStore[string]
+	snapshot  string                       // File written by Shutdown ("" = don't save)
+	normalize func(string) (string, error) // Validates SET and COUNT values
+
+	mu       sync.Mutex // Protects the fields below
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closing  bool
+	wg       sync.WaitGroup // Running connection handlers
+}
+
+// New creates a server for kv. If snapshotPath is not empty, Shutdown saves
+// the store to it once the last connection has finished.
+func New(kv *store.KVStore[string], snapshotPath string) *Server {
+	return &Server{
+		kv:        kv,
+		snapshot:  snapshotPath,
+		normalize: func(s string) (string, error) { return s, nil },
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// SetValueNormalizer sets the function SET and COUNT pass values through
+// before they reach the store, e.g. to reject non-numbers in an int store.
+// An error is sent back to the client as an ERR reply. Call it before Serve.
+func (s *Server) SetValueNormalizer(normalize func(string) (string, error)) {
+	s.normalize = normalize
+}
+
+// ListenAndServe listens on the TCP address addr and calls Serve
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts connections on ln, handling each in its own goroutine, until
+// Shutdown is called. It always returns a non-nil error (ErrServerClosed
+// after Shutdown).
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	s.listener = ln
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closing := s.closing
+			s.mu.Unlock()
+			if closing {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closing {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.serveConn(conn)
+	}
+}
+
+// Shutdown stops accepting connections, lets every connection finish the
+// commands it has already received, then saves the store. If ctx ends first,
+// the remaining connections are closed forcibly (the store is still saved)
+// and ctx's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	// Wake connections blocked waiting for their next request; a handler in
+	// the middle of a command finishes it (and any pipelined requests already
+	// buffered) first
+	for conn := range s.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		s.mu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+		<-done
+	}
+
+	if s.snapshot != "" {
+		if saveErr := s.kv.SaveToDisk(s.snapshot); saveErr != nil {
+			return fmt.Errorf("failed to save store: %w", saveErr)
+		}
+	}
+	return err
+}
+
+// serveConn reads and executes requests from one client until it quits or
+// disconnects
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				writeError(w, "ERR "+err.Error())
+				w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		quit := s.execute(w, args)
+
+		// Only flush once every pipelined request we already have is
+		// answered, so a pipeline gets its replies in as few writes as
+		// possible
+		if quit || r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// command describes one supported command. arity counts the command name
+// itself; a negative arity -N means at least N.
+type command struct {
+	arity int
+	run   func(s *Server, w *bufio.Writer, args []string)
+}
+
+var commands = map[string]command{
+	"PING":       {-1, (*Server).ping},
+	"ECHO":       {2, (*Server).echo},
+	"GET":        {2, (*Server).get},
+	"SET":        {-3, (*Server).set},
+	"DEL":        {-2, (*Server).del},
+	"EXISTS":     {-2, (*Server).exists},
+	"COUNT":      {2, (*Server).count},
+	"CHECKPOINT": {-1, (*Server).checkpoint},
+	"REVERT":     {-1, (*Server).revert},
+	"COMMAND":    {-1, (*Server).command},
+}
+
+// execute runs one request and writes its reply. It returns true if the
+// client asked to close the connection.
+func (s *Server) execute(w *bufio.Writer, args []string) bool {
+	name := strings.ToUpper(args[0])
+	if name == "QUIT" {
+		writeSimple(w, "OK")
+		return true
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		writeError(w, fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return false
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		writeError(w, fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+		return false
+	}
+	cmd.run(s, w, args)
+	return false
+}
+
+func (s *Server) ping(w *bufio.Writer, args []string) {
+	switch len(args) {
+	case 1:
+		writeSimple(w, "PONG")
+	case 2:
+		writeBulk(w, args[1])
+	default:
+		writeError(w, "ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+func (s *Server) echo(w *bufio.Writer, args []string) {
+	writeBulk(w, args[1])
+}
+
+func (s *Server) get(w *bufio.Writer, args []string) {
+	if value, exists := s.kv.Get(args[1]); exists {
+		writeBulk(w, value)
+	} else {
+		writeNull(w)
+	}
+}
+
+// set handles SET key value [EX seconds | PX milliseconds]
+func (s *Server) set(w *bufio.Writer, args []string) {
+	value, err := s.normalize(args[2])
+	if err != nil {
+		writeError(w, "ERR "+err.Error())
+		return
+	}
+
+	var ttl time.Duration
+	opts := args[3:]
+	for len(opts) > 0 {
+		unit := time.Duration(0)
+		switch strings.ToUpper(opts[0]) {
+		case "EX":
+			unit = time.Second
+		case "PX":
+			unit = time.Millisecond
+		}
+		if unit == 0 || ttl != 0 || len(opts) < 2 {
+			writeError(w, "ERR syntax error")
+			return
+		}
+		n, err := strconv.ParseInt(opts[1], 10, 64)
+		if err != nil || n <= 0 {
+			writeError(w, "ERR invalid expire time in 'set' command")
+			return
+		}
+		ttl = time.Duration(n) * unit
+		opts = opts[2:]
+	}
+
+	s.kv.PutWithTTL(args[1], value, ttl) // ttl 0 is a plain Put
+	if err := s.kv.Err(); err != nil {
+		writeError(w, "ERR "+err.Error())
+		return
+	}
+	writeSimple(w, "OK")
+}
+
+func (s *Server) del(w *bufio.Writer, args []string) {
+	deleted := 0
+	for _, key := range args[1:] {
+		if s.kv.Delete(key) {
+			deleted++
+		}
+	}
+	if err := s.kv.Err(); err != nil {
+		writeError(w, "ERR "+err.Error())
+		return
+	}
+	writeInt(w, deleted)
+}
+
+func (s *Server) exists(w *bufio.Writer, args []string) {
+	found := 0
+	for _, key := range args[1:] {
+		if _, exists := s.kv.Get(key); exists {
+			found++
+		}
+	}
+	writeInt(w, found)
+}
+
+// count handles COUNT value, replying with the number of keys holding value
+func (s *Server) count(w *bufio.Writer, args []string) {
+	value, err := s.normalize(args[1])
+	if err != nil {
+		writeError(w, "ERR "+err.Error())
+		return
+	}
+	writeInt(w, s.kv.CountValue(value))
+}
+
+// checkpoint handles CHECKPOINT [name], replying with the new checkpoint's
+// ID
+func (s *Server) checkpoint(w *bufio.Writer, args []string) {
+	if len(args) > 2 {
+		writeError(w, "ERR wrong number of arguments for 'checkpoint' command")
+		return
+	}
+	name := ""
+	if len(args) == 2 {
+		name = args[1]
+	}
+	id := s.kv.Checkpoint(name)
+	if id == 0 {
+		writeError(w, fmt.Sprintf("ERR failed to log checkpoint: %v", s.kv.Err()))
+		return
+	}
+	writeInt(w, int(id))
+}
+
+// revert handles REVERT [name | id]: the last checkpoint, or the given one
+// and everything after it
+func (s *Server) revert(w *bufio.Writer, args []string) {
+	var err error
+	switch len(args) {
+	case 1:
+		err = s.kv.Revert()
+	case 2:
+		id, found := s.kv.FindCheckpoint(args[1])
+		if !found {
+			writeError(w, fmt.Sprintf("ERR no such checkpoint '%s'", args[1]))
+			return
+		}
+		err = s.kv.RevertTo(id)
+	default:
+		writeError(w, "ERR wrong number of arguments for 'revert' command")
+		return
+	}
+	if err != nil {
+		writeError(w, "ERR "+err.Error())
+		return
+	}
+	writeSimple(w, "OK")
+}
+
+// command handles COMMAND, which redis-cli sends on connect to fetch
+// command docs for hints; an empty reply makes it fall back gracefully
+func (s *Server) command(w *bufio.Writer, args []string) {
+	writeArrayHeader(w, 0)
+}
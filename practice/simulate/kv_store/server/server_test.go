@@ -0,0 +1,284 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"workshop/practice/simulate/kv_store/store"
+)
+
+// startServer runs a server for kv on a random local port
+func startServer(t *testing.T, kv *store.KVStore[string], snapshot string) (*Server, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	srv := New(kv, snapshot)
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+	return srv, ln.Addr().String()
+}
+
+// client is a minimal RESP client for tests
+type client struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, addr string) *client {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &client{conn: conn, r: bufio.NewReader(conn)}
+}
+
+// encode formats args as a RESP array of bulk strings
+func encode(args ...string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return b.String()
+}
+
+// do sends one command and returns its reply in a readable form: "+OK",
+// "-ERR ...", ":3", the bulk string itself, or "(nil)"
+func (c *client) do(t *testing.T, args ...string) string {
+	t.Helper()
+	if _, err := c.conn.Write([]byte(encode(args...))); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	return c.reply(t)
+}
+
+func (c *client) reply(t *testing.T) string {
+	t.Helper()
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line[0] != '$' {
+		return line
+	}
+	size, _ := strconv.Atoi(line[1:])
+	if size < 0 {
+		return "(nil)"
+	}
+	buf := make([]byte, size+2)
+	if _, err := io.ReadFull(c.r, buf); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	return string(buf[:size])
+}
+
+// TestCommands tests each supported command
+func TestCommands(t *testing.T) {
+	kv := store.NewKVStore[string]()
+	_, addr := startServer(t, kv, "")
+	c := dial(t, addr)
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"PING"}, "+PONG"},
+		{[]string{"ping", "hi"}, "hi"},
+		{[]string{"GET", "name"}, "(nil)"},
+		{[]string{"SET", "name", "Alice"}, "+OK"},
+		{[]string{"set", "other", "Alice"}, "+OK"},
+		{[]string{"GET", "name"}, "Alice"},
+		{[]string{"SET", "msg", "hello\r\nworld"}, "+OK"},
+		{[]string{"GET", "msg"}, "hello\r\nworld"},
+		{[]string{"COUNT", "Alice"}, ":2"},
+		{[]string{"EXISTS", "name", "nope", "other"}, ":2"},
+		{[]string{"CHECKPOINT"}, ":1"},
+		{[]string{"DEL", "name", "nope", "other"}, ":2"},
+		{[]string{"COUNT", "Alice"}, ":0"},
+		{[]string{"REVERT"}, "+OK"},
+		{[]string{"COUNT", "Alice"}, ":2"},
+		{[]string{"REVERT"}, "-ERR no checkpoints to revert to"},
+		{[]string{"CHECKPOINT", "before"}, ":2"},
+		{[]string{"SET", "name", "Carol"}, "+OK"},
+		{[]string{"CHECKPOINT"}, ":3"},
+		{[]string{"DEL", "name"}, ":1"},
+		{[]string{"REVERT", "before"}, "+OK"},
+		{[]string{"GET", "name"}, "Alice"},
+		{[]string{"REVERT", "before"}, "-ERR no such checkpoint 'before'"},
+		{[]string{"GET"}, "-ERR wrong number of arguments for 'get' command"},
+		{[]string{"FLUSHALL"}, "-ERR unknown command 'FLUSHALL'"},
+		{[]string{"SET", "k", "v", "EX"}, "-ERR syntax error"},
+		{[]string{"SET", "k", "v", "EX", "0"}, "-ERR invalid expire time in 'set' command"},
+		{[]string{"SET", "k", "v", "PX", "60000"}, "+OK"},
+		{[]string{"COMMAND", "DOCS"}, "*0"},
+	}
+	for _, tt := range tests {
+		if got := c.do(t, tt.args...); got != tt.want {
+			t.Errorf("%v: expected %q, got %q", tt.args, tt.want, got)
+		}
+	}
+
+	if _, ok := kv.TTL("k"); !ok {
+		t.Error("Expected SET PX to give the key a TTL")
+	}
+	if got := c.do(t, "QUIT"); got != "+OK" {
+		t.Errorf("Expected +OK from QUIT, got %q", got)
+	}
+	if _, err := c.r.ReadByte(); err == nil {
+		t.Error("Expected the connection to be closed after QUIT")
+	}
+}
+
+// TestInlineAndProtocolErrors tests inline commands and malformed requests
+func TestInlineAndProtocolErrors(t *testing.T) {
+	kv := store.NewKVStore[string]()
+	_, addr := startServer(t, kv, "")
+
+	c := dial(t, addr)
+	c.conn.Write([]byte("SET name Bob\r\n\r\nGET name\n"))
+	if got := c.reply(t); got != "+OK" {
+		t.Errorf("Expected +OK, got %q", got)
+	}
+	if got := c.reply(t); got != "Bob" {
+		t.Errorf("Expected Bob, got %q", got)
+	}
+
+	c = dial(t, addr)
+	c.conn.Write([]byte("*1\r\n:5\r\n"))
+	if got := c.reply(t); !strings.HasPrefix(got, "-ERR Protocol error") {
+		t.Errorf("Expected protocol error, got %q", got)
+	}
+	if _, err := c.r.ReadByte(); err == nil {
+		t.Error("Expected the connection to be closed after a protocol error")
+	}
+}
+
+// TestValueNormalizer tests that SET and COUNT values are validated
+func TestValueNormalizer(t *testing.T) {
+	kv := store.NewKVStore[string]()
+	srv, addr := startServer(t, kv, "")
+	srv.SetValueNormalizer(func(s string) (string, error) {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return "", fmt.Errorf("value is not an integer: %s", s)
+		}
+		return strconv.Itoa(n), nil
+	})
+	c := dial(t, addr)
+
+	if got := c.do(t, "SET", "a", "abc"); got != "-ERR value is not an integer: abc" {
+		t.Errorf("Expected validation error, got %q", got)
+	}
+	if got := c.do(t, "SET", "a", "007"); got != "+OK" {
+		t.Errorf("Expected +OK, got %q", got)
+	}
+	if got := c.do(t, "COUNT", "7"); got != ":1" {
+		t.Errorf("Expected normalized count 1, got %q", got)
+	}
+}
+
+// TestPipelining tests many requests sent before reading any reply
+func TestPipelining(t *testing.T) {
+	kv := store.NewKVStore[string]()
+	_, addr := startServer(t, kv, "")
+	c := dial(t, addr)
+
+	const n = 1000
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		b.WriteString(encode("SET", fmt.Sprintf("key%d", i), strconv.Itoa(i)))
+		b.WriteString(encode("GET", fmt.Sprintf("key%d", i)))
+	}
+	go c.conn.Write([]byte(b.String()))
+
+	for i := 0; i < n; i++ {
+		if got := c.reply(t); got != "+OK" {
+			t.Fatalf("Request %d: expected +OK, got %q", i, got)
+		}
+		if got := c.reply(t); got != strconv.Itoa(i) {
+			t.Fatalf("Request %d: expected %d, got %q", i, i, got)
+		}
+	}
+}
+
+// TestConcurrentClients tests many connections writing at once
+func TestConcurrentClients(t *testing.T) {
+	kv := store.NewKVStore[string]()
+	_, addr := startServer(t, kv, "")
+
+	var wg sync.WaitGroup
+	clients, writes := 10, 100
+	for i := 0; i < clients; i++ {
+		c := dial(t, addr)
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				if got := c.do(t, "SET", fmt.Sprintf("c%d_%d", id, j), "x"); got != "+OK" {
+					t.Errorf("Expected +OK, got %q", got)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if kv.CountValue("x") != clients*writes {
+		t.Errorf("Expected %d keys, got %d", clients*writes, kv.CountValue("x"))
+	}
+}
+
+// TestShutdown tests that Shutdown drains connections and saves the store
+func TestShutdown(t *testing.T) {
+	kv := store.NewKVStore[string]()
+	snapshot := filepath.Join(t.TempDir(), "store.json")
+	srv, addr := startServer(t, kv, snapshot)
+
+	c := dial(t, addr)
+	c.do(t, "SET", "name", "Alice")
+
+	// An idle connection must not hold up shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if _, err := c.r.ReadByte(); err == nil {
+		t.Error("Expected the connection to be closed by Shutdown")
+	}
+	if _, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		t.Error("Expected new connections to be refused after Shutdown")
+	}
+
+	loaded := store.NewKVStore[string]()
+	if err := loaded.LoadFromDisk(snapshot); err != nil {
+		t.Fatalf("LoadFromDisk failed: %v", err)
+	}
+	if v, _ := loaded.Get("name"); v != "Alice" {
+		t.Errorf("Expected saved name=Alice, got %q", v)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	if err := srv.Serve(ln); !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Expected ErrServerClosed from Serve after Shutdown, got %v", err)
+	}
+}
@@ -0,0 +1,386 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// SnapshotFormat selects how SaveToDiskAs lays out a snapshot file.
+// LoadFromDisk detects the format from the file's contents.
+type SnapshotFormat int
+
+const (
+	FormatJSON       SnapshotFormat = iota // Checksummed, indented JSON (see snapshotFile)
+	FormatBinary                           // Length-prefixed binary with a SHA-256 trailer
+	FormatBinaryGzip                       // FormatBinary compressed with gzip
+)
+
+func (f SnapshotFormat) String() string {
+	switch f {
+	case FormatJSON:
+		return "json"
+	case FormatBinary:
+		return "binary"
+	case FormatBinaryGzip:
+		return "gzip"
+	}
+	return "unknown"
+}
+
+// ParseSnapshotFormat parses "json", "binary" or "gzip"
+func ParseSnapshotFormat(name string) (SnapshotFormat, error) {
+	switch name {
+	case "json":
+		return FormatJSON, nil
+	case "binary", "bin":
+		return FormatBinary, nil
+	case "gzip", "gz":
+		return FormatBinaryGzip, nil
+	default:
+		return 0, fmt.Errorf("unknown snapshot format %q (want json, binary or gzip)", name)
+	}
+}
+
+// FormatForFile picks the format SaveToDisk uses for filename: FormatBinary
+// for ".kvs", FormatBinaryGzip for ".kvs.gz" or ".gz", FormatJSON otherwise
+func FormatForFile(filename string) SnapshotFormat {
+	switch {
+	case strings.HasSuffix(filename, ".gz"):
+		return FormatBinaryGzip
+	case strings.HasSuffix(filename, ".kvs"):
+		return FormatBinary
+	default:
+		return FormatJSON
+	}
+}
+
+// ConvertSnapshot rewrites the snapshot file src as dst in format, keeping
+// values in their encoded form, so it works without knowing the value type
+func ConvertSnapshot(src, dst string, format SnapshotFormat) error {
+	content, err := os.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	state, err := decodeSnapshot(content)
+	if err != nil {
+		return err
+	}
+	if state.ValueCount == nil && format == FormatJSON {
+		// Binary files don't store the counts; JSON files always have,
+		// keyed by the encoded value
+		state.ValueCount = make(map[string]int)
+		for _, raw := range state.Data {
+			state.ValueCount[string(raw)]++
+		}
+	}
+	if content, err = encodeSnapshot(state, format); err != nil {
+		return err
+	}
+	return WriteFileAtomic(dst, content)
+}
+
+// The binary format is
+//
+//	magic "KVSB", format version (uvarint)
+//	walSeq, lastCheckpointID (uvarint)
+//	data:        count, then key, value per entry (in key order)
+//	expiry:      count, then key, deadline per entry
+//	checkpoints: count, then per checkpoint
+//	             ID, name, created,
+//	             ChangedKeys (count, then key, old value?),
+//	             DeletedKeys (count, then key, old value)
+//	tracking:    count, then key, old value? per entry
+//	SHA-256 of everything above (32 bytes)
+//
+// Counts are uvarints; keys, names and values are a uvarint length followed
+// by the bytes (values as encoded by the store's codec); times are varint
+// Unix nanoseconds (0 for the zero time); "old value?" is a 0 byte for a key
+// that didn't exist or a 1 byte followed by the value. valueCount is not
+// stored: LoadFromDisk rebuilds it from data.
+var binaryMagic = []byte("KVSB")
+
+const binaryVersion = 1
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// encodeBinarySnapshot lays out state in the binary format
+func encodeBinarySnapshot(state *diskState) []byte {
+	size := len(binaryMagic) + sha256.Size
+	for key, raw := range state.Data {
+		size += len(key) + len(raw) + 4
+	}
+	w := binaryWriter{buf: make([]byte, 0, size)}
+
+	w.buf = append(w.buf, binaryMagic...)
+	w.uvarint(binaryVersion)
+	w.uvarint(state.WALSeq)
+	w.uvarint(state.LastCheckpointID)
+
+	// In key order when known, so loading can skip sorting the index
+	w.uvarint(uint64(len(state.Data)))
+	if len(state.keys) == len(state.Data) {
+		for _, key := range state.keys {
+			w.bytes([]byte(key))
+			w.bytes(state.Data[key])
+		}
+	} else {
+		for key, raw := range state.Data {
+			w.bytes([]byte(key))
+			w.bytes(raw)
+		}
+	}
+	w.uvarint(uint64(len(state.Expiry)))
+	for key, deadline := range state.Expiry {
+		w.bytes([]byte(key))
+		w.time(deadline)
+	}
+
+	w.uvarint(uint64(len(state.Checkpoints)))
+	for _, delta := range state.Checkpoints {
+		if delta == nil {
+			delta = &diskDelta{}
+		}
+		w.uvarint(delta.ID)
+		w.bytes([]byte(delta.Name))
+		w.time(delta.Created)
+		w.oldValues(delta.ChangedKeys)
+		w.uvarint(uint64(len(delta.DeletedKeys)))
+		for key, raw := range delta.DeletedKeys {
+			w.bytes([]byte(key))
+			w.bytes(raw)
+		}
+	}
+	w.oldValues(state.Tracking)
+
+	sum := sha256.Sum256(w.buf)
+	return append(w.buf, sum[:]...)
+}
+
+// decodeBinarySnapshot is the inverse of encodeBinarySnapshot, verifying the
+// checksum before anything else
+func decodeBinarySnapshot(content []byte) (*diskState, error) {
+	if len(content) < len(binaryMagic)+sha256.Size {
+		return nil, fmt.Errorf("failed to decode binary snapshot: %w", io.ErrUnexpectedEOF)
+	}
+	body, trailer := content[:len(content)-sha256.Size], content[len(content)-sha256.Size:]
+	if sum := sha256.Sum256(body); !bytes.Equal(sum[:], trailer) {
+		return nil, fmt.Errorf("%w: binary snapshot", ErrChecksumMismatch)
+	}
+
+	r := binaryReader{buf: body[len(binaryMagic):]}
+	if version := r.uvarint(); r.err == nil && version != binaryVersion {
+		return nil, fmt.Errorf("%w: binary %d", ErrUnsupportedVersion, version)
+	}
+	state := &diskState{
+		WALSeq:           r.uvarint(),
+		LastCheckpointID: r.uvarint(),
+	}
+
+	n := r.count()
+	state.Data = make(map[string]json.RawMessage, n)
+	state.keys = make([]string, 0, n)
+	for range n {
+		key := r.string()
+		state.Data[key] = r.bytes()
+		state.keys = append(state.keys, key)
+	}
+	n = r.count()
+	state.Expiry = make(map[string]time.Time, n)
+	for range n {
+		key := r.string()
+		state.Expiry[key] = r.time()
+	}
+
+	n = r.count()
+	state.Checkpoints = make([]*diskDelta, 0, n)
+	for range n {
+		delta := &diskDelta{
+			ID:      r.uvarint(),
+			Name:    r.string(),
+			Created: r.time(),
+		}
+		delta.ChangedKeys = r.oldValues()
+		m := r.count()
+		delta.DeletedKeys = make(map[string]json.RawMessage, m)
+		for range m {
+			key := r.string()
+			delta.DeletedKeys[key] = r.bytes()
+		}
+		state.Checkpoints = append(state.Checkpoints, delta)
+	}
+	state.Tracking = r.oldValues()
+
+	if r.err == nil && len(r.buf) > 0 {
+		r.err = fmt.Errorf("%d trailing bytes", len(r.buf))
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("failed to decode binary snapshot: %w", r.err)
+	}
+	return state, nil
+}
+
+// gzipSnapshot compresses content, favouring speed: snapshots are rewritten
+// on every save and Compact
+func gzipSnapshot(content []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(content); err != nil {
+		return nil, fmt.Errorf("failed to compress snapshot: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress snapshot: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// gunzipSnapshot is the inverse of gzipSnapshot
+func gunzipSnapshot(content []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress snapshot: %w", err)
+	}
+	defer zr.Close()
+	decompressed, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress snapshot: %w", err)
+	}
+	return decompressed, nil
+}
+
+// binaryWriter appends binary format fields to buf
+type binaryWriter struct {
+	buf []byte
+}
+
+func (w *binaryWriter) uvarint(v uint64) {
+	w.buf = binary.AppendUvarint(w.buf, v)
+}
+
+func (w *binaryWriter) bytes(b []byte) {
+	w.uvarint(uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *binaryWriter) time(t time.Time) {
+	if t.IsZero() {
+		w.buf = binary.AppendVarint(w.buf, 0)
+		return
+	}
+	w.buf = binary.AppendVarint(w.buf, t.UnixNano())
+}
+
+func (w *binaryWriter) oldValues(values map[string]*json.RawMessage) {
+	w.uvarint(uint64(len(values)))
+	for key, raw := range values {
+		w.bytes([]byte(key))
+		if raw == nil {
+			w.buf = append(w.buf, 0)
+			continue
+		}
+		w.buf = append(w.buf, 1)
+		w.bytes(*raw)
+	}
+}
+
+// binaryReader consumes binary format fields from buf. The first error
+// sticks: later reads return zero values, so decoding checks err once at
+// the end.
+type binaryReader struct {
+	buf []byte
+	err error
+}
+
+func (r *binaryReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *binaryReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+// count reads an entry count. Every entry takes at least one byte, so a
+// count larger than what's left is corruption (and must not be used to size
+// an allocation).
+func (r *binaryReader) count() int {
+	n := r.uvarint()
+	if n > uint64(len(r.buf)) {
+		if r.err == nil {
+			r.err = io.ErrUnexpectedEOF
+		}
+		return 0
+	}
+	return int(n)
+}
+
+// bytes reads a length-prefixed field; the result aliases buf
+func (r *binaryReader) bytes() []byte {
+	n := r.count()
+	if r.err != nil {
+		return nil
+	}
+	b := r.buf[:n:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *binaryReader) string() string {
+	return string(r.bytes())
+}
+
+func (r *binaryReader) time() time.Time {
+	nanos := r.varint()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+func (r *binaryReader) oldValues() map[string]*json.RawMessage {
+	n := r.count()
+	values := make(map[string]*json.RawMessage, n)
+	for range n {
+		key := r.string()
+		if r.err != nil || len(r.buf) == 0 {
+			r.err = io.ErrUnexpectedEOF
+			return values
+		}
+		present := r.buf[0]
+		r.buf = r.buf[1:]
+		if present == 0 {
+			values[key] = nil
+			continue
+		}
+		raw := json.RawMessage(r.bytes())
+		values[key] = &raw
+	}
+	return values
+}
@@ -0,0 +1,229 @@
+package store
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// snapshotFixture builds a store exercising every part of the file format:
+// data, expiry, checkpoints with changed, created and deleted keys, and
+// tracking
+func snapshotFixture() *KVStore[int] {
+	clock := newFakeClock()
+	kv := NewKVStore[int]()
+	kv.now = clock.Now
+
+	kv.Put("a", 1)
+	kv.Put("b", 2)
+	kv.Put("gone", 3)
+	kv.Checkpoint("base")
+	kv.Put("a", 10)
+	kv.Put("new", 4)
+	kv.Delete("gone")
+	kv.Checkpoint("")
+	kv.Put("b", 20)
+	kv.PutWithTTL("session", 5, time.Hour)
+	return kv
+}
+
+// TestBinarySnapshot tests that every format round-trips the full state
+func TestBinarySnapshot(t *testing.T) {
+	dir := t.TempDir()
+	kv := snapshotFixture()
+	wantData, wantCounts := kv.GetAllData()
+	wantCheckpoints := kv.ListCheckpoints()
+
+	for _, format := range []SnapshotFormat{FormatJSON, FormatBinary, FormatBinaryGzip} {
+		filename := filepath.Join(dir, "store."+format.String())
+		if err := kv.SaveToDiskAs(filename, format); err != nil {
+			t.Fatalf("%s: SaveToDiskAs failed: %v", format, err)
+		}
+
+		loaded := NewKVStore[int]()
+		loaded.now = kv.now
+		if err := loaded.LoadFromDisk(filename); err != nil {
+			t.Fatalf("%s: LoadFromDisk failed: %v", format, err)
+		}
+		data, counts := loaded.GetAllData()
+		if !maps.Equal(data, wantData) || !maps.Equal(counts, wantCounts) {
+			t.Errorf("%s: expected %v %v, got %v %v", format, wantData, wantCounts, data, counts)
+		}
+		checkpoints := loaded.ListCheckpoints()
+		if len(checkpoints) != len(wantCheckpoints) {
+			t.Fatalf("%s: expected checkpoints %+v, got %+v", format, wantCheckpoints, checkpoints)
+		}
+		for i, cp := range checkpoints {
+			want := wantCheckpoints[i]
+			if cp.ID != want.ID || cp.Name != want.Name || !cp.Created.Equal(want.Created) || cp.ChangedKeys != want.ChangedKeys {
+				t.Errorf("%s: expected checkpoint %+v, got %+v", format, want, cp)
+			}
+		}
+		// The index is rebuilt in order (binary files skip the sort)
+		if keys := collectKeys(loaded.Scan("", "")); !slices.Equal(keys, []string{"a", "b", "new", "session"}) {
+			t.Errorf("%s: expected keys in order, got %v", format, keys)
+		}
+		if keys := collectKeys(loaded.ScanReverse("", "")); !slices.Equal(keys, []string{"session", "new", "b", "a"}) {
+			t.Errorf("%s: expected keys in reverse order, got %v", format, keys)
+		}
+		if remaining, ok := loaded.TTL("session"); !ok || remaining != time.Hour {
+			t.Errorf("%s: expected session to expire in 1h, got %v (ok=%v)", format, remaining, ok)
+		}
+
+		// The deltas work after loading
+		loaded.Revert()
+		loaded.Revert()
+		data, _ = loaded.GetAllData()
+		if want := map[string]int{"a": 1, "b": 2, "gone": 3}; !maps.Equal(data, want) {
+			t.Errorf("%s: expected %v after reverting, got %v", format, want, data)
+		}
+	}
+}
+
+// TestBinarySnapshotCorruption tests that damaged binary files are rejected
+func TestBinarySnapshotCorruption(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "store.kvs")
+	snapshotFixture().SaveToDisk(filename)
+	content, _ := os.ReadFile(filename)
+	if FormatForFile(filename) != FormatBinary || string(content[:4]) != "KVSB" {
+		t.Fatalf("Expected a binary file for .kvs, got %q", content[:4])
+	}
+
+	flipped := append([]byte(nil), content...)
+	flipped[len(flipped)/2] ^= 0xff
+	tests := []struct {
+		name    string
+		content []byte
+		want    error
+	}{
+		{"flipped", flipped, ErrChecksumMismatch},
+		{"truncated", content[:len(content)-1], ErrChecksumMismatch},
+		{"short", content[:10], nil},
+		{"bad gzip", []byte{0x1f, 0x8b, 0, 0}, nil},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, "bad.kvs")
+		os.WriteFile(path, tt.content, 0644)
+		err := NewKVStore[int]().LoadFromDisk(path)
+		if err == nil || (tt.want != nil && !errors.Is(err, tt.want)) {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.want, err)
+		}
+	}
+
+	// A well-formed checksum over a bogus body still fails cleanly
+	w := binaryWriter{buf: append([]byte(nil), binaryMagic...)}
+	w.uvarint(binaryVersion)
+	w.uvarint(0)
+	w.uvarint(0)
+	w.uvarint(1 << 40) // Entry count far beyond the file
+	if _, err := decodeBinarySnapshot(withChecksum(w.buf)); err == nil {
+		t.Error("Expected an error for an impossible entry count")
+	}
+}
+
+// withChecksum appends the binary format's SHA-256 trailer
+func withChecksum(body []byte) []byte {
+	sum := sha256.Sum256(body)
+	return append(body, sum[:]...)
+}
+
+// TestConvertSnapshot tests converting between formats without a value type
+func TestConvertSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	kv := snapshotFixture()
+	wantData, _ := kv.GetAllData()
+
+	jsonFile := filepath.Join(dir, "store.json")
+	binFile := filepath.Join(dir, "store.kvs.gz")
+	backFile := filepath.Join(dir, "back.json")
+	kv.SaveToDisk(jsonFile)
+	if err := ConvertSnapshot(jsonFile, binFile, FormatForFile(binFile)); err != nil {
+		t.Fatalf("ConvertSnapshot to gzip failed: %v", err)
+	}
+	if err := ConvertSnapshot(binFile, backFile, FormatJSON); err != nil {
+		t.Fatalf("ConvertSnapshot to json failed: %v", err)
+	}
+
+	for _, filename := range []string{binFile, backFile} {
+		loaded := NewKVStore[int]()
+		// No ErrInconsistentState: the JSON file gets its counts back
+		if err := loaded.LoadFromDisk(filename); err != nil {
+			t.Fatalf("LoadFromDisk(%s) failed: %v", filename, err)
+		}
+		if data, _ := loaded.GetAllData(); !maps.Equal(data, wantData) {
+			t.Errorf("%s: expected %v, got %v", filename, wantData, data)
+		}
+		if loaded.GetCheckpointCount() != 2 {
+			t.Errorf("%s: expected 2 checkpoints, got %d", filename, loaded.GetCheckpointCount())
+		}
+	}
+
+	if err := ConvertSnapshot(filepath.Join(dir, "missing.json"), backFile, FormatJSON); err == nil {
+		t.Error("Expected an error converting a missing file")
+	}
+}
+
+// TestParseSnapshotFormat tests format names and extensions
+func TestParseSnapshotFormat(t *testing.T) {
+	for name, want := range map[string]SnapshotFormat{"json": FormatJSON, "binary": FormatBinary, "gzip": FormatBinaryGzip} {
+		if got, err := ParseSnapshotFormat(name); err != nil || got != want {
+			t.Errorf("Expected %q to parse as %s, got %s (%v)", name, want, got, err)
+		}
+	}
+	if _, err := ParseSnapshotFormat("zstd"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+	for filename, want := range map[string]SnapshotFormat{"a.json": FormatJSON, "a": FormatJSON, "a.kvs": FormatBinary, "a.kvs.gz": FormatBinaryGzip} {
+		if got := FormatForFile(filename); got != want {
+			t.Errorf("Expected %s for %q, got %s", want, filename, got)
+		}
+	}
+}
+
+const benchSnapshotKeys = 1_000_000
+
+// BenchmarkSnapshot compares save and load times and file sizes of each
+// format for a store with 1M keys and a checkpoint covering 10% of them
+func BenchmarkSnapshot(b *testing.B) {
+	kv := NewKVStore[int]()
+	for i := 0; i < benchSnapshotKeys; i++ {
+		kv.Put(fmt.Sprintf("key_%07d", i), i%1000)
+	}
+	kv.Checkpoint("")
+	for i := 0; i < benchSnapshotKeys; i += 10 {
+		kv.Put(fmt.Sprintf("key_%07d", i), -i)
+	}
+	dir := b.TempDir()
+
+	for _, format := range []SnapshotFormat{FormatJSON, FormatBinary, FormatBinaryGzip} {
+		filename := filepath.Join(dir, "bench."+format.String())
+		if err := kv.SaveToDiskAs(filename, format); err != nil {
+			b.Fatal(err)
+		}
+		b.Run("Save/"+format.String(), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := kv.SaveToDiskAs(filename, format); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if info, err := os.Stat(filename); err == nil {
+				b.ReportMetric(float64(info.Size())/(1<<20), "MB")
+			}
+		})
+		b.Run("Load/"+format.String(), func(b *testing.B) {
+			loaded := NewKVStore[int]()
+			for i := 0; i < b.N; i++ {
+				if err := loaded.LoadFromDisk(filename); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
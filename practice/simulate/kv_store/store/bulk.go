@@ -0,0 +1,38 @@
+package store
+
+import "fmt"
+
+// BulkLoad puts every pair as one unit: under a single acquisition of the
+// lock, as a single WAL record, and right after a new checkpoint named name
+// (which may be empty), so Revert undoes the whole load at once. Later pairs
+// win over earlier ones with the same key, and loaded keys lose any TTL.
+//
+// It returns the checkpoint's ID. Nothing is applied if a value can't be
+// encoded or the batch can't be logged.
+func (kv *KVStore[V]) BulkLoad(name string, pairs []KeyValue[V]) (CheckpointID, error) {
+	start := kv.lockOp()
+	defer kv.unlockOp(opBulkLoad, start)
+
+	id, created := kv.lastCheckpointID+1, kv.now()
+	if kv.wal != nil {
+		rec := walRecord{Op: walOpBulk, ID: uint64(id), Name: name, Time: created.UnixNano()}
+		rec.Txn = make([]walRecord, 0, len(pairs))
+		for _, pair := range pairs {
+			encoded, err := kv.codec.Marshal(pair.Value)
+			if err != nil {
+				return 0, fmt.Errorf("failed to encode value of '%s': %w", pair.Key, err)
+			}
+			rec.Txn = append(rec.Txn, walRecord{Op: walOpPut, Key: pair.Key, Value: encoded})
+		}
+		if !kv.logLocked(rec) {
+			return 0, fmt.Errorf("failed to log bulk load: %w", kv.failErr)
+		}
+	}
+
+	kv.checkpointLocked(id, name, created)
+	for _, pair := range pairs {
+		kv.putLocked(pair.Key, pair.Value)
+	}
+	kv.evictLocked()
+	return id, nil
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestBulkLoad tests that a load is applied in full and undone by one
+// revert
+func TestBulkLoad(t *testing.T) {
+	kv := NewKVStore[int]()
+	kv.Put("a", 1)
+	kv.PutWithTTL("b", 2, time.Hour)
+
+	id, err := kv.BulkLoad("import", []KeyValue[int]{{"b", 20}, {"c", 3}, {"d", 3}, {"c", 30}})
+	if err != nil {
+		t.Fatalf("BulkLoad failed: %v", err)
+	}
+	if id != 1 || kv.GetCheckpointCount() != 1 {
+		t.Errorf("Expected checkpoint 1 before the load, got %d (count %d)", id, kv.GetCheckpointCount())
+	}
+	if found, _ := kv.FindCheckpoint("import"); found != id {
+		t.Errorf("Expected the checkpoint to be named 'import'")
+	}
+	for key, want := range map[string]int{"a": 1, "b": 20, "c": 30, "d": 3} {
+		if v, ok := kv.Get(key); !ok || v != want {
+			t.Errorf("Expected %s=%d, got %d (exists=%v)", key, want, v, ok)
+		}
+	}
+	if kv.CountValue(3) != 1 || kv.CountValue(30) != 1 {
+		t.Errorf("Expected value counts to follow the last pair for each key")
+	}
+	if _, ok := kv.TTL("b"); ok {
+		t.Errorf("Expected the loaded b to lose its TTL")
+	}
+
+	if err := kv.Revert(); err != nil {
+		t.Fatalf("Revert failed: %v", err)
+	}
+	data, _ := kv.GetAllData()
+	if len(data) != 2 || data["a"] != 1 || data["b"] != 2 {
+		t.Errorf("Expected revert to undo the whole load, got %v", data)
+	}
+}
+
+// TestBulkLoadWAL tests that a load is logged as one record and replayed
+// with its checkpoint
+func TestBulkLoadWAL(t *testing.T) {
+	snapshot := filepath.Join(t.TempDir(), "store.json")
+	kv, err := OpenKVStore[int](snapshot, WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenKVStore failed: %v", err)
+	}
+	kv.Put("a", 1)
+	if _, err := kv.BulkLoad("", []KeyValue[int]{{"a", 10}, {"b", 2}}); err != nil {
+		t.Fatalf("BulkLoad failed: %v", err)
+	}
+	if kv.walSeq != 2 {
+		t.Errorf("Expected the load to be one record after the put, got seq %d", kv.walSeq)
+	}
+	kv.Close()
+
+	kv, err = OpenKVStore[int](snapshot, WALOptions{})
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer kv.Close()
+	if v, _ := kv.Get("a"); v != 10 {
+		t.Errorf("Expected a=10 after replay, got %d", v)
+	}
+	if kv.GetCheckpointCount() != 1 {
+		t.Errorf("Expected the load's checkpoint after replay, got %d", kv.GetCheckpointCount())
+	}
+	if err := kv.Revert(); err != nil {
+		t.Fatalf("Revert failed: %v", err)
+	}
+	if v, _ := kv.Get("a"); v != 1 {
+		t.Errorf("Expected a=1 after reverting the replayed load, got %d", v)
+	}
+	if _, ok := kv.Get("b"); ok {
+		t.Errorf("Expected b to be gone after reverting the replayed load")
+	}
+}
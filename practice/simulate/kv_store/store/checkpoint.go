@@ -0,0 +1,176 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// ErrCheckpointNotFound is returned for an ID that isn't on the checkpoint
+// stack (it was never created, or has been reverted or released)
+var ErrCheckpointNotFound = errors.New("checkpoint not found")
+
+// CheckpointID identifies a checkpoint. IDs start at 1 and increase; the ID
+// of a reverted or released checkpoint is not handed out again.
+type CheckpointID uint64
+
+// CheckpointInfo describes one checkpoint on the stack
+type CheckpointInfo struct {
+	ID          CheckpointID
+	Name        string
+	Created     time.Time
+	Version     Version // Read the checkpoint's state with GetAt or SnapshotAt (0 if loaded from a snapshot file)
+	ChangedKeys int     // Keys changed between this checkpoint and the next one (or now)
+}
+
+// ListCheckpoints returns the checkpoints from oldest to newest
+func (kv *KVStore[V]) ListCheckpoints() []CheckpointInfo {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	return kv.listCheckpointsLocked()
+}
+
+// listCheckpointsLocked implements ListCheckpoints; the caller must hold mu
+// (read or write)
+func (kv *KVStore[V]) listCheckpointsLocked() []CheckpointInfo {
+	infos := make([]CheckpointInfo, len(kv.checkpoints))
+	for i, delta := range kv.checkpoints {
+		// The changes made after a checkpoint are held by the next delta
+		// up, or by tracking for the newest checkpoint
+		changed := len(kv.tracking)
+		if i+1 < len(kv.checkpoints) {
+			next := kv.checkpoints[i+1]
+			changed = len(next.ChangedKeys) + len(next.DeletedKeys)
+		}
+		infos[i] = CheckpointInfo{ID: delta.ID, Name: delta.Name, Created: delta.Created, Version: delta.Version, ChangedKeys: changed}
+	}
+	return infos
+}
+
+// FindCheckpoint resolves a checkpoint reference: the newest checkpoint with
+// that name, or else the checkpoint whose ID is ref in decimal
+func (kv *KVStore[V]) FindCheckpoint(ref string) (CheckpointID, bool) {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	for i := len(kv.checkpoints) - 1; i >= 0; i-- {
+		if kv.checkpoints[i].Name == ref {
+			return kv.checkpoints[i].ID, true
+		}
+	}
+	if n, err := strconv.ParseUint(ref, 10, 64); err == nil {
+		if id := CheckpointID(n); kv.checkpointIndexLocked(id) >= 0 {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// RevertTo restores the state at checkpoint id in one step, as if Revert
+// were called until that checkpoint was undone: id and every later
+// checkpoint are removed from the stack
+func (kv *KVStore[V]) RevertTo(id CheckpointID) error {
+	start := kv.lockOp()
+	defer kv.unlockOp(opRevert, start)
+
+	i := kv.checkpointIndexLocked(id)
+	if i < 0 {
+		return fmt.Errorf("%w: %d", ErrCheckpointNotFound, id)
+	}
+	if !kv.logLocked(walRecord{Op: walOpRevertTo, ID: uint64(id)}) {
+		return fmt.Errorf("failed to log revert: %w", kv.failErr)
+	}
+	kv.revertToLocked(i)
+	kv.evictLocked()
+	return nil
+}
+
+// revertToLocked reverts until checkpoints[i] is undone; the caller must
+// hold mu
+func (kv *KVStore[V]) revertToLocked(i int) {
+	for len(kv.checkpoints) > i {
+		kv.revertLocked()
+	}
+}
+
+// ReleaseCheckpoint removes checkpoint id from the stack without changing
+// any data. Its delta is squashed into the next one up, so a later revert
+// past it goes straight back to the checkpoint before it.
+func (kv *KVStore[V]) ReleaseCheckpoint(id CheckpointID) error {
+	start := kv.lockOp()
+	defer kv.unlockOp(opRelease, start)
+
+	i := kv.checkpointIndexLocked(id)
+	if i < 0 {
+		return fmt.Errorf("%w: %d", ErrCheckpointNotFound, id)
+	}
+	if !kv.logLocked(walRecord{Op: walOpRelease, ID: uint64(id)}) {
+		return fmt.Errorf("failed to log release: %w", kv.failErr)
+	}
+	kv.releaseLocked(i)
+	return nil
+}
+
+// releaseLocked removes checkpoints[i]; the caller must hold mu
+func (kv *KVStore[V]) releaseLocked(i int) {
+	older := kv.checkpoints[i]
+	kv.checkpoints = slices.Delete(kv.checkpoints, i, i+1)
+
+	if len(kv.checkpoints) == 0 {
+		// Nothing left to revert to
+		kv.tracking = make(map[string]*V)
+		return
+	}
+	if i < len(kv.checkpoints) {
+		mergeDelta(kv.checkpoints[i], older)
+		return
+	}
+
+	// Released the newest checkpoint: tracking now covers both periods, and
+	// where both changed a key the older value is the one to restore
+	for key, oldValue := range older.ChangedKeys {
+		kv.tracking[key] = oldValue
+	}
+	for key, oldValue := range older.DeletedKeys {
+		oldValueCopy := oldValue
+		kv.tracking[key] = &oldValueCopy
+	}
+}
+
+// mergeDelta folds older, the delta of the checkpoint just below newer, into
+// newer. Where both changed a key, the older value wins. A key that was in
+// ChangedKeys at the older checkpoint still exists at the newer one unless
+// newer says otherwise.
+func mergeDelta[V comparable](newer, older *DeltaSnapshot[V]) {
+	for key, oldValue := range older.ChangedKeys {
+		if _, deleted := newer.DeletedKeys[key]; deleted {
+			delete(newer.DeletedKeys, key)
+			if oldValue != nil {
+				newer.DeletedKeys[key] = *oldValue
+			} // else: created and deleted again in between, nothing to undo
+			continue
+		}
+		newer.ChangedKeys[key] = oldValue
+	}
+	for key, oldValue := range older.DeletedKeys {
+		if _, changed := newer.ChangedKeys[key]; changed {
+			oldValueCopy := oldValue
+			newer.ChangedKeys[key] = &oldValueCopy // Deleted, then recreated
+			continue
+		}
+		newer.DeletedKeys[key] = oldValue
+	}
+}
+
+// checkpointIndexLocked returns the stack position of checkpoint id, or -1;
+// the caller must hold mu (read or write)
+func (kv *KVStore[V]) checkpointIndexLocked(id CheckpointID) int {
+	for i, delta := range kv.checkpoints {
+		if delta.ID == id {
+			return i
+		}
+	}
+	return -1
+}
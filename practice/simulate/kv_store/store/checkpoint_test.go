@@ -0,0 +1,264 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestNamedCheckpoints tests IDs, names and ListCheckpoints
+func TestNamedCheckpoints(t *testing.T) {
+	clock := newFakeClock()
+	kv := NewKVStore[int]()
+	kv.now = clock.Now
+
+	first := kv.Checkpoint("first")
+	kv.Put("a", 1)
+	kv.Put("b", 2)
+	clock.Advance(time.Minute)
+	second := kv.Checkpoint("")
+	kv.Put("a", 3)
+	third := kv.Checkpoint("first") // Names need not be unique
+
+	if first != 1 || second != 2 || third != 3 {
+		t.Errorf("Expected IDs 1, 2, 3, got %d, %d, %d", first, second, third)
+	}
+
+	list := kv.ListCheckpoints()
+	if len(list) != 3 {
+		t.Fatalf("Expected 3 checkpoints, got %d", len(list))
+	}
+	if list[0].Name != "first" || list[1].Name != "" || list[0].ChangedKeys != 2 || list[1].ChangedKeys != 1 || list[2].ChangedKeys != 0 {
+		t.Errorf("Unexpected checkpoint list: %+v", list)
+	}
+	if !list[1].Created.Equal(clock.Now()) {
+		t.Errorf("Expected created time %v, got %v", clock.Now(), list[1].Created)
+	}
+
+	if id, ok := kv.FindCheckpoint("first"); !ok || id != third {
+		t.Errorf("Expected newest 'first' to be %d, got %d (found=%v)", third, id, ok)
+	}
+	if id, ok := kv.FindCheckpoint("2"); !ok || id != second {
+		t.Errorf("Expected ID lookup to find %d, got %d (found=%v)", second, id, ok)
+	}
+	if _, ok := kv.FindCheckpoint("9"); ok {
+		t.Error("Expected unknown ID not to be found")
+	}
+
+	// Reverted IDs are not handed out again
+	kv.Revert()
+	if id := kv.Checkpoint(""); id != 4 {
+		t.Errorf("Expected ID 4 after revert, got %d", id)
+	}
+}
+
+// TestRevertTo tests unwinding several checkpoints at once
+func TestRevertTo(t *testing.T) {
+	kv := NewKVStore[int]()
+	kv.Put("a", 1)
+	base := kv.Checkpoint("base")
+	kv.Put("a", 2)
+	kv.Put("b", 2)
+	kv.Checkpoint("")
+	kv.Delete("a")
+	kv.Put("c", 3)
+	kv.Checkpoint("")
+	kv.Put("b", 4)
+
+	if err := kv.RevertTo(base); err != nil {
+		t.Fatalf("RevertTo failed: %v", err)
+	}
+	data, counts := kv.GetAllData()
+	if len(data) != 1 || data["a"] != 1 || len(counts) != 1 || counts[1] != 1 {
+		t.Errorf("Expected only a=1 after RevertTo, got %v %v", data, counts)
+	}
+	if kv.GetCheckpointCount() != 0 {
+		t.Errorf("Expected no checkpoints left, got %d", kv.GetCheckpointCount())
+	}
+
+	if err := kv.RevertTo(base); !errors.Is(err, ErrCheckpointNotFound) {
+		t.Errorf("Expected ErrCheckpointNotFound, got %v", err)
+	}
+	if err := kv.ReleaseCheckpoint(42); !errors.Is(err, ErrCheckpointNotFound) {
+		t.Errorf("Expected ErrCheckpointNotFound, got %v", err)
+	}
+}
+
+// TestReleaseCheckpoint tests squashing a delta without changing data
+func TestReleaseCheckpoint(t *testing.T) {
+	kv := NewKVStore[int]()
+	kv.Put("a", 1)
+	kv.Put("d", 1)
+	base := kv.Checkpoint("base")
+	kv.Put("a", 2)
+	kv.Delete("d")
+	kv.Put("x", 9)
+	middle := kv.Checkpoint("middle")
+	kv.Put("a", 3)
+	kv.Put("d", 5) // Deleted before middle, recreated after
+	kv.Delete("x") // Created before middle, deleted after
+	top := kv.Checkpoint("top")
+	kv.Put("b", 4)
+
+	if err := kv.ReleaseCheckpoint(middle); err != nil {
+		t.Fatalf("ReleaseCheckpoint failed: %v", err)
+	}
+	if v, _ := kv.Get("a"); v != 3 {
+		t.Errorf("Expected release to leave a=3, got %d", v)
+	}
+	// a and d changed between base and top; x came and went
+	list := kv.ListCheckpoints()
+	if len(list) != 2 || list[0].ID != base || list[1].ID != top || list[0].ChangedKeys != 2 {
+		t.Errorf("Unexpected checkpoints after release: %+v", list)
+	}
+
+	// Releasing the newest checkpoint folds it into the current tracking
+	if err := kv.ReleaseCheckpoint(top); err != nil {
+		t.Fatalf("ReleaseCheckpoint failed: %v", err)
+	}
+	if err := kv.Revert(); err != nil {
+		t.Fatalf("Revert failed: %v", err)
+	}
+	data, counts := kv.GetAllData()
+	if len(data) != 2 || data["a"] != 1 || data["d"] != 1 || len(counts) != 1 || counts[1] != 2 {
+		t.Errorf("Expected the base state after revert, got %v %v", data, counts)
+	}
+
+	// Releasing the only checkpoint leaves nothing to revert
+	kv.Checkpoint("")
+	kv.Put("a", 7)
+	list = kv.ListCheckpoints()
+	kv.ReleaseCheckpoint(list[0].ID)
+	kv.Checkpoint("")
+	if err := kv.Revert(); err != nil {
+		t.Fatalf("Revert failed: %v", err)
+	}
+	if v, _ := kv.Get("a"); v != 7 {
+		t.Errorf("Expected a=7 to survive the released checkpoint, got %d", v)
+	}
+}
+
+// TestReleaseRandomized checks release and RevertTo against recorded states
+func TestReleaseRandomized(t *testing.T) {
+	r := rand.New(rand.NewPCG(7, 7))
+	for round := 0; round < 50; round++ {
+		kv := NewKVStore[int]()
+		states := make(map[CheckpointID]map[string]int)
+
+		for step := 0; step < 60; step++ {
+			key := fmt.Sprintf("k%d", r.IntN(6))
+			switch op := r.IntN(10); {
+			case op < 5:
+				kv.Put(key, r.IntN(3))
+			case op < 8:
+				kv.Delete(key)
+			case op < 9:
+				data, _ := kv.GetAllData()
+				states[kv.Checkpoint("")] = data
+			default:
+				if list := kv.ListCheckpoints(); len(list) > 0 {
+					id := list[r.IntN(len(list))].ID
+					if err := kv.ReleaseCheckpoint(id); err != nil {
+						t.Fatalf("ReleaseCheckpoint failed: %v", err)
+					}
+					delete(states, id)
+				}
+			}
+		}
+
+		list := kv.ListCheckpoints()
+		if len(list) == 0 {
+			continue
+		}
+		target := list[r.IntN(len(list))].ID
+		if err := kv.RevertTo(target); err != nil {
+			t.Fatalf("RevertTo failed: %v", err)
+		}
+		data, counts := kv.GetAllData()
+		if !maps.Equal(data, states[target]) {
+			t.Fatalf("Round %d: expected state %v at checkpoint %d, got %v", round, states[target], target, data)
+		}
+		want := make(map[int]int)
+		for _, v := range data {
+			want[v]++
+		}
+		if !maps.Equal(counts, want) {
+			t.Fatalf("Round %d: expected counts %v, got %v", round, want, counts)
+		}
+	}
+}
+
+// TestCheckpointPersistence tests that IDs and names survive disk and WAL
+func TestCheckpointPersistence(t *testing.T) {
+	dir := t.TempDir()
+
+	kv := NewKVStore[int]()
+	kv.Checkpoint("one")
+	kv.Put("a", 1)
+	kv.Checkpoint("two")
+	kv.Revert()
+	filename := filepath.Join(dir, "cp.json")
+	if err := kv.SaveToDisk(filename); err != nil {
+		t.Fatalf("SaveToDisk failed: %v", err)
+	}
+
+	kv2 := NewKVStore[int]()
+	if err := kv2.LoadFromDisk(filename); err != nil {
+		t.Fatalf("LoadFromDisk failed: %v", err)
+	}
+	if list := kv2.ListCheckpoints(); len(list) != 1 || list[0].ID != 1 || list[0].Name != "one" {
+		t.Errorf("Expected checkpoint 1 'one' after load, got %+v", list)
+	}
+	if id := kv2.Checkpoint(""); id != 3 {
+		t.Errorf("Expected next ID 3 after load, got %d", id)
+	}
+
+	// Legacy files get IDs on load
+	legacy := NewKVStore[int]()
+	if err := legacy.LoadFromDisk("../test_delta_int.json"); err != nil {
+		t.Fatalf("LoadFromDisk failed: %v", err)
+	}
+	for i, cp := range legacy.ListCheckpoints() {
+		if cp.ID != CheckpointID(i+1) {
+			t.Errorf("Expected legacy checkpoint %d to get ID %d, got %d", i, i+1, cp.ID)
+		}
+	}
+
+	// Through the WAL
+	snapshot := filepath.Join(dir, "wal.json")
+	kv3, err := OpenKVStore[int](snapshot, WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenKVStore failed: %v", err)
+	}
+	kv3.Put("a", 1)
+	keep := kv3.Checkpoint("keep")
+	kv3.Put("a", 2)
+	drop := kv3.Checkpoint("drop")
+	kv3.Put("a", 3)
+	kv3.Checkpoint("")
+	kv3.Put("a", 4)
+	kv3.ReleaseCheckpoint(drop)
+	kv3.RevertTo(3)
+	kv3.Close()
+
+	kv4, err := OpenKVStore[int](snapshot, WALOptions{})
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer kv4.Close()
+	if v, _ := kv4.Get("a"); v != 3 {
+		t.Errorf("Expected a=3 after replay, got %d", v)
+	}
+	list := kv4.ListCheckpoints()
+	if len(list) != 1 || list[0].ID != keep || list[0].Name != "keep" {
+		t.Errorf("Expected only 'keep' after replay, got %+v", list)
+	}
+	kv4.RevertTo(keep)
+	if v, _ := kv4.Get("a"); v != 1 {
+		t.Errorf("Expected a=1 after reverting to 'keep', got %d", v)
+	}
+}
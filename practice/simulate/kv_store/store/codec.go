@@ -0,0 +1,99 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Codec converts values to and from the JSON written by SaveToDisk and the
+// write-ahead log. Marshal must produce valid JSON, and Unmarshal(Marshal(v))
+// must return a value equal to v.
+type Codec[V comparable] interface {
+	Marshal(value V) ([]byte, error)
+	Unmarshal(data []byte) (V, error)
+}
+
+// JSONCodec encodes values with encoding/json. It suits ints, strings,
+// bools and structs of comparable fields.
+type JSONCodec[V comparable] struct{}
+
+// Marshal encodes value as JSON
+func (JSONCodec[V]) Marshal(value V) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+// Unmarshal decodes a JSON value
+func (JSONCodec[V]) Unmarshal(data []byte) (V, error) {
+	var value V
+	err := json.Unmarshal(data, &value)
+	return value, err
+}
+
+// Blob is a byte slice held in a string so it can be used as a comparable
+// store value. Convert with Blob(b) and []byte(blob).
+type Blob string
+
+// BlobCodec encodes Blob values as base64 JSON strings, which (unlike plain
+// JSON strings) round-trip arbitrary bytes.
+type BlobCodec struct{}
+
+// Marshal encodes value as a base64 JSON string
+func (BlobCodec) Marshal(value Blob) ([]byte, error) {
+	return json.Marshal(base64.StdEncoding.EncodeToString([]byte(value)))
+}
+
+// Unmarshal decodes a base64 JSON string
+func (BlobCodec) Unmarshal(data []byte) (Blob, error) {
+	var encoded string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return "", err
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("invalid base64 blob: %w", err)
+	}
+	return Blob(raw), nil
+}
+
+// JSONValue is a structured JSON document stored in canonical form (compact,
+// object keys sorted), so equal documents compare equal and CountValue
+// groups them together. Create one with NewJSONValue.
+type JSONValue string
+
+// NewJSONValue validates doc and returns its canonical form
+func NewJSONValue(doc string) (JSONValue, error) {
+	// UseNumber keeps large integers exact instead of rounding to float64
+	decoder := json.NewDecoder(strings.NewReader(doc))
+	decoder.UseNumber()
+	var parsed any
+	if err := decoder.Decode(&parsed); err != nil {
+		return "", fmt.Errorf("invalid JSON value: %w", err)
+	}
+	if decoder.More() {
+		return "", fmt.Errorf("invalid JSON value: trailing data after document")
+	}
+	// Re-encoding sorts object keys and drops insignificant whitespace
+	canonical, err := json.Marshal(parsed)
+	if err != nil {
+		return "", err
+	}
+	return JSONValue(canonical), nil
+}
+
+// JSONValueCodec embeds JSONValue documents directly in the snapshot
+type JSONValueCodec struct{}
+
+// Marshal returns the document itself
+func (JSONValueCodec) Marshal(value JSONValue) ([]byte, error) {
+	if value == "" {
+		return nil, fmt.Errorf("empty JSON value (use NewJSONValue)")
+	}
+	return []byte(value), nil
+}
+
+// Unmarshal canonicalizes the embedded document
+func (JSONValueCodec) Unmarshal(data []byte) (JSONValue, error) {
+	return NewJSONValue(string(data))
+}
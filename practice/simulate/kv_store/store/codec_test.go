@@ -0,0 +1,141 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestStringValues tests a store of strings with checkpoints and persistence
+func TestStringValues(t *testing.T) {
+	kv := NewKVStore[string]()
+
+	kv.Put("user1", "active")
+	kv.Put("user2", "active")
+	kv.Checkpoint("")
+	kv.Put("user1", "inactive")
+	kv.Delete("user2")
+
+	if kv.CountValue("active") != 0 || kv.CountValue("inactive") != 1 {
+		t.Errorf("Unexpected counts before revert: active=%d inactive=%d",
+			kv.CountValue("active"), kv.CountValue("inactive"))
+	}
+
+	filename := filepath.Join(t.TempDir(), "strings.json")
+	if err := kv.SaveToDisk(filename); err != nil {
+		t.Fatalf("SaveToDisk failed: %v", err)
+	}
+	kv2 := NewKVStore[string]()
+	if err := kv2.LoadFromDisk(filename); err != nil {
+		t.Fatalf("LoadFromDisk failed: %v", err)
+	}
+
+	if err := kv2.Revert(); err != nil {
+		t.Fatalf("Revert failed: %v", err)
+	}
+	if v, _ := kv2.Get("user1"); v != "active" {
+		t.Errorf("Expected user1=active after revert, got %q", v)
+	}
+	if v, ok := kv2.Get("user2"); !ok || v != "active" {
+		t.Errorf("Expected user2 restored after revert, got %q (exists=%v)", v, ok)
+	}
+	if kv2.CountValue("active") != 2 {
+		t.Errorf("Expected active count 2 after revert, got %d", kv2.CountValue("active"))
+	}
+}
+
+// TestBlobValues tests that arbitrary bytes survive SaveToDisk/LoadFromDisk
+func TestBlobValues(t *testing.T) {
+	kv := NewKVStoreWithCodec[Blob](BlobCodec{})
+	blob := Blob([]byte{0x00, 0xff, 0xfe, '\n', 0x80})
+	kv.Put("bin", blob)
+	kv.Put("copy", blob)
+
+	filename := filepath.Join(t.TempDir(), "blobs.json")
+	if err := kv.SaveToDisk(filename); err != nil {
+		t.Fatalf("SaveToDisk failed: %v", err)
+	}
+	kv2 := NewKVStoreWithCodec[Blob](BlobCodec{})
+	if err := kv2.LoadFromDisk(filename); err != nil {
+		t.Fatalf("LoadFromDisk failed: %v", err)
+	}
+
+	if v, _ := kv2.Get("bin"); v != blob {
+		t.Errorf("Expected blob % x, got % x", []byte(blob), []byte(v))
+	}
+	if kv2.CountValue(blob) != 2 {
+		t.Errorf("Expected blob count 2, got %d", kv2.CountValue(blob))
+	}
+}
+
+// TestJSONValues tests canonicalization of structured values
+func TestJSONValues(t *testing.T) {
+	a, err := NewJSONValue(`{"b": [1, 2], "a": {"x": true}}`)
+	if err != nil {
+		t.Fatalf("NewJSONValue failed: %v", err)
+	}
+	b, err := NewJSONValue(`{"a":{"x":true},"b":[1,2]}`)
+	if err != nil {
+		t.Fatalf("NewJSONValue failed: %v", err)
+	}
+	if a != b {
+		t.Errorf("Expected equal documents to canonicalize equally: %s vs %s", a, b)
+	}
+	big, err := NewJSONValue(`{"n": 12345678901234567890}`)
+	if err != nil || big != `{"n":12345678901234567890}` {
+		t.Errorf("Expected large integers to stay exact, got %s (%v)", big, err)
+	}
+	if _, err := NewJSONValue(`{"a":`); err == nil {
+		t.Error("Expected error for invalid JSON")
+	}
+
+	dir := t.TempDir()
+	kv, err := OpenKVStoreWithCodec[JSONValue](filepath.Join(dir, "docs.json"), JSONValueCodec{}, WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenKVStoreWithCodec failed: %v", err)
+	}
+	kv.Put("doc1", a)
+	kv.Put("doc2", b)
+	kv.Close()
+
+	kv, err = OpenKVStoreWithCodec[JSONValue](filepath.Join(dir, "docs.json"), JSONValueCodec{}, WALOptions{})
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer kv.Close()
+	if kv.CountValue(a) != 2 {
+		t.Errorf("Expected document count 2 after replay, got %d", kv.CountValue(a))
+	}
+}
+
+// TestLoadLegacyFiles tests loading snapshots written before codecs existed
+func TestLoadLegacyFiles(t *testing.T) {
+	ints := NewKVStore[int]()
+	if err := ints.LoadFromDisk("../test_delta_int.json"); err != nil {
+		t.Fatalf("LoadFromDisk(int) failed: %v", err)
+	}
+	if ints.CountValue(99) != 1 {
+		t.Errorf("Expected count 1 for 99, got %d", ints.CountValue(99))
+	}
+
+	strs := NewKVStore[string]()
+	if err := strs.LoadFromDisk("../test_delta.json"); err != nil {
+		t.Fatalf("LoadFromDisk(string) failed: %v", err)
+	}
+	if strs.CountValue("1") != 1 || strs.CountValue("modified") != 1 {
+		t.Error("Expected legacy string value counts to load")
+	}
+	if err := strs.Revert(); err != nil {
+		t.Fatalf("Revert failed: %v", err)
+	}
+	if v, _ := strs.Get("key1"); v != "value1" {
+		t.Errorf("Expected key1=value1 after revert, got %q", v)
+	}
+}
+
+// TestLoadWrongType tests that a snapshot of another value type is rejected
+func TestLoadWrongType(t *testing.T) {
+	kv := NewKVStore[int]()
+	if err := kv.LoadFromDisk("../test_delta.json"); err == nil {
+		t.Error("Expected error loading string values into an int store")
+	}
+}
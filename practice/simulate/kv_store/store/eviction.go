@@ -0,0 +1,360 @@
+package store
+
+import (
+	"container/heap"
+	"container/list"
+	"fmt"
+	"math/rand/v2"
+)
+
+// EvictionPolicy chooses which key to evict when a store is over its
+// Capacity. The store reports every key added, read or overwritten, and
+// removed (for any reason), and asks for a Victim when it needs room. When
+// its keys are replaced wholesale (SetCapacity, LoadFromDisk) the store
+// calls Reset, then Added for every key. The store serializes the calls; a
+// policy must not be shared between stores.
+type EvictionPolicy interface {
+	Added(key string)
+	Accessed(key string)
+	Removed(key string)
+	Victim() (key string, ok bool) // ok is false if the policy holds no keys
+	Reset()                        // Forget every key
+}
+
+// Capacity bounds a store's size (see SetCapacity). Zero limits are
+// unlimited.
+type Capacity struct {
+	MaxKeys  int
+	MaxBytes int64          // Approximate: each key's length plus its encoded value's
+	Policy   EvictionPolicy // Default NewLRUPolicy()
+}
+
+// Stats are a store's size and usage counters
+type Stats struct {
+	Keys      int
+	Bytes     int64  // Approximate, measured as for Capacity.MaxBytes
+	Hits      uint64 // Gets that found the key
+	Misses    uint64 // Gets that didn't, including keys whose TTL had passed
+	Evictions uint64 // Keys removed to stay within the Capacity
+}
+
+// NewEvictionPolicy returns a new policy by name: lru, lfu or random
+func NewEvictionPolicy(name string) (EvictionPolicy, error) {
+	switch name {
+	case "lru":
+		return NewLRUPolicy(), nil
+	case "lfu":
+		return NewLFUPolicy(), nil
+	case "random":
+		return NewRandomPolicy(), nil
+	}
+	return nil, fmt.Errorf("unknown eviction policy %q (expected lru, lfu or random)", name)
+}
+
+// SetCapacity limits the store's size. Whenever a Put, transaction, Revert
+// or LoadFromDisk leaves it over a limit, the policy's victims are removed
+// until it fits again (the last key is always kept, however large).
+// Evictions are recorded in the checkpoint tracking like deletes, so
+// Revert brings evicted keys back, and are logged to the WAL. The policy
+// is reset and starts out knowing the existing keys in key order, so it
+// can be passed again to change the limits. A zero Capacity removes them.
+func (kv *KVStore[V]) SetCapacity(c Capacity) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if c.MaxKeys <= 0 && c.MaxBytes <= 0 {
+		kv.capacity = Capacity{}
+		kv.sizes, kv.bytes = nil, 0
+		return
+	}
+	if c.Policy == nil {
+		c.Policy = NewLRUPolicy()
+	}
+	kv.capacity = c
+	kv.rebuildCapacityLocked()
+	kv.evictLocked()
+}
+
+// Stats returns the store's size and its hit, miss and eviction counters.
+// Bytes is measured on the spot (one encode per key) unless MaxBytes is set.
+func (kv *KVStore[V]) Stats() Stats {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	bytes := kv.bytes
+	if kv.sizes == nil {
+		for key, value := range kv.data {
+			bytes += kv.sizeLocked(key, value)
+		}
+	}
+	return Stats{
+		Keys:      len(kv.data),
+		Bytes:     bytes,
+		Hits:      kv.hits.Load(),
+		Misses:    kv.misses.Load(),
+		Evictions: kv.evictions,
+	}
+}
+
+// sizeLocked returns the approximate size of a key-value pair; the caller
+// must hold mu (read or write)
+func (kv *KVStore[V]) sizeLocked(key string, value V) int64 {
+	encoded, _ := kv.codec.Marshal(value)
+	return int64(len(key) + len(encoded))
+}
+
+// addedLocked tells the policy about a new or overwritten key and updates
+// its size; the caller must hold mu
+func (kv *KVStore[V]) addedLocked(key string, value V, existed bool) {
+	if kv.capacity.Policy == nil {
+		return
+	}
+	if existed {
+		kv.capacity.Policy.Accessed(key)
+	} else {
+		kv.capacity.Policy.Added(key)
+	}
+	if kv.sizes != nil {
+		size := kv.sizeLocked(key, value)
+		kv.bytes += size - kv.sizes[key]
+		kv.sizes[key] = size
+	}
+}
+
+// removedLocked tells the policy a key is gone; the caller must hold mu
+func (kv *KVStore[V]) removedLocked(key string) {
+	if kv.capacity.Policy == nil {
+		return
+	}
+	kv.capacity.Policy.Removed(key)
+	if kv.sizes != nil {
+		kv.bytes -= kv.sizes[key]
+		delete(kv.sizes, key)
+	}
+}
+
+// accessedLocked tells the policy key was read. Get holds mu only for
+// reading, so concurrent Gets are serialized here.
+func (kv *KVStore[V]) accessedLocked(key string) {
+	if kv.capacity.Policy == nil {
+		return
+	}
+	kv.evictMu.Lock()
+	kv.capacity.Policy.Accessed(key)
+	kv.evictMu.Unlock()
+}
+
+// rebuildCapacityLocked resets the policy, registers every key with it and
+// recomputes sizes, for a new policy or after data was replaced wholesale.
+// The caller must hold mu.
+func (kv *KVStore[V]) rebuildCapacityLocked() {
+	if kv.capacity.Policy == nil {
+		return
+	}
+	kv.capacity.Policy.Reset()
+	for node := kv.index.first(); node != nil; node = node.next[0] {
+		kv.capacity.Policy.Added(node.key)
+	}
+	kv.sizes, kv.bytes = nil, 0
+	if kv.capacity.MaxBytes > 0 {
+		kv.sizes = make(map[string]int64, len(kv.data))
+		for key, value := range kv.data {
+			kv.sizes[key] = kv.sizeLocked(key, value)
+			kv.bytes += kv.sizes[key]
+		}
+	}
+}
+
+// overCapacityLocked reports whether the store exceeds a limit; the caller
+// must hold mu
+func (kv *KVStore[V]) overCapacityLocked() bool {
+	c := kv.capacity
+	return (c.MaxKeys > 0 && len(kv.data) > c.MaxKeys) || (c.MaxBytes > 0 && kv.bytes > c.MaxBytes)
+}
+
+// evictLocked removes the policy's victims until the store is within its
+// capacity; the caller must hold mu. It stops early if an eviction can't
+// be logged.
+func (kv *KVStore[V]) evictLocked() {
+	policy := kv.capacity.Policy
+	if policy == nil {
+		return
+	}
+	for len(kv.data) > 1 && kv.overCapacityLocked() {
+		key, ok := policy.Victim()
+		if _, exists := kv.data[key]; !ok || !exists {
+			return // A policy out of step with the store; don't spin
+		}
+		if !kv.logLocked(walRecord{Op: walOpEvict, Key: key}) {
+			return
+		}
+		kv.trackLocked(key)
+		kv.removeLocked(key, EventEvict)
+		kv.evictions++
+	}
+}
+
+// lruPolicy evicts the least recently used key
+type lruPolicy struct {
+	order *list.List // Most recently used at the front
+	elems map[string]*list.Element
+}
+
+// NewLRUPolicy returns a policy that evicts the least recently read or
+// written key
+func NewLRUPolicy() EvictionPolicy {
+	return &lruPolicy{order: list.New(), elems: make(map[string]*list.Element)}
+}
+
+func (p *lruPolicy) Added(key string) {
+	p.elems[key] = p.order.PushFront(key)
+}
+
+func (p *lruPolicy) Accessed(key string) {
+	if elem, ok := p.elems[key]; ok {
+		p.order.MoveToFront(elem)
+	}
+}
+
+func (p *lruPolicy) Removed(key string) {
+	if elem, ok := p.elems[key]; ok {
+		p.order.Remove(elem)
+		delete(p.elems, key)
+	}
+}
+
+func (p *lruPolicy) Victim() (string, bool) {
+	if back := p.order.Back(); back != nil {
+		return back.Value.(string), true
+	}
+	return "", false
+}
+
+func (p *lruPolicy) Reset() {
+	p.order.Init()
+	clear(p.elems)
+}
+
+// lfuEntry is one key in the LFU heap
+type lfuEntry struct {
+	key   string
+	count uint64 // Reads and writes
+	last  uint64 // Tick of the last one, to break ties by recency
+	index int    // Position in the heap
+}
+
+// lfuHeap orders keys by use count, least recently used first among equals
+type lfuHeap []*lfuEntry
+
+func (h lfuHeap) Len() int { return len(h) }
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].last < h[j].last
+}
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+func (h *lfuHeap) Push(x any) {
+	entry := x.(*lfuEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+func (h *lfuHeap) Pop() any {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// lfuPolicy evicts the least frequently used key
+type lfuPolicy struct {
+	heap    lfuHeap
+	entries map[string]*lfuEntry
+	tick    uint64
+}
+
+// NewLFUPolicy returns a policy that evicts the key read or written the
+// fewest times (the least recently used of those on a tie). New keys start
+// with one use, so they go first unless they are used again.
+func NewLFUPolicy() EvictionPolicy {
+	return &lfuPolicy{entries: make(map[string]*lfuEntry)}
+}
+
+func (p *lfuPolicy) Added(key string) {
+	p.tick++
+	entry := &lfuEntry{key: key, count: 1, last: p.tick}
+	p.entries[key] = entry
+	heap.Push(&p.heap, entry)
+}
+
+func (p *lfuPolicy) Accessed(key string) {
+	if entry, ok := p.entries[key]; ok {
+		p.tick++
+		entry.count++
+		entry.last = p.tick
+		heap.Fix(&p.heap, entry.index)
+	}
+}
+
+func (p *lfuPolicy) Removed(key string) {
+	if entry, ok := p.entries[key]; ok {
+		heap.Remove(&p.heap, entry.index)
+		delete(p.entries, key)
+	}
+}
+
+func (p *lfuPolicy) Victim() (string, bool) {
+	if len(p.heap) == 0 {
+		return "", false
+	}
+	return p.heap[0].key, true
+}
+
+func (p *lfuPolicy) Reset() {
+	p.heap = nil
+	clear(p.entries)
+}
+
+// randomPolicy evicts a key chosen uniformly at random
+type randomPolicy struct {
+	keys  []string
+	index map[string]int // key -> position in keys
+}
+
+// NewRandomPolicy returns a policy that evicts a random key
+func NewRandomPolicy() EvictionPolicy {
+	return &randomPolicy{index: make(map[string]int)}
+}
+
+func (p *randomPolicy) Added(key string) {
+	p.index[key] = len(p.keys)
+	p.keys = append(p.keys, key)
+}
+
+func (p *randomPolicy) Accessed(string) {}
+
+func (p *randomPolicy) Removed(key string) {
+	i, ok := p.index[key]
+	if !ok {
+		return
+	}
+	last := p.keys[len(p.keys)-1]
+	p.keys[i], p.index[last] = last, i
+	p.keys = p.keys[:len(p.keys)-1]
+	delete(p.index, key)
+}
+
+func (p *randomPolicy) Victim() (string, bool) {
+	if len(p.keys) == 0 {
+		return "", false
+	}
+	return p.keys[rand.IntN(len(p.keys))], true
+}
+
+func (p *randomPolicy) Reset() {
+	p.keys = p.keys[:0]
+	clear(p.index)
+}
@@ -0,0 +1,260 @@
+package store
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestEvictionLRU tests that the least recently used key goes first
+func TestEvictionLRU(t *testing.T) {
+	kv := NewKVStore[int]()
+	kv.SetCapacity(Capacity{MaxKeys: 3, Policy: NewLRUPolicy()})
+	events := kv.Watch("")
+	kv.Put("a", 1)
+	kv.Put("b", 1)
+	kv.Put("c", 2)
+	kv.Get("a")    // b is now the least recently used
+	kv.Put("c", 3) // Overwrites count as use too
+	kv.Put("d", 4)
+
+	data, counts := kv.GetAllData()
+	if want := map[string]int{"a": 1, "c": 3, "d": 4}; !maps.Equal(data, want) {
+		t.Errorf("Expected %v, got %v", want, data)
+	}
+	if counts[1] != 1 || kv.CountValue(2) != 0 {
+		t.Errorf("Expected valueCount to drop the evicted key, got %v", counts)
+	}
+	var evicted []string
+	for len(events) > 0 {
+		if e := <-events; e.Type == EventEvict {
+			evicted = append(evicted, e.Key)
+		}
+	}
+	if !slices.Equal(evicted, []string{"b"}) {
+		t.Errorf("Expected an evict event for b, got %v", evicted)
+	}
+	if s := kv.Stats(); s.Evictions != 1 || s.Keys != 3 {
+		t.Errorf("Expected 1 eviction and 3 keys, got %+v", s)
+	}
+}
+
+// TestEvictionLFU tests that the least frequently used key goes first,
+// and the least recently used of those on a tie
+func TestEvictionLFU(t *testing.T) {
+	kv := NewKVStore[int]()
+	kv.SetCapacity(Capacity{MaxKeys: 3, Policy: NewLFUPolicy()})
+	kv.Put("a", 1)
+	kv.Put("b", 2)
+	kv.Put("c", 3)
+	kv.Get("a")
+	kv.Get("a")
+	kv.Get("b")
+	kv.Put("d", 4) // c has one use, like d, but is older
+
+	if _, ok := kv.Get("c"); ok {
+		t.Errorf("Expected c to be evicted")
+	}
+	kv.Put("e", 5) // d is now the only key with one use
+	if keys := collectKeys(kv.Scan("", "")); !slices.Equal(keys, []string{"a", "b", "e"}) {
+		t.Errorf("Expected [a b e], got %v", keys)
+	}
+}
+
+// TestEvictionRandom tests that a random policy keeps the store within
+// its limit with value counts intact
+func TestEvictionRandom(t *testing.T) {
+	kv := NewKVStore[int]()
+	kv.SetCapacity(Capacity{MaxKeys: 10, Policy: NewRandomPolicy()})
+	for i := 0; i < 100; i++ {
+		kv.Put(fmt.Sprintf("k%d", i), i%3)
+	}
+
+	data, counts := kv.GetAllData()
+	if len(data) != 10 {
+		t.Errorf("Expected 10 keys, got %d", len(data))
+	}
+	rebuilt := make(map[int]int)
+	for _, v := range data {
+		rebuilt[v]++
+	}
+	if !maps.Equal(counts, rebuilt) {
+		t.Errorf("Expected value counts %v, got %v", rebuilt, counts)
+	}
+	if s := kv.Stats(); s.Evictions != 90 {
+		t.Errorf("Expected 90 evictions, got %d", s.Evictions)
+	}
+}
+
+// TestEvictionMaxBytes tests the byte limit and that an oversized key is
+// kept rather than emptying the store
+func TestEvictionMaxBytes(t *testing.T) {
+	kv := NewKVStore[string]()
+	kv.SetCapacity(Capacity{MaxBytes: 20}) // LRU by default
+	kv.Put("a", "12345")                   // 1 + 7 bytes ("12345" in JSON)
+	kv.Put("b", "12345")
+	if s := kv.Stats(); s.Bytes != 16 || s.Evictions != 0 {
+		t.Errorf("Expected 16 bytes and no evictions, got %+v", s)
+	}
+	kv.Put("c", "12345")
+	data, _ := kv.GetAllData()
+	if keys := slices.Sorted(maps.Keys(data)); !slices.Equal(keys, []string{"b", "c"}) {
+		t.Errorf("Expected [b c] after going over 20 bytes, got %v", keys)
+	}
+
+	kv.Put("big", "0123456789012345678901234567890")
+	data, _ = kv.GetAllData()
+	if keys := slices.Sorted(maps.Keys(data)); !slices.Equal(keys, []string{"big"}) {
+		t.Errorf("Expected only the oversized key to be left, got %v", keys)
+	}
+
+	kv.SetCapacity(Capacity{})
+	kv.Put("a", "1")
+	if s := kv.Stats(); s.Keys != 2 || s.Bytes != 2+3+35 {
+		t.Errorf("Expected no limit and 2 keys of 40 bytes, got %+v", s)
+	}
+}
+
+// TestEvictionRevert tests that evictions after a checkpoint are tracked,
+// so Revert restores the evicted keys
+func TestEvictionRevert(t *testing.T) {
+	kv := NewKVStore[int]()
+	kv.Put("a", 1)
+	kv.Put("b", 2)
+	kv.Put("c", 3)
+	kv.Put("d", 4)
+	kv.SetCapacity(Capacity{MaxKeys: 3}) // Evicts a, first in key order
+	kv.Checkpoint("")
+	kv.Put("e", 5) // Evicts b
+	kv.Put("f", 6) // Evicts c
+
+	if err := kv.Revert(); err != nil {
+		t.Fatalf("Revert failed: %v", err)
+	}
+	data, _ := kv.GetAllData()
+	if want := map[string]int{"b": 2, "c": 3, "d": 4}; !maps.Equal(data, want) {
+		t.Errorf("Expected %v after revert, got %v", want, data)
+	}
+	if kv.CountValue(2) != 1 || kv.CountValue(5) != 0 {
+		t.Errorf("Expected value counts restored with the keys")
+	}
+}
+
+// TestEvictionWAL tests that evictions are logged, so replay ends in the
+// same state without the capacity being set again
+func TestEvictionWAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	kv, err := OpenKVStore[int](path, WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenKVStore failed: %v", err)
+	}
+	kv.SetCapacity(Capacity{MaxKeys: 2, Policy: NewRandomPolicy()})
+	for i := 0; i < 10; i++ {
+		kv.Put(fmt.Sprintf("k%d", i), i)
+	}
+	want, _ := kv.GetAllData()
+	kv.Close()
+
+	kv, err = OpenKVStore[int](path, WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenKVStore failed: %v", err)
+	}
+	defer kv.Close()
+	if data, _ := kv.GetAllData(); !maps.Equal(data, want) {
+		t.Errorf("Expected %v after replay, got %v", want, data)
+	}
+}
+
+// TestEvictionLoad tests that a loaded snapshot is cut down to capacity
+// and that the policy forgets the keys it replaced
+func TestEvictionLoad(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "store.json")
+	big := NewKVStore[int]()
+	for i := 0; i < 5; i++ {
+		big.Put(fmt.Sprintf("k%d", i), i)
+	}
+	big.SaveToDisk(filename)
+
+	kv := NewKVStore[int]()
+	kv.SetCapacity(Capacity{MaxKeys: 3})
+	kv.Put("old", 1)
+	if err := kv.LoadFromDisk(filename); err != nil {
+		t.Fatalf("LoadFromDisk failed: %v", err)
+	}
+	if keys := collectKeys(kv.Scan("", "")); !slices.Equal(keys, []string{"k2", "k3", "k4"}) {
+		t.Errorf("Expected [k2 k3 k4], got %v", keys)
+	}
+	kv.Put("new", 1)
+	if _, ok := kv.Get("k2"); ok {
+		t.Errorf("Expected k2 to be the next victim, not the replaced key")
+	}
+}
+
+// TestSetCapacityAgain tests that passing the same policy to SetCapacity
+// again, with keys deleted while it was unlimited, still evicts
+func TestSetCapacityAgain(t *testing.T) {
+	for _, name := range []string{"lru", "lfu", "random"} {
+		t.Run(name, func(t *testing.T) {
+			policy, _ := NewEvictionPolicy(name)
+			kv := NewKVStore[int]()
+			kv.Put("gone", 0)
+			kv.SetCapacity(Capacity{MaxKeys: 10, Policy: policy})
+			kv.SetCapacity(Capacity{})
+			kv.Delete("gone")
+			for i := 0; i < 5; i++ {
+				kv.Put(fmt.Sprintf("k%d", i), i)
+			}
+			kv.SetCapacity(Capacity{MaxKeys: 4, Policy: policy})
+			kv.SetCapacity(Capacity{MaxKeys: 3, Policy: policy})
+			kv.Put("k5", 5)
+
+			if s := kv.Stats(); s.Keys != 3 || s.Evictions != 3 {
+				t.Errorf("Expected 3 keys after 3 evictions, got %+v", s)
+			}
+			if _, ok := kv.Get("k5"); !ok && name != "random" {
+				t.Errorf("Expected the new key to be kept")
+			}
+		})
+	}
+}
+
+// TestStats tests the hit and miss counters
+func TestStats(t *testing.T) {
+	clock := newFakeClock()
+	kv := NewKVStore[int]()
+	kv.now = clock.Now
+	kv.Put("a", 1)
+	kv.PutWithTTL("b", 2, time.Second)
+	kv.Get("a")
+	kv.Get("a")
+	kv.Get("b")
+	kv.Get("missing")
+	clock.Advance(time.Second)
+	kv.Get("b")
+
+	s := kv.Stats()
+	if s.Hits != 3 || s.Misses != 2 || s.Keys != 1 || s.Evictions != 0 {
+		t.Errorf("Expected 3 hits, 2 misses, 1 key, got %+v", s)
+	}
+	if s.Bytes != 2 {
+		t.Errorf("Expected 2 bytes (\"a\" and 1), got %d", s.Bytes)
+	}
+}
+
+// BenchmarkPutWithEviction benchmarks writes to a full store
+func BenchmarkPutWithEviction(b *testing.B) {
+	for _, name := range []string{"lru", "lfu", "random"} {
+		b.Run(name, func(b *testing.B) {
+			policy, _ := NewEvictionPolicy(name)
+			kv := NewKVStore[int]()
+			kv.SetCapacity(Capacity{MaxKeys: 1000, Policy: policy})
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				kv.Put(benchKeys[i%len(benchKeys)], i)
+			}
+		})
+	}
+}
@@ -1,120 +1,291 @@
 package store
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // DeltaSnapshot stores only the keys that changed and their old values
-type DeltaSnapshot struct {
-	ChangedKeys map[string]*int // key -> old value (nil if key didn't exist)
-	DeletedKeys map[string]int  // key -> old value (for keys that were deleted)
+type DeltaSnapshot[V comparable] struct {
+	ID          CheckpointID  // Checkpoint this delta leads up to
+	Name        string        // Optional name given to Checkpoint
+	Created     time.Time     // When the checkpoint was taken
+	Version     Version       // Version when the checkpoint was taken (0 if loaded from a snapshot file)
+	ChangedKeys map[string]*V // key -> old value (nil if key didn't exist)
+	DeletedKeys map[string]V  // key -> old value (for keys that were deleted)
 }
 
-// KVStore represents a key-value store with delta-based snapshot capabilities
-type KVStore struct {
-	mu          sync.RWMutex     // Protects data and checkpoints
-	data        map[string]int
-	valueCount  map[int]int      // value -> count
-	checkpoints []*DeltaSnapshot // Stack of delta snapshots
-	tracking    map[string]*int  // Tracks original values since last checkpoint (nil = new key)
+// KVStore represents a key-value store with delta-based snapshot capabilities.
+// V is the value type; it must be comparable so CountValue can index it.
+type KVStore[V comparable] struct {
+	mu          sync.RWMutex // Protects data and checkpoints
+	data        map[string]V
+	valueCount  map[V]int            // value -> count
+	checkpoints []*DeltaSnapshot[V]  // Stack of delta snapshots
+	tracking    map[string]*V        // Tracks original values since last checkpoint (nil = new key)
+	codec       Codec[V]             // Encodes values for SaveToDisk/LoadFromDisk and the WAL
+	expiry      map[string]time.Time // key -> deadline, for keys put with a TTL
+	now         func() time.Time     // Clock used for expiry (replaceable in tests)
+	janitor     *janitor             // Background expiry goroutine (nil = not running)
+
+	index            *skipList[string, struct{}] // Keys of data in sorted order, for scans
+	lastCheckpointID CheckpointID                // Last ID handed out by Checkpoint
+
+	version atomic.Uint64                     // Changes made so far (see Version)
+	history atomic.Pointer[versionHistory[V]] // Past versions of every key (nil = not enabled)
+	metrics atomic.Pointer[metrics]           // Histograms for WriteMetrics (nil = not enabled)
+
+	valueIndex *skipList[V, valueKeys] // Value -> keys, for KeysWithValue/CountRange/TopK (nil = not enabled)
+
+	watchers   map[<-chan Event[V]]*watcher[V] // Subscriptions made with Watch
+	watchIndex sync.Map                        // Same as watchers, readable without mu (for Unwatch)
+
+	wal          *writeAheadLog // Optional write-ahead log (nil = disabled)
+	snapshotPath string         // Snapshot the WAL is replayed on top of
+	walSeq       uint64         // Sequence number of the last logged operation
+	failErr      error          // First failure to log a change; every change is refused after it (see Err)
+
+	activeTxns int               // Transactions begun but not yet committed or rolled back
+	writeSeq   uint64            // Bumped on every write while transactions are active
+	modified   map[string]uint64 // key -> writeSeq of its last write (only while activeTxns > 0)
+	lastReset  uint64            // writeSeq of the last LoadFromDisk while transactions were active
+
+	capacity  Capacity         // Size limits and eviction policy (zero = unbounded)
+	sizes     map[string]int64 // key -> approximate size (only while MaxBytes is set)
+	bytes     int64            // Sum of sizes
+	evictMu   sync.Mutex       // Serializes policy calls from Get, which holds mu only for reading
+	evictions uint64
+	hits      atomic.Uint64
+	misses    atomic.Uint64
 }
 
-// NewKVStore creates a new KV store instance
-func NewKVStore() *KVStore {
-	return &KVStore{
-		data:        make(map[string]int),
-		valueCount:  make(map[int]int),
-		checkpoints: []*DeltaSnapshot{},
-		tracking:    make(map[string]*int),
+// NewKVStore creates a new KV store instance that persists values as JSON
+func NewKVStore[V comparable]() *KVStore[V] {
+	return NewKVStoreWithCodec[V](JSONCodec[V]{})
+}
+
+// NewKVStoreWithCodec creates a new KV store instance that persists values
+// with the given codec
+func NewKVStoreWithCodec[V comparable](codec Codec[V]) *KVStore[V] {
+	return &KVStore[V]{
+		data:        make(map[string]V),
+		index:       newKeyIndex(),
+		watchers:    make(map[<-chan Event[V]]*watcher[V]),
+		valueCount:  make(map[V]int),
+		checkpoints: []*DeltaSnapshot[V]{},
+		tracking:    make(map[string]*V),
+		codec:       codec,
+		expiry:      make(map[string]time.Time),
+		now:         time.Now,
+		modified:    make(map[string]uint64),
 	}
 }
 
-// Put sets a key-value pair in the store
-func (kv *KVStore) Put(key string, value int) {
-	kv.mu.Lock()
-	defer kv.mu.Unlock()
-
-	// Track original value if we have checkpoints and haven't tracked this key yet
-	if len(kv.checkpoints) > 0 {
-		if _, alreadyTracked := kv.tracking[key]; !alreadyTracked {
-			if oldValue, existed := kv.data[key]; existed {
-				oldValueCopy := oldValue
-				kv.tracking[key] = &oldValueCopy
-			} else {
-				kv.tracking[key] = nil // Mark as new key
-			}
-		}
+// Put sets a key-value pair in the store. If the write-ahead log is enabled
+// and the put can't be logged, it is not applied and the store stops taking
+// changes: callers must check Err.
+func (kv *KVStore[V]) Put(key string, value V) {
+	start := kv.lockOp()
+	defer kv.unlockOp(opPut, start)
+
+	if !kv.logValueLocked(walRecord{Op: walOpPut, Key: key}, value) {
+		return
+	}
+	kv.putLocked(key, value)
+	kv.evictLocked()
+}
+
+// putLocked applies a Put; the caller must hold mu
+func (kv *KVStore[V]) putLocked(key string, value V) {
+	kv.trackLocked(key)
+	kv.setLocked(key, value, EventPut)
+}
+
+// trackLocked records the original value of key for the next revert, if we
+// have checkpoints and haven't tracked this key yet
+func (kv *KVStore[V]) trackLocked(key string) {
+	if len(kv.checkpoints) == 0 {
+		return
 	}
+	if _, alreadyTracked := kv.tracking[key]; alreadyTracked {
+		return
+	}
+	if oldValue, existed := kv.data[key]; existed {
+		oldValueCopy := oldValue
+		kv.tracking[key] = &oldValueCopy
+	} else {
+		kv.tracking[key] = nil // Mark as new key
+	}
+}
 
+// setLocked stores value under key, keeps valueCount and the key and value
+// indexes in sync, and notifies watchers with an event of type typ
+func (kv *KVStore[V]) setLocked(key string, value V, typ EventType) {
 	// If key exists, decrement the old value's count
-	if oldValue, exists := kv.data[key]; exists {
-		kv.valueCount[oldValue]--
-		if kv.valueCount[oldValue] == 0 {
-			delete(kv.valueCount, oldValue)
-		}
+	oldValue, exists := kv.data[key]
+	if exists {
+		kv.decrementCountLocked(oldValue)
+		kv.unindexValueLocked(key, oldValue)
+	} else {
+		kv.index.set(key, struct{}{})
 	}
 
 	// Set the new value and increment its count
 	kv.data[key] = value
 	kv.valueCount[value]++
+	kv.indexValueLocked(key, value)
+	kv.addedLocked(key, value, exists)
+	delete(kv.expiry, key) // PutWithTTL sets a new deadline after this
+	kv.noteWriteLocked(key)
+	kv.recordVersionLocked(key, value, false)
+
+	if len(kv.watchers) > 0 {
+		var old *V
+		if exists {
+			old = &oldValue
+		}
+		kv.notifyLocked(Event[V]{Type: typ, Key: key, OldValue: old, NewValue: &value})
+	}
+}
+
+// removeLocked deletes key, keeps valueCount and the key and value indexes
+// in sync, and notifies watchers with an event of type typ
+func (kv *KVStore[V]) removeLocked(key string, typ EventType) {
+	if oldValue, exists := kv.data[key]; exists {
+		delete(kv.data, key)
+		kv.index.remove(key)
+		delete(kv.expiry, key)
+		kv.decrementCountLocked(oldValue)
+		kv.unindexValueLocked(key, oldValue)
+		kv.removedLocked(key)
+		kv.noteWriteLocked(key)
+		kv.recordVersionLocked(key, oldValue, true)
+
+		if len(kv.watchers) > 0 {
+			kv.notifyLocked(Event[V]{Type: typ, Key: key, OldValue: &oldValue})
+		}
+	}
+}
+
+func (kv *KVStore[V]) decrementCountLocked(value V) {
+	kv.valueCount[value]--
+	if kv.valueCount[value] == 0 {
+		delete(kv.valueCount, value)
+	}
 }
 
 // Get retrieves the value for a given key
-func (kv *KVStore) Get(key string) (int, bool) {
-	kv.mu.RLock()
-	defer kv.mu.RUnlock()
+// A key whose TTL has passed is expired on the spot and reported missing
+func (kv *KVStore[V]) Get(key string) (V, bool) {
+	start := kv.rlockOp()
 	value, exists := kv.data[key]
+	expired := exists && kv.expiredLocked(key)
+	if exists && !expired {
+		kv.accessedLocked(key)
+	}
+
+	if !expired {
+		kv.runlockOp(opGet, start)
+		kv.countLookup(exists)
+		return value, exists
+	}
+	kv.mu.RUnlock()
+
+	kv.lockOp()
+	defer kv.unlockOp(opGet, start)
+
+	// Re-check: the key may have been replaced while we were unlocked
+	if kv.expiredLocked(key) {
+		kv.expireLocked(key)
+	}
+	if kv.expiredLocked(key) {
+		kv.countLookup(false)
+		var zero V
+		return zero, false // Expiry couldn't be logged; still hide the key
+	}
+	value, exists = kv.data[key]
+	if exists {
+		kv.accessedLocked(key)
+	}
+	kv.countLookup(exists)
 	return value, exists
 }
 
-// Delete removes a key-value pair from the store
-// Returns true if the key existed and was deleted, false otherwise
-func (kv *KVStore) Delete(key string) bool {
-	kv.mu.Lock()
-	defer kv.mu.Unlock()
+// countLookup counts a Get as a hit or a miss for Stats
+func (kv *KVStore[V]) countLookup(hit bool) {
+	if hit {
+		kv.hits.Add(1)
+	} else {
+		kv.misses.Add(1)
+	}
+}
 
-	if oldValue, exists := kv.data[key]; exists {
-		// Track original value if we have checkpoints and haven't tracked this key yet
-		if len(kv.checkpoints) > 0 {
-			if _, alreadyTracked := kv.tracking[key]; !alreadyTracked {
-				oldValueCopy := oldValue
-				kv.tracking[key] = &oldValueCopy
-			}
-		}
+// Delete removes a key-value pair from the store. It returns true if the key
+// existed and was deleted, and false if it didn't exist or the delete
+// couldn't be logged (see Err).
+func (kv *KVStore[V]) Delete(key string) bool {
+	start := kv.lockOp()
+	defer kv.unlockOp(opDelete, start)
 
-		delete(kv.data, key)
-		kv.valueCount[oldValue]--
-		if kv.valueCount[oldValue] == 0 {
-			delete(kv.valueCount, oldValue)
-		}
-		return true
+	if _, exists := kv.data[key]; !exists {
+		return false
 	}
-	return false
+	if !kv.logLocked(walRecord{Op: walOpDelete, Key: key}) {
+		return false
+	}
+	kv.deleteLocked(key)
+	return true
+}
+
+// deleteLocked applies a Delete of an existing key; the caller must hold mu
+func (kv *KVStore[V]) deleteLocked(key string) {
+	kv.trackLocked(key)
+	kv.removeLocked(key, EventDelete)
 }
 
 // CountValue returns the number of keys that have the given value
 // O(1) lookup using the valueCount map
-func (kv *KVStore) CountValue(value int) int {
+func (kv *KVStore[V]) CountValue(value V) int {
 	kv.mu.RLock()
 	defer kv.mu.RUnlock()
 	return kv.valueCount[value]
 }
 
-// Checkpoint creates a delta snapshot storing the current tracking info
-// After this, changes continue to be tracked for the next revert
-func (kv *KVStore) Checkpoint() {
-	kv.mu.Lock()
-	defer kv.mu.Unlock()
+// Checkpoint creates a delta snapshot storing the current tracking info and
+// returns its ID. The name is optional and need not be unique (see
+// FindCheckpoint). After this, changes continue to be tracked for the next
+// revert. It returns 0 if the checkpoint could not be logged (see Err).
+func (kv *KVStore[V]) Checkpoint(name string) CheckpointID {
+	start := kv.lockOp()
+	defer kv.unlockOp(opCheckpoint, start)
+
+	id, created := kv.lastCheckpointID+1, kv.now()
+	rec := walRecord{Op: walOpCheckpoint, ID: uint64(id), Name: name, Time: created.UnixNano()}
+	if !kv.logLocked(rec) {
+		return 0
+	}
+	kv.checkpointLocked(id, name, created)
+	return id
+}
 
+// checkpointLocked applies a Checkpoint; the caller must hold mu
+func (kv *KVStore[V]) checkpointLocked(id CheckpointID, name string, created time.Time) {
 	// Create a delta snapshot with CURRENT tracking info
 	// This represents changes since the PREVIOUS checkpoint (or start)
-	delta := &DeltaSnapshot{
-		ChangedKeys: make(map[string]*int),
-		DeletedKeys: make(map[string]int),
+	delta := &DeltaSnapshot[V]{
+		ID:          id,
+		Name:        name,
+		Created:     created,
+		Version:     Version(kv.version.Load()),
+		ChangedKeys: make(map[string]*V),
+		DeletedKeys: make(map[string]V),
 	}
+	kv.lastCheckpointID = max(kv.lastCheckpointID, id)
 
 	// Copy current tracking to the snapshot
 	for key, oldValue := range kv.tracking {
@@ -130,53 +301,49 @@ func (kv *KVStore) Checkpoint() {
 	}
 
 	kv.checkpoints = append(kv.checkpoints, delta)
+	kv.observeDeltaLocked(delta)
 
 	// Clear tracking - next checkpoint should track from THIS point
-	kv.tracking = make(map[string]*int)
+	kv.tracking = make(map[string]*V)
 }
 
 // Revert restores the state from the last checkpoint
-func (kv *KVStore) Revert() error {
-	kv.mu.Lock()
-	defer kv.mu.Unlock()
+func (kv *KVStore[V]) Revert() error {
+	start := kv.lockOp()
+	defer kv.unlockOp(opRevert, start)
 
 	if len(kv.checkpoints) == 0 {
 		return fmt.Errorf("no checkpoints to revert to")
 	}
+	if !kv.logLocked(walRecord{Op: walOpRevert}) {
+		return fmt.Errorf("failed to log revert: %w", kv.failErr)
+	}
+	kv.revertLocked()
+	kv.evictLocked() // Restored keys may not all fit
+	return nil
+}
 
+// revertLocked applies a Revert; the caller must hold mu and ensure there is
+// at least one checkpoint
+func (kv *KVStore[V]) revertLocked() {
 	// Get the last checkpoint delta
 	delta := kv.checkpoints[len(kv.checkpoints)-1]
 	kv.checkpoints = kv.checkpoints[:len(kv.checkpoints)-1]
 
 	// Undo ONLY the current tracking (changes since last checkpoint)
 	for key, oldValue := range kv.tracking {
-		if currentValue, exists := kv.data[key]; exists {
-			// Key exists and was changed
-			kv.valueCount[currentValue]--
-			if kv.valueCount[currentValue] == 0 {
-				delete(kv.valueCount, currentValue)
-			}
-
-			if oldValue == nil {
-				// Key didn't exist before, delete it
-				delete(kv.data, key)
-			} else {
-				// Restore old value
-				kv.data[key] = *oldValue
-				kv.valueCount[*oldValue]++
-			}
+		if oldValue == nil {
+			// Key didn't exist before, delete it
+			kv.removeLocked(key, EventRevert)
 		} else {
-			// Key was deleted after checkpoint, restore it
-			if oldValue != nil {
-				kv.data[key] = *oldValue
-				kv.valueCount[*oldValue]++
-			}
+			// Restore old value (or the deleted key)
+			kv.setLocked(key, *oldValue, EventRevert)
 		}
 	}
 
 	// Now restore the tracking from the checkpoint delta
 	// This becomes our new tracking for potential next revert
-	kv.tracking = make(map[string]*int)
+	kv.tracking = make(map[string]*V)
 	for key, oldValue := range delta.ChangedKeys {
 		kv.tracking[key] = oldValue
 	}
@@ -184,92 +351,98 @@ func (kv *KVStore) Revert() error {
 		oldValueCopy := oldValue
 		kv.tracking[key] = &oldValueCopy
 	}
+}
 
-	return nil
+// SaveToDisk saves the current state to a file, in the format its extension
+// selects (see FormatForFile). The file is replaced atomically, so a crash
+// mid-save leaves the previous contents intact.
+func (kv *KVStore[V]) SaveToDisk(filename string) error {
+	return kv.SaveToDiskAs(filename, FormatForFile(filename))
 }
 
-// SaveToDisk saves the current state to a file
-func (kv *KVStore) SaveToDisk(filename string) error {
-	kv.mu.RLock()
-	defer kv.mu.RUnlock()
+// SaveToDiskAs is SaveToDisk with an explicit format
+func (kv *KVStore[V]) SaveToDiskAs(filename string, format SnapshotFormat) error {
+	start := kv.rlockOp()
+	defer kv.runlockOp(opSave, start)
+	return kv.saveLocked(filename, format)
+}
 
-	file, err := os.Create(filename)
+// saveLocked writes the snapshot; the caller must hold mu (read or write)
+func (kv *KVStore[V]) saveLocked(filename string, format SnapshotFormat) error {
+	state, err := kv.encodeStateLocked()
 	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
-	}
-	defer file.Close()
-
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-
-	state := struct {
-		Data        map[string]int      `json:"data"`
-		ValueCount  map[int]int         `json:"valueCount"`
-		Checkpoints []*DeltaSnapshot    `json:"checkpoints"`
-		Tracking    map[string]*int     `json:"tracking"`
-	}{
-		Data:        kv.data,
-		ValueCount:  kv.valueCount,
-		Checkpoints: kv.checkpoints,
-		Tracking:    kv.tracking,
+		return err
 	}
-
-	if err := encoder.Encode(state); err != nil {
-		return fmt.Errorf("failed to encode state: %w", err)
+	content, err := encodeSnapshot(state, format)
+	if err != nil {
+		return err
 	}
-
-	return nil
+	return WriteFileAtomic(filename, content)
 }
 
-// LoadFromDisk loads the state from a file
-func (kv *KVStore) LoadFromDisk(filename string) error {
-	file, err := os.Open(filename)
+// LoadFromDisk loads the state from a file in any SnapshotFormat (detected
+// from its contents, not its name), verifying its checksum. The
+// store is left untouched if the file can't be read. If the file's value
+// counts don't match its data, the state is still loaded (with the counts
+// rebuilt) and an error wrapping ErrInconsistentState is returned.
+func (kv *KVStore[V]) LoadFromDisk(filename string) error {
+	content, err := os.ReadFile(filename)
 	if err != nil {
 		return fmt.Errorf("failed to open file: %w", err)
 	}
-	defer file.Close()
-
-	var state struct {
-		Data        map[string]int      `json:"data"`
-		ValueCount  map[int]int         `json:"valueCount"`
-		Checkpoints []*DeltaSnapshot    `json:"checkpoints"`
-		Tracking    map[string]*int     `json:"tracking"`
+	state, err := decodeSnapshot(content)
+	if err != nil {
+		return err
 	}
 
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&state); err != nil {
-		return fmt.Errorf("failed to decode state: %w", err)
-	}
+	start := kv.lockOp()
+	defer kv.unlockOp(opLoad, start)
 
-	kv.mu.Lock()
-	defer kv.mu.Unlock()
+	if kv.failErr != nil {
+		return fmt.Errorf("store has stopped: %w", kv.failErr)
+	}
+	inconsistent := kv.decodeStateLocked(state)
+	if inconsistent != nil && !errors.Is(inconsistent, ErrInconsistentState) {
+		return inconsistent
+	}
+	kv.noteResetLocked()
 
-	kv.data = state.Data
-	kv.valueCount = state.ValueCount
-	kv.checkpoints = state.Checkpoints
-	kv.tracking = state.Tracking
+	if kv.wal != nil {
+		// The log was relative to the old state: fold the loaded state into
+		// a fresh snapshot so replay starts from it
+		if err := kv.saveLocked(kv.snapshotPath, FormatForFile(kv.snapshotPath)); err != nil {
+			return fmt.Errorf("failed to write snapshot: %w", err)
+		}
+		if err := kv.wal.reset(); err != nil {
+			return err
+		}
+	} else {
+		kv.walSeq = state.WALSeq
+	}
+	kv.evictLocked() // After the reset, so evictions are logged against the new state
 
-	return nil
+	return inconsistent
 }
 
 // GetCheckpointCount returns the number of checkpoints
-func (kv *KVStore) GetCheckpointCount() int {
+func (kv *KVStore[V]) GetCheckpointCount() int {
 	kv.mu.RLock()
 	defer kv.mu.RUnlock()
 	return len(kv.checkpoints)
 }
 
-// GetAllData returns a copy of all key-value pairs and value counts
-func (kv *KVStore) GetAllData() (map[string]int, map[int]int) {
+// GetAllData returns a copy of all key-value pairs and value counts (use
+// Scan to visit keys in order)
+func (kv *KVStore[V]) GetAllData() (map[string]V, map[V]int) {
 	kv.mu.RLock()
 	defer kv.mu.RUnlock()
 
-	dataCopy := make(map[string]int, len(kv.data))
+	dataCopy := make(map[string]V, len(kv.data))
 	for k, v := range kv.data {
 		dataCopy[k] = v
 	}
 
-	countCopy := make(map[int]int, len(kv.valueCount))
+	countCopy := make(map[V]int, len(kv.valueCount))
 	for v, count := range kv.valueCount {
 		countCopy[v] = count
 	}
@@ -278,17 +451,22 @@ func (kv *KVStore) GetAllData() (map[string]int, map[int]int) {
 }
 
 // Print displays the current state (for debugging)
-func (kv *KVStore) Print() {
+func (kv *KVStore[V]) Print() {
+	kv.PrintTo(os.Stdout)
+}
+
+// PrintTo writes what Print displays to w
+func (kv *KVStore[V]) PrintTo(w io.Writer) {
 	kv.mu.RLock()
 	defer kv.mu.RUnlock()
 
-	fmt.Println("Current State:")
+	fmt.Fprintln(w, "Current State:")
 	if len(kv.data) == 0 {
-		fmt.Println("  (empty)")
+		fmt.Fprintln(w, "  (empty)")
 	} else {
-		for k, v := range kv.data {
-			fmt.Printf("  %s: %d\n", k, v)
+		for node := kv.index.first(); node != nil; node = node.next[0] {
+			fmt.Fprintf(w, "  %s: %v\n", node.key, kv.data[node.key])
 		}
 	}
-	fmt.Printf("Checkpoints: %d\n", len(kv.checkpoints))
+	fmt.Fprintf(w, "Checkpoints: %d\n", len(kv.checkpoints))
 }
@@ -9,7 +9,7 @@ import (
 
 // TestPutAndGet tests basic put and get operations
 func TestPutAndGet(t *testing.T) {
-	kv := NewKVStore()
+	kv := NewKVStore[int]()
 
 	// Test putting and getting a value
 	kv.Put("name", 100)
@@ -32,7 +32,7 @@ func TestPutAndGet(t *testing.T) {
 
 // TestPutOverwrite tests overwriting existing keys
 func TestPutOverwrite(t *testing.T) {
-	kv := NewKVStore()
+	kv := NewKVStore[int]()
 
 	kv.Put("key", 10)
 	kv.Put("key", 20)
@@ -61,7 +61,7 @@ func TestPutOverwrite(t *testing.T) {
 
 // TestCountValue tests value counting functionality
 func TestCountValue(t *testing.T) {
-	kv := NewKVStore()
+	kv := NewKVStore[int]()
 
 	kv.Put("user1", 1)
 	kv.Put("user2", 1)
@@ -101,14 +101,14 @@ func TestCountValue(t *testing.T) {
 
 // TestCheckpointAndRevert tests checkpoint and revert functionality
 func TestCheckpointAndRevert(t *testing.T) {
-	kv := NewKVStore()
+	kv := NewKVStore[int]()
 
 	// Set initial data
 	kv.Put("key1", 10)
 	kv.Put("key2", 20)
 
 	// Create checkpoint
-	kv.Checkpoint()
+	kv.Checkpoint("")
 
 	if kv.GetCheckpointCount() != 1 {
 		t.Errorf("Expected 1 checkpoint, got %d", kv.GetCheckpointCount())
@@ -149,13 +149,13 @@ func TestCheckpointAndRevert(t *testing.T) {
 
 // TestMultipleCheckpoints tests multiple levels of checkpoints
 func TestMultipleCheckpoints(t *testing.T) {
-	kv := NewKVStore()
+	kv := NewKVStore[int]()
 
 	kv.Put("state", 11)
-	kv.Checkpoint()
+	kv.Checkpoint("")
 
 	kv.Put("state", 22)
-	kv.Checkpoint()
+	kv.Checkpoint("")
 
 	kv.Put("state", 33)
 
@@ -184,7 +184,7 @@ func TestMultipleCheckpoints(t *testing.T) {
 
 // TestRevertWithoutCheckpoint tests reverting with no checkpoints
 func TestRevertWithoutCheckpoint(t *testing.T) {
-	kv := NewKVStore()
+	kv := NewKVStore[int]()
 
 	err := kv.Revert()
 	if err == nil {
@@ -194,11 +194,11 @@ func TestRevertWithoutCheckpoint(t *testing.T) {
 
 // TestSaveAndLoad tests disk persistence
 func TestSaveAndLoad(t *testing.T) {
-	kv1 := NewKVStore()
+	kv1 := NewKVStore[int]()
 
 	kv1.Put("persistent", 777)
 	kv1.Put("saved", 1)
-	kv1.Checkpoint()
+	kv1.Checkpoint("")
 	kv1.Put("another", 50)
 
 	filename := "/tmp/kv_store_test_unit.json"
@@ -211,7 +211,7 @@ func TestSaveAndLoad(t *testing.T) {
 	}
 
 	// Load into new store
-	kv2 := NewKVStore()
+	kv2 := NewKVStore[int]()
 	err = kv2.LoadFromDisk(filename)
 	if err != nil {
 		t.Fatalf("LoadFromDisk failed: %v", err)
@@ -237,7 +237,7 @@ func TestSaveAndLoad(t *testing.T) {
 
 // TestGetAllData tests the GetAllData method
 func TestGetAllData(t *testing.T) {
-	kv := NewKVStore()
+	kv := NewKVStore[int]()
 
 	kv.Put("key1", 10)
 	kv.Put("key2", 10)
@@ -267,7 +267,7 @@ func TestGetAllData(t *testing.T) {
 
 // TestConcurrentPut tests concurrent put operations
 func TestConcurrentPut(t *testing.T) {
-	kv := NewKVStore()
+	kv := NewKVStore[int]()
 	var wg sync.WaitGroup
 
 	numGoroutines := 10
@@ -297,7 +297,7 @@ func TestConcurrentPut(t *testing.T) {
 
 // TestConcurrentGetAndPut tests concurrent reads and writes
 func TestConcurrentGetAndPut(t *testing.T) {
-	kv := NewKVStore()
+	kv := NewKVStore[int]()
 	var wg sync.WaitGroup
 
 	// Pre-populate with some data
@@ -339,7 +339,7 @@ func TestConcurrentGetAndPut(t *testing.T) {
 
 // TestConcurrentCheckpoint tests concurrent checkpoint operations
 func TestConcurrentCheckpoint(t *testing.T) {
-	kv := NewKVStore()
+	kv := NewKVStore[int]()
 	var wg sync.WaitGroup
 
 	kv.Put("key", 50)
@@ -350,7 +350,7 @@ func TestConcurrentCheckpoint(t *testing.T) {
 	for i := 0; i < numCheckpoints; i++ {
 		go func() {
 			defer wg.Done()
-			kv.Checkpoint()
+			kv.Checkpoint("")
 		}()
 	}
 
@@ -364,7 +364,7 @@ func TestConcurrentCheckpoint(t *testing.T) {
 
 // TestEmptyStore tests operations on empty store
 func TestEmptyStore(t *testing.T) {
-	kv := NewKVStore()
+	kv := NewKVStore[int]()
 
 	// Get from empty store
 	_, exists := kv.Get("key")
@@ -392,13 +392,13 @@ func TestEmptyStore(t *testing.T) {
 
 // TestCheckpointPreservesValueCounts tests that checkpoints preserve value counts correctly
 func TestCheckpointPreservesValueCounts(t *testing.T) {
-	kv := NewKVStore()
+	kv := NewKVStore[int]()
 
 	kv.Put("k1", 5)
 	kv.Put("k2", 5)
 	kv.Put("k3", 6)
 
-	kv.Checkpoint()
+	kv.Checkpoint("")
 
 	// Change values
 	kv.Put("k1", 6)
@@ -427,7 +427,7 @@ func TestCheckpointPreservesValueCounts(t *testing.T) {
 
 // TestLoadInvalidFile tests loading from non-existent or invalid file
 func TestLoadInvalidFile(t *testing.T) {
-	kv := NewKVStore()
+	kv := NewKVStore[int]()
 
 	// Test non-existent file
 	err := kv.LoadFromDisk("/nonexistent/path/file.json")
@@ -448,7 +448,7 @@ func TestLoadInvalidFile(t *testing.T) {
 
 // BenchmarkPut benchmarks put operations
 func BenchmarkPut(b *testing.B) {
-	kv := NewKVStore()
+	kv := NewKVStore[int]()
 	for i := 0; i < b.N; i++ {
 		kv.Put(fmt.Sprintf("key_%d", i), i)
 	}
@@ -456,7 +456,7 @@ func BenchmarkPut(b *testing.B) {
 
 // BenchmarkGet benchmarks get operations
 func BenchmarkGet(b *testing.B) {
-	kv := NewKVStore()
+	kv := NewKVStore[int]()
 	// Pre-populate
 	for i := 0; i < 1000; i++ {
 		kv.Put(fmt.Sprintf("key_%d", i), i)
@@ -470,7 +470,7 @@ func BenchmarkGet(b *testing.B) {
 
 // BenchmarkCountValue benchmarks value counting
 func BenchmarkCountValue(b *testing.B) {
-	kv := NewKVStore()
+	kv := NewKVStore[int]()
 	// Pre-populate
 	for i := 0; i < 1000; i++ {
 		kv.Put(fmt.Sprintf("key_%d", i), 42)
@@ -484,7 +484,7 @@ func BenchmarkCountValue(b *testing.B) {
 
 // BenchmarkCheckpoint benchmarks checkpoint creation
 func BenchmarkCheckpoint(b *testing.B) {
-	kv := NewKVStore()
+	kv := NewKVStore[int]()
 	// Pre-populate
 	for i := 0; i < 100; i++ {
 		kv.Put(fmt.Sprintf("key_%d", i), i)
@@ -492,27 +492,27 @@ func BenchmarkCheckpoint(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		kv.Checkpoint()
+		kv.Checkpoint("")
 	}
 }
 
 // BenchmarkRevert benchmarks revert operations
 func BenchmarkRevert(b *testing.B) {
-	kv := NewKVStore()
+	kv := NewKVStore[int]()
 	// Pre-populate and checkpoint
 	for i := 0; i < 100; i++ {
 		kv.Put(fmt.Sprintf("key_%d", i), i)
 	}
 
 	for i := 0; i < b.N; i++ {
-		kv.Checkpoint()
+		kv.Checkpoint("")
 	}
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		kv.Revert()
 		if i < b.N-1 {
-			kv.Checkpoint() // Re-checkpoint for next iteration
+			kv.Checkpoint("") // Re-checkpoint for next iteration
 		}
 	}
 }
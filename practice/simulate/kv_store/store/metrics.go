@@ -0,0 +1,219 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// op is an operation timed by the metrics
+type op int
+
+const (
+	opGet op = iota
+	opPut
+	opDelete
+	opScan
+	opCheckpoint
+	opRevert
+	opRelease
+	opBulkLoad
+	opCommit
+	opSave
+	opLoad
+	numOps
+)
+
+// opNames are the op labels of kv_operation_duration_seconds
+var opNames = [numOps]string{"get", "put", "delete", "scan", "checkpoint", "revert", "release", "bulk_load", "commit", "save", "load"}
+
+// Lock modes, the mode labels of kv_lock_wait_seconds
+const (
+	lockRead = iota
+	lockWrite
+)
+
+// Bucket bounds: durations in nanoseconds, delta sizes in keys
+var (
+	durationBuckets = []int64{1e3, 5e3, 1e4, 5e4, 1e5, 5e5, 1e6, 5e6, 1e7, 5e7, 1e8, 5e8, 1e9}
+	deltaBuckets    = []int64{0, 1, 10, 100, 1e3, 1e4, 1e5}
+)
+
+// histogram counts observations into buckets, without locking
+type histogram struct {
+	bounds []int64         // Upper bound of each bucket, ascending
+	counts []atomic.Uint64 // Observations per bucket, the last one unbounded
+	sum    atomic.Int64
+}
+
+func newHistogram(bounds []int64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]atomic.Uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v int64) {
+	i := 0
+	for i < len(h.bounds) && v > h.bounds[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(v)
+}
+
+// metrics are the histograms kept once EnableMetrics is called
+type metrics struct {
+	ops       [numOps]*histogram
+	lockWait  [2]*histogram // By lock mode
+	deltaKeys *histogram    // Keys recorded by each checkpoint's delta
+}
+
+// EnableMetrics starts timing operations and lock waits and measuring
+// checkpoint deltas, for WriteMetrics. Calling it again does nothing.
+func (kv *KVStore[V]) EnableMetrics() {
+	m := &metrics{deltaKeys: newHistogram(deltaBuckets)}
+	for i := range m.ops {
+		m.ops[i] = newHistogram(durationBuckets)
+	}
+	for i := range m.lockWait {
+		m.lockWait[i] = newHistogram(durationBuckets)
+	}
+	kv.metrics.CompareAndSwap(nil, m)
+}
+
+// lockOp takes mu for writing, timing the wait if metrics are enabled. It
+// returns when the operation started, for unlockOp (zero if not timed).
+func (kv *KVStore[V]) lockOp() time.Time {
+	m := kv.metrics.Load()
+	if m == nil {
+		kv.mu.Lock()
+		return time.Time{}
+	}
+	start := time.Now()
+	kv.mu.Lock()
+	m.lockWait[lockWrite].observe(int64(time.Since(start)))
+	return start
+}
+
+// rlockOp is lockOp for reading
+func (kv *KVStore[V]) rlockOp() time.Time {
+	m := kv.metrics.Load()
+	if m == nil {
+		kv.mu.RLock()
+		return time.Time{}
+	}
+	start := time.Now()
+	kv.mu.RLock()
+	m.lockWait[lockRead].observe(int64(time.Since(start)))
+	return start
+}
+
+// unlockOp releases the write lock taken by lockOp and times o
+func (kv *KVStore[V]) unlockOp(o op, start time.Time) {
+	kv.mu.Unlock()
+	kv.observeOp(o, start)
+}
+
+// runlockOp releases the read lock taken by rlockOp and times o
+func (kv *KVStore[V]) runlockOp(o op, start time.Time) {
+	kv.mu.RUnlock()
+	kv.observeOp(o, start)
+}
+
+// observeOp records that o, begun at start, has finished
+func (kv *KVStore[V]) observeOp(o op, start time.Time) {
+	if start.IsZero() {
+		return
+	}
+	if m := kv.metrics.Load(); m != nil {
+		m.ops[o].observe(int64(time.Since(start)))
+	}
+}
+
+// observeDeltaLocked records the size of a new checkpoint's delta; the
+// caller must hold mu
+func (kv *KVStore[V]) observeDeltaLocked(delta *DeltaSnapshot[V]) {
+	if m := kv.metrics.Load(); m != nil {
+		m.deltaKeys.observe(int64(len(delta.ChangedKeys) + len(delta.DeletedKeys)))
+	}
+}
+
+// WriteMetrics writes the store's metrics in the Prometheus text format:
+//
+//	kv_keys, kv_checkpoint_depth, kv_pending_delta_keys   gauges
+//	kv_hits_total, kv_misses_total, kv_evictions_total    counters
+//	kv_operation_duration_seconds{op}                     histogram
+//	kv_lock_wait_seconds{mode="read"|"write"}             histogram
+//	kv_checkpoint_delta_keys                              histogram
+//
+// The histograms are written only once EnableMetrics has been called; their
+// _count series count the operations.
+func (kv *KVStore[V]) WriteMetrics(w io.Writer) error {
+	kv.mu.RLock()
+	keys, depth, pending := len(kv.data), len(kv.checkpoints), len(kv.tracking)
+	evictions := kv.evictions
+	kv.mu.RUnlock()
+
+	var b strings.Builder
+	writeMetric(&b, "kv_keys", "gauge", "Keys in the store, including expired keys not yet removed", keys)
+	writeMetric(&b, "kv_checkpoint_depth", "gauge", "Checkpoints on the stack", depth)
+	writeMetric(&b, "kv_pending_delta_keys", "gauge", "Keys changed since the newest checkpoint", pending)
+	writeMetric(&b, "kv_hits_total", "counter", "Gets that found the key", kv.hits.Load())
+	writeMetric(&b, "kv_misses_total", "counter", "Gets that didn't find the key", kv.misses.Load())
+	writeMetric(&b, "kv_evictions_total", "counter", "Keys evicted to stay within the capacity", evictions)
+
+	if m := kv.metrics.Load(); m != nil {
+		const seconds = 1e9 // Nanoseconds per second
+		writeHeader(&b, "kv_operation_duration_seconds", "histogram", "Time taken by operations, including waiting for the lock")
+		for o, h := range m.ops {
+			writeHistogram(&b, "kv_operation_duration_seconds", `op="`+opNames[o]+`"`, h, seconds)
+		}
+		writeHeader(&b, "kv_lock_wait_seconds", "histogram", "Time spent waiting for the store's lock")
+		writeHistogram(&b, "kv_lock_wait_seconds", `mode="read"`, m.lockWait[lockRead], seconds)
+		writeHistogram(&b, "kv_lock_wait_seconds", `mode="write"`, m.lockWait[lockWrite], seconds)
+		writeHeader(&b, "kv_checkpoint_delta_keys", "histogram", "Keys each checkpoint's delta records")
+		writeHistogram(&b, "kv_checkpoint_delta_keys", "", m.deltaKeys, 1)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeHeader writes the HELP and TYPE lines of a metric
+func writeHeader(b *strings.Builder, name, typ, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// writeMetric writes a metric with one unlabelled sample
+func writeMetric[N int | uint64](b *strings.Builder, name, typ, help string, value N) {
+	writeHeader(b, name, typ, help)
+	fmt.Fprintf(b, "%s %d\n", name, value)
+}
+
+// writeHistogram writes the samples of one histogram, with labels (which
+// may be empty) and its observations divided by unit
+func writeHistogram(b *strings.Builder, name, labels string, h *histogram, unit float64) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	var count uint64
+	for i := range h.counts {
+		count += h.counts[i].Load()
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = formatFloat(float64(h.bounds[i]) / unit)
+		}
+		fmt.Fprintf(b, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, sep, le, count)
+	}
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(b, "%s_sum%s %s\n", name, labels, formatFloat(float64(h.sum.Load())/unit))
+	fmt.Fprintf(b, "%s_count%s %d\n", name, labels, count)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
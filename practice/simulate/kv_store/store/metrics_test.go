@@ -0,0 +1,104 @@
+package store
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+// metricLines returns kv's metrics, one sample or comment per line
+func metricLines(t *testing.T, kv *KVStore[int]) map[string]bool {
+	t.Helper()
+	var b strings.Builder
+	if err := kv.WriteMetrics(&b); err != nil {
+		t.Fatalf("WriteMetrics failed: %v", err)
+	}
+	lines := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n") {
+		lines[line] = true
+	}
+	return lines
+}
+
+// TestWriteMetrics tests the gauges, counters and histograms after a few
+// operations
+func TestWriteMetrics(t *testing.T) {
+	kv := NewKVStore[int]()
+	kv.EnableMetrics()
+	kv.Put("a", 1)
+	kv.Put("b", 2)
+	kv.Get("a")
+	kv.Get("missing")
+	kv.Checkpoint("")
+	kv.Put("c", 3)
+	kv.Checkpoint("")
+	kv.Delete("a")
+
+	lines := metricLines(t, kv)
+	for _, want := range []string{
+		"# TYPE kv_keys gauge",
+		"kv_keys 2",
+		"kv_checkpoint_depth 2",
+		"kv_pending_delta_keys 1",
+		"kv_hits_total 1",
+		"kv_misses_total 1",
+		"# TYPE kv_operation_duration_seconds histogram",
+		`kv_operation_duration_seconds_count{op="put"} 3`,
+		`kv_operation_duration_seconds_count{op="get"} 2`,
+		`kv_operation_duration_seconds_count{op="delete"} 1`,
+		`kv_operation_duration_seconds_count{op="checkpoint"} 2`,
+		`kv_operation_duration_seconds_bucket{op="put",le="+Inf"} 3`,
+		`kv_operation_duration_seconds_count{op="revert"} 0`,
+		`kv_lock_wait_seconds_count{mode="read"} 2`,
+		`kv_lock_wait_seconds_count{mode="write"} 6`,
+		// Deltas of 0 keys (nothing before the first checkpoint) and 1 key (c)
+		`kv_checkpoint_delta_keys_bucket{le="0"} 1`,
+		`kv_checkpoint_delta_keys_bucket{le="1"} 2`,
+		"kv_checkpoint_delta_keys_sum 1",
+		"kv_checkpoint_delta_keys_count 2",
+	} {
+		if !lines[want] {
+			t.Errorf("Expected the line %q in the metrics", want)
+		}
+	}
+	found := false
+	for line := range lines {
+		found = found || strings.HasPrefix(line, `kv_operation_duration_seconds_bucket{op="put",le="1e-06"} `)
+	}
+	if !found {
+		t.Errorf("Expected bucket bounds in seconds")
+	}
+}
+
+// TestWriteMetricsDisabled tests that only the gauges and counters are
+// written without EnableMetrics
+func TestWriteMetricsDisabled(t *testing.T) {
+	kv := NewKVStore[int]()
+	kv.Put("a", 1)
+	for line := range metricLines(t, kv) {
+		if strings.Contains(line, "histogram") || strings.Contains(line, "_bucket") {
+			t.Errorf("Expected no histograms, got %q", line)
+		}
+	}
+}
+
+// TestMetricsConcurrent tests that operations are counted exactly under
+// concurrency (run with -race)
+func TestMetricsConcurrent(t *testing.T) {
+	kv := NewKVStore[int]()
+	kv.EnableMetrics()
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			for i := range 100 {
+				kv.Put("k", i)
+				kv.Get("k")
+			}
+		})
+	}
+	wg.Wait()
+	lines := metricLines(t, kv)
+	if !lines[`kv_operation_duration_seconds_count{op="put"} 800`] || !lines[`kv_operation_duration_seconds_count{op="get"} 800`] {
+		t.Errorf("Expected 800 puts and gets to be counted")
+	}
+}
@@ -0,0 +1,306 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Errors returned by GetAt and SnapshotAt
+var (
+	ErrNoVersions        = errors.New("versions not enabled")
+	ErrVersionCollected  = errors.New("version garbage collected")
+	ErrVersionNotWritten = errors.New("version not written yet")
+)
+
+// Version numbers the states of a store. It goes up by one for every key a
+// write changes, so a Put is one version and a Revert or BulkLoad is one per
+// key; read it with Version, or from a checkpoint, to get a state between
+// operations.
+type Version uint64
+
+// versionNode is one value a key has held, from version on. Nodes are
+// immutable except for older, which GC cuts.
+type versionNode[V comparable] struct {
+	version Version
+	value   V
+	deleted bool // The key was removed at version
+	older   atomic.Pointer[versionNode[V]]
+}
+
+// versionChain is the history of one key, newest first
+type versionChain[V comparable] = atomic.Pointer[versionNode[V]]
+
+// versionHistory holds every key's versions since EnableVersions. Writers
+// add to it under mu; readers walk it without any lock.
+type versionHistory[V comparable] struct {
+	chains sync.Map      // key -> *versionChain[V]
+	floor  atomic.Uint64 // Oldest version still readable; raised before versions are cut
+
+	pinMu sync.Mutex      // Serializes pins with raising floor
+	pins  map[Version]int // Versions held by open Snapshots -> how many
+}
+
+// EnableVersions starts keeping the history of every key, so GetAt and
+// SnapshotAt can read past states without reverting. History starts at the
+// current version; calling it again does nothing.
+//
+// Versions are kept back to the oldest checkpoint (or open Snapshot) that
+// may need them. Each write drops its key's older versions; GCVersions drops
+// everyone else's. History is kept in memory only.
+func (kv *KVStore[V]) EnableVersions() {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if kv.history.Load() != nil {
+		return
+	}
+	h := &versionHistory[V]{pins: make(map[Version]int)}
+	current := Version(kv.version.Load())
+	h.floor.Store(uint64(current))
+	for key, value := range kv.data {
+		chain := new(versionChain[V])
+		chain.Store(&versionNode[V]{version: current, value: value})
+		h.chains.Store(key, chain)
+	}
+	kv.history.Store(h)
+}
+
+// Version returns the current version: the state after the last completed
+// write
+func (kv *KVStore[V]) Version() Version {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	return Version(kv.version.Load())
+}
+
+// GetAt returns the value key had at version, without blocking writers. It
+// fails with ErrVersionCollected once GC has dropped version (hold it with
+// SnapshotAt to keep it) and ErrVersionNotWritten for a future version.
+func (kv *KVStore[V]) GetAt(key string, version Version) (V, bool, error) {
+	var zero V
+	h := kv.history.Load()
+	if h == nil {
+		return zero, false, ErrNoVersions
+	}
+	if version > Version(kv.version.Load()) {
+		return zero, false, fmt.Errorf("%w: %d", ErrVersionNotWritten, version)
+	}
+	value, exists := h.get(key, version)
+	// Checked after the walk: floor is raised before versions are cut, so a
+	// walk that missed a cut version sees the new floor here
+	if Version(h.floor.Load()) > version {
+		return zero, false, fmt.Errorf("%w: %d", ErrVersionCollected, version)
+	}
+	return value, exists, nil
+}
+
+// get walks key's chain to its value at version
+func (h *versionHistory[V]) get(key string, version Version) (V, bool) {
+	var zero V
+	chain, ok := h.chains.Load(key)
+	if !ok {
+		return zero, false
+	}
+	node := chain.(*versionChain[V]).Load()
+	for node != nil && node.version > version {
+		node = node.older.Load()
+	}
+	if node == nil || node.deleted {
+		return zero, false
+	}
+	return node.value, true
+}
+
+// Snapshot is a read-only view of a store at one version. Its reads take no
+// lock, so they never block writers, and see nothing written after its
+// version. It holds its version back from GC until Close.
+type Snapshot[V comparable] struct {
+	version Version
+	history *versionHistory[V]
+	closed  atomic.Bool
+}
+
+// SnapshotAt returns a view of the store at version, which must not have
+// been garbage collected yet. Close it when done.
+func (kv *KVStore[V]) SnapshotAt(version Version) (*Snapshot[V], error) {
+	h := kv.history.Load()
+	if h == nil {
+		return nil, ErrNoVersions
+	}
+	if version > Version(kv.version.Load()) {
+		return nil, fmt.Errorf("%w: %d", ErrVersionNotWritten, version)
+	}
+
+	h.pinMu.Lock()
+	defer h.pinMu.Unlock()
+	if Version(h.floor.Load()) > version {
+		return nil, fmt.Errorf("%w: %d", ErrVersionCollected, version)
+	}
+	h.pins[version]++
+	return &Snapshot[V]{version: version, history: h}, nil
+}
+
+// Version returns the version the snapshot views
+func (s *Snapshot[V]) Version() Version {
+	return s.version
+}
+
+// Get returns the value key had at the snapshot's version
+func (s *Snapshot[V]) Get(key string) (V, bool) {
+	return s.history.get(key, s.version)
+}
+
+// Scan returns an iterator over the keys in [start, end) at the snapshot's
+// version, in ascending order. An empty end means no upper bound. Like
+// KVStore.Scan it includes keys whose TTL had passed but which hadn't been
+// removed yet.
+func (s *Snapshot[V]) Scan(start, end string) iter.Seq2[string, V] {
+	return func(yield func(string, V) bool) {
+		var entries []scanEntry[V]
+		s.history.chains.Range(func(k, _ any) bool {
+			key := k.(string)
+			if key >= start && (end == "" || key < end) {
+				if value, ok := s.history.get(key, s.version); ok {
+					entries = append(entries, scanEntry[V]{key, value})
+				}
+			}
+			return true
+		})
+		slices.SortFunc(entries, func(a, b scanEntry[V]) int { return strings.Compare(a.key, b.key) })
+
+		for _, entry := range entries {
+			if !yield(entry.key, entry.value) {
+				return
+			}
+		}
+	}
+}
+
+// Close releases the snapshot's version for GC. Reading a closed snapshot
+// is a bug; calling Close again does nothing.
+func (s *Snapshot[V]) Close() {
+	if !s.closed.CompareAndSwap(false, true) {
+		return
+	}
+	h := s.history
+	h.pinMu.Lock()
+	defer h.pinMu.Unlock()
+	if h.pins[s.version]--; h.pins[s.version] == 0 {
+		delete(h.pins, s.version)
+	}
+}
+
+// GCVersions drops every version older than the oldest live checkpoint and
+// open Snapshot (or than the current version, if there are neither) and
+// returns how many it dropped. Writes already drop their own key's old
+// versions, so this reclaims keys that haven't been written since.
+func (kv *KVStore[V]) GCVersions() int {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	h := kv.history.Load()
+	if h == nil {
+		return 0
+	}
+	floor := kv.raiseFloorLocked(h)
+	dropped := 0
+	h.chains.Range(func(k, chain any) bool {
+		dropped += h.prune(k.(string), chain.(*versionChain[V]), floor)
+		return true
+	})
+	return dropped
+}
+
+// recordVersionLocked counts a change to key and adds it to the history, if
+// enabled; the caller must hold mu. The new version is published only once
+// its node is linked, so GetAt never accepts a version it can't see yet.
+func (kv *KVStore[V]) recordVersionLocked(key string, value V, deleted bool) {
+	version := Version(kv.version.Load() + 1) // Writers are serialized by mu
+	h := kv.history.Load()
+	if h == nil {
+		kv.version.Store(uint64(version))
+		return
+	}
+	chain := h.chainLocked(key)
+	node := &versionNode[V]{version: version, value: value, deleted: deleted}
+	node.older.Store(chain.Load())
+	chain.Store(node)
+	kv.version.Store(uint64(version))
+	h.prune(key, chain, kv.raiseFloorLocked(h))
+}
+
+// recordLoadLocked adds the keys LoadFromDisk is about to change from
+// kv.data to data to the history; the caller must hold mu
+func (kv *KVStore[V]) recordLoadLocked(data map[string]V) {
+	for key, oldValue := range kv.data {
+		if _, exists := data[key]; !exists {
+			kv.recordVersionLocked(key, oldValue, true)
+		}
+	}
+	for key, newValue := range data {
+		if oldValue, existed := kv.data[key]; !existed || oldValue != newValue {
+			kv.recordVersionLocked(key, newValue, false)
+		}
+	}
+}
+
+// chainLocked returns key's chain, creating it if need be; the caller must
+// hold mu, so no other writer creates it at the same time
+func (h *versionHistory[V]) chainLocked(key string) *versionChain[V] {
+	if chain, ok := h.chains.Load(key); ok {
+		return chain.(*versionChain[V])
+	}
+	chain := new(versionChain[V])
+	h.chains.Store(key, chain)
+	return chain
+}
+
+// raiseFloorLocked moves the history's floor up to the oldest version still
+// needed and returns it; the caller must hold mu. Checkpoints loaded from a
+// snapshot file have no version and hold nothing back.
+func (kv *KVStore[V]) raiseFloorLocked(h *versionHistory[V]) Version {
+	floor := Version(kv.version.Load())
+	for _, delta := range kv.checkpoints {
+		if delta.Version != 0 {
+			floor = min(floor, delta.Version)
+			break // Oldest first
+		}
+	}
+
+	h.pinMu.Lock()
+	defer h.pinMu.Unlock()
+	for pinned := range h.pins {
+		floor = min(floor, pinned)
+	}
+	if floor > Version(h.floor.Load()) {
+		h.floor.Store(uint64(floor))
+	}
+	return Version(h.floor.Load())
+}
+
+// prune cuts the versions of key older than its value at floor, which must
+// already be the history's floor, and returns how many it dropped. A key
+// deleted by then loses its chain altogether.
+func (h *versionHistory[V]) prune(key string, chain *versionChain[V], floor Version) int {
+	node := chain.Load()
+	for node != nil && node.version > floor {
+		node = node.older.Load()
+	}
+	if node == nil {
+		return 0
+	}
+	dropped := 0
+	for older := node.older.Swap(nil); older != nil; older = older.older.Load() {
+		dropped++
+	}
+	if node.deleted && chain.Load() == node {
+		h.chains.Delete(key)
+		dropped++
+	}
+	return dropped
+}
@@ -0,0 +1,234 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+	"testing"
+)
+
+// TestGetAtCheckpoint tests reading a key as it was at a checkpoint, after
+// later writes and reverts
+func TestGetAtCheckpoint(t *testing.T) {
+	kv := NewKVStore[int]()
+	kv.EnableVersions()
+	kv.Put("a", 1)
+	kv.Put("b", 2)
+	kv.Checkpoint("one")
+	kv.Put("a", 10)
+	kv.Delete("b")
+	kv.Put("c", 3)
+	kv.Checkpoint("two")
+	kv.Put("a", 100)
+
+	infos := kv.ListCheckpoints()
+	one, two := infos[0].Version, infos[1].Version
+	if one != 2 || two != 5 {
+		t.Fatalf("Expected checkpoint versions 2 and 5, got %d and %d", one, two)
+	}
+	for _, tt := range []struct {
+		key     string
+		version Version
+		want    int
+		exists  bool
+	}{
+		{"a", one, 1, true}, {"b", one, 2, true}, {"c", one, 0, false},
+		{"a", two, 10, true}, {"b", two, 0, false}, {"c", two, 3, true},
+		{"a", kv.Version(), 100, true},
+	} {
+		v, ok, err := kv.GetAt(tt.key, tt.version)
+		if err != nil || v != tt.want || ok != tt.exists {
+			t.Errorf("Expected GetAt(%s, %d) = %d, %v, got %d, %v, %v", tt.key, tt.version, tt.want, tt.exists, v, ok, err)
+		}
+	}
+
+	// Reverting is a write: the old versions still read the same
+	kv.Revert()
+	if v, _, _ := kv.GetAt("a", kv.Version()); v != 10 {
+		t.Errorf("Expected a=10 now, got %d", v)
+	}
+	if v, _, err := kv.GetAt("a", two); v != 10 || err != nil {
+		t.Errorf("Expected a=10 at checkpoint two after reverting it, got %d, %v", v, err)
+	}
+	if v, _, _ := kv.GetAt("a", one); v != 1 {
+		t.Errorf("Expected a=1 at checkpoint one, got %d", v)
+	}
+}
+
+// TestGetAtErrors tests the versions GetAt refuses
+func TestGetAtErrors(t *testing.T) {
+	kv := NewKVStore[int]()
+	if _, _, err := kv.GetAt("a", 0); !errors.Is(err, ErrNoVersions) {
+		t.Errorf("Expected ErrNoVersions, got %v", err)
+	}
+	kv.Put("a", 1)
+	kv.EnableVersions()
+	if v, ok, err := kv.GetAt("a", 1); v != 1 || !ok || err != nil {
+		t.Errorf("Expected the data at EnableVersions to be readable, got %d, %v, %v", v, ok, err)
+	}
+	if _, _, err := kv.GetAt("a", 2); !errors.Is(err, ErrVersionNotWritten) {
+		t.Errorf("Expected ErrVersionNotWritten, got %v", err)
+	}
+
+	// Without checkpoints only the current version is kept
+	kv.Put("b", 2)
+	if _, _, err := kv.GetAt("a", 1); !errors.Is(err, ErrVersionCollected) {
+		t.Errorf("Expected ErrVersionCollected, got %v", err)
+	}
+	if _, err := kv.SnapshotAt(1); !errors.Is(err, ErrVersionCollected) {
+		t.Errorf("Expected SnapshotAt to refuse a collected version, got %v", err)
+	}
+}
+
+// TestSnapshotAt tests that a snapshot sees one version while the store
+// moves on, and holds it back from GC until closed
+func TestSnapshotAt(t *testing.T) {
+	kv := NewKVStore[int]()
+	kv.EnableVersions()
+	for i := range 5 {
+		kv.Put(fmt.Sprintf("k%d", i), i)
+	}
+	snap, err := kv.SnapshotAt(kv.Version())
+	if err != nil {
+		t.Fatalf("SnapshotAt failed: %v", err)
+	}
+	want := map[string]int{"k0": 0, "k1": 1, "k2": 2, "k3": 3, "k4": 4}
+
+	kv.Put("k0", 100)
+	kv.Delete("k1")
+	kv.Put("k5", 5)
+	kv.GCVersions()
+
+	if v, ok := snap.Get("k1"); !ok || v != 1 {
+		t.Errorf("Expected the snapshot to keep k1=1, got %d, %v", v, ok)
+	}
+	if got := maps.Collect(snap.Scan("", "")); !maps.Equal(got, want) {
+		t.Errorf("Expected scan %v, got %v", want, got)
+	}
+	var keys []string
+	for k := range snap.Scan("k1", "k3") {
+		keys = append(keys, k)
+	}
+	if fmt.Sprint(keys) != "[k1 k2]" {
+		t.Errorf("Expected [k1 k2] in order, got %v", keys)
+	}
+
+	snap.Close()
+	snap.Close()
+	if dropped := kv.GCVersions(); dropped != 3 {
+		t.Errorf("Expected GC to drop the old k0, k1 and the deleted k1, got %d", dropped)
+	}
+	if _, _, err := kv.GetAt("k0", snap.Version()); !errors.Is(err, ErrVersionCollected) {
+		t.Errorf("Expected the closed snapshot's version to be collected, got %v", err)
+	}
+}
+
+// TestGCVersions tests that GC keeps what the oldest checkpoint needs, and
+// drops it once the checkpoint is gone
+func TestGCVersions(t *testing.T) {
+	kv := NewKVStore[int]()
+	kv.EnableVersions()
+	kv.Put("a", 1)
+	kv.Put("b", 1)
+	id := kv.Checkpoint("")
+	at := kv.ListCheckpoints()[0].Version
+	for i := 2; i <= 10; i++ {
+		kv.Put("a", i)
+	}
+	kv.Put("b", 2)
+	if dropped := kv.GCVersions(); dropped != 0 {
+		t.Errorf("Expected nothing to collect while the checkpoint lives, dropped %d", dropped)
+	}
+	if v, _, _ := kv.GetAt("a", at); v != 1 {
+		t.Errorf("Expected a=1 at the checkpoint, got %d", v)
+	}
+
+	kv.ReleaseCheckpoint(id)
+	if dropped := kv.GCVersions(); dropped != 9+1 {
+		t.Errorf("Expected 10 old versions dropped, got %d", dropped)
+	}
+	if _, _, err := kv.GetAt("a", at); !errors.Is(err, ErrVersionCollected) {
+		t.Errorf("Expected ErrVersionCollected, got %v", err)
+	}
+	if v, _, err := kv.GetAt("a", kv.Version()); v != 10 || err != nil {
+		t.Errorf("Expected a=10 now, got %d, %v", v, err)
+	}
+}
+
+// TestVersionsLoad tests that LoadFromDisk is recorded like other writes
+func TestVersionsLoad(t *testing.T) {
+	file := t.TempDir() + "/store.json"
+	kv := NewKVStore[int]()
+	kv.Put("a", 1)
+	kv.SaveToDisk(file)
+
+	kv.EnableVersions()
+	kv.Put("a", 2)
+	kv.Put("b", 2)
+	kv.Checkpoint("")
+	before := kv.Version()
+	if err := kv.LoadFromDisk(file); err != nil {
+		t.Fatalf("LoadFromDisk failed: %v", err)
+	}
+	snap, err := kv.SnapshotAt(before)
+	if err != nil {
+		t.Fatalf("Expected the version before the load to be readable: %v", err)
+	}
+	defer snap.Close()
+	if got := maps.Collect(snap.Scan("", "")); len(got) != 2 || got["a"] != 2 {
+		t.Errorf("Expected a=2, b=2 before the load, got %v", got)
+	}
+	if _, ok, _ := kv.GetAt("b", kv.Version()); ok {
+		t.Errorf("Expected b to be gone after the load")
+	}
+}
+
+// TestVersionsConcurrent tests that readers of past versions see a fixed
+// state while writers carry on (run with -race)
+func TestVersionsConcurrent(t *testing.T) {
+	kv := NewKVStore[int]()
+	kv.EnableVersions()
+	for i := range 100 {
+		kv.Put(fmt.Sprintf("k%02d", i), 0)
+	}
+	snap, _ := kv.SnapshotAt(kv.Version())
+	defer snap.Close()
+
+	var wg sync.WaitGroup
+	for w := range 4 {
+		wg.Go(func() {
+			for i := range 500 {
+				key := fmt.Sprintf("k%02d", (w*31+i)%100)
+				if i%7 == 0 {
+					kv.Delete(key)
+				} else {
+					kv.Put(key, i)
+				}
+				if i%50 == 0 {
+					kv.GCVersions()
+				}
+			}
+		})
+	}
+	for range 4 {
+		wg.Go(func() {
+			for range 20 {
+				sum, n := 0, 0
+				for _, v := range snap.Scan("", "") {
+					sum += v
+					n++
+				}
+				if sum != 0 || n != 100 {
+					t.Errorf("Expected the snapshot's 100 zeros, got %d keys summing to %d", n, sum)
+					return
+				}
+				at := kv.Version()
+				if _, _, err := kv.GetAt("k00", at); err != nil && !errors.Is(err, ErrVersionCollected) {
+					t.Errorf("GetAt failed: %v", err)
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"iter"
+	"strings"
+)
+
+// newKeyIndex creates an empty sorted set of keys
+func newKeyIndex() *skipList[string, struct{}] {
+	return newSkipList[string, struct{}](strings.Compare)
+}
+
+// scanEntry is one key-value pair copied out of the store by a scan
+type scanEntry[V comparable] struct {
+	key   string
+	value V
+}
+
+// Scan returns an iterator over the keys in [start, end) in ascending order.
+// An empty end means no upper bound, so Scan("", "") visits every key.
+//
+// The range is copied under the read lock when iteration starts, so the
+// iterator sees a single consistent snapshot: writes made during the loop
+// (including by the loop body itself) are not visible to it. The copy costs
+// O(size of the range), so bound end rather than breaking out of a huge scan.
+func (kv *KVStore[V]) Scan(start, end string) iter.Seq2[string, V] {
+	return kv.scan(start, end, false)
+}
+
+// ScanReverse is like Scan but visits the keys in [start, end) in descending
+// order
+func (kv *KVStore[V]) ScanReverse(start, end string) iter.Seq2[string, V] {
+	return kv.scan(start, end, true)
+}
+
+// ScanPrefix returns an iterator over the keys that start with prefix, in
+// ascending order, with the same snapshot semantics as Scan
+func (kv *KVStore[V]) ScanPrefix(prefix string) iter.Seq2[string, V] {
+	return kv.scan(prefix, prefixEnd(prefix), false)
+}
+
+func (kv *KVStore[V]) scan(start, end string, reverse bool) iter.Seq2[string, V] {
+	return func(yield func(string, V) bool) {
+		began := kv.rlockOp()
+		entries := kv.scanLocked(start, end, reverse)
+		kv.runlockOp(opScan, began)
+
+		for _, entry := range entries {
+			if !yield(entry.key, entry.value) {
+				return
+			}
+		}
+	}
+}
+
+// scanLocked copies the live entries in [start, end); the caller must hold
+// mu (read or write). Expired keys are skipped but not removed.
+func (kv *KVStore[V]) scanLocked(start, end string, reverse bool) []scanEntry[V] {
+	var entries []scanEntry[V]
+	add := func(key string) {
+		if !kv.expiredLocked(key) {
+			entries = append(entries, scanEntry[V]{key: key, value: kv.data[key]})
+		}
+	}
+
+	if !reverse {
+		for node := kv.index.seek(start); node != nil; node = node.next[0] {
+			if end != "" && node.key >= end {
+				break
+			}
+			add(node.key)
+		}
+		return entries
+	}
+
+	node := kv.index.last()
+	if end != "" {
+		node = kv.index.seekBefore(end)
+	}
+	for ; node != nil && node.key >= start; node = node.prev {
+		add(node.key)
+	}
+	return entries
+}
+
+// prefixEnd returns the smallest key greater than every key with the given
+// prefix, or "" if there is none (the prefix is empty or all 0xff bytes)
+func prefixEnd(prefix string) string {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] < 0xff {
+			return prefix[:i] + string([]byte{prefix[i] + 1})
+		}
+	}
+	return ""
+}
@@ -0,0 +1,233 @@
+package store
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// collectKeys drains a scan into a slice of keys
+func collectKeys(seq func(func(string, int) bool)) []string {
+	var keys []string
+	for k := range seq {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// TestScan tests ascending and descending range scans
+func TestScan(t *testing.T) {
+	kv := NewKVStore[int]()
+	for i, key := range []string{"d", "b", "a", "e", "c"} {
+		kv.Put(key, i)
+	}
+
+	tests := []struct {
+		start, end string
+		want       []string
+	}{
+		{"", "", []string{"a", "b", "c", "d", "e"}},
+		{"b", "d", []string{"b", "c"}},
+		{"bb", "", []string{"c", "d", "e"}},
+		{"", "c", []string{"a", "b"}},
+		{"x", "", nil},
+	}
+	for _, tt := range tests {
+		got := collectKeys(kv.Scan(tt.start, tt.end))
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Scan(%q, %q): expected %v, got %v", tt.start, tt.end, tt.want, got)
+		}
+
+		reversed := slices.Clone(tt.want)
+		slices.Reverse(reversed)
+		got = collectKeys(kv.ScanReverse(tt.start, tt.end))
+		if !slices.Equal(got, reversed) {
+			t.Errorf("ScanReverse(%q, %q): expected %v, got %v", tt.start, tt.end, reversed, got)
+		}
+	}
+
+	for k, v := range kv.Scan("a", "b") {
+		if k != "a" || v != 2 {
+			t.Errorf("Expected a=2, got %s=%d", k, v)
+		}
+	}
+
+	// Breaking out early stops the iteration
+	count := 0
+	for range kv.Scan("", "") {
+		count++
+		if count == 2 {
+			break
+		}
+	}
+	if count != 2 {
+		t.Errorf("Expected to stop after 2 keys, got %d", count)
+	}
+}
+
+// TestScanPrefix tests prefix iteration
+func TestScanPrefix(t *testing.T) {
+	kv := NewKVStore[int]()
+	for _, key := range []string{"user:1", "user:2", "user:10", "users", "useq", "order:1", "user;"} {
+		kv.Put(key, 1)
+	}
+
+	got := collectKeys(kv.ScanPrefix("user:"))
+	want := []string{"user:1", "user:10", "user:2"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	if got := collectKeys(kv.ScanPrefix("")); len(got) != 7 {
+		t.Errorf("Expected empty prefix to visit all 7 keys, got %v", got)
+	}
+	if got := collectKeys(kv.ScanPrefix("nope")); got != nil {
+		t.Errorf("Expected no keys, got %v", got)
+	}
+
+	if end := prefixEnd("a\xff\xff"); end != "b" {
+		t.Errorf("Expected prefixEnd to skip 0xff bytes, got %q", end)
+	}
+	if end := prefixEnd("\xff"); end != "" {
+		t.Errorf("Expected no upper bound for all-0xff prefix, got %q", end)
+	}
+}
+
+// TestScanSnapshot tests that a scan is unaffected by writes during the loop
+func TestScanSnapshot(t *testing.T) {
+	kv := NewKVStore[int]()
+	kv.Put("a", 1)
+	kv.Put("b", 2)
+	kv.Put("c", 3)
+
+	var got []string
+	for k, v := range kv.Scan("", "") {
+		got = append(got, fmt.Sprintf("%s=%d", k, v))
+		kv.Delete("c")
+		kv.Put("bb", 0)
+		kv.Put("b", 20)
+	}
+	want := []string{"a=1", "b=2", "c=3"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected snapshot %v, got %v", want, got)
+	}
+
+	want = []string{"a", "b", "bb"}
+	if got := collectKeys(kv.Scan("", "")); !slices.Equal(got, want) {
+		t.Errorf("Expected %v after the loop, got %v", want, got)
+	}
+}
+
+// TestScanIndexSync tests that the index follows Revert, expiry and loads
+func TestScanIndexSync(t *testing.T) {
+	clock := newFakeClock()
+	kv := NewKVStore[int]()
+	kv.now = clock.Now
+
+	kv.Put("a", 1)
+	kv.Put("b", 2)
+	kv.Checkpoint("")
+	kv.Delete("a")
+	kv.Put("c", 3)
+	kv.PutWithTTL("d", 4, time.Second)
+
+	want := []string{"b", "c", "d"}
+	if got := collectKeys(kv.Scan("", "")); !slices.Equal(got, want) {
+		t.Errorf("Expected %v before revert, got %v", want, got)
+	}
+
+	clock.Advance(time.Second)
+	want = []string{"b", "c"}
+	if got := collectKeys(kv.Scan("", "")); !slices.Equal(got, want) {
+		t.Errorf("Expected expired key to be skipped, got %v", got)
+	}
+
+	if err := kv.Revert(); err != nil {
+		t.Fatalf("Revert failed: %v", err)
+	}
+	want = []string{"a", "b"}
+	if got := collectKeys(kv.Scan("", "")); !slices.Equal(got, want) {
+		t.Errorf("Expected %v after revert, got %v", want, got)
+	}
+
+	filename := filepath.Join(t.TempDir(), "scan.json")
+	kv.Put("z", 26)
+	if err := kv.SaveToDisk(filename); err != nil {
+		t.Fatalf("SaveToDisk failed: %v", err)
+	}
+	kv2 := NewKVStore[int]()
+	kv2.Put("stale", 0)
+	if err := kv2.LoadFromDisk(filename); err != nil {
+		t.Fatalf("LoadFromDisk failed: %v", err)
+	}
+	want = []string{"a", "b", "z"}
+	if got := collectKeys(kv2.Scan("", "")); !slices.Equal(got, want) {
+		t.Errorf("Expected %v after load, got %v", want, got)
+	}
+}
+
+// TestSkipListRandomized tests the skiplist against a sorted slice
+func TestSkipListRandomized(t *testing.T) {
+	s := newKeyIndex()
+	present := make(map[string]bool)
+	r := rand.New(rand.NewPCG(1, 2))
+
+	for i := 0; i < 5000; i++ {
+		key := fmt.Sprintf("k%03d", r.IntN(500))
+		if r.IntN(3) == 0 {
+			if s.remove(key) != present[key] {
+				t.Fatalf("remove(%s) disagreed with model", key)
+			}
+			delete(present, key)
+		} else {
+			if s.set(key, struct{}{}) == present[key] {
+				t.Fatalf("set(%s) disagreed with model", key)
+			}
+			present[key] = true
+		}
+	}
+
+	var want []string
+	for key := range present {
+		want = append(want, key)
+	}
+	sort.Strings(want)
+
+	var forward, backward []string
+	for node := s.first(); node != nil; node = node.next[0] {
+		forward = append(forward, node.key)
+	}
+	for node := s.last(); node != nil; node = node.prev {
+		backward = append(backward, node.key)
+	}
+	slices.Reverse(backward)
+
+	if s.length != len(want) || !slices.Equal(forward, want) || !slices.Equal(backward, want) {
+		t.Errorf("Expected %d sorted keys in both directions, got length=%d forward=%d backward=%d",
+			len(want), s.length, len(forward), len(backward))
+	}
+	if node := s.seek("k250"); node != nil && strings.Compare(node.key, "k250") < 0 {
+		t.Errorf("seek returned %s < k250", node.key)
+	}
+}
+
+// BenchmarkScan benchmarks a 100-key range scan over 100k keys
+func BenchmarkScan(b *testing.B) {
+	kv := NewKVStore[int]()
+	for i := 0; i < 100000; i++ {
+		kv.Put(fmt.Sprintf("key_%06d", i), i)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		from := (i * 7919) % 99900
+		start, end := fmt.Sprintf("key_%06d", from), fmt.Sprintf("key_%06d", from+100)
+		for range kv.Scan(start, end) {
+		}
+	}
+}
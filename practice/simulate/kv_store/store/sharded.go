@@ -0,0 +1,194 @@
+package store
+
+import (
+	"fmt"
+	"hash/maphash"
+	"runtime"
+	"time"
+)
+
+// ShardedKVStore spreads keys over several KVStores by hash, each with its
+// own lock, valueCount and checkpoint tracking, so writes to different
+// shards don't wait for each other.
+//
+// Single-key operations lock only their shard. Checkpoint, Revert and
+// RevertTo lock every shard (always in the same order) and apply to all of
+// them at once, so a checkpoint is a consistent cut across the whole store:
+// a write either happened before it on every shard or after it. CountValue,
+// GetAllData and ListCheckpoints read-lock every shard for the same reason.
+//
+// It has no persistence, WAL, scans, watches or transactions; use KVStore
+// for those.
+type ShardedKVStore[V comparable] struct {
+	shards []*KVStore[V]
+	seed   maphash.Seed
+}
+
+// NewShardedKVStore creates a store with n shards (4 per CPU if n <= 0)
+func NewShardedKVStore[V comparable](n int) *ShardedKVStore[V] {
+	if n <= 0 {
+		n = 4 * runtime.GOMAXPROCS(0)
+	}
+	s := &ShardedKVStore[V]{
+		shards: make([]*KVStore[V], n),
+		seed:   maphash.MakeSeed(),
+	}
+	for i := range s.shards {
+		s.shards[i] = NewKVStore[V]()
+	}
+	return s
+}
+
+// shard returns the shard that holds key
+func (s *ShardedKVStore[V]) shard(key string) *KVStore[V] {
+	return s.shards[maphash.String(s.seed, key)%uint64(len(s.shards))]
+}
+
+// ShardCount returns the number of shards
+func (s *ShardedKVStore[V]) ShardCount() int {
+	return len(s.shards)
+}
+
+// Put sets a key-value pair in the store
+func (s *ShardedKVStore[V]) Put(key string, value V) {
+	s.shard(key).Put(key, value)
+}
+
+// PutWithTTL sets a key-value pair that expires after ttl (see
+// KVStore.PutWithTTL)
+func (s *ShardedKVStore[V]) PutWithTTL(key string, value V, ttl time.Duration) {
+	s.shard(key).PutWithTTL(key, value, ttl)
+}
+
+// Get retrieves the value for a given key
+func (s *ShardedKVStore[V]) Get(key string) (V, bool) {
+	return s.shard(key).Get(key)
+}
+
+// Delete removes a key, returning true if it existed
+func (s *ShardedKVStore[V]) Delete(key string) bool {
+	return s.shard(key).Delete(key)
+}
+
+// CountValue returns the number of keys that have the given value, summed
+// over the shards at one instant
+func (s *ShardedKVStore[V]) CountValue(value V) int {
+	s.rlockAll()
+	defer s.runlockAll()
+
+	count := 0
+	for _, shard := range s.shards {
+		count += shard.valueCount[value]
+	}
+	return count
+}
+
+// GetAllData returns a copy of all key-value pairs and value counts
+func (s *ShardedKVStore[V]) GetAllData() (map[string]V, map[V]int) {
+	s.rlockAll()
+	defer s.runlockAll()
+
+	data := make(map[string]V)
+	counts := make(map[V]int)
+	for _, shard := range s.shards {
+		for k, v := range shard.data {
+			data[k] = v
+		}
+		for v, count := range shard.valueCount {
+			counts[v] += count
+		}
+	}
+	return data, counts
+}
+
+// Checkpoint takes a checkpoint of every shard at the same instant and
+// returns its ID (see KVStore.Checkpoint)
+func (s *ShardedKVStore[V]) Checkpoint(name string) CheckpointID {
+	s.lockAll()
+	defer s.unlockAll()
+
+	// Shards only ever checkpoint together, so they agree on the last ID
+	first := s.shards[0]
+	id, created := first.lastCheckpointID+1, first.now()
+	for _, shard := range s.shards {
+		shard.checkpointLocked(id, name, created)
+	}
+	return id
+}
+
+// Revert restores every shard to the last checkpoint
+func (s *ShardedKVStore[V]) Revert() error {
+	s.lockAll()
+	defer s.unlockAll()
+
+	if len(s.shards[0].checkpoints) == 0 {
+		return fmt.Errorf("no checkpoints to revert to")
+	}
+	for _, shard := range s.shards {
+		shard.revertLocked()
+	}
+	return nil
+}
+
+// RevertTo restores every shard to checkpoint id, removing it and every
+// later checkpoint (see KVStore.RevertTo)
+func (s *ShardedKVStore[V]) RevertTo(id CheckpointID) error {
+	s.lockAll()
+	defer s.unlockAll()
+
+	i := s.shards[0].checkpointIndexLocked(id)
+	if i < 0 {
+		return fmt.Errorf("%w: %d", ErrCheckpointNotFound, id)
+	}
+	for _, shard := range s.shards {
+		shard.revertToLocked(i)
+	}
+	return nil
+}
+
+// GetCheckpointCount returns the number of checkpoints
+func (s *ShardedKVStore[V]) GetCheckpointCount() int {
+	return s.shards[0].GetCheckpointCount()
+}
+
+// ListCheckpoints returns the checkpoints from oldest to newest, with
+// ChangedKeys summed over the shards
+func (s *ShardedKVStore[V]) ListCheckpoints() []CheckpointInfo {
+	s.rlockAll()
+	defer s.runlockAll()
+
+	infos := s.shards[0].listCheckpointsLocked()
+	for _, shard := range s.shards[1:] {
+		for i, info := range shard.listCheckpointsLocked() {
+			infos[i].ChangedKeys += info.ChangedKeys
+		}
+	}
+	return infos
+}
+
+// lockAll write-locks every shard in index order; every method that holds
+// more than one shard lock takes them in this order, so they can't deadlock
+func (s *ShardedKVStore[V]) lockAll() {
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+	}
+}
+
+func (s *ShardedKVStore[V]) unlockAll() {
+	for _, shard := range s.shards {
+		shard.mu.Unlock()
+	}
+}
+
+// rlockAll read-locks every shard in index order
+func (s *ShardedKVStore[V]) rlockAll() {
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+	}
+}
+
+func (s *ShardedKVStore[V]) runlockAll() {
+	for _, shard := range s.shards {
+		shard.mu.RUnlock()
+	}
+}
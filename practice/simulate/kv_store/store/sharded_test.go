@@ -0,0 +1,182 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"sync"
+	"testing"
+)
+
+// TestShardedKVStore checks the sharded store against a plain KVStore over
+// random operations, including checkpoints and reverts
+func TestShardedKVStore(t *testing.T) {
+	r := rand.New(rand.NewPCG(11, 11))
+	sharded := NewShardedKVStore[int](8)
+	model := NewKVStore[int]()
+
+	for step := 0; step < 5000; step++ {
+		key := fmt.Sprintf("k%d", r.IntN(50))
+		switch op := r.IntN(20); {
+		case op < 10:
+			v := r.IntN(5)
+			sharded.Put(key, v)
+			model.Put(key, v)
+		case op < 15:
+			if got, want := sharded.Delete(key), model.Delete(key); got != want {
+				t.Fatalf("Step %d: Delete(%s) = %v, expected %v", step, key, got, want)
+			}
+		case op < 17:
+			if got, want := sharded.Checkpoint(""), model.Checkpoint(""); got != want {
+				t.Fatalf("Step %d: Checkpoint = %d, expected %d", step, got, want)
+			}
+		case op < 19:
+			if got, want := sharded.Revert(), model.Revert(); (got == nil) != (want == nil) {
+				t.Fatalf("Step %d: Revert = %v, expected %v", step, got, want)
+			}
+		default:
+			if list := model.ListCheckpoints(); len(list) > 0 {
+				id := list[r.IntN(len(list))].ID
+				if err := sharded.RevertTo(id); err != nil {
+					t.Fatalf("Step %d: RevertTo(%d) failed: %v", step, id, err)
+				}
+				model.RevertTo(id)
+			}
+		}
+
+		if got, want := sharded.CountValue(1), model.CountValue(1); got != want {
+			t.Fatalf("Step %d: CountValue(1) = %d, expected %d", step, got, want)
+		}
+	}
+
+	data, counts := sharded.GetAllData()
+	wantData, wantCounts := model.GetAllData()
+	if !maps.Equal(data, wantData) || !maps.Equal(counts, wantCounts) {
+		t.Errorf("Expected %v %v, got %v %v", wantData, wantCounts, data, counts)
+	}
+	got, want := sharded.ListCheckpoints(), model.ListCheckpoints()
+	if len(got) != len(want) {
+		t.Fatalf("Expected checkpoints %+v, got %+v", want, got)
+	}
+	for i := range want {
+		if got[i].ID != want[i].ID || got[i].ChangedKeys != want[i].ChangedKeys {
+			t.Errorf("Checkpoint %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+	if err := sharded.RevertTo(9999); !errors.Is(err, ErrCheckpointNotFound) {
+		t.Errorf("Expected ErrCheckpointNotFound, got %v", err)
+	}
+}
+
+// TestShardedCheckpointConsistency tests that a checkpoint taken while
+// writers are running is a consistent cut across shards. Each writer sets
+// a<i> then b<i> to the same counter, so in any consistent state a<i> is
+// equal to b<i> or one ahead of it.
+func TestShardedCheckpointConsistency(t *testing.T) {
+	const writers = 8
+	kv := NewShardedKVStore[int](16)
+	for i := 0; i < writers; i++ {
+		kv.Put(fmt.Sprintf("a%d", i), 0)
+		kv.Put(fmt.Sprintf("b%d", i), 0)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a, b := fmt.Sprintf("a%d", i), fmt.Sprintf("b%d", i)
+			for n := 1; ; n++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				kv.Put(a, n)
+				kv.Put(b, n)
+			}
+		}()
+	}
+
+	var ids []CheckpointID
+	for len(ids) < 20 {
+		ids = append(ids, kv.Checkpoint(""))
+	}
+	close(stop)
+	wg.Wait()
+
+	for j := len(ids) - 1; j >= 0; j-- {
+		if err := kv.RevertTo(ids[j]); err != nil {
+			t.Fatalf("RevertTo failed: %v", err)
+		}
+		for i := 0; i < writers; i++ {
+			a, _ := kv.Get(fmt.Sprintf("a%d", i))
+			b, _ := kv.Get(fmt.Sprintf("b%d", i))
+			if a != b && a != b+1 {
+				t.Fatalf("Checkpoint %d: inconsistent cut, a%d=%d b%d=%d", ids[j], i, a, i, b)
+			}
+		}
+	}
+}
+
+// benchStore is the part of the KVStore API the parallel benchmarks use
+type benchStore interface {
+	Put(key string, value int)
+	Get(key string) (int, bool)
+}
+
+// benchParallel runs op from every benchmark goroutine against a plain
+// KVStore and a ShardedKVStore
+func benchParallel(b *testing.B, op func(kv benchStore, r *rand.Rand)) {
+	stores := []struct {
+		name string
+		kv   benchStore
+	}{
+		{"KVStore", NewKVStore[int]()},
+		{"Sharded", NewShardedKVStore[int](0)},
+	}
+	for _, s := range stores {
+		for i := 0; i < 10000; i++ {
+			s.kv.Put(fmt.Sprintf("key_%d", i), i)
+		}
+		b.Run(s.name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				r := rand.New(rand.NewPCG(rand.Uint64(), 0))
+				for pb.Next() {
+					op(s.kv, r)
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkParallelPut benchmarks concurrent writes
+func BenchmarkParallelPut(b *testing.B) {
+	benchParallel(b, func(kv benchStore, r *rand.Rand) {
+		kv.Put(benchKeys[r.IntN(len(benchKeys))], 1)
+	})
+}
+
+// BenchmarkParallelMixed benchmarks concurrent 90% reads, 10% writes
+func BenchmarkParallelMixed(b *testing.B) {
+	benchParallel(b, func(kv benchStore, r *rand.Rand) {
+		key := benchKeys[r.IntN(len(benchKeys))]
+		if r.IntN(10) == 0 {
+			kv.Put(key, 1)
+		} else {
+			kv.Get(key)
+		}
+	})
+}
+
+// benchKeys are preformatted so the benchmarks measure the stores, not
+// fmt.Sprintf
+var benchKeys = func() []string {
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key_%d", i)
+	}
+	return keys
+}()
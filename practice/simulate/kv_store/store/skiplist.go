@@ -0,0 +1,175 @@
+package store
+
+import "math/rand/v2"
+
+const (
+	skipListMaxLevel = 32
+	skipListP        = 0.25 // Probability of promoting a node one level up
+)
+
+// skipList is an ordered map from K to T. Nodes are doubly linked on the
+// bottom level so it can be walked in both directions.
+type skipList[K, T any] struct {
+	head   *skipNode[K, T]
+	tail   *skipNode[K, T] // Last node (nil when empty)
+	level  int             // Highest level currently in use
+	length int
+	cmp    func(a, b K) int
+}
+
+type skipNode[K, T any] struct {
+	key   K
+	value T
+	next  []*skipNode[K, T]
+	prev  *skipNode[K, T] // Previous node on level 0 (nil for the first node)
+}
+
+func newSkipList[K, T any](cmp func(a, b K) int) *skipList[K, T] {
+	return &skipList[K, T]{
+		head:  &skipNode[K, T]{next: make([]*skipNode[K, T], skipListMaxLevel)},
+		level: 1,
+		cmp:   cmp,
+	}
+}
+
+func randomSkipLevel() int {
+	level := 1
+	for level < skipListMaxLevel && rand.Float64() < skipListP {
+		level++
+	}
+	return level
+}
+
+// findPath fills update with the last node before key on every level and
+// returns the first node >= key
+func (s *skipList[K, T]) findPath(key K, update []*skipNode[K, T]) *skipNode[K, T] {
+	x := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && s.cmp(x.next[i].key, key) < 0 {
+			x = x.next[i]
+		}
+		if update != nil {
+			update[i] = x
+		}
+	}
+	return x.next[0]
+}
+
+// get returns the value stored under key
+func (s *skipList[K, T]) get(key K) (T, bool) {
+	if x := s.findPath(key, nil); x != nil && s.cmp(x.key, key) == 0 {
+		return x.value, true
+	}
+	var zero T
+	return zero, false
+}
+
+// set stores value under key, returning true if the key is new
+func (s *skipList[K, T]) set(key K, value T) bool {
+	var update [skipListMaxLevel]*skipNode[K, T]
+	x := s.findPath(key, update[:])
+	if x != nil && s.cmp(x.key, key) == 0 {
+		x.value = value
+		return false
+	}
+
+	level := randomSkipLevel()
+	if level > s.level {
+		for i := s.level; i < level; i++ {
+			update[i] = s.head
+		}
+		s.level = level
+	}
+
+	node := &skipNode[K, T]{key: key, value: value, next: make([]*skipNode[K, T], level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+	}
+	if update[0] != s.head {
+		node.prev = update[0]
+	}
+	if node.next[0] != nil {
+		node.next[0].prev = node
+	} else {
+		s.tail = node
+	}
+	s.length++
+	return true
+}
+
+// fillSorted fills an empty list from keys, which must be in ascending order
+// without duplicates, with value(i) stored under keys[i] (zero values if
+// value is nil). Each node is linked in at the tail, so this is O(n) where
+// calling set for every key is O(n log n).
+func (s *skipList[K, T]) fillSorted(keys []K, value func(i int) T) {
+	var tails [skipListMaxLevel]*skipNode[K, T]
+	for i := range tails {
+		tails[i] = s.head
+	}
+	for i, key := range keys {
+		level := randomSkipLevel()
+		s.level = max(s.level, level)
+		node := &skipNode[K, T]{key: key, next: make([]*skipNode[K, T], level), prev: s.tail}
+		if value != nil {
+			node.value = value(i)
+		}
+		for l := 0; l < level; l++ {
+			tails[l].next[l] = node
+			tails[l] = node
+		}
+		s.tail = node
+		s.length++
+	}
+}
+
+// remove deletes key, returning true if it was present
+func (s *skipList[K, T]) remove(key K) bool {
+	var update [skipListMaxLevel]*skipNode[K, T]
+	x := s.findPath(key, update[:])
+	if x == nil || s.cmp(x.key, key) != 0 {
+		return false
+	}
+
+	for i := 0; i < s.level; i++ {
+		if update[i].next[i] != x {
+			break
+		}
+		update[i].next[i] = x.next[i]
+	}
+	if x.next[0] != nil {
+		x.next[0].prev = x.prev
+	} else {
+		s.tail = x.prev
+	}
+	for s.level > 1 && s.head.next[s.level-1] == nil {
+		s.level--
+	}
+	s.length--
+	return true
+}
+
+// seek returns the first node with key >= key (nil if none)
+func (s *skipList[K, T]) seek(key K) *skipNode[K, T] {
+	return s.findPath(key, nil)
+}
+
+// seekBefore returns the last node with key < key (nil if none)
+func (s *skipList[K, T]) seekBefore(key K) *skipNode[K, T] {
+	var update [skipListMaxLevel]*skipNode[K, T]
+	s.findPath(key, update[:])
+	if update[0] == s.head {
+		return nil
+	}
+	return update[0]
+}
+
+// first returns the smallest node (nil if empty)
+func (s *skipList[K, T]) first() *skipNode[K, T] {
+	return s.head.next[0]
+}
+
+// last returns the largest node (nil if empty)
+func (s *skipList[K, T]) last() *skipNode[K, T] {
+	return s.tail
+}
@@ -0,0 +1,419 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// snapshotVersion is the file format written by SaveToDisk. Version 1 is the
+// bare diskState written before files had a header; it still loads.
+const snapshotVersion = 2
+
+var (
+	// ErrChecksumMismatch is returned by LoadFromDisk for a file whose
+	// contents don't match its checksum (corrupted or edited by hand)
+	ErrChecksumMismatch = errors.New("snapshot checksum mismatch")
+	// ErrUnsupportedVersion is returned by LoadFromDisk for a file written
+	// by a newer format version
+	ErrUnsupportedVersion = errors.New("unsupported snapshot version")
+	// ErrInconsistentState is returned by LoadFromDisk when the file's value
+	// counts don't match its data. The file is still loaded, with the
+	// counts rebuilt from the data.
+	ErrInconsistentState = errors.New("snapshot value counts don't match data")
+)
+
+// snapshotFile is the envelope SaveToDisk writes around a diskState.
+// Checksum is "sha256:" and the hex SHA-256 of State in compact JSON, so it
+// survives reindenting but not any change to the content.
+type snapshotFile struct {
+	Version  int             `json:"version"`
+	Checksum string          `json:"checksum"`
+	State    json.RawMessage `json:"state"`
+}
+
+// encodeSnapshot lays out state as a file in format
+func encodeSnapshot(state *diskState, format SnapshotFormat) ([]byte, error) {
+	switch format {
+	case FormatJSON:
+		return encodeJSONSnapshot(state)
+	case FormatBinary:
+		return encodeBinarySnapshot(state), nil
+	case FormatBinaryGzip:
+		return gzipSnapshot(encodeBinarySnapshot(state))
+	}
+	return nil, fmt.Errorf("unknown snapshot format %d", format)
+}
+
+// decodeSnapshot parses a file written by SaveToDisk in any format
+func decodeSnapshot(content []byte) (*diskState, error) {
+	if bytes.HasPrefix(content, gzipMagic) {
+		var err error
+		if content, err = gunzipSnapshot(content); err != nil {
+			return nil, err
+		}
+	}
+	if bytes.HasPrefix(content, binaryMagic) {
+		return decodeBinarySnapshot(content)
+	}
+	return decodeJSONSnapshot(content)
+}
+
+// encodeJSONSnapshot wraps state in a checksummed snapshotFile
+func encodeJSONSnapshot(state *diskState) ([]byte, error) {
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode state: %w", err)
+	}
+	content, err := json.MarshalIndent(snapshotFile{
+		Version:  snapshotVersion,
+		Checksum: checksum(raw),
+		State:    raw,
+	}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	return append(content, '\n'), nil
+}
+
+// decodeJSONSnapshot parses a JSON snapshot, verifying its checksum. Legacy
+// files without an envelope are read as a bare diskState.
+func decodeJSONSnapshot(content []byte) (*diskState, error) {
+	var file snapshotFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("failed to decode state: %w", err)
+	}
+
+	var state diskState
+	if file.Version == 0 && file.State == nil {
+		if err := json.Unmarshal(content, &state); err != nil {
+			return nil, fmt.Errorf("failed to decode state: %w", err)
+		}
+		return &state, nil
+	}
+	if file.Version > snapshotVersion || file.Version < 2 {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, file.Version)
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, file.State); err != nil {
+		return nil, fmt.Errorf("failed to decode state: %w", err)
+	}
+	if sum := checksum(compact.Bytes()); sum != file.Checksum {
+		return nil, fmt.Errorf("%w: file says %q, content is %q", ErrChecksumMismatch, file.Checksum, sum)
+	}
+	if err := json.Unmarshal(file.State, &state); err != nil {
+		return nil, fmt.Errorf("failed to decode state: %w", err)
+	}
+	return &state, nil
+}
+
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// WriteFileAtomic replaces filename with content so that a crash leaves
+// either the old file or the new one, never a mix: content goes to a temp
+// file in the same directory, which is fsynced and renamed over filename
+func WriteFileAtomic(filename string, content []byte) (err error) {
+	dir := filepath.Dir(filename)
+	tmp, err := os.CreateTemp(dir, filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(content); err != nil {
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err = tmp.Chmod(0644); err != nil {
+		return fmt.Errorf("failed to set file mode: %w", err)
+	}
+	if err = tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), filename); err != nil {
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+
+	// Make the rename itself durable. Best effort: not every platform can
+	// sync a directory, and the new file is complete either way.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// diskState is the JSON layout written by SaveToDisk (FormatBinary holds the
+// same fields, see binary.go). Values are encoded
+// with the store's codec, and valueCount is keyed by the encoded value, so
+// int stores keep the original {"data": {"k": 1}, "valueCount": {"1": 1}}
+// format.
+type diskState struct {
+	Data        map[string]json.RawMessage  `json:"data"`
+	ValueCount  map[string]int              `json:"valueCount"`
+	Checkpoints []*diskDelta                `json:"checkpoints"`
+	Tracking    map[string]*json.RawMessage `json:"tracking"`
+	Expiry      map[string]time.Time        `json:"expiry,omitempty"`
+	WALSeq      uint64                      `json:"walSeq,omitempty"`
+
+	LastCheckpointID uint64 `json:"lastCheckpointID,omitempty"`
+
+	keys []string // Data's keys in ascending order when known (not saved in JSON)
+}
+
+// diskDelta is the on-disk form of a DeltaSnapshot. Files written before
+// checkpoints had IDs are given fresh ones on load.
+type diskDelta struct {
+	ID          uint64    `json:",omitempty"`
+	Name        string    `json:",omitempty"`
+	Created     time.Time `json:",omitzero"`
+	ChangedKeys map[string]*json.RawMessage
+	DeletedKeys map[string]json.RawMessage
+}
+
+// encodeStateLocked converts the store to its on-disk form; the caller must
+// hold mu (read or write)
+func (kv *KVStore[V]) encodeStateLocked() (*diskState, error) {
+	state := &diskState{
+		Data:        make(map[string]json.RawMessage, len(kv.data)),
+		ValueCount:  make(map[string]int, len(kv.valueCount)),
+		Checkpoints: make([]*diskDelta, 0, len(kv.checkpoints)),
+		Expiry:      make(map[string]time.Time, len(kv.expiry)),
+		WALSeq:      kv.walSeq,
+
+		LastCheckpointID: uint64(kv.lastCheckpointID),
+	}
+	for key, deadline := range kv.expiry {
+		state.Expiry[key] = deadline
+	}
+	state.keys = make([]string, 0, len(kv.data))
+	for node := kv.index.first(); node != nil; node = node.next[0] {
+		state.keys = append(state.keys, node.key)
+	}
+
+	for key, value := range kv.data {
+		encoded, err := kv.codec.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode value of %q: %w", key, err)
+		}
+		state.Data[key] = encoded
+	}
+	for value, count := range kv.valueCount {
+		encoded, err := kv.codec.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode value count: %w", err)
+		}
+		state.ValueCount[string(encoded)] = count
+	}
+	for _, delta := range kv.checkpoints {
+		encoded := &diskDelta{
+			ID:          uint64(delta.ID),
+			Name:        delta.Name,
+			Created:     delta.Created,
+			DeletedKeys: make(map[string]json.RawMessage, len(delta.DeletedKeys)),
+		}
+		var err error
+		if encoded.ChangedKeys, err = kv.encodeOldValues(delta.ChangedKeys); err != nil {
+			return nil, err
+		}
+		for key, value := range delta.DeletedKeys {
+			if encoded.DeletedKeys[key], err = kv.codec.Marshal(value); err != nil {
+				return nil, fmt.Errorf("failed to encode checkpoint value of %q: %w", key, err)
+			}
+		}
+		state.Checkpoints = append(state.Checkpoints, encoded)
+	}
+
+	var err error
+	if state.Tracking, err = kv.encodeOldValues(kv.tracking); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// decodeStateLocked replaces the store's contents with state; the caller
+// must hold mu. The store is left untouched if any value fails to decode.
+// valueCount is rebuilt from the data; if the file's counts disagree the
+// state is still loaded and an error wrapping ErrInconsistentState returned.
+func (kv *KVStore[V]) decodeStateLocked(state *diskState) error {
+	data := make(map[string]V, len(state.Data))
+	for key, raw := range state.Data {
+		value, err := kv.codec.Unmarshal(raw)
+		if err != nil {
+			return fmt.Errorf("failed to decode value of %q: %w", key, err)
+		}
+		data[key] = value
+	}
+
+	valueCount := make(map[V]int, len(state.ValueCount))
+	for _, value := range data {
+		valueCount[value]++
+	}
+	inconsistent := kv.checkValueCounts(state.ValueCount, valueCount)
+
+	lastCheckpointID := CheckpointID(state.LastCheckpointID)
+	for _, encoded := range state.Checkpoints {
+		if encoded != nil {
+			lastCheckpointID = max(lastCheckpointID, CheckpointID(encoded.ID))
+		}
+	}
+
+	checkpoints := make([]*DeltaSnapshot[V], 0, len(state.Checkpoints))
+	for _, encoded := range state.Checkpoints {
+		if encoded == nil {
+			encoded = &diskDelta{}
+		}
+		delta := &DeltaSnapshot[V]{
+			ID:          CheckpointID(encoded.ID),
+			Name:        encoded.Name,
+			Created:     encoded.Created,
+			DeletedKeys: make(map[string]V),
+		}
+		if delta.ID == 0 {
+			lastCheckpointID++
+			delta.ID = lastCheckpointID
+		}
+		var err error
+		if delta.ChangedKeys, err = kv.decodeOldValues(encoded.ChangedKeys); err != nil {
+			return err
+		}
+		for key, raw := range encoded.DeletedKeys {
+			if delta.DeletedKeys[key], err = kv.codec.Unmarshal(raw); err != nil {
+				return fmt.Errorf("failed to decode checkpoint value of %q: %w", key, err)
+			}
+		}
+		checkpoints = append(checkpoints, delta)
+	}
+
+	tracking, err := kv.decodeOldValues(state.Tracking)
+	if err != nil {
+		return err
+	}
+
+	expiry := make(map[string]time.Time, len(state.Expiry))
+	for key, deadline := range state.Expiry {
+		if _, exists := data[key]; exists {
+			expiry[key] = deadline
+		}
+	}
+
+	kv.notifyLoadLocked(data)
+	kv.recordLoadLocked(data)
+	kv.data = data
+	kv.index = newKeyIndex()
+	keys := state.keys
+	if len(keys) != len(data) || !slices.IsSorted(keys) {
+		keys = slices.Sorted(maps.Keys(data))
+	}
+	kv.index.fillSorted(keys, nil)
+	if kv.valueIndex != nil {
+		kv.buildValueIndexLocked(kv.valueIndex.cmp)
+	}
+	kv.rebuildCapacityLocked()
+	kv.valueCount = valueCount
+	kv.checkpoints = checkpoints
+	kv.lastCheckpointID = lastCheckpointID
+	kv.tracking = tracking
+	kv.expiry = expiry
+	return inconsistent
+}
+
+// checkValueCounts compares the value counts read from a file with the ones
+// rebuilt from its data, returning an ErrInconsistentState error listing
+// the differences (nil if there are none). Binary snapshots store no counts
+// (stored is nil), so there is nothing to check.
+func (kv *KVStore[V]) checkValueCounts(stored map[string]int, rebuilt map[V]int) error {
+	if stored == nil {
+		return nil
+	}
+	var problems []string
+	seen := make(map[V]bool, len(stored))
+	for raw, count := range stored {
+		value, err := kv.codec.Unmarshal([]byte(raw))
+		if err != nil {
+			// Files written before codecs existed keyed string values by
+			// the bare string rather than its JSON encoding
+			quoted, _ := json.Marshal(raw)
+			if value, err = kv.codec.Unmarshal(quoted); err != nil {
+				problems = append(problems, fmt.Sprintf("undecodable value %q", raw))
+				continue
+			}
+		}
+		seen[value] = true
+		if count != rebuilt[value] {
+			problems = append(problems, fmt.Sprintf("value %s counted %d, found %d", raw, count, rebuilt[value]))
+		}
+	}
+	for value, count := range rebuilt {
+		if !seen[value] {
+			encoded, _ := kv.codec.Marshal(value)
+			problems = append(problems, fmt.Sprintf("value %s counted 0, found %d", encoded, count))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+
+	slices.Sort(problems)
+	const maxListed = 5
+	summary := strings.Join(problems[:min(len(problems), maxListed)], "; ")
+	if len(problems) > maxListed {
+		summary += fmt.Sprintf("; and %d more", len(problems)-maxListed)
+	}
+	return fmt.Errorf("%w: %s", ErrInconsistentState, summary)
+}
+
+// encodeOldValues encodes a key -> old value map (nil = key didn't exist)
+func (kv *KVStore[V]) encodeOldValues(values map[string]*V) (map[string]*json.RawMessage, error) {
+	encoded := make(map[string]*json.RawMessage, len(values))
+	for key, value := range values {
+		if value == nil {
+			encoded[key] = nil
+			continue
+		}
+		raw, err := kv.codec.Marshal(*value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode tracked value of %q: %w", key, err)
+		}
+		msg := json.RawMessage(raw)
+		encoded[key] = &msg
+	}
+	return encoded, nil
+}
+
+// decodeOldValues is the inverse of encodeOldValues
+func (kv *KVStore[V]) decodeOldValues(encoded map[string]*json.RawMessage) (map[string]*V, error) {
+	values := make(map[string]*V, len(encoded))
+	for key, raw := range encoded {
+		if raw == nil {
+			values[key] = nil
+			continue
+		}
+		value, err := kv.codec.Unmarshal(*raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode tracked value of %q: %w", key, err)
+		}
+		values[key] = &value
+	}
+	return values, nil
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSnapshotFormat tests the versioned, checksummed file layout
+func TestSnapshotFormat(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "store.json")
+
+	kv := NewKVStore[int]()
+	kv.Put("a", 1)
+	kv.Put("b", 1)
+	if err := kv.SaveToDisk(filename); err != nil {
+		t.Fatalf("SaveToDisk failed: %v", err)
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	var file snapshotFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		t.Fatalf("Expected a JSON envelope: %v", err)
+	}
+	if file.Version != snapshotVersion || !strings.HasPrefix(file.Checksum, "sha256:") {
+		t.Errorf("Expected version %d with a sha256 checksum, got %d %q", snapshotVersion, file.Version, file.Checksum)
+	}
+
+	// Reformatting keeps the checksum valid
+	var compact bytes.Buffer
+	json.Compact(&compact, content)
+	os.WriteFile(filename, compact.Bytes(), 0644)
+	kv2 := NewKVStore[int]()
+	if err := kv2.LoadFromDisk(filename); err != nil {
+		t.Fatalf("LoadFromDisk of a reformatted file failed: %v", err)
+	}
+	if kv2.CountValue(1) != 2 {
+		t.Errorf("Expected count 2, got %d", kv2.CountValue(1))
+	}
+
+	// No temp files are left behind
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("Expected only the snapshot in the directory, got %d entries", len(entries))
+	}
+}
+
+// TestSnapshotCorruption tests that damaged files are rejected without
+// touching the store
+func TestSnapshotCorruption(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "store.json")
+
+	kv := NewKVStore[int]()
+	kv.Put("a", 1)
+	kv.SaveToDisk(filename)
+	content, _ := os.ReadFile(filename)
+
+	loaded := NewKVStore[int]()
+	loaded.Put("keep", 7)
+	check := func(name string, content []byte, want error) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, content, 0644)
+		err := loaded.LoadFromDisk(path)
+		if err == nil || (want != nil && !errors.Is(err, want)) {
+			t.Errorf("%s: expected error %v, got %v", name, want, err)
+		}
+		if data, _ := loaded.GetAllData(); len(data) != 1 || data["keep"] != 7 {
+			t.Errorf("%s: expected the store to be untouched", name)
+		}
+	}
+
+	check("tampered.json", bytes.Replace(content, []byte(`"a": 1`), []byte(`"a": 2`), 1), ErrChecksumMismatch)
+	check("truncated.json", content[:len(content)/2], nil)
+	check("future.json", bytes.Replace(content, []byte(`"version": 2`), []byte(`"version": 9`), 1), ErrUnsupportedVersion)
+}
+
+// TestSnapshotValueCounts tests that value counts are rebuilt from data and
+// mismatches reported
+func TestSnapshotValueCounts(t *testing.T) {
+	dir := t.TempDir()
+
+	// Legacy files (no envelope) still load
+	legacy := NewKVStore[int]()
+	if err := legacy.LoadFromDisk("../test_delta_int.json"); err != nil {
+		t.Fatalf("LoadFromDisk of a legacy file failed: %v", err)
+	}
+
+	filename := filepath.Join(dir, "bad.json")
+	os.WriteFile(filename, []byte(`{
+  "data": {"a": 1, "b": 1, "c": 2},
+  "valueCount": {"1": 5, "3": 1}
+}`), 0644)
+
+	kv := NewKVStore[int]()
+	err := kv.LoadFromDisk(filename)
+	if !errors.Is(err, ErrInconsistentState) {
+		t.Fatalf("Expected ErrInconsistentState, got %v", err)
+	}
+	for _, want := range []string{"value 1 counted 5, found 2", "value 2 counted 0, found 1", "value 3 counted 1, found 0"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q in %q", want, err)
+		}
+	}
+	data, counts := kv.GetAllData()
+	if len(data) != 3 || len(counts) != 2 || counts[1] != 2 || counts[2] != 1 {
+		t.Errorf("Expected the data loaded with rebuilt counts, got %v %v", data, counts)
+	}
+
+	// OpenKVStore opens the store anyway and the next save is consistent
+	wal, err := OpenKVStore[int](filename, WALOptions{})
+	if !errors.Is(err, ErrInconsistentState) || wal == nil {
+		t.Fatalf("Expected the store with ErrInconsistentState, got %v", err)
+	}
+	wal.Compact()
+	wal.Close()
+	if err := NewKVStore[int]().LoadFromDisk(filename); err != nil {
+		t.Errorf("Expected Compact to repair the counts, got %v", err)
+	}
+}
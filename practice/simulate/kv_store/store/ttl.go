@@ -0,0 +1,129 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// PutWithTTL sets a key-value pair that expires after ttl. Expired keys are
+// removed lazily by Get, or in the background by the janitor (see
+// StartJanitor). A plain Put of the key clears its TTL. A non-positive ttl
+// stores the key without expiry. Like Put, callers must check Err.
+func (kv *KVStore[V]) PutWithTTL(key string, value V, ttl time.Duration) {
+	if ttl <= 0 {
+		kv.Put(key, value)
+		return
+	}
+
+	start := kv.lockOp()
+	defer kv.unlockOp(opPut, start)
+
+	deadline := kv.now().Add(ttl)
+	if !kv.logValueLocked(walRecord{Op: walOpPut, Key: key, Expires: deadline.UnixNano()}, value) {
+		return
+	}
+	kv.putLocked(key, value)
+	kv.expiry[key] = deadline
+	kv.evictLocked()
+}
+
+// TTL returns the time left before key expires. The second result is false
+// if the key doesn't exist, has already expired, or has no TTL.
+func (kv *KVStore[V]) TTL(key string) (time.Duration, bool) {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	deadline, hasTTL := kv.expiry[key]
+	if !hasTTL {
+		return 0, false
+	}
+	remaining := deadline.Sub(kv.now())
+	if remaining <= 0 {
+		return 0, false
+	}
+	return remaining, true
+}
+
+// ExpireNow removes every key whose TTL has passed and returns how many were
+// removed. Like Delete, each expiry is recorded in the checkpoint tracking,
+// so Revert brings back a key that expired after a checkpoint (without its
+// TTL).
+func (kv *KVStore[V]) ExpireNow() int {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	now := kv.now()
+	expired := 0
+	for key, deadline := range kv.expiry {
+		if !now.Before(deadline) && kv.expireLocked(key) {
+			expired++
+		}
+	}
+	return expired
+}
+
+// expiredLocked reports whether key has a TTL that has passed; the caller
+// must hold mu (read or write)
+func (kv *KVStore[V]) expiredLocked(key string) bool {
+	deadline, hasTTL := kv.expiry[key]
+	return hasTTL && !kv.now().Before(deadline)
+}
+
+// expireLocked logs and removes an expired key; the caller must hold mu.
+// It returns false if the expiry could not be logged.
+func (kv *KVStore[V]) expireLocked(key string) bool {
+	if !kv.logLocked(walRecord{Op: walOpExpire, Key: key}) {
+		return false
+	}
+	kv.trackLocked(key)
+	kv.removeLocked(key, EventExpire)
+	return true
+}
+
+// janitor periodically calls ExpireNow
+type janitor struct {
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// StartJanitor starts a background goroutine that removes expired keys every
+// interval, replacing any janitor already running. Stop it with StopJanitor
+// (Close also stops it).
+func (kv *KVStore[V]) StartJanitor(interval time.Duration) {
+	kv.StopJanitor()
+
+	j := &janitor{stop: make(chan struct{})}
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				kv.ExpireNow()
+			case <-j.stop:
+				return
+			}
+		}
+	}()
+
+	kv.mu.Lock()
+	kv.janitor = j
+	kv.mu.Unlock()
+}
+
+// StopJanitor stops the background expiry goroutine, if running
+func (kv *KVStore[V]) StopJanitor() {
+	kv.mu.Lock()
+	j := kv.janitor
+	kv.janitor = nil
+	kv.mu.Unlock()
+
+	if j != nil {
+		close(j.stop)
+		j.wg.Wait() // Outside mu: the janitor may be waiting for it
+	}
+}
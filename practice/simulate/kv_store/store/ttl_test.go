@@ -0,0 +1,162 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced clock for expiry tests
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+// TestPutWithTTL tests lazy expiry on Get
+func TestPutWithTTL(t *testing.T) {
+	clock := newFakeClock()
+	kv := NewKVStore[int]()
+	kv.now = clock.Now
+
+	kv.PutWithTTL("session", 1, 10*time.Second)
+	kv.Put("user", 1)
+
+	if v, ok := kv.Get("session"); !ok || v != 1 {
+		t.Errorf("Expected session=1 before expiry, got %d (exists=%v)", v, ok)
+	}
+	if ttl, ok := kv.TTL("session"); !ok || ttl != 10*time.Second {
+		t.Errorf("Expected TTL 10s, got %v (ok=%v)", ttl, ok)
+	}
+	if _, ok := kv.TTL("user"); ok {
+		t.Error("Expected no TTL for plain Put")
+	}
+
+	clock.Advance(10 * time.Second)
+	if _, ok := kv.Get("session"); ok {
+		t.Error("Expected session to be expired")
+	}
+	if kv.CountValue(1) != 1 {
+		t.Errorf("Expected count 1 after expiry, got %d", kv.CountValue(1))
+	}
+
+	// A plain Put clears the TTL
+	kv.PutWithTTL("k", 5, time.Second)
+	kv.Put("k", 6)
+	clock.Advance(time.Hour)
+	if v, ok := kv.Get("k"); !ok || v != 6 {
+		t.Errorf("Expected k=6 to persist after Put cleared the TTL, got %d (exists=%v)", v, ok)
+	}
+}
+
+// TestExpireNowAndRevert tests that expiries are tracked for Revert
+func TestExpireNowAndRevert(t *testing.T) {
+	clock := newFakeClock()
+	kv := NewKVStore[int]()
+	kv.now = clock.Now
+
+	kv.PutWithTTL("a", 7, time.Minute)
+	kv.PutWithTTL("b", 7, time.Hour)
+	kv.Checkpoint("")
+
+	clock.Advance(2 * time.Minute)
+	if n := kv.ExpireNow(); n != 1 {
+		t.Errorf("Expected 1 expired key, got %d", n)
+	}
+	if kv.CountValue(7) != 1 {
+		t.Errorf("Expected count 1 after expiry, got %d", kv.CountValue(7))
+	}
+
+	if err := kv.Revert(); err != nil {
+		t.Fatalf("Revert failed: %v", err)
+	}
+	if v, ok := kv.Get("a"); !ok || v != 7 {
+		t.Errorf("Expected a restored by Revert, got %d (exists=%v)", v, ok)
+	}
+	if kv.CountValue(7) != 2 {
+		t.Errorf("Expected count 2 after revert, got %d", kv.CountValue(7))
+	}
+	if _, ok := kv.TTL("a"); ok {
+		t.Error("Expected reverted key to come back without a TTL")
+	}
+	if _, ok := kv.TTL("b"); !ok {
+		t.Error("Expected untouched key to keep its TTL")
+	}
+}
+
+// TestJanitor tests background eviction
+func TestJanitor(t *testing.T) {
+	kv := NewKVStore[int]()
+	kv.PutWithTTL("short", 1, 20*time.Millisecond)
+	kv.Put("long", 1)
+
+	kv.StartJanitor(5 * time.Millisecond)
+	defer kv.StopJanitor()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for kv.CountValue(1) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected janitor to evict the expired key")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	data, _ := kv.GetAllData()
+	if _, ok := data["short"]; ok {
+		t.Error("Expected short to be removed from data")
+	}
+}
+
+// TestTTLSaveAndLoad tests that deadlines round-trip through disk and WAL
+func TestTTLSaveAndLoad(t *testing.T) {
+	clock := newFakeClock()
+	dir := t.TempDir()
+
+	kv := NewKVStore[int]()
+	kv.now = clock.Now
+	kv.PutWithTTL("a", 1, time.Minute)
+	kv.PutWithTTL("b", 2, time.Hour)
+
+	filename := filepath.Join(dir, "ttl.json")
+	if err := kv.SaveToDisk(filename); err != nil {
+		t.Fatalf("SaveToDisk failed: %v", err)
+	}
+
+	kv2 := NewKVStore[int]()
+	kv2.now = clock.Now
+	if err := kv2.LoadFromDisk(filename); err != nil {
+		t.Fatalf("LoadFromDisk failed: %v", err)
+	}
+	if ttl, ok := kv2.TTL("b"); !ok || ttl != time.Hour {
+		t.Errorf("Expected TTL 1h after load, got %v (ok=%v)", ttl, ok)
+	}
+	clock.Advance(2 * time.Minute)
+	if _, ok := kv2.Get("a"); ok {
+		t.Error("Expected a to expire after load")
+	}
+
+	// Through the WAL
+	snapshot := filepath.Join(dir, "wal.json")
+	kv3, err := OpenKVStore[int](snapshot, WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenKVStore failed: %v", err)
+	}
+	kv3.now = clock.Now
+	kv3.PutWithTTL("c", 3, time.Minute)
+	kv3.Close()
+
+	kv4, err := OpenKVStore[int](snapshot, WALOptions{})
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer kv4.Close()
+	kv4.now = clock.Now
+	if ttl, ok := kv4.TTL("c"); !ok || ttl != time.Minute {
+		t.Errorf("Expected TTL 1m after replay, got %v (ok=%v)", ttl, ok)
+	}
+}
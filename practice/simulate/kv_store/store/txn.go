@@ -0,0 +1,191 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var (
+	// ErrTxnConflict is returned by Commit when a key the transaction read
+	// or wrote was changed by someone else after Begin
+	ErrTxnConflict = errors.New("transaction conflict")
+	// ErrTxnDone is returned when using a transaction that has already been
+	// committed or rolled back
+	ErrTxnDone = errors.New("transaction already committed or rolled back")
+)
+
+// Txn is a multi-key transaction with its own buffered writes.
+//
+// Writes are invisible to other readers until Commit, which applies them
+// atomically under the store lock. Concurrency control is optimistic: Commit
+// fails with ErrTxnConflict if any key the transaction read or wrote was
+// changed (by a Put, Delete, Revert, LoadFromDisk or another transaction's
+// Commit) after Begin. A Txn must be used by one goroutine at a time, and
+// must always end with Commit or Rollback.
+type Txn[V comparable] struct {
+	kv     *KVStore[V]
+	start  uint64              // Store writeSeq at Begin
+	writes map[string]*V       // Buffered writes (nil = delete)
+	reads  map[string]struct{} // Keys read from the store
+	done   bool
+}
+
+// Begin starts a transaction
+func (kv *KVStore[V]) Begin() *Txn[V] {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.activeTxns++
+	return &Txn[V]{
+		kv:     kv,
+		start:  kv.writeSeq,
+		writes: make(map[string]*V),
+		reads:  make(map[string]struct{}),
+	}
+}
+
+// Get returns the value of key as seen by the transaction: its own buffered
+// write if there is one, otherwise the committed value
+func (t *Txn[V]) Get(key string) (V, bool) {
+	if value, written := t.writes[key]; written {
+		if value == nil {
+			var zero V
+			return zero, false
+		}
+		return *value, true
+	}
+	t.reads[key] = struct{}{}
+	return t.kv.Get(key)
+}
+
+// Put buffers a write of key
+func (t *Txn[V]) Put(key string, value V) {
+	t.writes[key] = &value
+}
+
+// Delete buffers a delete of key. It returns true if the key exists as seen
+// by the transaction.
+func (t *Txn[V]) Delete(key string) bool {
+	_, exists := t.Get(key)
+	t.writes[key] = nil
+	return exists
+}
+
+// Commit atomically applies the buffered writes. It returns ErrTxnConflict
+// (and applies nothing) if another writer changed an overlapping key first.
+func (t *Txn[V]) Commit() error {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.done = true
+
+	kv := t.kv
+	start := kv.lockOp()
+	defer kv.unlockOp(opCommit, start)
+	defer kv.endTxnLocked()
+
+	if kv.lastReset > t.start {
+		return fmt.Errorf("%w: store was reloaded", ErrTxnConflict)
+	}
+	for _, keys := range []map[string]struct{}{t.reads, t.writeKeys()} {
+		for key := range keys {
+			if kv.modified[key] > t.start {
+				return fmt.Errorf("%w: key '%s' was changed", ErrTxnConflict, key)
+			}
+		}
+	}
+
+	// Apply in key order so the log (and replay) is deterministic
+	keys := make([]string, 0, len(t.writes))
+	for key := range t.writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	if kv.wal != nil {
+		rec := walRecord{Op: walOpTxn}
+		for _, key := range keys {
+			op := walRecord{Op: walOpDelete, Key: key}
+			if value := t.writes[key]; value != nil {
+				encoded, err := kv.codec.Marshal(*value)
+				if err != nil {
+					return fmt.Errorf("failed to encode value of '%s': %w", key, err)
+				}
+				op = walRecord{Op: walOpPut, Key: key, Value: encoded}
+			}
+			rec.Txn = append(rec.Txn, op)
+		}
+		if !kv.logLocked(rec) {
+			return fmt.Errorf("failed to log commit: %w", kv.failErr)
+		}
+	}
+
+	for _, key := range keys {
+		kv.applyTxnWriteLocked(key, t.writes[key])
+	}
+	kv.evictLocked()
+	return nil
+}
+
+// Rollback discards the buffered writes
+func (t *Txn[V]) Rollback() error {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.done = true
+	t.writes = nil
+
+	t.kv.mu.Lock()
+	defer t.kv.mu.Unlock()
+	t.kv.endTxnLocked()
+	return nil
+}
+
+// writeKeys returns the set of keys the transaction wrote
+func (t *Txn[V]) writeKeys() map[string]struct{} {
+	keys := make(map[string]struct{}, len(t.writes))
+	for key := range t.writes {
+		keys[key] = struct{}{}
+	}
+	return keys
+}
+
+// applyTxnWriteLocked applies one committed write (nil = delete); the
+// caller must hold mu
+func (kv *KVStore[V]) applyTxnWriteLocked(key string, value *V) {
+	if value != nil {
+		kv.putLocked(key, *value)
+	} else if _, exists := kv.data[key]; exists {
+		kv.deleteLocked(key)
+	}
+}
+
+// endTxnLocked forgets per-key write history once no transaction needs it;
+// the caller must hold mu
+func (kv *KVStore[V]) endTxnLocked() {
+	kv.activeTxns--
+	if kv.activeTxns == 0 {
+		kv.modified = make(map[string]uint64)
+	}
+}
+
+// noteWriteLocked records that key changed, for transaction conflict
+// detection; the caller must hold mu
+func (kv *KVStore[V]) noteWriteLocked(key string) {
+	if kv.activeTxns == 0 {
+		return
+	}
+	kv.writeSeq++
+	kv.modified[key] = kv.writeSeq
+}
+
+// noteResetLocked records that every key may have changed; the caller must
+// hold mu
+func (kv *KVStore[V]) noteResetLocked() {
+	if kv.activeTxns == 0 {
+		return
+	}
+	kv.writeSeq++
+	kv.lastReset = kv.writeSeq
+}
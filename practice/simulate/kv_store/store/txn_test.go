@@ -0,0 +1,226 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestTxnCommit tests buffered writes and read-your-writes
+func TestTxnCommit(t *testing.T) {
+	kv := NewKVStore[int]()
+	kv.Put("a", 1)
+	kv.Put("b", 2)
+
+	txn := kv.Begin()
+	txn.Put("a", 10)
+	txn.Put("c", 30)
+	if !txn.Delete("b") {
+		t.Error("Expected txn.Delete to report existing key")
+	}
+
+	// Read-your-writes inside the transaction
+	if v, ok := txn.Get("a"); !ok || v != 10 {
+		t.Errorf("Expected txn to see a=10, got %d (exists=%v)", v, ok)
+	}
+	if _, ok := txn.Get("b"); ok {
+		t.Error("Expected txn to see b deleted")
+	}
+
+	// Other readers see nothing until commit
+	if v, _ := kv.Get("a"); v != 1 {
+		t.Errorf("Expected store to still have a=1 before commit, got %d", v)
+	}
+	if _, ok := kv.Get("c"); ok {
+		t.Error("Expected c to be invisible before commit")
+	}
+
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	if v, _ := kv.Get("a"); v != 10 {
+		t.Errorf("Expected a=10 after commit, got %d", v)
+	}
+	if _, ok := kv.Get("b"); ok {
+		t.Error("Expected b deleted after commit")
+	}
+	if kv.CountValue(1) != 0 || kv.CountValue(2) != 0 || kv.CountValue(10) != 1 || kv.CountValue(30) != 1 {
+		t.Error("Expected value counts to match committed data")
+	}
+
+	if err := txn.Commit(); !errors.Is(err, ErrTxnDone) {
+		t.Errorf("Expected ErrTxnDone on second commit, got %v", err)
+	}
+}
+
+// TestTxnRollback tests discarding buffered writes
+func TestTxnRollback(t *testing.T) {
+	kv := NewKVStore[int]()
+	kv.Put("a", 1)
+
+	txn := kv.Begin()
+	txn.Put("a", 2)
+	txn.Delete("a")
+	if err := txn.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+
+	if v, _ := kv.Get("a"); v != 1 {
+		t.Errorf("Expected a=1 after rollback, got %d", v)
+	}
+	if kv.CountValue(1) != 1 {
+		t.Errorf("Expected count 1 after rollback, got %d", kv.CountValue(1))
+	}
+	if err := txn.Rollback(); !errors.Is(err, ErrTxnDone) {
+		t.Errorf("Expected ErrTxnDone on second rollback, got %v", err)
+	}
+}
+
+// TestTxnConflict tests optimistic conflict detection
+func TestTxnConflict(t *testing.T) {
+	kv := NewKVStore[int]()
+	kv.Put("a", 1)
+
+	t1 := kv.Begin()
+	t2 := kv.Begin()
+	t1.Put("a", 10)
+	t2.Put("a", 20)
+
+	if err := t1.Commit(); err != nil {
+		t.Fatalf("First commit failed: %v", err)
+	}
+	if err := t2.Commit(); !errors.Is(err, ErrTxnConflict) {
+		t.Fatalf("Expected ErrTxnConflict, got %v", err)
+	}
+	if v, _ := kv.Get("a"); v != 10 {
+		t.Errorf("Expected a=10 from the winning transaction, got %d", v)
+	}
+
+	// A read of a key changed by a plain Put also conflicts
+	t3 := kv.Begin()
+	t3.Get("a")
+	t3.Put("b", 1)
+	kv.Put("a", 11)
+	if err := t3.Commit(); !errors.Is(err, ErrTxnConflict) {
+		t.Errorf("Expected read conflict, got %v", err)
+	}
+
+	// Disjoint keys do not conflict
+	t4 := kv.Begin()
+	t5 := kv.Begin()
+	t4.Put("x", 1)
+	t5.Put("y", 2)
+	if err := t4.Commit(); err != nil {
+		t.Errorf("Commit of disjoint txn failed: %v", err)
+	}
+	if err := t5.Commit(); err != nil {
+		t.Errorf("Commit of disjoint txn failed: %v", err)
+	}
+}
+
+// TestTxnCheckpointRevert tests that committed writes are undone by Revert
+func TestTxnCheckpointRevert(t *testing.T) {
+	kv := NewKVStore[int]()
+	kv.Put("a", 1)
+	kv.Checkpoint("")
+
+	txn := kv.Begin()
+	txn.Put("a", 2)
+	txn.Put("b", 3)
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	// A transaction that began before the revert conflicts with it
+	stale := kv.Begin()
+	stale.Get("a")
+
+	if err := kv.Revert(); err != nil {
+		t.Fatalf("Revert failed: %v", err)
+	}
+	if v, _ := kv.Get("a"); v != 1 {
+		t.Errorf("Expected a=1 after revert, got %d", v)
+	}
+	if _, ok := kv.Get("b"); ok {
+		t.Error("Expected b removed after revert")
+	}
+
+	stale.Put("c", 1)
+	if err := stale.Commit(); !errors.Is(err, ErrTxnConflict) {
+		t.Errorf("Expected conflict after revert, got %v", err)
+	}
+}
+
+// TestTxnWAL tests that a committed transaction is replayed as a unit
+func TestTxnWAL(t *testing.T) {
+	snapshot := filepath.Join(t.TempDir(), "store.json")
+
+	kv, err := OpenKVStore[int](snapshot, WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenKVStore failed: %v", err)
+	}
+	kv.Put("a", 1)
+	txn := kv.Begin()
+	txn.Put("b", 2)
+	txn.Delete("a")
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	rolledBack := kv.Begin()
+	rolledBack.Put("c", 3)
+	rolledBack.Rollback()
+	kv.Close()
+
+	kv, err = OpenKVStore[int](snapshot, WALOptions{})
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer kv.Close()
+
+	if _, ok := kv.Get("a"); ok {
+		t.Error("Expected a deleted after replay")
+	}
+	if v, _ := kv.Get("b"); v != 2 {
+		t.Errorf("Expected b=2 after replay, got %d", v)
+	}
+	if _, ok := kv.Get("c"); ok {
+		t.Error("Expected rolled back write to be absent after replay")
+	}
+}
+
+// TestConcurrentTxnIncrements tests retrying read-modify-write transactions
+func TestConcurrentTxnIncrements(t *testing.T) {
+	kv := NewKVStore[int]()
+	kv.Put("counter", 0)
+
+	var wg sync.WaitGroup
+	workers, increments := 8, 50
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func(id int) {
+			defer wg.Done()
+			for j := 0; j < increments; j++ {
+				for {
+					txn := kv.Begin()
+					v, _ := txn.Get("counter")
+					txn.Put("counter", v+1)
+					txn.Put(fmt.Sprintf("worker_%d", id), j)
+					if err := txn.Commit(); err == nil {
+						break
+					} else if !errors.Is(err, ErrTxnConflict) {
+						t.Errorf("Unexpected commit error: %v", err)
+						return
+					}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if v, _ := kv.Get("counter"); v != workers*increments {
+		t.Errorf("Expected counter=%d, got %d", workers*increments, v)
+	}
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+	"errors"
+	"slices"
+)
+
+// ErrNoValueIndex is returned by CountRange and TopK on a store without a
+// value index (see EnableValueIndex)
+var ErrNoValueIndex = errors.New("value index not enabled")
+
+// KeyValue is one key and its value
+type KeyValue[V any] struct {
+	Key   string
+	Value V
+}
+
+// valueKeys is the set of keys holding one value in the value index
+type valueKeys = map[string]struct{}
+
+// EnableValueIndex keeps an index from value to keys, ordered by cmp (which
+// returns a negative number, zero or a positive number as a sorts before,
+// equal to or after b, like cmp.Compare). It is built from the current data
+// and then kept in sync through Put, Delete, expiry, Revert, transactions
+// and LoadFromDisk. Calling it again rebuilds the index with the new order.
+func (kv *KVStore[V]) EnableValueIndex(cmp func(a, b V) int) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.buildValueIndexLocked(cmp)
+}
+
+// KeysWithValue returns the keys whose value is value, sorted. It uses the
+// value index if enabled and scans every key otherwise.
+func (kv *KVStore[V]) KeysWithValue(value V) []string {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	var keys []string
+	if kv.valueIndex == nil {
+		for key, v := range kv.data {
+			if v == value && !kv.expiredLocked(key) {
+				keys = append(keys, key)
+			}
+		}
+	} else if set, ok := kv.valueIndex.get(value); ok {
+		for key := range set {
+			// cmp may treat distinct values as equal; they share an entry
+			if kv.data[key] == value && !kv.expiredLocked(key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// CountRange returns the number of keys whose value is between lo and hi
+// (inclusive) in the value index's order. Like CountValue, it counts keys
+// whose TTL has passed but which haven't been removed yet.
+func (kv *KVStore[V]) CountRange(lo, hi V) (int, error) {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	if kv.valueIndex == nil {
+		return 0, ErrNoValueIndex
+	}
+	count := 0
+	for node := kv.valueIndex.seek(lo); node != nil && kv.valueIndex.cmp(node.key, hi) <= 0; node = node.next[0] {
+		count += len(node.value)
+	}
+	return count, nil
+}
+
+// TopK returns the k keys with the largest values in the value index's
+// order, largest first; keys with equal values come in key order
+func (kv *KVStore[V]) TopK(k int) ([]KeyValue[V], error) {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	if kv.valueIndex == nil {
+		return nil, ErrNoValueIndex
+	}
+	var top []KeyValue[V]
+	for node := kv.valueIndex.last(); node != nil && len(top) < k; node = node.prev {
+		keys := make([]string, 0, len(node.value))
+		for key := range node.value {
+			if !kv.expiredLocked(key) {
+				keys = append(keys, key)
+			}
+		}
+		slices.Sort(keys)
+		for _, key := range keys[:min(len(keys), k-len(top))] {
+			top = append(top, KeyValue[V]{Key: key, Value: kv.data[key]})
+		}
+	}
+	return top, nil
+}
+
+// indexValueLocked adds key to value's entry in the value index, if
+// enabled; the caller must hold mu
+func (kv *KVStore[V]) indexValueLocked(key string, value V) {
+	if kv.valueIndex == nil {
+		return
+	}
+	set, ok := kv.valueIndex.get(value)
+	if !ok {
+		set = make(valueKeys)
+		kv.valueIndex.set(value, set)
+	}
+	set[key] = struct{}{}
+}
+
+// unindexValueLocked removes key from value's entry in the value index, if
+// enabled; the caller must hold mu
+func (kv *KVStore[V]) unindexValueLocked(key string, value V) {
+	if kv.valueIndex == nil {
+		return
+	}
+	if set, ok := kv.valueIndex.get(value); ok {
+		delete(set, key)
+		if len(set) == 0 {
+			kv.valueIndex.remove(value)
+		}
+	}
+}
+
+// buildValueIndexLocked replaces the value index with one built from data
+// in cmp's order; the caller must hold mu
+func (kv *KVStore[V]) buildValueIndexLocked(cmp func(a, b V) int) {
+	kv.valueIndex = newSkipList[V, valueKeys](cmp)
+	for key, value := range kv.data {
+		kv.indexValueLocked(key, value)
+	}
+}
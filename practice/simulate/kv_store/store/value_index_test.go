@@ -0,0 +1,184 @@
+package store
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestValueIndex tests KeysWithValue, CountRange and TopK
+func TestValueIndex(t *testing.T) {
+	clock := newFakeClock()
+	kv := NewKVStore[int]()
+	kv.now = clock.Now
+	kv.Put("a", 5)
+	kv.Put("b", 3)
+
+	if _, err := kv.CountRange(0, 10); !errors.Is(err, ErrNoValueIndex) {
+		t.Errorf("Expected ErrNoValueIndex, got %v", err)
+	}
+	if _, err := kv.TopK(1); !errors.Is(err, ErrNoValueIndex) {
+		t.Errorf("Expected ErrNoValueIndex, got %v", err)
+	}
+	if keys := kv.KeysWithValue(5); !slices.Equal(keys, []string{"a"}) {
+		t.Errorf("Expected KeysWithValue to scan without an index, got %v", keys)
+	}
+
+	kv.EnableValueIndex(cmp.Compare[int]) // Built from the existing keys
+	kv.Put("c", 5)
+	kv.Put("d", 9)
+	kv.Put("e", 1)
+	kv.Put("b", 7) // Moves b from 3 to 7
+	kv.Delete("e")
+
+	if keys := kv.KeysWithValue(5); !slices.Equal(keys, []string{"a", "c"}) {
+		t.Errorf("Expected [a c] with value 5, got %v", keys)
+	}
+	if keys := kv.KeysWithValue(3); len(keys) != 0 {
+		t.Errorf("Expected no keys with value 3, got %v", keys)
+	}
+
+	tests := []struct{ lo, hi, want int }{
+		{5, 7, 3}, {0, 100, 4}, {6, 6, 0}, {9, 9, 1}, {10, 20, 0}, {7, 5, 0},
+	}
+	for _, tt := range tests {
+		if got, _ := kv.CountRange(tt.lo, tt.hi); got != tt.want {
+			t.Errorf("CountRange(%d, %d): expected %d, got %d", tt.lo, tt.hi, tt.want, got)
+		}
+	}
+
+	top, _ := kv.TopK(3)
+	want := []KeyValue[int]{{"d", 9}, {"b", 7}, {"a", 5}}
+	if !slices.Equal(top, want) {
+		t.Errorf("Expected top 3 %v, got %v", want, top)
+	}
+	if top, _ := kv.TopK(10); len(top) != 4 {
+		t.Errorf("Expected TopK to stop at 4 keys, got %v", top)
+	}
+
+	// Keys whose TTL has passed are left out of the key lists
+	kv.PutWithTTL("d", 9, time.Second)
+	clock.Advance(time.Second)
+	if top, _ := kv.TopK(1); len(top) != 1 || top[0].Key != "b" {
+		t.Errorf("Expected b on top after d expired, got %v", top)
+	}
+	if keys := kv.KeysWithValue(9); len(keys) != 0 {
+		t.Errorf("Expected no live keys with value 9, got %v", keys)
+	}
+}
+
+// TestValueIndexSync tests that Revert, transactions and LoadFromDisk keep
+// the index in sync
+func TestValueIndexSync(t *testing.T) {
+	kv := NewKVStore[int]()
+	kv.EnableValueIndex(cmp.Compare[int])
+	kv.Put("a", 1)
+	kv.Put("b", 2)
+	kv.Checkpoint("")
+	kv.Put("a", 3)
+	kv.Delete("b")
+	kv.Put("c", 2)
+
+	kv.Revert()
+	if keys := kv.KeysWithValue(2); !slices.Equal(keys, []string{"b"}) {
+		t.Errorf("Expected [b] with value 2 after revert, got %v", keys)
+	}
+	if n, _ := kv.CountRange(3, 3); n != 0 {
+		t.Errorf("Expected no keys with value 3 after revert, got %d", n)
+	}
+
+	txn := kv.Begin()
+	txn.Put("d", 4)
+	txn.Delete("a")
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if top, _ := kv.TopK(5); !slices.Equal(top, []KeyValue[int]{{"d", 4}, {"b", 2}}) {
+		t.Errorf("Expected [d b] after commit, got %v", top)
+	}
+
+	filename := filepath.Join(t.TempDir(), "index.json")
+	kv.SaveToDisk(filename)
+	kv.Put("z", 100)
+	if err := kv.LoadFromDisk(filename); err != nil {
+		t.Fatalf("LoadFromDisk failed: %v", err)
+	}
+	if top, _ := kv.TopK(1); len(top) != 1 || top[0].Key != "d" {
+		t.Errorf("Expected the loaded state in the index, got %v", top)
+	}
+}
+
+// TestValueIndexRandomized checks the index against a brute-force answer
+// over random operations
+func TestValueIndexRandomized(t *testing.T) {
+	r := rand.New(rand.NewPCG(3, 3))
+	kv := NewKVStore[int]()
+	kv.EnableValueIndex(cmp.Compare[int])
+
+	for step := 0; step < 3000; step++ {
+		key := fmt.Sprintf("k%d", r.IntN(40))
+		switch op := r.IntN(20); {
+		case op < 12:
+			kv.Put(key, r.IntN(15))
+		case op < 17:
+			kv.Delete(key)
+		case op < 18:
+			kv.Checkpoint("")
+		default:
+			kv.Revert()
+		}
+
+		data, _ := kv.GetAllData()
+		lo, hi := r.IntN(15), r.IntN(15)
+		want := 0
+		var keys []string
+		for k, v := range data {
+			if v >= lo && v <= hi {
+				want++
+			}
+			if v == lo {
+				keys = append(keys, k)
+			}
+		}
+		slices.Sort(keys)
+		if got, _ := kv.CountRange(lo, hi); got != want {
+			t.Fatalf("Step %d: CountRange(%d, %d) = %d, expected %d", step, lo, hi, got, want)
+		}
+		if got := kv.KeysWithValue(lo); !slices.Equal(got, keys) {
+			t.Fatalf("Step %d: KeysWithValue(%d) = %v, expected %v", step, lo, got, keys)
+		}
+	}
+
+	data, _ := kv.GetAllData()
+	var all []KeyValue[int]
+	for k, v := range data {
+		all = append(all, KeyValue[int]{k, v})
+	}
+	slices.SortFunc(all, func(a, b KeyValue[int]) int {
+		return cmp.Or(cmp.Compare(b.Value, a.Value), cmp.Compare(a.Key, b.Key))
+	})
+	top, _ := kv.TopK(10)
+	if !slices.Equal(top, all[:min(10, len(all))]) {
+		t.Errorf("Expected top 10 %v, got %v", all[:min(10, len(all))], top)
+	}
+}
+
+// BenchmarkCountRange benchmarks range counts over 100k keys with 1000
+// distinct values
+func BenchmarkCountRange(b *testing.B) {
+	kv := NewKVStore[int]()
+	kv.EnableValueIndex(cmp.Compare[int])
+	for i := 0; i < 100000; i++ {
+		kv.Put(fmt.Sprintf("key_%d", i), i%1000)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		kv.CountRange(100, 200)
+	}
+}
@@ -0,0 +1,549 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// SyncPolicy controls when the write-ahead log is fsynced to disk
+type SyncPolicy int
+
+const (
+	// SyncEveryOp fsyncs after every logged operation (safest, slowest)
+	SyncEveryOp SyncPolicy = iota
+	// SyncBatched buffers records and fsyncs every BatchSize records or
+	// every BatchInterval, whichever comes first
+	SyncBatched
+	// SyncNone writes records to the OS but never fsyncs; a process crash
+	// is survivable, a machine crash may lose recent operations
+	SyncNone
+)
+
+// String returns the policy name used in flags and logs
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncEveryOp:
+		return "always"
+	case SyncBatched:
+		return "batched"
+	case SyncNone:
+		return "none"
+	default:
+		return fmt.Sprintf("SyncPolicy(%d)", int(p))
+	}
+}
+
+// ParseSyncPolicy parses "always", "batched" or "none"
+func ParseSyncPolicy(name string) (SyncPolicy, error) {
+	switch name {
+	case "always", "":
+		return SyncEveryOp, nil
+	case "batched":
+		return SyncBatched, nil
+	case "none":
+		return SyncNone, nil
+	default:
+		return 0, fmt.Errorf("unknown sync policy %q (want always, batched or none)", name)
+	}
+}
+
+// WALOptions configures the write-ahead log
+type WALOptions struct {
+	Path          string        // Log file path (default: snapshot path + ".wal")
+	SyncPolicy    SyncPolicy    // When to fsync
+	BatchSize     int           // SyncBatched: records per fsync (default 64)
+	BatchInterval time.Duration // SyncBatched: max delay before fsync (default 100ms)
+}
+
+const (
+	defaultWALBatchSize     = 64
+	defaultWALBatchInterval = 100 * time.Millisecond
+)
+
+// WAL operation names as written to the log
+const (
+	walOpPut        = "put"
+	walOpDelete     = "delete"
+	walOpCheckpoint = "checkpoint"
+	walOpRevert     = "revert"
+	walOpTxn        = "txn"
+	walOpExpire     = "expire"
+	walOpRevertTo   = "revertTo"
+	walOpRelease    = "release"
+	walOpEvict      = "evict"
+	walOpBulk       = "bulk"
+)
+
+// walRecord is one line of the write-ahead log
+type walRecord struct {
+	Seq     uint64          `json:"seq"`
+	Op      string          `json:"op"`
+	Key     string          `json:"key,omitempty"`
+	Value   json.RawMessage `json:"value,omitempty"`
+	Expires int64           `json:"expires,omitempty"` // Put deadline in Unix nanoseconds (0 = no TTL)
+	Txn     []walRecord     `json:"txn,omitempty"`     // Put/Delete ops of a committed transaction, Puts of a bulk load
+	ID      uint64          `json:"id,omitempty"`      // Checkpoint ID (checkpoint, revertTo, release, bulk)
+	Name    string          `json:"name,omitempty"`    // Checkpoint name (checkpoint, bulk)
+	Time    int64           `json:"time,omitempty"`    // Checkpoint creation time in Unix nanoseconds (checkpoint, bulk)
+}
+
+// writeAheadLog is an append-only JSON-lines log of mutations
+type writeAheadLog struct {
+	mu       sync.Mutex // Protects everything below
+	file     *os.File
+	writer   *bufio.Writer
+	opts     WALOptions
+	pending  int   // Records written since the last fsync
+	stickErr error // First write/sync error; the log refuses appends after it
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// OpenKVStore opens a store backed by a snapshot file and a write-ahead log.
+// The snapshot is loaded if it exists, then every record in the log is
+// replayed on top of it. From then on every Put, Delete, Checkpoint and
+// Revert is appended to the log before it is applied.
+//
+// If the snapshot's value counts don't match its data, the store is opened
+// with the counts rebuilt and returned along with an error wrapping
+// ErrInconsistentState; the next Compact writes the corrected counts.
+func OpenKVStore[V comparable](snapshotPath string, opts WALOptions) (*KVStore[V], error) {
+	return OpenKVStoreWithCodec[V](snapshotPath, JSONCodec[V]{}, opts)
+}
+
+// OpenKVStoreWithCodec is OpenKVStore for values persisted with codec
+func OpenKVStoreWithCodec[V comparable](snapshotPath string, codec Codec[V], opts WALOptions) (*KVStore[V], error) {
+	if opts.Path == "" {
+		opts.Path = snapshotPath + ".wal"
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultWALBatchSize
+	}
+	if opts.BatchInterval <= 0 {
+		opts.BatchInterval = defaultWALBatchInterval
+	}
+
+	kv := NewKVStoreWithCodec[V](codec)
+	kv.snapshotPath = snapshotPath
+	var inconsistent error
+	if _, err := os.Stat(snapshotPath); err == nil {
+		if err := kv.LoadFromDisk(snapshotPath); errors.Is(err, ErrInconsistentState) {
+			inconsistent = err
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to load snapshot: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to stat snapshot: %w", err)
+	}
+
+	file, err := os.OpenFile(opts.Path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open wal: %w", err)
+	}
+
+	if err := kv.replayWAL(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	wal := &writeAheadLog{
+		file:   file,
+		writer: bufio.NewWriter(file),
+		opts:   opts,
+		done:   make(chan struct{}),
+	}
+	if opts.SyncPolicy == SyncBatched {
+		wal.wg.Add(1)
+		go wal.syncLoop()
+	}
+	kv.wal = wal
+
+	return kv, inconsistent
+}
+
+// ReadKVStore loads the state a store opened with OpenKVStore would have,
+// without opening either file for writing: another process may be using
+// them. A torn final record is ignored rather than truncated. The returned
+// store has no WAL, so changes to it are not persisted.
+func ReadKVStore[V comparable](snapshotPath string, opts WALOptions) (*KVStore[V], error) {
+	return ReadKVStoreWithCodec[V](snapshotPath, JSONCodec[V]{}, opts)
+}
+
+// ReadKVStoreWithCodec is ReadKVStore for values persisted with codec
+func ReadKVStoreWithCodec[V comparable](snapshotPath string, codec Codec[V], opts WALOptions) (*KVStore[V], error) {
+	if opts.Path == "" {
+		opts.Path = snapshotPath + ".wal"
+	}
+
+	kv := NewKVStoreWithCodec[V](codec)
+	var inconsistent error
+	if err := kv.LoadFromDisk(snapshotPath); errors.Is(err, ErrInconsistentState) {
+		inconsistent = err
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to load snapshot: %w", err)
+	}
+
+	content, err := os.ReadFile(opts.Path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read wal: %w", err)
+	}
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if _, err := kv.replayLocked(content); err != nil {
+		return nil, err
+	}
+	return kv, inconsistent
+}
+
+// replayWAL applies every record in file and leaves the file offset at the
+// end of the last complete record. A torn final record (from a crash during
+// append) is truncated away; corruption before the tail is an error.
+func (kv *KVStore[V]) replayWAL(file *os.File) error {
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return fmt.Errorf("failed to read wal: %w", err)
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	offset, err := kv.replayLocked(content)
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(int64(offset)); err != nil {
+		return fmt.Errorf("failed to truncate wal: %w", err)
+	}
+	if _, err := file.Seek(int64(offset), io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek wal: %w", err)
+	}
+	return nil
+}
+
+// replayLocked applies every complete record in content and returns the
+// offset just past the last one; the caller must hold mu
+func (kv *KVStore[V]) replayLocked(content []byte) (int, error) {
+	offset := 0
+	lineNo := 0
+	for offset < len(content) {
+		lineNo++
+		end := bytes.IndexByte(content[offset:], '\n')
+		if end < 0 {
+			// Final line without a newline: the append never completed
+			break
+		}
+		line := content[offset : offset+end]
+
+		var rec walRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			if offset+end+1 == len(content) {
+				break // Torn final record
+			}
+			return 0, fmt.Errorf("corrupt wal record at line %d: %w", lineNo, err)
+		}
+		if err := kv.applyRecordLocked(rec); err != nil {
+			return 0, fmt.Errorf("failed to replay wal line %d: %w", lineNo, err)
+		}
+		offset += end + 1
+	}
+	return offset, nil
+}
+
+// applyRecordLocked applies a replayed record; the caller must hold mu.
+// Records already folded into the snapshot (by a compaction that crashed
+// before truncating the log) are skipped.
+func (kv *KVStore[V]) applyRecordLocked(rec walRecord) error {
+	if rec.Seq <= kv.walSeq {
+		return nil
+	}
+	kv.walSeq = rec.Seq
+	return kv.applyOpLocked(rec)
+}
+
+// applyOpLocked applies the operation in rec; the caller must hold mu
+func (kv *KVStore[V]) applyOpLocked(rec walRecord) error {
+	switch rec.Op {
+	case walOpPut:
+		value, err := kv.codec.Unmarshal(rec.Value)
+		if err != nil {
+			return fmt.Errorf("failed to decode value for %q: %w", rec.Key, err)
+		}
+		kv.putLocked(rec.Key, value)
+		if rec.Expires != 0 {
+			kv.expiry[rec.Key] = time.Unix(0, rec.Expires)
+		}
+	case walOpDelete, walOpExpire, walOpEvict:
+		if _, exists := kv.data[rec.Key]; exists {
+			kv.deleteLocked(rec.Key)
+		}
+	case walOpCheckpoint, walOpBulk:
+		id := CheckpointID(rec.ID)
+		if id == 0 {
+			id = kv.lastCheckpointID + 1 // Logged before checkpoints had IDs
+		}
+		var created time.Time
+		if rec.Time != 0 {
+			created = time.Unix(0, rec.Time)
+		}
+		kv.checkpointLocked(id, rec.Name, created)
+		if rec.Op == walOpBulk {
+			for _, op := range rec.Txn {
+				if op.Op != walOpPut {
+					return fmt.Errorf("unexpected op %q in bulk load", op.Op)
+				}
+				if err := kv.applyOpLocked(op); err != nil {
+					return err
+				}
+			}
+		}
+	case walOpRevert:
+		if len(kv.checkpoints) == 0 {
+			return fmt.Errorf("revert without checkpoint")
+		}
+		kv.revertLocked()
+	case walOpRevertTo, walOpRelease:
+		i := kv.checkpointIndexLocked(CheckpointID(rec.ID))
+		if i < 0 {
+			return fmt.Errorf("%s of unknown checkpoint %d", rec.Op, rec.ID)
+		}
+		if rec.Op == walOpRevertTo {
+			kv.revertToLocked(i)
+		} else {
+			kv.releaseLocked(i)
+		}
+	case walOpTxn:
+		for _, op := range rec.Txn {
+			if op.Op != walOpPut && op.Op != walOpDelete {
+				return fmt.Errorf("unexpected op %q in transaction", op.Op)
+			}
+			if err := kv.applyOpLocked(op); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown op %q", rec.Op)
+	}
+	return nil
+}
+
+// logLocked appends rec to the WAL (if enabled) before the caller applies it.
+// It returns false if the record could not be logged, or an earlier one
+// couldn't, in which case the operation must not be applied and Err reports
+// why. The caller must hold mu.
+func (kv *KVStore[V]) logLocked(rec walRecord) bool {
+	if kv.failErr != nil {
+		return false
+	}
+	if kv.wal == nil {
+		return true
+	}
+	rec.Seq = kv.walSeq + 1
+	if err := kv.wal.append(rec); err != nil {
+		kv.failErr = err
+		return false
+	}
+	kv.walSeq = rec.Seq
+	return true
+}
+
+// logValueLocked is logLocked for a record that sets key rec.Key to value,
+// which is encoded into it if the WAL is enabled. A value the codec can't
+// encode stops the store like a failed write. The caller must hold mu.
+func (kv *KVStore[V]) logValueLocked(rec walRecord, value V) bool {
+	if kv.failErr == nil && kv.wal != nil {
+		encoded, err := kv.codec.Marshal(value)
+		if err != nil {
+			kv.failErr = fmt.Errorf("failed to encode value of '%s': %w", rec.Key, err)
+			return false
+		}
+		rec.Value = encoded
+	}
+	return kv.logLocked(rec)
+}
+
+// append writes one record according to the sync policy
+func (w *writeAheadLog) append(rec walRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stickErr != nil {
+		return w.stickErr
+	}
+
+	line = append(line, '\n')
+	if _, err := w.writer.Write(line); err != nil {
+		w.stickErr = fmt.Errorf("wal write failed: %w", err)
+		return w.stickErr
+	}
+	w.pending++
+
+	switch w.opts.SyncPolicy {
+	case SyncEveryOp:
+		return w.syncLocked()
+	case SyncBatched:
+		if w.pending >= w.opts.BatchSize {
+			return w.syncLocked()
+		}
+	case SyncNone:
+		if err := w.writer.Flush(); err != nil {
+			w.stickErr = fmt.Errorf("wal flush failed: %w", err)
+			return w.stickErr
+		}
+	}
+	return nil
+}
+
+// syncLocked flushes buffered records and fsyncs; the caller must hold w.mu
+func (w *writeAheadLog) syncLocked() error {
+	if w.stickErr != nil {
+		return w.stickErr
+	}
+	if err := w.writer.Flush(); err != nil {
+		w.stickErr = fmt.Errorf("wal flush failed: %w", err)
+		return w.stickErr
+	}
+	if w.pending > 0 && w.opts.SyncPolicy != SyncNone {
+		if err := w.file.Sync(); err != nil {
+			w.stickErr = fmt.Errorf("wal fsync failed: %w", err)
+			return w.stickErr
+		}
+	}
+	w.pending = 0
+	return nil
+}
+
+// syncLoop fsyncs batched records every BatchInterval until the log closes
+func (w *writeAheadLog) syncLoop() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.opts.BatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.mu.Lock()
+			w.syncLocked()
+			w.mu.Unlock()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// reset discards every record after a compaction
+func (w *writeAheadLog) reset() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.syncLocked(); err != nil {
+		return err
+	}
+	if err := w.file.Truncate(0); err != nil {
+		w.stickErr = fmt.Errorf("wal truncate failed: %w", err)
+		return w.stickErr
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		w.stickErr = fmt.Errorf("wal seek failed: %w", err)
+		return w.stickErr
+	}
+	return w.file.Sync()
+}
+
+// err returns the sticky error, if any
+func (w *writeAheadLog) err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stickErr
+}
+
+// close flushes, fsyncs and closes the log file
+func (w *writeAheadLog) close() error {
+	close(w.done)
+	w.wg.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	syncErr := w.syncLocked()
+	closeErr := w.file.Close()
+	if syncErr != nil {
+		return syncErr
+	}
+	return closeErr
+}
+
+// Err returns the error that stopped the store, or nil if it is healthy.
+// Once a change can't be written to the write-ahead log (or its value can't
+// be encoded for it), that change and every later one is refused: Put and
+// PutWithTTL do nothing, Delete returns false, Checkpoint returns 0 and the
+// methods that return an error wrap this one. Callers of the methods that
+// don't return an error must check Err. The store must be reopened with
+// OpenKVStore to recover.
+func (kv *KVStore[V]) Err() error {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	if kv.failErr != nil || kv.wal == nil {
+		return kv.failErr
+	}
+	return kv.wal.err()
+}
+
+// SyncWAL flushes and fsyncs any buffered WAL records. It returns the first
+// error the log has hit; once that happens, mutations are refused until the
+// store is reopened.
+func (kv *KVStore[V]) SyncWAL() error {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	if kv.wal == nil {
+		return nil
+	}
+	kv.wal.mu.Lock()
+	defer kv.wal.mu.Unlock()
+	return kv.wal.syncLocked()
+}
+
+// Compact folds the write-ahead log into a fresh snapshot: the current state
+// is written with SaveToDisk to the snapshot path and the log is truncated.
+func (kv *KVStore[V]) Compact() error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if kv.wal == nil {
+		return fmt.Errorf("write-ahead log is not enabled")
+	}
+	if err := kv.saveLocked(kv.snapshotPath, FormatForFile(kv.snapshotPath)); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return kv.wal.reset()
+}
+
+// Close stops the janitor, then flushes and closes the write-ahead log. The
+// store remains usable in memory, but further changes are no longer logged.
+func (kv *KVStore[V]) Close() error {
+	kv.StopJanitor()
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if kv.wal == nil {
+		return nil
+	}
+	err := kv.wal.close()
+	kv.wal = nil
+	return err
+}
@@ -0,0 +1,396 @@
+package store
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestWALReplay tests that operations survive a crash without SaveToDisk
+func TestWALReplay(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := filepath.Join(dir, "store.json")
+
+	kv, err := OpenKVStore[int](snapshot, WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenKVStore failed: %v", err)
+	}
+	kv.Put("a", 1)
+	kv.Put("b", 2)
+	kv.Checkpoint("")
+	kv.Put("a", 10)
+	kv.Delete("b")
+	kv.Put("c", 3)
+	kv.Checkpoint("")
+	kv.Put("c", 30)
+	if err := kv.Revert(); err != nil {
+		t.Fatalf("Revert failed: %v", err)
+	}
+	// Simulate a crash: no Close, no SaveToDisk
+
+	kv2, err := OpenKVStore[int](snapshot, WALOptions{})
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer kv2.Close()
+
+	if v, ok := kv2.Get("a"); !ok || v != 10 {
+		t.Errorf("Expected a=10 after replay, got %d (exists=%v)", v, ok)
+	}
+	if _, ok := kv2.Get("b"); ok {
+		t.Error("Expected b to stay deleted after replay")
+	}
+	if v, ok := kv2.Get("c"); !ok || v != 3 {
+		t.Errorf("Expected c=3 after replay, got %d (exists=%v)", v, ok)
+	}
+	if kv2.GetCheckpointCount() != 1 {
+		t.Errorf("Expected 1 checkpoint after replay, got %d", kv2.GetCheckpointCount())
+	}
+
+	// Checkpoint tracking must be replayed too
+	if err := kv2.Revert(); err != nil {
+		t.Fatalf("Revert after replay failed: %v", err)
+	}
+	if v, _ := kv2.Get("a"); v != 1 {
+		t.Errorf("Expected a=1 after revert, got %d", v)
+	}
+	if v, ok := kv2.Get("b"); !ok || v != 2 {
+		t.Errorf("Expected b=2 restored after revert, got %d (exists=%v)", v, ok)
+	}
+	if kv2.CountValue(10) != 0 || kv2.CountValue(1) != 1 {
+		t.Error("Expected value counts to be consistent after replay and revert")
+	}
+}
+
+// TestWALCompact tests folding the log into a snapshot
+func TestWALCompact(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := filepath.Join(dir, "store.json")
+	walPath := filepath.Join(dir, "store.log")
+
+	kv, err := OpenKVStore[int](snapshot, WALOptions{Path: walPath, SyncPolicy: SyncBatched})
+	if err != nil {
+		t.Fatalf("OpenKVStore failed: %v", err)
+	}
+	kv.Put("a", 1)
+	kv.Checkpoint("")
+	kv.Put("a", 2)
+
+	if err := kv.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	info, err := os.Stat(walPath)
+	if err != nil {
+		t.Fatalf("stat wal: %v", err)
+	}
+	if info.Size() != 0 {
+		t.Errorf("Expected empty wal after compaction, got %d bytes", info.Size())
+	}
+
+	kv.Put("b", 5)
+	if err := kv.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	kv2, err := OpenKVStore[int](snapshot, WALOptions{Path: walPath})
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer kv2.Close()
+
+	if v, _ := kv2.Get("a"); v != 2 {
+		t.Errorf("Expected a=2 from snapshot, got %d", v)
+	}
+	if v, _ := kv2.Get("b"); v != 5 {
+		t.Errorf("Expected b=5 from wal, got %d", v)
+	}
+	if err := kv2.Revert(); err != nil {
+		t.Fatalf("Revert failed: %v", err)
+	}
+	if v, _ := kv2.Get("a"); v != 1 {
+		t.Errorf("Expected a=1 after revert, got %d", v)
+	}
+}
+
+// TestWALCompactCrashBeforeTruncate tests that records already in the
+// snapshot are not applied twice
+func TestWALCompactCrashBeforeTruncate(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := filepath.Join(dir, "store.json")
+	walPath := snapshot + ".wal"
+
+	kv, err := OpenKVStore[int](snapshot, WALOptions{SyncPolicy: SyncNone})
+	if err != nil {
+		t.Fatalf("OpenKVStore failed: %v", err)
+	}
+	kv.Put("a", 1)
+	kv.Checkpoint("")
+	kv.Put("a", 2)
+	kv.Close()
+
+	// Keep a copy of the log, compact, then put the stale log back
+	stale, err := os.ReadFile(walPath)
+	if err != nil {
+		t.Fatalf("read wal: %v", err)
+	}
+	kv, err = OpenKVStore[int](snapshot, WALOptions{})
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	if err := kv.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	kv.Close()
+	if err := os.WriteFile(walPath, stale, 0644); err != nil {
+		t.Fatalf("restore wal: %v", err)
+	}
+
+	kv, err = OpenKVStore[int](snapshot, WALOptions{})
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer kv.Close()
+
+	if kv.GetCheckpointCount() != 1 {
+		t.Errorf("Expected 1 checkpoint, got %d", kv.GetCheckpointCount())
+	}
+}
+
+// TestWALTornRecord tests that a partially written final record is ignored
+func TestWALTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := filepath.Join(dir, "store.json")
+	walPath := snapshot + ".wal"
+
+	kv, err := OpenKVStore[int](snapshot, WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenKVStore failed: %v", err)
+	}
+	kv.Put("a", 1)
+	kv.Close()
+
+	f, err := os.OpenFile(walPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("open wal: %v", err)
+	}
+	f.WriteString(`{"seq":2,"op":"put","key":"b","val`)
+	f.Close()
+
+	kv, err = OpenKVStore[int](snapshot, WALOptions{})
+	if err != nil {
+		t.Fatalf("reopen with torn record failed: %v", err)
+	}
+	if _, ok := kv.Get("b"); ok {
+		t.Error("Expected torn record to be discarded")
+	}
+	kv.Put("c", 3)
+	kv.Close()
+
+	kv, err = OpenKVStore[int](snapshot, WALOptions{})
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer kv.Close()
+	if v, _ := kv.Get("c"); v != 3 {
+		t.Errorf("Expected c=3 appended after torn record, got %d", v)
+	}
+}
+
+// TestWALCorruptRecord tests that corruption before the tail is reported
+func TestWALCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := filepath.Join(dir, "store.json")
+	walPath := snapshot + ".wal"
+
+	content := "{\"seq\":1,\"op\":\"put\",\"key\":\"a\",\"value\":1}\n" +
+		"garbage\n" +
+		"{\"seq\":2,\"op\":\"put\",\"key\":\"b\",\"value\":2}\n"
+	if err := os.WriteFile(walPath, []byte(content), 0644); err != nil {
+		t.Fatalf("write wal: %v", err)
+	}
+
+	if _, err := OpenKVStore[int](snapshot, WALOptions{}); err == nil {
+		t.Error("Expected error for corrupt wal record")
+	}
+}
+
+// failingWriter fails every write, like a full disk
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+// TestWALWriteFailure tests that once a change can't be logged the store
+// refuses every change, reports why through Err, and keeps what was logged
+func TestWALWriteFailure(t *testing.T) {
+	snapshot := filepath.Join(t.TempDir(), "store.json")
+	kv, err := OpenKVStore[int](snapshot, WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenKVStore failed: %v", err)
+	}
+	kv.Put("a", 1)
+	id := kv.Checkpoint("")
+	if err := kv.Err(); err != nil {
+		t.Fatalf("Expected a healthy store, got %v", err)
+	}
+	kv.wal.writer = bufio.NewWriter(failingWriter{})
+
+	kv.Put("a", 2)
+	failure := kv.Err()
+	if failure == nil {
+		t.Fatal("Expected Err to report the failed write")
+	}
+	if v, _ := kv.Get("a"); v != 1 {
+		t.Errorf("Expected the failed Put not to be applied, got a=%d", v)
+	}
+
+	// Everything after it is refused, and says why
+	kv.PutWithTTL("b", 2, time.Hour)
+	if _, ok := kv.Get("b"); ok {
+		t.Error("Expected PutWithTTL to be refused")
+	}
+	if kv.Delete("a") {
+		t.Error("Expected Delete to be refused")
+	}
+	if kv.Checkpoint("") != 0 {
+		t.Error("Expected Checkpoint to be refused")
+	}
+	if err := kv.RevertTo(id); !errors.Is(err, failure) {
+		t.Errorf("Expected RevertTo to fail with the log's error, got %v", err)
+	}
+	if _, err := kv.BulkLoad("", []KeyValue[int]{{Key: "c", Value: 3}}); !errors.Is(err, failure) {
+		t.Errorf("Expected BulkLoad to fail with the log's error, got %v", err)
+	}
+	if kv.Close() == nil {
+		t.Error("Expected Close to report the failed write")
+	}
+	kv.Put("a", 3)
+	if v, _ := kv.Get("a"); v != 1 || !errors.Is(kv.Err(), failure) {
+		t.Errorf("Expected the store to stay stopped after Close, got a=%d, %v", v, kv.Err())
+	}
+
+	kv2, err := OpenKVStore[int](snapshot, WALOptions{})
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer kv2.Close()
+	if v, _ := kv2.Get("a"); v != 1 || kv2.GetCheckpointCount() != 1 || kv2.Err() != nil {
+		t.Errorf("Expected a=1 and 1 checkpoint after reopening, got a=%d and %d", v, kv2.GetCheckpointCount())
+	}
+}
+
+// TestParseSyncPolicy tests sync policy names
+func TestParseSyncPolicy(t *testing.T) {
+	for _, p := range []SyncPolicy{SyncEveryOp, SyncBatched, SyncNone} {
+		parsed, err := ParseSyncPolicy(p.String())
+		if err != nil || parsed != p {
+			t.Errorf("ParseSyncPolicy(%q) = %v, %v", p.String(), parsed, err)
+		}
+	}
+	if _, err := ParseSyncPolicy("sometimes"); err == nil {
+		t.Error("Expected error for unknown sync policy")
+	}
+}
+
+// TestWALLoadFromDisk tests that loading a file resets the log to it
+func TestWALLoadFromDisk(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := filepath.Join(dir, "store.json")
+	other := filepath.Join(dir, "other.json")
+
+	plain := NewKVStore[int]()
+	plain.Put("x", 42)
+	if err := plain.SaveToDisk(other); err != nil {
+		t.Fatalf("SaveToDisk failed: %v", err)
+	}
+
+	kv, err := OpenKVStore[int](snapshot, WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenKVStore failed: %v", err)
+	}
+	kv.Put("a", 1)
+	if err := kv.LoadFromDisk(other); err != nil {
+		t.Fatalf("LoadFromDisk failed: %v", err)
+	}
+	kv.Put("y", 7)
+	kv.Close()
+
+	kv, err = OpenKVStore[int](snapshot, WALOptions{})
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer kv.Close()
+
+	if _, ok := kv.Get("a"); ok {
+		t.Error("Expected a to be replaced by the loaded file")
+	}
+	if v, _ := kv.Get("x"); v != 42 {
+		t.Errorf("Expected x=42 from loaded file, got %d", v)
+	}
+	if v, _ := kv.Get("y"); v != 7 {
+		t.Errorf("Expected y=7 logged after load, got %d", v)
+	}
+}
+
+// TestReadKVStore tests reading a store another process has open, torn
+// record and all, without changing its files
+func TestReadKVStore(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := filepath.Join(dir, "store.json")
+	walPath := snapshot + ".wal"
+
+	kv, err := OpenKVStore[int](snapshot, WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenKVStore failed: %v", err)
+	}
+	defer kv.Close()
+	kv.Put("a", 1)
+	kv.Compact()
+	kv.Put("b", 2)
+	kv.Delete("a")
+
+	f, err := os.OpenFile(walPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("open wal: %v", err)
+	}
+	f.WriteString(`{"seq":9,"op":"put","key":"c","val`)
+	f.Close()
+	before, _ := os.ReadFile(walPath)
+
+	read, err := ReadKVStore[int](snapshot, WALOptions{})
+	if err != nil {
+		t.Fatalf("ReadKVStore failed: %v", err)
+	}
+	data, _ := read.GetAllData()
+	if len(data) != 1 || data["b"] != 2 {
+		t.Errorf("Expected only b=2, got %v", data)
+	}
+	read.Put("d", 4)
+	if after, _ := os.ReadFile(walPath); string(after) != string(before) {
+		t.Errorf("Expected the wal to be left alone")
+	}
+
+	if read, err := ReadKVStore[int](filepath.Join(dir, "missing.json"), WALOptions{}); err != nil || len(read.data) != 0 {
+		t.Errorf("Expected an empty store for missing files, got %v", err)
+	}
+}
+
+// BenchmarkPutWAL benchmarks put operations for each sync policy
+func BenchmarkPutWAL(b *testing.B) {
+	for _, policy := range []SyncPolicy{SyncEveryOp, SyncBatched, SyncNone} {
+		b.Run(policy.String(), func(b *testing.B) {
+			kv, err := OpenKVStore[int](filepath.Join(b.TempDir(), "store.json"), WALOptions{SyncPolicy: policy})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer kv.Close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				kv.Put("key", i)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package store
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+// ErrWatchOverflow is carried by the last event sent on an OverflowClose
+// watch whose buffer filled up
+var ErrWatchOverflow = errors.New("watch buffer overflow")
+
+// EventType says what caused an Event
+type EventType int
+
+const (
+	EventPut      EventType = iota + 1 // Put, PutWithTTL or a committed transaction write
+	EventDelete                        // Delete or a committed transaction delete
+	EventExpire                        // A key's TTL passed (see PutWithTTL)
+	EventRevert                        // Revert or RevertTo undid a change to the key
+	EventLoad                          // LoadFromDisk replaced the key's value
+	EventOverflow                      // The watch fell behind and is closing (OverflowClose)
+	EventEvict                         // The store was over its capacity (see SetCapacity)
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventPut:
+		return "put"
+	case EventDelete:
+		return "delete"
+	case EventExpire:
+		return "expire"
+	case EventRevert:
+		return "revert"
+	case EventLoad:
+		return "load"
+	case EventOverflow:
+		return "overflow"
+	case EventEvict:
+		return "evict"
+	}
+	return "unknown"
+}
+
+// Event describes one change to one key. OldValue is nil if the key didn't
+// exist before the change, NewValue is nil if it doesn't exist after it.
+type Event[V comparable] struct {
+	Type     EventType
+	Key      string
+	OldValue *V
+	NewValue *V
+	Err      error // ErrWatchOverflow on an EventOverflow, nil otherwise
+}
+
+// OverflowPolicy says what happens when a watcher's buffer is full
+type OverflowPolicy int
+
+const (
+	// OverflowClose sends a final EventOverflow carrying ErrWatchOverflow and
+	// closes the channel. The watcher never silently misses an event: it
+	// knows to resync (e.g. with Scan) and Watch again. This is the default.
+	OverflowClose OverflowPolicy = iota
+	// OverflowDrop discards events that don't fit in the buffer, so the
+	// watcher may miss changes
+	OverflowDrop
+	// OverflowBlock makes the writer wait until there is room. Every writer
+	// stalls behind a slow watcher (the store lock is held while waiting), so
+	// the consumer must never call into the store while it is behind.
+	OverflowBlock
+)
+
+// WatchOptions configures WatchWithOptions
+type WatchOptions struct {
+	BufferSize int            // Events buffered per watcher (default 256)
+	Overflow   OverflowPolicy // What to do when the buffer is full
+}
+
+const defaultWatchBuffer = 256
+
+// watcher is one Watch subscription
+type watcher[V comparable] struct {
+	prefix   string
+	ch       chan Event[V]
+	size     int // Buffered events allowed (OverflowClose has one more slot for EventOverflow)
+	overflow OverflowPolicy
+	done     chan struct{} // Closed by Unwatch to release a blocked writer
+	stop     sync.Once
+}
+
+// Watch returns a channel that receives an Event for every change to a key
+// starting with prefix ("" watches every key), with the default options
+func (kv *KVStore[V]) Watch(prefix string) <-chan Event[V] {
+	return kv.WatchWithOptions(prefix, WatchOptions{})
+}
+
+// WatchWithOptions is like Watch with a configurable buffer size and
+// overflow policy.
+//
+// Events are sent while the change is applied under the store lock, so each
+// watcher sees changes in the order they happened, and a watcher that reads
+// the value of a key after its event sees that change (or a later one). Revert
+// and RevertTo send an EventRevert for every key they restore or remove, so
+// applying events in order keeps a replica consistent with the rollback.
+// Stop watching with Unwatch.
+func (kv *KVStore[V]) WatchWithOptions(prefix string, opts WatchOptions) <-chan Event[V] {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = defaultWatchBuffer
+	}
+	capacity := opts.BufferSize
+	if opts.Overflow == OverflowClose {
+		capacity++
+	}
+	w := &watcher[V]{
+		prefix:   prefix,
+		ch:       make(chan Event[V], capacity),
+		size:     opts.BufferSize,
+		overflow: opts.Overflow,
+		done:     make(chan struct{}),
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.watchers[w.ch] = w
+	kv.watchIndex.Store((<-chan Event[V])(w.ch), w)
+	return w.ch
+}
+
+// Unwatch stops a watch and closes its channel. It is a no-op for a channel
+// that is already closed.
+func (kv *KVStore[V]) Unwatch(ch <-chan Event[V]) {
+	// Found without mu, which a writer blocked on w (OverflowBlock) holds
+	found, ok := kv.watchIndex.Load(ch)
+	if !ok {
+		return
+	}
+	w := found.(*watcher[V])
+	w.stop.Do(func() { close(w.done) }) // Release that writer
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.closeWatcherLocked(w)
+}
+
+// notifyLocked sends ev to every watcher whose prefix matches; the caller
+// must hold mu
+func (kv *KVStore[V]) notifyLocked(ev Event[V]) {
+	for _, w := range kv.watchers {
+		if !strings.HasPrefix(ev.Key, w.prefix) {
+			continue
+		}
+
+		if len(w.ch) < w.size {
+			w.ch <- ev
+			continue
+		}
+		switch w.overflow {
+		case OverflowDrop:
+		case OverflowBlock:
+			select {
+			case w.ch <- ev:
+			case <-w.done:
+			}
+		default:
+			// Into the slot kept free for it
+			w.ch <- Event[V]{Type: EventOverflow, Key: ev.Key, Err: ErrWatchOverflow}
+			kv.closeWatcherLocked(w)
+		}
+	}
+}
+
+// closeWatcherLocked removes w and closes its channel; the caller must hold
+// mu
+func (kv *KVStore[V]) closeWatcherLocked(w *watcher[V]) {
+	if _, ok := kv.watchers[w.ch]; !ok {
+		return
+	}
+	delete(kv.watchers, w.ch)
+	kv.watchIndex.Delete((<-chan Event[V])(w.ch))
+	close(w.ch)
+}
+
+// notifyLoadLocked sends an EventLoad for every key whose value differs
+// between the current data and data, which is about to replace it; the
+// caller must hold mu
+func (kv *KVStore[V]) notifyLoadLocked(data map[string]V) {
+	if len(kv.watchers) == 0 {
+		return
+	}
+	for key, oldValue := range kv.data {
+		newValue, exists := data[key]
+		switch {
+		case !exists:
+			kv.notifyLocked(Event[V]{Type: EventLoad, Key: key, OldValue: &oldValue})
+		case newValue != oldValue:
+			kv.notifyLocked(Event[V]{Type: EventLoad, Key: key, OldValue: &oldValue, NewValue: &newValue})
+		}
+	}
+	for key, newValue := range data {
+		if _, existed := kv.data[key]; !existed {
+			kv.notifyLocked(Event[V]{Type: EventLoad, Key: key, NewValue: &newValue})
+		}
+	}
+}
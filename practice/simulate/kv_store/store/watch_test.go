@@ -0,0 +1,229 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// describe formats an event as "type key old->new" with "-" for nil values
+func describe(ev Event[int]) string {
+	value := func(v *int) string {
+		if v == nil {
+			return "-"
+		}
+		return fmt.Sprint(*v)
+	}
+	return fmt.Sprintf("%s %s %s->%s", ev.Type, ev.Key, value(ev.OldValue), value(ev.NewValue))
+}
+
+// pending returns the events buffered on ch
+func pending(ch <-chan Event[int]) []Event[int] {
+	var events []Event[int]
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return events
+			}
+			events = append(events, ev)
+		default:
+			return events
+		}
+	}
+}
+
+// drain returns the descriptions of the events buffered on ch
+func drain(ch <-chan Event[int]) []string {
+	var got []string
+	for _, ev := range pending(ch) {
+		got = append(got, describe(ev))
+	}
+	return got
+}
+
+func expectEvents(t *testing.T, ch <-chan Event[int], want ...string) {
+	t.Helper()
+	got := drain(ch)
+	if len(got) != len(want) {
+		t.Fatalf("Expected events %q, got %q", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Event %d: expected %q, got %q", i, want[i], got[i])
+		}
+	}
+}
+
+// TestWatch tests put, delete and expire events and prefix filtering
+func TestWatch(t *testing.T) {
+	clock := newFakeClock()
+	kv := NewKVStore[int]()
+	kv.now = clock.Now
+
+	users := kv.Watch("user:")
+	all := kv.Watch("")
+
+	kv.Put("user:1", 1)
+	kv.Put("user:1", 2)
+	kv.Put("order:1", 5)
+	kv.Delete("user:1")
+	kv.Delete("user:1") // No such key: no event
+	kv.PutWithTTL("user:2", 3, time.Second)
+	clock.Advance(time.Second)
+	kv.Get("user:2")
+
+	expectEvents(t, users,
+		"put user:1 -->1",
+		"put user:1 1->2",
+		"delete user:1 2->-",
+		"put user:2 -->3",
+		"expire user:2 3->-",
+	)
+	if got := drain(all); len(got) != 6 {
+		t.Errorf("Expected 6 events for the catch-all watcher, got %q", got)
+	}
+
+	kv.Unwatch(users)
+	if _, ok := <-users; ok {
+		t.Error("Expected Unwatch to close the channel")
+	}
+	kv.Unwatch(users) // No-op
+	kv.Put("user:3", 1)
+	expectEvents(t, all, "put user:3 -->1")
+}
+
+// TestWatchRevert tests that Revert emits compensating events
+func TestWatchRevert(t *testing.T) {
+	kv := NewKVStore[int]()
+	kv.Put("a", 1)
+	kv.Put("b", 2)
+	base := kv.Checkpoint("")
+
+	ch := kv.Watch("")
+	kv.Put("a", 10)
+	kv.Delete("b")
+	kv.Put("c", 3)
+	kv.Checkpoint("")
+	kv.Put("a", 20)
+	drain(ch)
+
+	// Apply the rollback's events to a replica of the state they started from
+	replica := map[string]int{"a": 20, "c": 3}
+	if err := kv.RevertTo(base); err != nil {
+		t.Fatalf("RevertTo failed: %v", err)
+	}
+	for _, ev := range pending(ch) {
+		if ev.Type != EventRevert {
+			t.Errorf("Expected only revert events, got %s", describe(ev))
+		}
+		if ev.NewValue == nil {
+			delete(replica, ev.Key)
+		} else {
+			replica[ev.Key] = *ev.NewValue
+		}
+	}
+
+	data, _ := kv.GetAllData()
+	if !maps.Equal(replica, data) {
+		t.Errorf("Expected replica %v to match store %v", replica, data)
+	}
+}
+
+// TestWatchTxnAndLoad tests events from transactions and LoadFromDisk
+func TestWatchTxnAndLoad(t *testing.T) {
+	kv := NewKVStore[int]()
+	kv.Put("a", 1)
+	kv.Put("b", 2)
+	filename := filepath.Join(t.TempDir(), "watch.json")
+	kv.SaveToDisk(filename)
+
+	ch := kv.Watch("")
+	txn := kv.Begin()
+	txn.Put("c", 3)
+	txn.Delete("a")
+	expectEvents(t, ch) // Nothing until commit
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	expectEvents(t, ch, "delete a 1->-", "put c -->3")
+
+	kv.Put("b", 20)
+	drain(ch)
+	if err := kv.LoadFromDisk(filename); err != nil {
+		t.Fatalf("LoadFromDisk failed: %v", err)
+	}
+	got := drain(ch)
+	want := map[string]bool{"load a -->1": true, "load b 20->2": true, "load c 3->-": true}
+	if len(got) != len(want) {
+		t.Fatalf("Expected load events %v, got %q", want, got)
+	}
+	for _, ev := range got {
+		if !want[ev] {
+			t.Errorf("Unexpected load event %q", ev)
+		}
+	}
+}
+
+// TestWatchOverflow tests the three overflow policies
+func TestWatchOverflow(t *testing.T) {
+	kv := NewKVStore[int]()
+
+	closing := kv.WatchWithOptions("", WatchOptions{BufferSize: 2})
+	dropping := kv.WatchWithOptions("", WatchOptions{BufferSize: 2, Overflow: OverflowDrop})
+	for i := 0; i < 5; i++ {
+		kv.Put(fmt.Sprintf("k%d", i), i)
+	}
+
+	var last Event[int]
+	n := 0
+	for ev := range closing {
+		last = ev
+		n++
+	}
+	if n != 3 || last.Type != EventOverflow || !errors.Is(last.Err, ErrWatchOverflow) {
+		t.Errorf("Expected 2 events then an overflow, got %d events ending with %s (err=%v)", n, describe(last), last.Err)
+	}
+	expectEvents(t, dropping, "put k0 -->0", "put k1 -->1")
+	kv.Put("k9", 9)
+	expectEvents(t, dropping, "put k9 -->9")
+
+	// A blocked writer waits for the watcher to catch up
+	blocking := kv.WatchWithOptions("", WatchOptions{BufferSize: 1, Overflow: OverflowBlock})
+	kv.Put("x", 1)
+	done := make(chan struct{})
+	go func() {
+		kv.Put("y", 2)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Expected the second Put to block")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if ev := <-blocking; ev.Key != "x" {
+		t.Errorf("Expected x first, got %s", describe(ev))
+	}
+	<-done
+	if ev := <-blocking; ev.Key != "y" {
+		t.Errorf("Expected y second, got %s", describe(ev))
+	}
+
+	// Unwatch releases a writer blocked on a watcher nobody reads
+	kv.Put("z", 3)
+	done = make(chan struct{})
+	go func() {
+		kv.Put("w", 4)
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	kv.Unwatch(blocking)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected Unwatch to release the blocked writer")
+	}
+}
@@ -1,33 +1,22 @@
 #!/bin/bash
 # Test script for KV Store CLI
+#
+# The commands and their expected output live in cmd/cli/testdata/session.kv,
+# which `go test ./cmd/cli` also runs as a golden test. Any mismatch is
+# reported and the script exits 1.
 
-echo "Testing KV Store CLI with automated commands..."
-echo ""
+set -e
+cd "$(dirname "$0")"
+dir=$(mktemp -d)
+trap 'rm -rf "$dir"' EXIT
+
+go build -o "$dir/kv-cli" ./cmd/cli
+cp cmd/cli/testdata/session.kv "$dir"
+cd "$dir"
 
-# Create a test input file
-cat << 'EOF' | ./cli
-put name Alice
-put age 30
-put city NYC
-put status active
-put role admin
-get name
-get age
-count active
-list
-checkpoint
-put name Bob
-put newkey newvalue
-list
-revert
-list
-save test_store.json
-clear
-list
-load test_store.json
-list
-exit
-EOF
+echo "Testing KV Store CLI with cmd/cli/testdata/session.kv..."
+echo ""
+./kv-cli -type string exec session.kv
 
 echo ""
 echo "Test completed!"
@@ -1,37 +1,80 @@
 #!/bin/bash
-# Test flag-based CLI
+# Test save/load and checkpoints through the CLI
+#
+# The commands and their expected output live in
+# cmd/cli/testdata/checkpoints.kv, which `go test ./cmd/cli` also runs as a
+# golden test. The store is saved once, to .kv_store.json, when the script
+# passes. The same ground is then covered one flag-style invocation at a
+# time (flags before the command), each checked for the output it prints.
+
+set -e
+cd "$(dirname "$0")"
+dir=$(mktemp -d)
+trap 'rm -rf "$dir"' EXIT
+
+go build -o "$dir/kv-cli" ./cmd/cli
+cd "$dir"
+
+echo "=== Running cmd/cli/testdata/checkpoints.kv ==="
+echo ""
+./kv-cli -type string < "$OLDPWD/cmd/cli/testdata/checkpoints.kv"
 
-echo "=== Testing Flag-Based CLI ==="
 echo ""
+echo "=== Stored afterwards ==="
+./kv-cli -type string list
 
-# Put some data
-./bin/kv-cli put --key name --value Alice
-./bin/kv-cli put --key age --value 30
-./bin/kv-cli put --key status --value active
-./bin/kv-cli put --key role --value admin
+# expect runs the CLI with the given flags and command and fails the script
+# unless the output contains want (and, with -fail, unless the CLI exits 1)
+expect() {
+	status=0
+	if [[ $1 == -fail ]]; then
+		status=1
+		shift
+	fi
+	want=$1
+	shift
+	got=$(../kv-cli -type string "$@") && code=0 || code=$?
+	echo "$got"
+	if [[ $got != *"$want"* || $code != "$status" ]]; then
+		echo "❌ kv-cli $*: expected exit $status and output containing '$want', got exit $code"
+		exit 1
+	fi
+}
 
-# Save to file
-./bin/kv-cli save --file test_cli.json
+mkdir flags
+cd flags
+
+echo ""
+echo "=== Testing Flag-Based CLI ==="
+expect "Set 'name' = Alice" -key name -value Alice put
+expect "Set 'age' = 30" -key age -value 30 put
+expect "Set 'status' = active" -key status -value active put
+expect "Set 'role' = admin" -key role -value admin put
+expect "Saved to 'test_cli.json'" -file test_cli.json save
 
 echo ""
 echo "=== Loading and querying ==="
-# Load and query
-./bin/kv-cli load --file test_cli.json
-./bin/kv-cli get --key name
-./bin/kv-cli count --value active
-./bin/kv-cli list
+expect "Loaded from 'test_cli.json'" -file test_cli.json load
+expect "'name' = Alice" -key name get
+expect "Value active appears 1 time(s)" -value active count
+expect "Total keys: 4" list
 
 echo ""
 echo "=== Testing checkpoint/revert ==="
-./bin/kv-cli load --file test_cli.json
-./bin/kv-cli checkpoint
-./bin/kv-cli put --key name --value Bob
-./bin/kv-cli get --key name
-./bin/kv-cli revert
-./bin/kv-cli get --key name
-
-# Cleanup
-rm -f test_cli.json
+expect "Loaded from 'test_cli.json'" -file test_cli.json load
+expect "Checkpoint 1 created" checkpoint
+expect "Set 'name' = Bob" -key name -value Bob put
+expect "'name' = Bob" -key name get
+expect "Reverted to last checkpoint" revert
+expect "'name' = Alice" -key name get
+
+echo ""
+echo "=== Testing TTL and delete ==="
+expect "expires in 1h0m0s" -key tmp -value x -ttl 1h put
+expect "'tmp' expires in" -key tmp ttl
+expect "Deleted key 'age'" -key age delete
+expect -fail "Key 'age' not found" -key age get
+expect -fail "put requires --key and --value flags" put --key name --value Alice
 
 echo ""
 echo "=== All tests complete! ==="